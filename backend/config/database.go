@@ -12,17 +12,29 @@ import (
 )
 
 var (
-	client                 *mongo.Client
-	fileCollection         *mongo.Collection
-	userCollection         *mongo.Collection
-	favoriteCollection     *mongo.Collection
-	roomCollection         *mongo.Collection
-	folderCollection       *mongo.Collection
-	paperCollection        *mongo.Collection
-	sharedCollection       *mongo.Collection
-	backlistCollection     *mongo.Collection
-	roomMemberCollection   *mongo.Collection
-	RefreshTokenCollection *mongo.Collection
+	client                    *mongo.Client
+	fileCollection            *mongo.Collection
+	userCollection            *mongo.Collection
+	favoriteCollection        *mongo.Collection
+	roomCollection            *mongo.Collection
+	folderCollection          *mongo.Collection
+	paperCollection           *mongo.Collection
+	sharedCollection          *mongo.Collection
+	backlistCollection        *mongo.Collection
+	roomMemberCollection      *mongo.Collection
+	RefreshTokenCollection    *mongo.Collection
+	groupCollection           *mongo.Collection
+	roomGroupCollection       *mongo.Collection
+	roleCollection            *mongo.Collection
+	starredCollection         *mongo.Collection
+	tagCollection             *mongo.Collection
+	fileActivityCollection    *mongo.Collection
+	schemaMigrationCollection *mongo.Collection
+	paperStrokeCollection     *mongo.Collection
+	fileVersionCollection     *mongo.Collection
+	paperSnapshotCollection   *mongo.Collection
+	paperHistoryCollection    *mongo.Collection
+	paperThumbnailCollection  *mongo.Collection
 )
 
 func ConnectDB() {
@@ -40,19 +52,26 @@ func ConnectDB() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOptions := options.Client().ApplyURI(mongoURI)
-	client, err = mongo.Connect(ctx, clientOptions)
-	if err != nil {
+	if err := Connect(ctx, mongoURI, "Roomlen"); err != nil {
 		log.Fatal("MongoDB connection error:", err)
 	}
+}
 
-	// Check the connection
-	err = client.Ping(ctx, nil)
+// Connect connects to the MongoDB deployment at uri and points every
+// collection at the named database
+func Connect(ctx context.Context, uri, database string) error {
+	var err error
+	client, err = mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	// Check the connection
+	if err := client.Ping(ctx, nil); err != nil {
+		return err
 	}
 
-	db := client.Database("Roomlen")
+	db := client.Database(database)
 	userCollection = db.Collection("Users")
 	roomCollection = db.Collection("Rooms")
 	favoriteCollection = db.Collection("Favorites")
@@ -63,6 +82,19 @@ func ConnectDB() {
 	backlistCollection = db.Collection("Backlist")
 	roomMemberCollection = db.Collection("Room_Member")
 	RefreshTokenCollection = db.Collection("RefreshToken")
+	groupCollection = db.Collection("Groups")
+	roomGroupCollection = db.Collection("Room_Group")
+	roleCollection = db.Collection("Roles")
+	starredCollection = db.Collection("Starred")
+	tagCollection = db.Collection("Tags")
+	fileActivityCollection = db.Collection("File_Activity")
+	schemaMigrationCollection = db.Collection("Schema_Migrations")
+	paperStrokeCollection = db.Collection("Paper_Strokes")
+	fileVersionCollection = db.Collection("File_Versions")
+	paperSnapshotCollection = db.Collection("Paper_Snapshots")
+	paperHistoryCollection = db.Collection("Paper_History")
+	paperThumbnailCollection = db.Collection("Paper_Thumbnails")
+	return nil
 }
 
 func GetFileCollection() *mongo.Collection {
@@ -104,3 +136,73 @@ func GetRoomMemberCollection() *mongo.Collection {
 func GetRefreshTokenCollection() *mongo.Collection {
 	return RefreshTokenCollection
 }
+
+func GetGroupCollection() *mongo.Collection {
+	return groupCollection
+}
+
+func GetRoomGroupCollection() *mongo.Collection {
+	return roomGroupCollection
+}
+
+func GetRoleCollection() *mongo.Collection {
+	return roleCollection
+}
+
+func GetStarredCollection() *mongo.Collection {
+	return starredCollection
+}
+
+// RunInTransaction runs fn inside a MongoDB transaction. The driver retries
+// the whole callback on transient errors and the commit on unknown results.
+// Every operation in fn must use the session context it is given. When ctx
+// already carries a session, fn joins that session's transaction, so nested
+// calls commit or abort together with the outer one.
+func RunInTransaction(ctx context.Context, fn func(sessCtx mongo.SessionContext) error) error {
+	if session := mongo.SessionFromContext(ctx); session != nil {
+		return fn(mongo.NewSessionContext(ctx, session))
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sessCtx)
+	})
+	return err
+}
+
+func GetTagCollection() *mongo.Collection {
+	return tagCollection
+}
+
+func GetFileActivityCollection() *mongo.Collection {
+	return fileActivityCollection
+}
+
+func GetSchemaMigrationCollection() *mongo.Collection {
+	return schemaMigrationCollection
+}
+
+func GetPaperStrokeCollection() *mongo.Collection {
+	return paperStrokeCollection
+}
+
+func GetFileVersionCollection() *mongo.Collection {
+	return fileVersionCollection
+}
+
+func GetPaperSnapshotCollection() *mongo.Collection {
+	return paperSnapshotCollection
+}
+
+func GetPaperHistoryCollection() *mongo.Collection {
+	return paperHistoryCollection
+}
+
+func GetPaperThumbnailCollection() *mongo.Collection {
+	return paperThumbnailCollection
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/argon2"
 	"google.golang.org/api/idtoken"
 
@@ -188,6 +189,12 @@ func UserSignup(response http.ResponseWriter, request *http.Request) {
 
 	// If we reach here, the email is unique, so proceed with insertion
 	result, err := collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		// Lost a race with another signup for the same email
+		response.WriteHeader(http.StatusConflict)
+		response.Write([]byte(`{"message":"Email already registered"}`))
+		return
+	}
 	if err != nil {
 		response.WriteHeader(http.StatusInternalServerError)
 		response.Write([]byte(`{"message":"Error creating user"}`))
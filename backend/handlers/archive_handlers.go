@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxRoomArchiveSize caps the size of an uploaded room archive
+const maxRoomArchiveSize = 512 << 20 // 512MB
+
+// ExportRoom streams a zip archive containing a room, its tags, folders,
+// files, papers and every background image they reference
+func ExportRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "Missing room_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, roomID, models.PermExport)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+
+	roomObjID, err := primitive.ObjectIDFromHex(roomID)
+	if err != nil {
+		http.Error(w, "Invalid room ID", http.StatusBadRequest)
+		return
+	}
+
+	var room models.Room
+	if err := config.GetRoomCollection().FindOne(ctx, bson.M{"_id": roomObjID}).Decode(&room); err != nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	var folders []models.Folder
+	cursor, err := config.GetFolderCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil || cursor.All(ctx, &folders) != nil {
+		http.Error(w, "Failed to fetch folders", http.StatusInternalServerError)
+		return
+	}
+
+	var files []models.File
+	cursor, err = config.GetFileCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil || cursor.All(ctx, &files) != nil {
+		http.Error(w, "Failed to fetch files", http.StatusInternalServerError)
+		return
+	}
+
+	var tags []models.Tag
+	cursor, err = config.GetTagCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil || cursor.All(ctx, &tags) != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	papers, err := findPapers(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		http.Error(w, "Failed to fetch papers", http.StatusInternalServerError)
+		return
+	}
+
+	// Give every distinct background image a stable path inside the archive
+	var assets []models.RoomArchiveAsset
+	seen := make(map[string]bool)
+	for _, paper := range papers {
+		if paper.BackgroundImage == "" || seen[paper.BackgroundImage] {
+			continue
+		}
+		seen[paper.BackgroundImage] = true
+		assets = append(assets, models.RoomArchiveAsset{
+			Path:      fmt.Sprintf("assets/%04d%s", len(assets)+1, assetExtension(paper.BackgroundImage)),
+			SourceURL: paper.BackgroundImage,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", room.Name+".zip"))
+
+	archive := zip.NewWriter(w)
+	defer archive.Close()
+
+	entries := []struct {
+		name string
+		data interface{}
+	}{
+		{"room.json", room},
+		{"tags.json", tags},
+		{"folders.json", folders},
+		{"files.json", files},
+		{"papers.json", papers},
+	}
+	for _, entry := range entries {
+		if err := writeArchiveJSON(archive, entry.name, entry.data); err != nil {
+			log.Printf("Error writing %s to room archive: %v", entry.name, err)
+			return
+		}
+	}
+
+	// Stream assets one by one; a missing blob is skipped and the importer drops its URL
+	archived := make([]models.RoomArchiveAsset, 0, len(assets))
+	for _, asset := range assets {
+		if err := copyAssetToArchive(archive, asset); err != nil {
+			log.Printf("Skipping asset %s: %v", asset.SourceURL, err)
+			continue
+		}
+		archived = append(archived, asset)
+	}
+
+	manifest := models.RoomArchiveManifest{
+		Format:     models.RoomArchiveFormat,
+		Version:    models.RoomArchiveVersion,
+		ExportedAt: time.Now(),
+		ExportedBy: userID,
+		RoomID:     roomID,
+		RoomName:   room.Name,
+		Folders:    len(folders),
+		Files:      len(files),
+		Papers:     len(papers),
+		Assets:     archived,
+	}
+	if err := writeArchiveJSON(archive, "manifest.json", manifest); err != nil {
+		log.Printf("Error writing manifest to room archive: %v", err)
+	}
+}
+
+// ImportRoom recreates a room from an archive produced by ExportRoom. The new
+// room is owned by the caller and every ID is regenerated. Nothing that
+// points back at the source room is kept: tags are recreated, and the last
+// editor and backgrounds missing from the archive are dropped.
+func ImportRoom(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxRoomArchiveSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Archive too big", http.StatusBadRequest)
+		return
+	}
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Error retrieving the archive", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, handler.Size)
+	if err != nil {
+		http.Error(w, "Invalid archive", http.StatusBadRequest)
+		return
+	}
+
+	entries := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		entries[f.Name] = f
+	}
+
+	var manifest models.RoomArchiveManifest
+	var room models.Room
+	var folders []models.Folder
+	var files []models.File
+	var papers []models.Paper
+	for name, target := range map[string]interface{}{
+		"manifest.json": &manifest,
+		"room.json":     &room,
+		"folders.json":  &folders,
+		"files.json":    &files,
+		"papers.json":   &papers,
+	} {
+		if err := readArchiveJSON(entries, name, target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if manifest.Format != models.RoomArchiveFormat || manifest.Version > models.RoomArchiveVersion {
+		http.Error(w, "Unsupported archive format", http.StatusBadRequest)
+		return
+	}
+
+	// Archives before version 2 have no tags
+	var tags []models.Tag
+	if manifest.Version >= 2 {
+		if err := readArchiveJSON(entries, "tags.json", &tags); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	newRoom := models.Room{
+		ID:         primitive.NewObjectID(),
+		OriginalID: utils.NewUUID(),
+		OwnerID:    userID,
+		Name:       room.Name,
+		Color:      room.Color,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if name := r.FormValue("name"); name != "" {
+		newRoom.Name = name
+	}
+	roomID := newRoom.ID.Hex()
+
+	newTags := make([]interface{}, 0, len(tags))
+	tagIDs := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		newID := primitive.NewObjectID()
+		tagIDs[tag.ID.Hex()] = newID.Hex()
+		tag.ID = newID
+		tag.RoomID = roomID
+		tag.CreatedAt = now
+		tag.UpdatedAt = now
+		newTags = append(newTags, tag)
+	}
+
+	// Assign new IDs up front so parents can be remapped regardless of order
+	folderIDs := make(map[string]string, len(folders))
+	for _, folder := range folders {
+		folderIDs[folder.ID.Hex()] = primitive.NewObjectID().Hex()
+	}
+	fileIDs := make(map[string]string, len(files))
+	for _, f := range files {
+		fileIDs[f.ID.Hex()] = primitive.NewObjectID().Hex()
+	}
+
+	// Re-upload assets so the new room does not depend on the source blobs
+	assetURLs := make(map[string]string)
+	for _, asset := range manifest.Assets {
+		entry, ok := entries[asset.Path]
+		if !ok {
+			continue
+		}
+		newURL, err := uploadArchiveAsset(entry)
+		if err != nil {
+			log.Printf("Failed to upload asset %s: %v", asset.Path, err)
+			deleteUploadedAssets(assetURLs)
+			http.Error(w, "Failed to upload archive assets", http.StatusInternalServerError)
+			return
+		}
+		assetURLs[asset.SourceURL] = newURL
+	}
+
+	newFolders := make([]interface{}, 0, len(folders))
+	for _, folder := range folders {
+		newID, _ := primitive.ObjectIDFromHex(folderIDs[folder.ID.Hex()])
+		folder.ID = newID
+		folder.OriginalID = utils.NewUUID()
+		folder.RoomID = roomID
+		folder.Version = 0
+		folder.SubFolderID = remapID(folderIDs, folder.SubFolderID)
+		folder.Tags = remapTags(tagIDs, folder.Tags)
+		newFolders = append(newFolders, folder)
+	}
+
+	newPapers := make([]models.Paper, 0, len(papers))
+	for _, paper := range papers {
+		newFileID, ok := fileIDs[paper.FileID]
+		if !ok {
+			log.Printf("Skipping paper %s: file %s is not in the archive", paper.ID.Hex(), paper.FileID)
+			continue
+		}
+		paper.ID = primitive.NewObjectID()
+		paper.OriginalID = utils.NewUUID()
+		paper.RoomID = roomID
+		paper.Version = 0
+		paper.FileID = newFileID
+		// A background missing from the archive belongs to the source room, so it is dropped
+		paper.BackgroundImage = assetURLs[paper.BackgroundImage]
+		newPapers = append(newPapers, paper)
+	}
+
+	pageCounts := make(map[string]int, len(files))
+	for _, paper := range newPapers {
+		pageCounts[paper.FileID]++
+	}
+	thumbnails := firstPageThumbnails(newPapers)
+
+	newFiles := make([]interface{}, 0, len(files))
+	for _, f := range files {
+		newID, _ := primitive.ObjectIDFromHex(fileIDs[f.ID.Hex()])
+		f.ID = newID
+		f.OriginalID = utils.NewUUID()
+		f.RoomID = roomID
+		f.Version = 0
+		f.SubFolderID = remapID(folderIDs, f.SubFolderID)
+		f.Tags = remapTags(tagIDs, f.Tags)
+		f.PageCount = pageCounts[newID.Hex()]
+		f.ThumbnailURL = thumbnails[newID.Hex()]
+		f.LastEditedBy = ""
+		f.LastEditedAt = time.Time{}
+		newFiles = append(newFiles, f)
+	}
+
+	ctx := context.Background()
+	if err := insertImportedRoom(ctx, newRoom, newTags, newFolders, newFiles, newPapers); err != nil {
+		log.Printf("Room import failed: %v", err)
+		deleteUploadedAssets(assetURLs)
+		http.Error(w, "Failed to import room", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Room imported successfully",
+		"room_id": roomID,
+		"stats": map[string]int{
+			"tags":    len(newTags),
+			"folders": len(newFolders),
+			"files":   len(newFiles),
+			"papers":  len(newPapers),
+			"assets":  len(assetURLs),
+		},
+	})
+}
+
+// insertImportedRoom writes the imported documents in one transaction
+func insertImportedRoom(ctx context.Context, room models.Room, tags, folders, files []interface{}, papers []models.Paper) error {
+	return config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := config.GetRoomCollection().InsertOne(sessCtx, room); err != nil {
+			return fmt.Errorf("failed to insert room: %v", err)
+		}
+		if len(tags) > 0 {
+			if _, err := config.GetTagCollection().InsertMany(sessCtx, tags); err != nil {
+				return fmt.Errorf("failed to insert tags: %v", err)
+			}
+		}
+		if len(folders) > 0 {
+			if _, err := config.GetFolderCollection().InsertMany(sessCtx, folders); err != nil {
+				return fmt.Errorf("failed to insert folders: %v", err)
+			}
+		}
+		if len(files) > 0 {
+			if _, err := config.GetFileCollection().InsertMany(sessCtx, files); err != nil {
+				return fmt.Errorf("failed to insert files: %v", err)
+			}
+		}
+		if len(papers) > 0 {
+			if err := storeNewPaperStrokes(sessCtx, papers); err != nil {
+				return err
+			}
+			documents := make([]interface{}, 0, len(papers))
+			for _, paper := range papers {
+				documents = append(documents, paper)
+			}
+			if _, err := config.GetPaperCollection().InsertMany(sessCtx, documents); err != nil {
+				return fmt.Errorf("failed to insert papers: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// remapID returns the mapped value for id, or id unchanged when it has no mapping
+// (e.g. the root sub_folder_id)
+func remapID(ids map[string]string, id string) string {
+	if mapped, ok := ids[id]; ok {
+		return mapped
+	}
+	return id
+}
+
+// remapTags maps tag IDs to the imported tags, dropping any the archive does not have
+func remapTags(ids map[string]string, tags []string) []string {
+	var mapped []string
+	for _, tag := range tags {
+		if id, ok := ids[tag]; ok {
+			mapped = append(mapped, id)
+		}
+	}
+	return mapped
+}
+
+func assetExtension(blobURL string) string {
+	parsedURL, err := url.Parse(blobURL)
+	if err != nil {
+		return ""
+	}
+	return path.Ext(parsedURL.Path)
+}
+
+func writeArchiveJSON(archive *zip.Writer, name string, data interface{}) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(entry).Encode(data)
+}
+
+func readArchiveJSON(entries map[string]*zip.File, name string, target interface{}) error {
+	entry, ok := entries[name]
+	if !ok {
+		return fmt.Errorf("archive is missing %s", name)
+	}
+
+	reader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer reader.Close()
+
+	if err := json.NewDecoder(reader).Decode(target); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+	return nil
+}
+
+func copyAssetToArchive(archive *zip.Writer, asset models.RoomArchiveAsset) error {
+	blob, err := DownloadByURL(asset.SourceURL)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{
+		Name:   asset.Path,
+		Method: zip.Store, // images are already compressed
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, blob)
+	return err
+}
+
+func uploadArchiveAsset(entry *zip.File) (string, error) {
+	reader, err := entry.Open()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	return UploadToAzureBlob(reader, utils.NewUUID()+path.Ext(entry.Name))
+}
+
+func deleteUploadedAssets(assetURLs map[string]string) {
+	for _, blobURL := range assetURLs {
+		if err := DeleteByURL(blobURL); err != nil {
+			log.Printf("Failed to clean up uploaded asset %s: %v", blobURL, err)
+		}
+	}
+}
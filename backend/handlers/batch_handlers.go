@@ -0,0 +1,487 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/socketio"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const maxBatchOperations = 500
+
+// BatchOperation is one step of a batch. Op is "delete", "move", "copy",
+// "rename", "tag" or "untag"; the other fields are used as the op needs them.
+// ExpectedVersion guards every op except copy, which leaves the item as it is.
+type BatchOperation struct {
+	Op              string   `json:"op"`
+	ItemType        string   `json:"item_type"`
+	ItemID          string   `json:"item_id"`
+	TargetRoomID    string   `json:"target_room_id,omitempty"`
+	TargetFolderID  string   `json:"target_folder_id,omitempty"`
+	Name            string   `json:"name,omitempty"`
+	Naming          string   `json:"naming,omitempty"`
+	TagIDs          []string `json:"tag_ids,omitempty"`
+	ExpectedVersion *int64   `json:"expected_version,omitempty"`
+}
+
+// BatchResult is the outcome of one operation. Status is "ok", "error",
+// "rolled_back" (it succeeded but an atomic batch failed later) or "skipped".
+// Current is the item as it is now when the op failed on a version conflict.
+type BatchResult struct {
+	Index    int         `json:"index"`
+	Op       string      `json:"op"`
+	ItemType string      `json:"item_type"`
+	ItemID   string      `json:"item_id"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Current  interface{} `json:"current,omitempty"`
+}
+
+// batchEffects collects what the operations changed, so list updates can be
+// sent once per room and blob cleanup can wait for the commit
+type batchEffects struct {
+	folderRooms map[string]bool
+	fileRooms   map[string]bool
+	paperRooms  map[string]bool
+	pagedFiles  map[string]string // fileID -> roomID, page count or thumbnail may have changed
+	editedFiles map[string]string // fileID -> roomID
+	plans       []*deletePlan
+}
+
+func newBatchEffects() *batchEffects {
+	return &batchEffects{
+		folderRooms: make(map[string]bool),
+		fileRooms:   make(map[string]bool),
+		paperRooms:  make(map[string]bool),
+		pagedFiles:  make(map[string]string),
+		editedFiles: make(map[string]string),
+	}
+}
+
+func (e *batchEffects) merge(other *batchEffects) {
+	for roomID := range other.folderRooms {
+		e.folderRooms[roomID] = true
+	}
+	for roomID := range other.fileRooms {
+		e.fileRooms[roomID] = true
+	}
+	for roomID := range other.paperRooms {
+		e.paperRooms[roomID] = true
+	}
+	for fileID, roomID := range other.pagedFiles {
+		e.pagedFiles[fileID] = roomID
+	}
+	for fileID, roomID := range other.editedFiles {
+		e.editedFiles[fileID] = roomID
+	}
+	e.plans = append(e.plans, other.plans...)
+}
+
+// BatchItems runs a list of operations on folders, files and papers. With
+// atomic (the default) every operation runs in one transaction and nothing is
+// kept if any of them fails; otherwise each operation commits on its own.
+// Either way each affected room gets one list update at the end.
+func BatchItems(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var batchRequest struct {
+		Atomic     *bool            `json:"atomic"`
+		Operations []BatchOperation `json:"operations"`
+	}
+	if err := json.Unmarshal(body, &batchRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if len(batchRequest.Operations) == 0 {
+		http.Error(w, "operations is required", http.StatusBadRequest)
+		return
+	}
+	if len(batchRequest.Operations) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("At most %d operations per batch", maxBatchOperations), http.StatusBadRequest)
+		return
+	}
+	atomic := batchRequest.Atomic == nil || *batchRequest.Atomic
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	operations := batchRequest.Operations
+	results := make([]BatchResult, len(operations))
+	for i, op := range operations {
+		results[i] = BatchResult{Index: i, Op: op.Op, ItemType: op.ItemType, ItemID: op.ItemID, Status: "skipped"}
+	}
+
+	effects := newBatchEffects()
+	status := http.StatusOK
+	committed := true
+
+	if atomic {
+		failed := -1
+		err := config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+			// A retried transaction starts over from the first operation
+			effects = newBatchEffects()
+			for i, op := range operations {
+				result, err := runBatchOperation(sessCtx, userID, op, effects)
+				if err != nil {
+					failed = i
+					results[i].Status, results[i].Error, results[i].Result = "error", err.Error(), nil
+					results[i].Current = conflictCurrent(err)
+					return err
+				}
+				results[i].Status, results[i].Error, results[i].Result = "ok", "", result
+			}
+			failed = -1
+			return nil
+		})
+		if err != nil {
+			log.Printf("Batch of %d operations by %s rolled back: %v", len(operations), userID, err)
+			committed = false
+			status = errorStatus(err)
+			for i := range results {
+				switch {
+				case failed < 0:
+					results[i].Status, results[i].Error = "error", err.Error()
+				case i < failed:
+					results[i].Status = "rolled_back"
+				case i > failed:
+					results[i].Status = "skipped"
+				}
+				if i != failed {
+					results[i].Result = nil
+				}
+			}
+			effects = newBatchEffects()
+		}
+	} else {
+		for i, op := range operations {
+			opEffects := newBatchEffects()
+			var result interface{}
+			err := config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+				opEffects = newBatchEffects()
+				var err error
+				result, err = runBatchOperation(sessCtx, userID, op, opEffects)
+				return err
+			})
+			if err != nil {
+				results[i].Status, results[i].Error = "error", err.Error()
+				results[i].Current = conflictCurrent(err)
+				continue
+			}
+			results[i].Status, results[i].Result = "ok", result
+			effects.merge(opEffects)
+		}
+	}
+
+	applyBatchEffects(ctx, userID, effects)
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Status == "ok" {
+			succeeded++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"atomic":    atomic,
+		"committed": committed,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+// runBatchOperation performs one operation in the caller's transaction and
+// records its effects
+func runBatchOperation(sessCtx mongo.SessionContext, userID string, op BatchOperation, effects *batchEffects) (interface{}, error) {
+	switch op.Op {
+	case "delete":
+		return batchDelete(sessCtx, userID, op, effects)
+
+	case "move":
+		result, err := moveItem(sessCtx, userID, MoveRequest{
+			ItemType:        op.ItemType,
+			ItemID:          op.ItemID,
+			TargetRoomID:    op.TargetRoomID,
+			TargetFolderID:  op.TargetFolderID,
+			ExpectedVersion: op.ExpectedVersion,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, roomID := range []string{result.SourceRoomID, result.RoomID} {
+			effects.folderRooms[roomID] = effects.folderRooms[roomID] || op.ItemType == models.StarFolder
+			effects.fileRooms[roomID] = true
+		}
+		return result, nil
+
+	case "copy":
+		result, err := copyItem(sessCtx, userID, CopyRequest{
+			ItemType:       op.ItemType,
+			ItemID:         op.ItemID,
+			TargetRoomID:   op.TargetRoomID,
+			TargetFolderID: op.TargetFolderID,
+			Name:           op.Name,
+			Naming:         op.Naming,
+		})
+		if err != nil {
+			return nil, err
+		}
+		effects.folderRooms[result.RoomID] = effects.folderRooms[result.RoomID] || op.ItemType == models.StarFolder
+		effects.fileRooms[result.RoomID] = true
+		return result, nil
+
+	case "rename":
+		roomID, version, err := renameItem(sessCtx, userID, op.ItemType, op.ItemID, op.Name, op.ExpectedVersion)
+		if err != nil {
+			return nil, err
+		}
+		if op.ItemType == models.StarFolder {
+			effects.folderRooms[roomID] = true
+		} else {
+			effects.fileRooms[roomID] = true
+		}
+		return map[string]interface{}{"name": strings.TrimSpace(op.Name), "version": version}, nil
+
+	case "tag", "untag":
+		roomID, err := itemRoomID(sessCtx, op.ItemType, op.ItemID)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPermission(sessCtx, userID, roomID, models.PermEdit); err != nil {
+			return nil, err
+		}
+		if err := checkOpVersion(sessCtx, op); err != nil {
+			return nil, err
+		}
+		folders, files, err := applyTags(sessCtx, roomID, op.TagIDs, []ItemRef{{ItemType: op.ItemType, ItemID: op.ItemID}}, op.Op == "tag")
+		if err != nil {
+			return nil, err
+		}
+		effects.folderRooms[roomID] = effects.folderRooms[roomID] || folders > 0
+		effects.fileRooms[roomID] = effects.fileRooms[roomID] || files > 0
+		return map[string]int64{"folders_updated": folders, "files_updated": files}, nil
+	}
+
+	return nil, newRequestError(http.StatusBadRequest, fmt.Sprintf("Unknown op: %s", op.Op))
+}
+
+// batchDelete deletes a folder or file with everything below it, or a paper
+func batchDelete(sessCtx mongo.SessionContext, userID string, op BatchOperation, effects *batchEffects) (interface{}, error) {
+	roomID, err := itemRoomID(sessCtx, op.ItemType, op.ItemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPermission(sessCtx, userID, roomID, models.PermDelete); err != nil {
+		return nil, err
+	}
+	if err := checkOpVersion(sessCtx, op); err != nil {
+		return nil, err
+	}
+	objID, _ := primitive.ObjectIDFromHex(op.ItemID)
+
+	var plan *deletePlan
+	switch op.ItemType {
+	case models.StarFolder:
+		plan, err = planFolderDelete(sessCtx, models.Folder{ID: objID})
+	case models.StarFile:
+		plan, err = planFileDelete(sessCtx, models.File{ID: objID})
+	case models.StarPaper:
+		return batchDeletePaper(sessCtx, userID, objID, effects)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := plan.deleteIn(sessCtx)
+	if err != nil {
+		return nil, err
+	}
+	effects.plans = append(effects.plans, plan)
+	effects.folderRooms[roomID] = effects.folderRooms[roomID] || stats.Folders > 0
+	effects.fileRooms[roomID] = true
+	return DeletionStats{Folders: stats.Folders, Files: stats.Files, Papers: stats.Papers}, nil
+}
+
+// batchDeletePaper deletes a paper and closes the gap in its file's page numbers
+func batchDeletePaper(sessCtx mongo.SessionContext, userID string, objID primitive.ObjectID, effects *batchEffects) (interface{}, error) {
+	paperCollection := config.GetPaperCollection()
+
+	var paper models.Paper
+	if err := paperCollection.FindOne(sessCtx, bson.M{"_id": objID}).Decode(&paper); err != nil {
+		return nil, newRequestError(http.StatusNotFound, "Paper not found")
+	}
+
+	if _, err := paperCollection.DeleteOne(sessCtx, bson.M{"_id": objID}); err != nil {
+		return nil, fmt.Errorf("failed to delete paper: %v", err)
+	}
+	if err := deleteStrokeChunks(sessCtx, []string{objID.Hex()}); err != nil {
+		return nil, err
+	}
+	if err := deletePaperHistory(sessCtx, []string{objID.Hex()}); err != nil {
+		return nil, err
+	}
+	if err := deletePaperThumbnails(sessCtx, []string{objID.Hex()}); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"file_id": paper.FileID, "page_number": bson.M{"$gt": paper.PageNumber}}
+	if _, err := paperCollection.UpdateMany(sessCtx, filter, bumpVersion(bson.M{"$inc": bson.M{"page_number": -1}})); err != nil {
+		return nil, fmt.Errorf("failed to update page numbers: %v", err)
+	}
+
+	starFilter := bson.M{"item_type": models.StarPaper, "item_id": objID.Hex()}
+	if _, err := config.GetStarredCollection().DeleteMany(sessCtx, starFilter); err != nil {
+		return nil, fmt.Errorf("failed to delete stars: %v", err)
+	}
+
+	if paper.BackgroundImage != "" {
+		effects.plans = append(effects.plans, &deletePlan{backgroundURLs: []string{paper.BackgroundImage}})
+	}
+	effects.paperRooms[paper.RoomID] = true
+	effects.pagedFiles[paper.FileID] = paper.RoomID
+	effects.editedFiles[paper.FileID] = paper.RoomID
+	return DeletionStats{Papers: 1}, nil
+}
+
+// renameItem renames a folder or file and returns its room and new version
+func renameItem(ctx context.Context, userID, itemType, itemID, name string, expected *int64) (string, int64, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", 0, newRequestError(http.StatusBadRequest, "name is required")
+	}
+	if itemType != models.StarFolder && itemType != models.StarFile {
+		return "", 0, newRequestError(http.StatusBadRequest, "item_type must be 'folder' or 'file'")
+	}
+
+	roomID, err := itemRoomID(ctx, itemType, itemID)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := checkPermission(ctx, userID, roomID, models.PermEdit); err != nil {
+		return "", 0, err
+	}
+
+	objID, _ := primitive.ObjectIDFromHex(itemID)
+	collection, current := versionedCollection(itemType)
+	update := bson.M{"$set": bson.M{"name": name, "updatedAt": time.Now()}}
+	version, err := updateVersioned(ctx, collection, itemType, objID, update, expected, current)
+	if err != nil {
+		return "", 0, err
+	}
+	return roomID, version, nil
+}
+
+// checkOpVersion fails an op whose item is no longer at its expected version
+func checkOpVersion(ctx context.Context, op BatchOperation) error {
+	if op.ExpectedVersion == nil {
+		return nil
+	}
+	objID, _ := primitive.ObjectIDFromHex(op.ItemID)
+	collection, current := versionedCollection(op.ItemType)
+	return checkVersion(ctx, collection, op.ItemType, objID, op.ExpectedVersion, current)
+}
+
+// conflictCurrent returns the current item of a version conflict, or nil
+func conflictCurrent(err error) interface{} {
+	if conflict, ok := err.(*versionConflict); ok {
+		return conflict.Current
+	}
+	return nil
+}
+
+// itemRoomID returns the room of a folder, file or paper
+func itemRoomID(ctx context.Context, itemType, itemID string) (string, error) {
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return "", newRequestError(http.StatusBadRequest, "Invalid item ID format")
+	}
+
+	var collection *mongo.Collection
+	switch itemType {
+	case models.StarFolder:
+		collection = config.GetFolderCollection()
+	case models.StarFile:
+		collection = config.GetFileCollection()
+	case models.StarPaper:
+		collection = config.GetPaperCollection()
+	default:
+		return "", newRequestError(http.StatusBadRequest, "item_type must be 'folder', 'file' or 'paper'")
+	}
+
+	var item struct {
+		RoomID string `bson:"room_id"`
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return "", newRequestError(http.StatusNotFound, fmt.Sprintf("%s not found", itemType))
+	}
+	return item.RoomID, nil
+}
+
+// applyBatchEffects sends one update per changed list and room, and does the
+// work that has to wait for the commit
+func applyBatchEffects(ctx context.Context, userID string, effects *batchEffects) {
+	for _, plan := range effects.plans {
+		plan.cleanupBlobs(ctx)
+	}
+	for fileID, roomID := range effects.editedFiles {
+		touchFile(ctx, roomID, fileID, userID)
+	}
+	for fileID, roomID := range effects.pagedFiles {
+		refreshFileMetadata(ctx, roomID, fileID)
+	}
+
+	for roomID := range effects.folderRooms {
+		if effects.folderRooms[roomID] {
+			broadcastFolderList(ctx, roomID)
+		}
+	}
+	for roomID := range effects.fileRooms {
+		if effects.fileRooms[roomID] {
+			broadcastFileList(ctx, roomID)
+		}
+	}
+
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		return
+	}
+	for roomID := range effects.paperRooms {
+		papers, err := findPapers(ctx, bson.M{"room_id": roomID})
+		if err != nil {
+			log.Printf("Error fetching papers of room %s: %v", roomID, err)
+			continue
+		}
+
+		socketServer.BroadcastToRoom("", roomID, "paper_list_updated", map[string]interface{}{
+			"roomID": roomID,
+			"papers": papers,
+		})
+	}
+}
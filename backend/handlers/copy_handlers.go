@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CopyRequest duplicates a folder (recursively) or a file. TargetRoomID and
+// TargetFolderID default to the item's current location. Name overrides the
+// name of the copy; otherwise Naming "keep" reuses the original name and the
+// default appends " (copy)", numbered if that name is taken.
+type CopyRequest struct {
+	ItemType       string `json:"item_type"`
+	ItemID         string `json:"item_id"`
+	TargetRoomID   string `json:"target_room_id"`
+	TargetFolderID string `json:"target_folder_id"`
+	Name           string `json:"name"`
+	Naming         string `json:"naming"`
+}
+
+// CopyResult describes a completed copy
+type CopyResult struct {
+	ItemType    string `json:"item_type"`
+	ItemID      string `json:"item_id"`
+	RoomID      string `json:"room_id"`
+	SubFolderID string `json:"sub_folder_id"`
+	Name        string `json:"name"`
+	Folders     int    `json:"folders"`
+	Files       int    `json:"files"`
+	Papers      int    `json:"papers"`
+}
+
+// CopyItem duplicates a folder or file with all papers, strokes, text and
+// background references. The copy is written in a single transaction.
+func CopyItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var copyRequest CopyRequest
+	if err := json.Unmarshal(body, &copyRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	result, err := copyItem(ctx, userID, copyRequest)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	broadcastFolderList(ctx, result.RoomID)
+	broadcastFileList(ctx, result.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Item copied successfully",
+		"result":  result,
+	})
+}
+
+// copyItem performs a copy without broadcasting so callers can coalesce list updates
+func copyItem(ctx context.Context, userID string, req CopyRequest) (CopyResult, error) {
+	result := CopyResult{ItemType: req.ItemType}
+
+	objID, err := primitive.ObjectIDFromHex(req.ItemID)
+	if err != nil {
+		return result, newRequestError(http.StatusBadRequest, "Invalid item ID format")
+	}
+
+	var source struct {
+		RoomID      string `bson:"room_id"`
+		SubFolderID string `bson:"sub_folder_id"`
+		Name        string `bson:"name"`
+	}
+	var collection *mongo.Collection
+	switch req.ItemType {
+	case models.StarFolder:
+		collection = config.GetFolderCollection()
+	case models.StarFile:
+		collection = config.GetFileCollection()
+	default:
+		return result, newRequestError(http.StatusBadRequest, "item_type must be 'folder' or 'file'")
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&source); err != nil {
+		return result, newRequestError(http.StatusNotFound, fmt.Sprintf("%s not found", req.ItemType))
+	}
+
+	result.RoomID = req.TargetRoomID
+	if result.RoomID == "" {
+		result.RoomID = source.RoomID
+	}
+	result.SubFolderID = req.TargetFolderID
+	if result.SubFolderID == "" {
+		result.SubFolderID = source.SubFolderID
+	}
+	if isRootFolder(result.SubFolderID) {
+		result.SubFolderID = models.RootFolderID
+	}
+
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, source.RoomID); err != nil {
+		return result, newRequestError(http.StatusForbidden, "Access denied")
+	}
+	if err := checkPermission(ctx, userID, result.RoomID, models.PermCreate); err != nil {
+		return result, err
+	}
+
+	if !isRootFolder(result.SubFolderID) {
+		targetObjID, err := primitive.ObjectIDFromHex(result.SubFolderID)
+		if err != nil {
+			return result, newRequestError(http.StatusBadRequest, "Invalid target folder ID format")
+		}
+
+		var target models.Folder
+		if err := config.GetFolderCollection().FindOne(ctx, bson.M{"_id": targetObjID}).Decode(&target); err != nil {
+			return result, newRequestError(http.StatusNotFound, "Target folder not found")
+		}
+		if target.RoomID != result.RoomID {
+			return result, newRequestError(http.StatusBadRequest, "Target folder is not in the target room")
+		}
+
+		// Copying a folder into its own subtree would copy forever
+		if req.ItemType == models.StarFolder {
+			for _, ancestor := range resolveFolderPath(ctx, result.SubFolderID) {
+				if ancestor.ID == req.ItemID {
+					return result, newRequestError(http.StatusConflict, "Cannot copy a folder into its own subtree")
+				}
+			}
+		}
+	}
+
+	switch {
+	case req.Name != "":
+		result.Name = req.Name
+	case req.Naming == "keep":
+		result.Name = source.Name
+	default:
+		result.Name, err = copyName(ctx, collection, result.RoomID, result.SubFolderID, source.Name)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	position, err := nextPosition(ctx, collection, result.RoomID, result.SubFolderID)
+	if err != nil {
+		return result, newRequestError(http.StatusInternalServerError, "Failed to compute position")
+	}
+
+	var folderIDs, fileIDs []string
+	if req.ItemType == models.StarFolder {
+		folderIDs, err = collectFolderSubtree(ctx, req.ItemID)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		fileIDs = []string{req.ItemID}
+	}
+
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		counts := CopyResult{}
+		newIDs := make(map[string]string)
+
+		if len(folderIDs) > 0 {
+			var folders []models.Folder
+			cursor, err := config.GetFolderCollection().Find(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(folderIDs)}})
+			if err != nil {
+				return fmt.Errorf("failed to query folders: %v", err)
+			}
+			if err := cursor.All(sessCtx, &folders); err != nil {
+				return fmt.Errorf("failed to decode folders: %v", err)
+			}
+
+			for _, folder := range folders {
+				newIDs[folder.ID.Hex()] = primitive.NewObjectID().Hex()
+			}
+
+			copies := make([]interface{}, 0, len(folders))
+			for _, folder := range folders {
+				copied := folder
+				copied.ID, _ = primitive.ObjectIDFromHex(newIDs[folder.ID.Hex()])
+				copied.OriginalID = utils.NewUUID()
+				copied.RoomID = result.RoomID
+				copied.Version = 0
+				copied.SubFolderID = remapID(newIDs, folder.SubFolderID)
+				if result.RoomID != source.RoomID {
+					copied.Tags = nil
+				}
+				copied.CreatedAt = time.Now()
+				copied.UpdatedAt = time.Now()
+				if folder.ID.Hex() == req.ItemID {
+					copied.SubFolderID = result.SubFolderID
+					copied.Name = result.Name
+					copied.Position = position
+				}
+				copies = append(copies, copied)
+			}
+
+			if _, err := config.GetFolderCollection().InsertMany(sessCtx, copies); err != nil {
+				return fmt.Errorf("failed to insert folders: %v", err)
+			}
+			counts.Folders = len(copies)
+
+			fileCursor, err := config.GetFileCollection().Find(sessCtx, bson.M{"sub_folder_id": bson.M{"$in": folderIDs}})
+			if err != nil {
+				return fmt.Errorf("failed to query files: %v", err)
+			}
+			var files []struct {
+				ID primitive.ObjectID `bson:"_id"`
+			}
+			if err := fileCursor.All(sessCtx, &files); err != nil {
+				return fmt.Errorf("failed to decode files: %v", err)
+			}
+			fileIDs = fileIDs[:0]
+			for _, file := range files {
+				fileIDs = append(fileIDs, file.ID.Hex())
+			}
+		}
+
+		if len(fileIDs) > 0 {
+			var files []models.File
+			cursor, err := config.GetFileCollection().Find(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(fileIDs)}})
+			if err != nil {
+				return fmt.Errorf("failed to query files: %v", err)
+			}
+			if err := cursor.All(sessCtx, &files); err != nil {
+				return fmt.Errorf("failed to decode files: %v", err)
+			}
+
+			copies := make([]models.File, 0, len(files))
+			for _, file := range files {
+				newIDs[file.ID.Hex()] = primitive.NewObjectID().Hex()
+
+				copied := file
+				copied.ID, _ = primitive.ObjectIDFromHex(newIDs[file.ID.Hex()])
+				copied.OriginalID = utils.NewUUID()
+				copied.RoomID = result.RoomID
+				copied.Version = 0
+				copied.SubFolderID = remapID(newIDs, file.SubFolderID)
+				if result.RoomID != source.RoomID {
+					copied.Tags = nil
+				}
+				copied.CreatedAt = time.Now()
+				copied.UpdatedAt = time.Now()
+				if file.ID.Hex() == req.ItemID {
+					copied.SubFolderID = result.SubFolderID
+					copied.Name = result.Name
+					copied.Position = position
+				}
+				copies = append(copies, copied)
+			}
+
+			papers, err := findPapers(sessCtx, bson.M{"file_id": bson.M{"$in": fileIDs}})
+			if err != nil {
+				return fmt.Errorf("failed to query papers: %v", err)
+			}
+
+			// Background images are shared with the original, not re-uploaded
+			paperCopies := make([]models.Paper, 0, len(papers))
+			for _, paper := range papers {
+				copied := paper
+				copied.ID = primitive.NewObjectID()
+				copied.OriginalID = utils.NewUUID()
+				copied.RoomID = result.RoomID
+				copied.Version = 0
+				copied.FileID = remapID(newIDs, paper.FileID)
+				copied.CreatedAt = time.Now()
+				copied.UpdatedAt = time.Now()
+				paperCopies = append(paperCopies, copied)
+			}
+
+			// The thumbnails of the originals show their own papers
+			thumbnails := firstPageThumbnails(paperCopies)
+			fileDocuments := make([]interface{}, 0, len(copies))
+			for _, copied := range copies {
+				copied.ThumbnailURL = thumbnails[copied.ID.Hex()]
+				fileDocuments = append(fileDocuments, copied)
+			}
+			if _, err := config.GetFileCollection().InsertMany(sessCtx, fileDocuments); err != nil {
+				return fmt.Errorf("failed to insert files: %v", err)
+			}
+			counts.Files = len(copies)
+
+			if len(paperCopies) > 0 {
+				if err := storeNewPaperStrokes(sessCtx, paperCopies); err != nil {
+					return err
+				}
+				documents := make([]interface{}, 0, len(paperCopies))
+				for _, paper := range paperCopies {
+					documents = append(documents, paper)
+				}
+				if _, err := config.GetPaperCollection().InsertMany(sessCtx, documents); err != nil {
+					return fmt.Errorf("failed to insert papers: %v", err)
+				}
+			}
+			counts.Papers = len(paperCopies)
+		}
+
+		result.ItemID = newIDs[req.ItemID]
+		result.Folders = counts.Folders
+		result.Files = counts.Files
+		result.Papers = counts.Papers
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error copying %s %s: %v", req.ItemType, req.ItemID, err)
+		return result, newRequestError(http.StatusInternalServerError, "Failed to copy item")
+	}
+
+	return result, nil
+}
+
+// copyName returns "<name> (copy)", or "<name> (copy N)" when that name is
+// already used in the target folder
+func copyName(ctx context.Context, collection *mongo.Collection, roomID, parentID, name string) (string, error) {
+	for n := 1; n <= 100; n++ {
+		candidate := fmt.Sprintf("%s (copy)", name)
+		if n > 1 {
+			candidate = fmt.Sprintf("%s (copy %d)", name, n)
+		}
+
+		filter := bson.M{"room_id": roomID, "sub_folder_id": parentID, "name": candidate}
+		count, err := collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return "", fmt.Errorf("failed to check existing names: %v", err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return fmt.Sprintf("%s (copy %s)", name, time.Now().Format("20060102150405")), nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// connectTestDB points the collections at a scratch database on the MongoDB
+// deployment in MONGODB_TEST_URI and drops it when the test ends. The
+// deployment must be a replica set, as deletes run in transactions. Tests
+// that need a database are skipped without it.
+func connectTestDB(t *testing.T) context.Context {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI is not set")
+	}
+
+	ctx := context.Background()
+	database := "Roomlen_test_" + primitive.NewObjectID().Hex()
+	connectCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := config.Connect(connectCtx, uri, database); err != nil {
+		t.Fatalf("Failed to connect to %s: %v", uri, err)
+	}
+	t.Cleanup(func() {
+		config.GetUserCollection().Database().Drop(ctx)
+	})
+	return ctx
+}
+
+// insertTestRoom creates a room owned by ownerID and returns its ID
+func insertTestRoom(t *testing.T, ctx context.Context, ownerID string) string {
+	t.Helper()
+	room := models.Room{ID: primitive.NewObjectID(), OwnerID: ownerID, Name: "room", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if _, err := config.GetRoomCollection().InsertOne(ctx, room); err != nil {
+		t.Fatalf("Failed to insert room: %v", err)
+	}
+	return room.ID.Hex()
+}
+
+// insertTestMember gives userID a role in the room
+func insertTestMember(t *testing.T, ctx context.Context, roomID, userID, roleID string) {
+	t.Helper()
+	member := models.RoomMembers{RoomID: roomID, SharedWith: userID, RoleID: roleID, JoinAt: time.Now()}
+	if _, err := config.GetRoomMemberCollection().InsertOne(ctx, member); err != nil {
+		t.Fatalf("Failed to insert room member: %v", err)
+	}
+}
+
+// serveAs calls handler with body encoded as JSON, authenticated as userID
+func serveAs(handler http.HandlerFunc, userID, method string, body interface{}) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	r := httptest.NewRequest(method, "/", bytes.NewReader(encoded))
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString(utils.SECRET_KEY)
+	r.Header.Set("Authorization", "Bearer "+token)
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeleteItem names a folder or file a cascading delete would remove
+type DeleteItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// DeletePreview is what a cascading delete removes, returned for dry runs
+type DeletePreview struct {
+	Rooms   int64        `json:"rooms"`
+	Folders []DeleteItem `json:"folders"`
+	Files   []DeleteItem `json:"files"`
+	Papers  int64        `json:"papers"`
+}
+
+// deletePlan collects everything below a room, folder or file before any of
+// it is deleted, so the delete itself can run in one transaction
+type deletePlan struct {
+	room   *models.Room
+	folder *models.Folder
+	file   *models.File
+
+	// expected is the version the room, folder or file must still be at
+	expected *int64
+
+	folders        []DeleteItem
+	files          []DeleteItem
+	paperIDs       []string
+	backgroundURLs []string
+}
+
+// planRoomDelete collects every folder, file and paper of a room
+func planRoomDelete(ctx context.Context, room models.Room) (*deletePlan, error) {
+	plan := &deletePlan{room: &room}
+	if err := plan.collect(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planFolderDelete collects a folder with every sub-folder, file and paper below it
+func planFolderDelete(ctx context.Context, folder models.Folder) (*deletePlan, error) {
+	plan := &deletePlan{folder: &folder}
+	if err := plan.collect(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// planFileDelete collects a file and its papers
+func planFileDelete(ctx context.Context, file models.File) (*deletePlan, error) {
+	plan := &deletePlan{file: &file}
+	if err := plan.collect(ctx); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// collect fills the plan with what is below its room, folder or file now,
+// replacing whatever an earlier collect found
+func (plan *deletePlan) collect(ctx context.Context) error {
+	plan.folders, plan.files, plan.paperIDs, plan.backgroundURLs = nil, nil, nil, nil
+
+	switch {
+	case plan.room != nil:
+		if err := plan.addFolders(ctx, bson.M{"room_id": plan.room.ID.Hex()}); err != nil {
+			return err
+		}
+		return plan.addFiles(ctx, bson.M{"room_id": plan.room.ID.Hex()})
+
+	case plan.folder != nil:
+		folderIDs, err := collectFolderSubtree(ctx, plan.folder.ID.Hex())
+		if err != nil {
+			return err
+		}
+		if err := plan.addFolders(ctx, bson.M{"_id": bson.M{"$in": toObjectIDs(folderIDs)}}); err != nil {
+			return err
+		}
+		return plan.addFiles(ctx, bson.M{"sub_folder_id": bson.M{"$in": folderIDs}})
+
+	case plan.file != nil:
+		return plan.addFiles(ctx, bson.M{"_id": plan.file.ID})
+	}
+	return nil
+}
+
+// checkVersion returns a versionConflict when the room, folder or file of the
+// plan is no longer at the expected version
+func (plan *deletePlan) checkVersion(ctx context.Context) error {
+	switch {
+	case plan.room != nil:
+		return checkVersion(ctx, config.GetRoomCollection(), models.StarRoom, plan.room.ID, plan.expected, &models.Room{})
+	case plan.folder != nil:
+		return checkVersion(ctx, config.GetFolderCollection(), models.StarFolder, plan.folder.ID, plan.expected, &models.Folder{})
+	case plan.file != nil:
+		return checkVersion(ctx, config.GetFileCollection(), models.StarFile, plan.file.ID, plan.expected, &models.File{})
+	}
+	return nil
+}
+
+// addFolders adds the folders matching filter to the plan
+func (plan *deletePlan) addFolders(ctx context.Context, filter bson.M) error {
+	var folders []models.Folder
+	cursor, err := config.GetFolderCollection().Find(ctx, filter, nameOnly())
+	if err != nil {
+		return fmt.Errorf("failed to query folders: %v", err)
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return fmt.Errorf("failed to decode folders: %v", err)
+	}
+	for _, folder := range folders {
+		plan.folders = append(plan.folders, DeleteItem{ID: folder.ID.Hex(), Name: folder.Name})
+	}
+	return nil
+}
+
+// addFiles adds the files matching filter and all of their papers to the plan
+func (plan *deletePlan) addFiles(ctx context.Context, filter bson.M) error {
+	var files []models.File
+	cursor, err := config.GetFileCollection().Find(ctx, filter, nameOnly())
+	if err != nil {
+		return fmt.Errorf("failed to query files: %v", err)
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return fmt.Errorf("failed to decode files: %v", err)
+	}
+
+	fileIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID.Hex())
+		plan.files = append(plan.files, DeleteItem{ID: file.ID.Hex(), Name: file.Name})
+	}
+	if len(fileIDs) == 0 {
+		return nil
+	}
+
+	// Strokes can be large and are not needed to delete a paper
+	var papers []struct {
+		ID              primitive.ObjectID `bson:"_id"`
+		BackgroundImage string             `bson:"background_image"`
+	}
+	paperOptions := options.Find().SetProjection(bson.M{"background_image": 1})
+	cursor, err = config.GetPaperCollection().Find(ctx, bson.M{"file_id": bson.M{"$in": fileIDs}}, paperOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query papers: %v", err)
+	}
+	if err := cursor.All(ctx, &papers); err != nil {
+		return fmt.Errorf("failed to decode papers: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, paper := range papers {
+		plan.paperIDs = append(plan.paperIDs, paper.ID.Hex())
+		if paper.BackgroundImage != "" && !seen[paper.BackgroundImage] {
+			seen[paper.BackgroundImage] = true
+			plan.backgroundURLs = append(plan.backgroundURLs, paper.BackgroundImage)
+		}
+	}
+
+	// Versions of the files go with them, and may be all that is left
+	// referring to the background of a page deleted earlier
+	snapshotBackgrounds, err := config.GetPaperSnapshotCollection().Distinct(ctx, "background_image", bson.M{"file_id": bson.M{"$in": fileIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to query version backgrounds: %v", err)
+	}
+	for _, value := range snapshotBackgrounds {
+		if blobURL, ok := value.(string); ok && blobURL != "" && !seen[blobURL] {
+			seen[blobURL] = true
+			plan.backgroundURLs = append(plan.backgroundURLs, blobURL)
+		}
+	}
+	return nil
+}
+
+// preview describes the plan without deleting anything
+func (plan *deletePlan) preview() DeletePreview {
+	preview := DeletePreview{
+		Folders: plan.folders,
+		Files:   plan.files,
+		Papers:  int64(len(plan.paperIDs)),
+	}
+	if preview.Folders == nil {
+		preview.Folders = []DeleteItem{}
+	}
+	if preview.Files == nil {
+		preview.Files = []DeleteItem{}
+	}
+	if plan.room != nil {
+		preview.Rooms = 1
+	}
+	return preview
+}
+
+// execute deletes everything in the plan in one transaction, together with
+// the stars, tags, activity and grants that refer to it. The version and the
+// plan are checked again in the transaction, so a change made since planning
+// fails the delete with a conflict, and folders, files and papers added since
+// go too. Background images are removed from blob storage only after the
+// transaction has committed.
+func (plan *deletePlan) execute(ctx context.Context) (DeletionRoomStats, error) {
+	var stats DeletionRoomStats
+	err := config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if err := plan.checkVersion(sessCtx); err != nil {
+			return err
+		}
+		if err := plan.collect(sessCtx); err != nil {
+			return err
+		}
+		var err error
+		stats, err = plan.deleteIn(sessCtx)
+		return err
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	plan.cleanupBlobs(ctx)
+	return stats, nil
+}
+
+// deleteIn runs the database part of the plan in the caller's transaction. It
+// may be called again when the transaction is retried.
+func (plan *deletePlan) deleteIn(sessCtx mongo.SessionContext) (DeletionRoomStats, error) {
+	stats := DeletionRoomStats{}
+	folderIDs := deleteItemIDs(plan.folders)
+	fileIDs := deleteItemIDs(plan.files)
+
+	paperResult, err := config.GetPaperCollection().DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(plan.paperIDs)}})
+	if err != nil {
+		return stats, fmt.Errorf("failed to delete papers: %v", err)
+	}
+	stats.Papers = paperResult.DeletedCount
+	if err := deleteStrokeChunks(sessCtx, plan.paperIDs); err != nil {
+		return stats, err
+	}
+	if err := deletePaperHistory(sessCtx, plan.paperIDs); err != nil {
+		return stats, err
+	}
+	if err := deletePaperThumbnails(sessCtx, plan.paperIDs); err != nil {
+		return stats, err
+	}
+
+	fileResult, err := config.GetFileCollection().DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(fileIDs)}})
+	if err != nil {
+		return stats, fmt.Errorf("failed to delete files: %v", err)
+	}
+	stats.Files = fileResult.DeletedCount
+
+	folderResult, err := config.GetFolderCollection().DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(folderIDs)}})
+	if err != nil {
+		return stats, fmt.Errorf("failed to delete folders: %v", err)
+	}
+	stats.Folders = folderResult.DeletedCount
+
+	if plan.room == nil && stats.Folders+stats.Files == 0 {
+		return stats, fmt.Errorf("item not found during deletion")
+	}
+
+	for itemType, ids := range map[string][]string{
+		models.StarFolder: folderIDs,
+		models.StarFile:   fileIDs,
+		models.StarPaper:  plan.paperIDs,
+	} {
+		if len(ids) == 0 {
+			continue
+		}
+		starFilter := bson.M{"item_type": itemType, "item_id": bson.M{"$in": ids}}
+		if _, err := config.GetStarredCollection().DeleteMany(sessCtx, starFilter); err != nil {
+			return stats, fmt.Errorf("failed to delete stars: %v", err)
+		}
+	}
+
+	if len(fileIDs) > 0 {
+		if _, err := config.GetFileActivityCollection().DeleteMany(sessCtx, bson.M{"file_id": bson.M{"$in": fileIDs}}); err != nil {
+			return stats, fmt.Errorf("failed to delete file activity: %v", err)
+		}
+		if _, err := deleteVersions(sessCtx, bson.M{"file_id": bson.M{"$in": fileIDs}}); err != nil {
+			return stats, err
+		}
+	}
+
+	if plan.room != nil {
+		return stats, plan.deleteRoom(sessCtx, &stats)
+	}
+	return stats, nil
+}
+
+// cleanupBlobs removes background images no paper refers to any more. It must
+// run after the transaction that deleted the papers has committed.
+func (plan *deletePlan) cleanupBlobs(ctx context.Context) {
+	// Copies share background images, so a blob goes only with its last paper
+	for _, blobURL := range plan.backgroundURLs {
+		deleteUnreferencedBlob(ctx, blobURL)
+	}
+}
+
+// deleteRoom removes the room document and everything that refers to the room
+func (plan *deletePlan) deleteRoom(sessCtx mongo.SessionContext, stats *DeletionRoomStats) error {
+	roomID := plan.room.ID.Hex()
+
+	roomResult, err := config.GetRoomCollection().DeleteOne(sessCtx, bson.M{"_id": plan.room.ID})
+	if err != nil {
+		return fmt.Errorf("failed to delete room: %v", err)
+	}
+	if roomResult.DeletedCount == 0 {
+		return fmt.Errorf("room not found during deletion")
+	}
+	stats.Rooms = roomResult.DeletedCount
+
+	// Members, group grants, custom roles, favorites, stars, tags and activity
+	for name, collection := range map[string]*mongo.Collection{
+		"room members":  config.GetRoomMemberCollection(),
+		"group grants":  config.GetRoomGroupCollection(),
+		"custom roles":  config.GetRoleCollection(),
+		"favorites":     config.GetFavoriteCollection(),
+		"stars":         config.GetStarredCollection(),
+		"tags":          config.GetTagCollection(),
+		"file activity": config.GetFileActivityCollection(),
+	} {
+		if _, err := collection.DeleteMany(sessCtx, bson.M{"room_id": roomID}); err != nil {
+			return fmt.Errorf("failed to delete %s: %v", name, err)
+		}
+	}
+
+	log.Printf("Deleted room %s with %d folders, %d files and %d papers", plan.room.Name, stats.Folders, stats.Files, stats.Papers)
+	return nil
+}
+
+func deleteItemIDs(items []DeleteItem) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	return ids
+}
+
+func nameOnly() *options.FindOptions {
+	return options.Find().SetProjection(bson.M{"name": 1})
+}
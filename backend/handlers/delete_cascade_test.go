@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestDeleteRoomCascades(t *testing.T) {
+	ctx := connectTestDB(t)
+	owner, member := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	roomID := insertTestRoom(t, ctx, owner)
+	otherRoomID := insertTestRoom(t, ctx, owner)
+	insertTestMember(t, ctx, roomID, member, models.RoleWrite)
+	fileID := insertTestFile(t, ctx, roomID)
+	otherFileID := insertTestFile(t, ctx, otherRoomID)
+
+	folder := models.Folder{ID: primitive.NewObjectID(), RoomID: roomID, Name: "folder"}
+	paper := models.Paper{ID: primitive.NewObjectID(), RoomID: roomID, FileID: fileID, PageNumber: 1}
+	version := models.FileVersion{ID: primitive.NewObjectID(), FileID: fileID, Kind: models.VersionNamed, CreatedAt: time.Now()}
+	snapshot := models.PaperSnapshot{VersionID: version.ID.Hex(), PaperID: paper.ID.Hex(),
+		Paper: models.Paper{ID: primitive.NewObjectID(), RoomID: roomID, FileID: fileID}}
+	inserts := []struct {
+		collection *mongo.Collection
+		document   interface{}
+	}{
+		{config.GetFolderCollection(), folder},
+		{config.GetPaperCollection(), paper},
+		{config.GetFileVersionCollection(), version},
+		{config.GetPaperSnapshotCollection(), snapshot},
+		{config.GetPaperStrokeCollection(), bson.M{"paper_id": paper.ID.Hex()}},
+		{config.GetPaperHistoryCollection(), bson.M{"paper_id": paper.ID.Hex()}},
+		{config.GetPaperThumbnailCollection(), bson.M{"paper_id": paper.ID.Hex()}},
+		{config.GetFileActivityCollection(), models.FileActivity{UserID: member, FileID: fileID, RoomID: roomID}},
+		{config.GetStarredCollection(), models.StarredItem{UserID: member, ItemType: models.StarFile, ItemID: fileID, RoomID: roomID}},
+		// Nothing of another room may go
+		{config.GetFileVersionCollection(), models.FileVersion{ID: primitive.NewObjectID(), FileID: otherFileID}},
+	}
+	for _, insert := range inserts {
+		if _, err := insert.collection.InsertOne(ctx, insert.document); err != nil {
+			t.Fatalf("Failed to insert into %s: %v", insert.collection.Name(), err)
+		}
+	}
+
+	w := serveAs(DeleteRoom, owner, http.MethodDelete, map[string]string{"room_id": roomID})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), http.StatusOK)
+	}
+
+	remaining := []struct {
+		collection *mongo.Collection
+		filter     bson.M
+		want       int64
+	}{
+		{config.GetRoomCollection(), bson.M{"_id": bson.M{"$in": toObjectIDs([]string{roomID, otherRoomID})}}, 1},
+		{config.GetRoomMemberCollection(), bson.M{"room_id": roomID}, 0},
+		{config.GetFolderCollection(), bson.M{"room_id": roomID}, 0},
+		{config.GetFileCollection(), bson.M{}, 1},
+		{config.GetPaperCollection(), bson.M{}, 0},
+		{config.GetPaperStrokeCollection(), bson.M{}, 0},
+		{config.GetPaperHistoryCollection(), bson.M{}, 0},
+		{config.GetPaperThumbnailCollection(), bson.M{}, 0},
+		{config.GetFileVersionCollection(), bson.M{"file_id": fileID}, 0},
+		{config.GetFileVersionCollection(), bson.M{"file_id": otherFileID}, 1},
+		{config.GetPaperSnapshotCollection(), bson.M{}, 0},
+		{config.GetFileActivityCollection(), bson.M{}, 0},
+		{config.GetStarredCollection(), bson.M{}, 0},
+	}
+	for _, tt := range remaining {
+		count, err := tt.collection.CountDocuments(ctx, tt.filter)
+		if err != nil {
+			t.Fatalf("Failed to count %s: %v", tt.collection.Name(), err)
+		}
+		if count != tt.want {
+			t.Errorf("%s has %d documents matching %v left, want %d", tt.collection.Name(), count, tt.filter, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+)
+
+// requestError carries the HTTP status for an error returned by the shared
+// operation functions that several endpoints call
+type requestError struct {
+	Status  int
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func newRequestError(status int, message string) error {
+	return &requestError{Status: status, Message: message}
+}
+
+// writeRequestError writes err with its status, or a 500 for other errors
+func writeRequestError(w http.ResponseWriter, err error) {
+	if conflict, ok := err.(*versionConflict); ok {
+		writeVersionConflict(w, conflict)
+		return
+	}
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus returns the HTTP status of err, or 500 for other errors
+func errorStatus(err error) int {
+	if reqErr, ok := err.(*requestError); ok {
+		return reqErr.Status
+	}
+	if _, ok := err.(*versionConflict); ok {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
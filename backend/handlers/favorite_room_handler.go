@@ -14,7 +14,6 @@ import (
 	"backend/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // ToggleFavoriteRoom toggles the favorite status of a room
@@ -44,39 +43,37 @@ func ToggleFavoriteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Query database for the favorite status
-	favoriteCollection := config.GetFavoriteCollection()
-	filter := bson.M{"user_id": userID, "room_id": request.RoomID}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	var favorite models.Favorite
-	err = favoriteCollection.FindOne(context.Background(), filter).Decode(&favorite)
+	// Favorite rooms are stars; legacy Favorites documents are still honoured
+	favoriteRooms, err := getFavoriteRoomIDs(ctx, userID)
 	if err != nil {
-		// If not found, create a new favorite document
-		favorite = models.Favorite{
-			ID:        primitive.NewObjectID(),
-			UserID:    userID,
-			RoomID:    request.RoomID,
-			IsFav:     true, // Set to true as we're toggling it ON
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		http.Error(w, "Failed to fetch favorite status", http.StatusInternalServerError)
+		return
+	}
+
+	isFavorite := !favoriteRooms[request.RoomID]
+	if isFavorite {
+		if _, err := utils.GetUserRoleInRoom(ctx, userID, request.RoomID); err != nil {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
 		}
-		_, err = favoriteCollection.InsertOne(context.Background(), favorite)
-		if err != nil {
+		if _, err := addStar(ctx, userID, models.StarRoom, request.RoomID, request.RoomID); err != nil {
 			http.Error(w, "Failed to create favorite", http.StatusInternalServerError)
 			return
 		}
 	} else {
-		// Toggle the favorite status
-		favorite.IsFav = !favorite.IsFav
-		favorite.UpdatedAt = time.Now()
+		if _, err := removeStar(ctx, userID, models.StarRoom, request.RoomID); err != nil {
+			http.Error(w, "Failed to update favorite", http.StatusInternalServerError)
+			return
+		}
 
 		fmt.Println("change fav")
 
-		// Update the favorite status in the database
-		// Use the correct field name - it's "is_favorite" in the database
-		update := bson.M{"$set": bson.M{"is_favorite": favorite.IsFav, "updated_at": favorite.UpdatedAt}}
-		_, err = favoriteCollection.UpdateOne(context.Background(), filter, update)
-		if err != nil {
+		filter := bson.M{"user_id": userID, "room_id": request.RoomID}
+		update := bson.M{"$set": bson.M{"is_favorite": false, "updated_at": time.Now()}}
+		if _, err := config.GetFavoriteCollection().UpdateMany(ctx, filter, update); err != nil {
 			http.Error(w, "Failed to update favorite", http.StatusInternalServerError)
 			return
 		}
@@ -87,6 +84,34 @@ func ToggleFavoriteRoom(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{
 		"message":     "Room favorite status updated successfully",
 		"id":          request.RoomID,
-		"is_favorite": fmt.Sprintf("%v", favorite.IsFav),
+		"is_favorite": fmt.Sprintf("%v", isFavorite),
 	})
 }
+
+// getFavoriteRoomIDs returns the rooms the user starred, plus rooms still
+// marked in the legacy Favorites collection
+func getFavoriteRoomIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	favoriteRooms := make(map[string]bool)
+
+	stars, err := findStars(ctx, bson.M{"user_id": userID, "item_type": models.StarRoom})
+	if err != nil {
+		return nil, err
+	}
+	for _, star := range stars {
+		favoriteRooms[star.ItemID] = true
+	}
+
+	cursor, err := config.GetFavoriteCollection().Find(ctx, bson.M{"user_id": userID, "is_favorite": true})
+	if err != nil {
+		return nil, err
+	}
+	var favorites []models.Favorite
+	if err := cursor.All(ctx, &favorites); err != nil {
+		return nil, err
+	}
+	for _, favorite := range favorites {
+		favoriteRooms[favorite.RoomID] = true
+	}
+
+	return favoriteRooms, nil
+}
@@ -14,6 +14,7 @@ import (
 	"backend/config"
 	"backend/models"
 	"backend/socketio"
+	"backend/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -40,6 +41,10 @@ func AddFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := requirePermission(w, r, fileRequest.RoomID, models.PermCreate); !ok {
+		return
+	}
+
 	// roomCollection := config.GetRoomCollection()
 	// roomIDObjID, err := primitive.ObjectIDFromHex(folderRequest.RoomID)
 	// if err != nil {
@@ -53,17 +58,24 @@ func AddFile(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
+	fileCollection := config.GetFileCollection()
+	position, err := nextPosition(context.Background(), fileCollection, fileRequest.RoomID, fileRequest.SubFolderID)
+	if err != nil {
+		http.Error(w, "Failed to compute file position", http.StatusInternalServerError)
+		return
+	}
+
 	file := models.File{
 		ID:          primitive.NewObjectID(),
 		OriginalID:  fileRequest.FileID,
 		RoomID:      fileRequest.RoomID,
 		SubFolderID: fileRequest.SubFolderID,
 		Name:        fileRequest.Name,
+		Position:    position,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	fileCollection := config.GetFileCollection()
 	_, err = fileCollection.InsertOne(context.Background(), file)
 	if err != nil {
 		http.Error(w, "Failed to add file", http.StatusInternalServerError)
@@ -71,18 +83,7 @@ func AddFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 🔥 **Emit to all users in the room**
-	socketServer := socketio.ServerInstance
-	if socketServer != nil {
-		// Fetch updated folder list
-		var files []models.File
-		cursor, _ := fileCollection.Find(context.Background(), bson.M{"room_id": fileRequest.RoomID})
-		cursor.All(context.Background(), &files)
-
-		socketServer.BroadcastToRoom("", fileRequest.RoomID, "file_list_updated", map[string]interface{}{
-			"roomID": fileRequest.RoomID,
-			"files":  files,
-		})
-	}
+	broadcastFileList(context.Background(), fileRequest.RoomID)
 
 	// ✅ Send success response
 	w.Header().Set("Content-Type", "application/json")
@@ -100,8 +101,9 @@ func RenameFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestRename struct {
-		FileID string `json:"file_id"`
-		Name   string `json:"name"`
+		FileID          string `json:"file_id"`
+		Name            string `json:"name"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 
 	if err := json.Unmarshal(body, &requestRename); err != nil {
@@ -128,13 +130,21 @@ func RenameFile(w http.ResponseWriter, r *http.Request) {
 
 	roomID := file.RoomID
 
-	filter := bson.M{"_id": FileID}
-	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAT": time.Now()}}
+	if _, ok := requirePermission(w, r, roomID, models.PermEdit); !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, requestRename.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
 
-	_, err = fileCollection.UpdateOne(context.Background(), filter, update)
+	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAt": time.Now()}}
+	version, err := updateVersioned(context.Background(), fileCollection, models.StarFile, FileID, update, expected, &file)
 	if err != nil {
 		log.Printf("Error updating file: %v", err)
-		http.Error(w, "Failed to update file name", http.StatusInternalServerError)
+		writeRequestError(w, versionedWriteError(err, "Failed to update file name"))
 		return
 	}
 
@@ -152,11 +162,13 @@ func RenameFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Return success response with stats
+	setETag(w, version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":  "Rename room successfully",
 		"folderId": FileID.Hex(),
+		"version":  version,
 	})
 }
 
@@ -168,7 +180,9 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var fileRequest struct {
-		FileID string `json:"file_id"`
+		FileID          string `json:"file_id"`
+		DryRun          bool   `json:"dry_run"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 	if err := json.Unmarshal(body, &fileRequest); err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
@@ -192,25 +206,49 @@ func DeleteFile(w http.ResponseWriter, r *http.Request) {
 
 	roomID := file.RoomID
 
-	// Use a recursive function to delete the folder and all its contents
-	deletedStats, err := deleteFileAndContents(file)
+	if _, ok := requirePermission(w, r, roomID, models.PermDelete); !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, fileRequest.ExpectedVersion)
+	if err == nil {
+		err = compareVersion(models.StarFile, file.Version, expected, file)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRequestError(w, err)
 		return
 	}
 
-	socketServer := socketio.ServerInstance
-	if socketServer != nil {
-		// Fetch updated file list for the room
-		var files []models.File
-		cursor, _ := fileCollection.Find(context.Background(), bson.M{"room_id": roomID})
-		cursor.All(context.Background(), &files)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
 
-		socketServer.BroadcastToRoom("", roomID, "file_list_updated", map[string]interface{}{
-			"roomID": roomID,
-			"files":  files,
+	plan, err := planFileDelete(ctx, file)
+	if err != nil {
+		log.Printf("Error collecting papers of file %s: %v", file.ID.Hex(), err)
+		http.Error(w, "Failed to collect file contents", http.StatusInternalServerError)
+		return
+	}
+
+	if fileRequest.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Nothing was deleted",
+			"dry_run": true,
+			"preview": plan.preview(),
 		})
+		return
+	}
+
+	plan.expected = expected
+	roomStats, err := plan.execute(ctx)
+	if err != nil {
+		log.Printf("Error deleting file %s: %v", file.ID.Hex(), err)
+		writeRequestError(w, versionedWriteError(err, "Failed to delete file"))
+		return
 	}
+	deletedStats := FileDeleteStats{Files: roomStats.Files, Papers: roomStats.Papers}
+
+	broadcastFileList(ctx, roomID)
 
 	// Return success response with stats
 	w.Header().Set("Content-Type", "application/json")
@@ -226,59 +264,6 @@ type FileDeleteStats struct {
 	Papers int64 `json:"papers"`
 }
 
-// deleteFileAndContents deletes a file and all its associated papers
-func deleteFileAndContents(file models.File) (FileDeleteStats, error) {
-	ctx := context.Background()
-	stats := FileDeleteStats{}
-
-	// Print debugging info
-	log.Printf("Deleting file: ID=%s, OriginalID=%s, Name=%s", file.ID.Hex(), file.OriginalID, file.Name)
-
-	cursor, err := config.GetPaperCollection().Find(ctx, bson.M{"file_id": file.ID.Hex()})
-	if err != nil {
-		return stats, fmt.Errorf("failed to retrieve papers for file %s: %v", file.ID.Hex(), err)
-	}
-	defer cursor.Close(ctx)
-
-	for cursor.Next(ctx) {
-		var paper bson.M
-		if err := cursor.Decode(&paper); err != nil {
-			log.Printf("Failed to decode paper: %v", err)
-			continue
-		}
-
-		if urlStr, ok := paper["background_image"].(string); ok && urlStr != "" {
-			log.Printf("Deleting background image: %s", urlStr)
-			if err := DeleteByURL(urlStr); err != nil {
-				log.Printf("Failed to delete background image: %v", err)
-			}
-		}
-	}
-	// Delete all papers associated with this file
-	// IMPORTANT: Use the OriginalID field to find papers, not ID.Hex()
-	paperResult, err := config.GetPaperCollection().DeleteMany(ctx, bson.M{"file_id": file.ID.Hex()})
-	if err != nil {
-		return stats, fmt.Errorf("failed to delete papers for file %s: %v", file.ID.Hex(), err)
-	}
-	log.Printf("Deleted %d papers from file %s", paperResult.DeletedCount, file.Name)
-	stats.Papers = paperResult.DeletedCount
-
-	// Delete the file itself
-	// IMPORTANT: Use the correct collection (FileCollection not FolderCollection)
-	fileResult, err := config.GetFileCollection().DeleteOne(ctx, bson.M{"_id": file.ID})
-	if err != nil {
-		return stats, fmt.Errorf("failed to delete file: %v", err)
-	}
-
-	if fileResult.DeletedCount == 0 {
-		return stats, fmt.Errorf("file not found during deletion")
-	}
-	log.Printf("Successfully deleted file: %s", file.Name)
-	stats.Files = fileResult.DeletedCount
-
-	return stats, nil
-}
-
 func GetFile(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -291,11 +276,15 @@ func GetFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Query database for folders
+	// Query database for files, optionally narrowed by tags
 	fileCollection := config.GetFileCollection()
 	filter := bson.M{"room_id": roomID}
+	if err := tagFilter(r, filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	cursor, err := fileCollection.Find(context.Background(), filter)
+	cursor, err := fileCollection.Find(context.Background(), filter, positionSort())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to fetch files: %v", err), http.StatusInternalServerError)
 		return
@@ -303,15 +292,21 @@ func GetFile(w http.ResponseWriter, r *http.Request) {
 	defer cursor.Close(context.Background())
 
 	// Decode results into slice
-	var files []models.Folder
+	var files []models.File
 	if err = cursor.All(context.Background(), &files); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to decode files: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Broadcast to socket if needed
-	socketServer := socketio.ServerInstance
-	socketServer.BroadcastToRoom("", roomID, "folder_list_updated", files)
+	// Broadcast to socket if needed. A tag-filtered list is only for the caller.
+	if len(filter) == 1 {
+		broadcastFileList(context.Background(), roomID)
+	}
+
+	// Last opened is per user, so it is only added to the caller's copy
+	if userID, err := utils.GetUserIDFromToken(r); err == nil {
+		fillLastOpened(context.Background(), userID, files)
+	}
 
 	// Encode and return folders
 	json.NewEncoder(w).Encode(files)
@@ -351,3 +346,44 @@ func GetFileIDByOriginalID(w http.ResponseWriter, r *http.Request) {
 	// Return only the ID as a string
 	fmt.Fprint(w, result.ID.Hex())
 }
+
+// broadcastFileList sends the files of a room to everyone connected to it
+func broadcastFileList(ctx context.Context, roomID string) {
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		return
+	}
+
+	var files []models.File
+	cursor, err := config.GetFileCollection().Find(ctx, bson.M{"room_id": roomID}, positionSort())
+	if err != nil {
+		log.Printf("Error fetching files of room %s: %v", roomID, err)
+		return
+	}
+	cursor.All(ctx, &files)
+
+	socketServer.BroadcastToRoom("", roomID, "file_list_updated", map[string]interface{}{
+		"roomID": roomID,
+		"files":  files,
+	})
+}
+
+// deleteUnreferencedBlob deletes a background image once no paper, and no
+// version of a paper, refers to it
+func deleteUnreferencedBlob(ctx context.Context, blobURL string) {
+	for _, collection := range []*mongo.Collection{config.GetPaperCollection(), config.GetPaperSnapshotCollection()} {
+		count, err := collection.CountDocuments(ctx, bson.M{"background_image": blobURL})
+		if err != nil {
+			log.Printf("Failed to check references to background image %s: %v", blobURL, err)
+			return
+		}
+		if count > 0 {
+			return
+		}
+	}
+
+	log.Printf("Deleting background image: %s", blobURL)
+	if err := DeleteByURL(blobURL); err != nil {
+		log.Printf("Failed to delete background image: %v", err)
+	}
+}
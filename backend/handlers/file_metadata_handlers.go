@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OpenFile records that the caller opened a file and returns the file with
+// its metadata
+func OpenFile(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var openRequest struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.Unmarshal(body, &openRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(openRequest.FileID)
+	if err != nil {
+		http.Error(w, "Invalid File ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	var file models.File
+	if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, file.RoomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	openedAt, err := recordFileOpen(ctx, userID, file.ID.Hex(), file.RoomID)
+	if err != nil {
+		log.Printf("Failed to record open of file %s by %s: %v", file.ID.Hex(), userID, err)
+		http.Error(w, "Failed to record file open", http.StatusInternalServerError)
+		return
+	}
+	file.LastOpenedAt = &openedAt
+
+	setETag(w, file.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// recordFileOpen stores now as the time the user last opened the file
+func recordFileOpen(ctx context.Context, userID, fileID, roomID string) (time.Time, error) {
+	now := time.Now()
+	filter := bson.M{"user_id": userID, "file_id": fileID}
+	update := bson.M{
+		"$set":   bson.M{"room_id": roomID, "last_opened_at": now},
+		"$unset": bson.M{"hidden": ""},
+	}
+	_, err := config.GetFileActivityCollection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return now, err
+}
+
+// TrackFileOpen records a file open reported by the socket server. Files the
+// user cannot access are ignored.
+func TrackFileOpen(userID, fileID string) {
+	objID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var file models.File
+	if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+		return
+	}
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, file.RoomID); err != nil {
+		return
+	}
+	if _, err := recordFileOpen(ctx, userID, fileID, file.RoomID); err != nil {
+		log.Printf("Failed to record open of file %s by %s: %v", fileID, userID, err)
+	}
+}
+
+// fillLastOpened sets LastOpenedAt on the files the user has opened
+func fillLastOpened(ctx context.Context, userID string, files []models.File) {
+	if len(files) == 0 {
+		return
+	}
+
+	fileIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		fileIDs = append(fileIDs, file.ID.Hex())
+	}
+
+	var activities []models.FileActivity
+	cursor, err := config.GetFileActivityCollection().Find(ctx, bson.M{"user_id": userID, "file_id": bson.M{"$in": fileIDs}})
+	if err != nil {
+		log.Printf("Error fetching file activity of %s: %v", userID, err)
+		return
+	}
+	if err := cursor.All(ctx, &activities); err != nil {
+		log.Printf("Error decoding file activity of %s: %v", userID, err)
+		return
+	}
+
+	openedAt := make(map[string]time.Time, len(activities))
+	for _, activity := range activities {
+		if !activity.LastOpenedAt.IsZero() {
+			openedAt[activity.FileID] = activity.LastOpenedAt
+		}
+	}
+	for i := range files {
+		if t, ok := openedAt[files[i].ID.Hex()]; ok {
+			files[i].LastOpenedAt = &t
+		}
+	}
+}
+
+// touchFile records who last edited a file and when, on the file and in the
+// editor's activity
+func touchFile(ctx context.Context, roomID, fileID, userID string) {
+	objID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{
+		"last_edited_by": userID,
+		"last_edited_at": now,
+		"updatedAt":      now,
+	}}
+	result, err := config.GetFileCollection().UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		log.Printf("Failed to update last editor of file %s: %v", fileID, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		return
+	}
+
+	filter := bson.M{"user_id": userID, "file_id": fileID}
+	activity := bson.M{
+		"$set":   bson.M{"room_id": roomID, "last_edited_at": now},
+		"$unset": bson.M{"hidden": ""},
+	}
+	if _, err := config.GetFileActivityCollection().UpdateOne(ctx, filter, activity, options.Update().SetUpsert(true)); err != nil {
+		log.Printf("Failed to record edit of file %s by %s: %v", fileID, userID, err)
+	}
+}
+
+// refreshFileMetadata recomputes the page count and thumbnail of a file from
+// its papers, and re-sends the file list when they changed. The thumbnail is
+// the rendering of the first page.
+func refreshFileMetadata(ctx context.Context, roomID, fileID string) {
+	objID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return
+	}
+
+	paperCollection := config.GetPaperCollection()
+	count, err := paperCollection.CountDocuments(ctx, bson.M{"file_id": fileID})
+	if err != nil {
+		log.Printf("Failed to count papers of file %s: %v", fileID, err)
+		return
+	}
+
+	var first models.Paper
+	findOptions := options.FindOne().
+		SetSort(bson.M{"page_number": 1}).
+		SetProjection(bson.M{"_id": 1})
+	if count > 0 {
+		paperCollection.FindOne(ctx, bson.M{"file_id": fileID}, findOptions).Decode(&first)
+	}
+
+	set := bson.M{"page_count": count}
+	update := bson.M{"$set": set}
+	if !first.ID.IsZero() {
+		set["thumbnail_url"] = models.PaperThumbnailURL(first.ID.Hex())
+	} else {
+		update["$unset"] = bson.M{"thumbnail_url": ""}
+	}
+
+	result, err := config.GetFileCollection().UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		log.Printf("Failed to update metadata of file %s: %v", fileID, err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		broadcastFileList(ctx, roomID)
+	}
+}
+
+// firstPageThumbnails returns the thumbnail URL of each file that has one of
+// the papers, keyed by file ID, for files whose papers are written in bulk
+func firstPageThumbnails(papers []models.Paper) map[string]string {
+	first := make(map[string]models.Paper)
+	for _, paper := range papers {
+		if current, ok := first[paper.FileID]; !ok || paper.PageNumber < current.PageNumber {
+			first[paper.FileID] = paper
+		}
+	}
+
+	thumbnails := make(map[string]string, len(first))
+	for fileID, paper := range first {
+		thumbnails[fileID] = models.PaperThumbnailURL(paper.ID.Hex())
+	}
+	return thumbnails
+}
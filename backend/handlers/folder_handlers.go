@@ -39,6 +39,10 @@ func AddFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, ok := requirePermission(w, r, folderRequest.RoomID, models.PermCreate); !ok {
+		return
+	}
+
 	// roomCollection := config.GetRoomCollection()
 	// roomIDObjID, err := primitive.ObjectIDFromHex(folderRequest.RoomID)
 	// if err != nil {
@@ -52,6 +56,13 @@ func AddFolder(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
+	folderCollection := config.GetFolderCollection()
+	position, err := nextPosition(context.Background(), folderCollection, folderRequest.RoomID, folderRequest.SubFolderID)
+	if err != nil {
+		http.Error(w, "Failed to compute folder position", http.StatusInternalServerError)
+		return
+	}
+
 	folder := models.Folder{
 		ID:          primitive.NewObjectID(),
 		OriginalID:  folderRequest.FolderID,
@@ -59,11 +70,11 @@ func AddFolder(w http.ResponseWriter, r *http.Request) {
 		SubFolderID: folderRequest.SubFolderID,
 		Name:        folderRequest.Name,
 		Color:       folderRequest.Color,
+		Position:    position,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	folderCollection := config.GetFolderCollection()
 	_, err = folderCollection.InsertOne(context.Background(), folder)
 	if err != nil {
 		http.Error(w, "Failed to add folder", http.StatusInternalServerError)
@@ -71,18 +82,7 @@ func AddFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 🔥 **Emit to all users in the room**
-	socketServer := socketio.ServerInstance
-	if socketServer != nil {
-		// Fetch updated folder list
-		var folders []models.Folder
-		cursor, _ := folderCollection.Find(context.Background(), bson.M{"room_id": folderRequest.RoomID})
-		cursor.All(context.Background(), &folders)
-
-		socketServer.BroadcastToRoom("", folderRequest.RoomID, "folder_list_updated", map[string]interface{}{
-			"roomID":  folderRequest.RoomID,
-			"folders": folders,
-		})
-	}
+	broadcastFolderList(context.Background(), folderRequest.RoomID)
 
 	// ✅ Send success response
 	w.Header().Set("Content-Type", "application/json")
@@ -100,8 +100,9 @@ func RenameFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestRename struct {
-		FolderID string `json:"folder_id"`
-		Name     string `json:"name"`
+		FolderID        string `json:"folder_id"`
+		Name            string `json:"name"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 
 	if err := json.Unmarshal(body, &requestRename); err != nil {
@@ -127,13 +128,21 @@ func RenameFolder(w http.ResponseWriter, r *http.Request) {
 
 	roomID := folder.RoomID
 
-	filter := bson.M{"_id": FolderID}
-	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAT": time.Now()}}
+	if _, ok := requirePermission(w, r, roomID, models.PermEdit); !ok {
+		return
+	}
 
-	_, err = folderCollection.UpdateOne(context.Background(), filter, update)
+	expected, err := expectedVersion(r, requestRename.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAt": time.Now()}}
+	version, err := updateVersioned(context.Background(), folderCollection, models.StarFolder, FolderID, update, expected, &folder)
 	if err != nil {
 		log.Printf("Error updating folder: %v", err)
-		http.Error(w, "Failed to update folder name", http.StatusInternalServerError)
+		writeRequestError(w, versionedWriteError(err, "Failed to update folder name"))
 		return
 	}
 
@@ -151,11 +160,13 @@ func RenameFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Return success response with stats
+	setETag(w, version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":  "Rename room successfully",
 		"folderId": FolderID.Hex(),
+		"version":  version,
 	})
 }
 
@@ -167,7 +178,9 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var folderRequest struct {
-		FolderID string `json:"folder_id"`
+		FolderID        string `json:"folder_id"`
+		DryRun          bool   `json:"dry_run"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 	if err := json.Unmarshal(body, &folderRequest); err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
@@ -191,26 +204,52 @@ func DeleteFolder(w http.ResponseWriter, r *http.Request) {
 
 	roomID := folder.RoomID
 
-	// Use a recursive function to delete the folder and all its contents
-	deletedStats, err := deleteFolderAndContents(folder)
+	if _, ok := requirePermission(w, r, roomID, models.PermDelete); !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, folderRequest.ExpectedVersion)
+	if err == nil {
+		err = compareVersion(models.StarFolder, folder.Version, expected, folder)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeRequestError(w, err)
 		return
 	}
 
-	socketServer := socketio.ServerInstance
-	if socketServer != nil {
-		// Fetch updated folder list for the room
-		var folders []models.Folder
-		cursor, _ := folderCollection.Find(context.Background(), bson.M{"room_id": roomID})
-		cursor.All(context.Background(), &folders)
-		log.Printf("Broadcasting folder list update to room %s", roomID)
-		log.Printf("Updated folder list: %v", folders)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
 
-		socketServer.BroadcastToRoom("", roomID, "folder_list_updated", map[string]interface{}{
-			"roomID":  roomID,
-			"folders": folders,
+	// Collect the folder and everything below it before deleting anything
+	plan, err := planFolderDelete(ctx, folder)
+	if err != nil {
+		log.Printf("Error collecting contents of folder %s: %v", folder.ID.Hex(), err)
+		http.Error(w, "Failed to collect folder contents", http.StatusInternalServerError)
+		return
+	}
+
+	if folderRequest.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Nothing was deleted",
+			"dry_run": true,
+			"preview": plan.preview(),
 		})
+		return
+	}
+
+	plan.expected = expected
+	roomStats, err := plan.execute(ctx)
+	if err != nil {
+		log.Printf("Error deleting folder %s: %v", folder.ID.Hex(), err)
+		writeRequestError(w, versionedWriteError(err, "Failed to delete folder"))
+		return
+	}
+	deletedStats := DeletionStats{Folders: roomStats.Folders, Files: roomStats.Files, Papers: roomStats.Papers}
+
+	broadcastFolderList(ctx, roomID)
+	if deletedStats.Files > 0 {
+		broadcastFileList(ctx, roomID)
 	}
 
 	// Return success response with stats
@@ -229,84 +268,6 @@ type DeletionStats struct {
 	Papers  int64 `json:"papers"`
 }
 
-// deleteFolderAndContents recursively deletes a folder, its sub-folders, files, and papers
-func deleteFolderAndContents(folder models.Folder) (DeletionStats, error) {
-	ctx := context.Background()
-	stats := DeletionStats{}
-
-	// Print debugging info to help diagnose the issue
-	log.Printf("Deleting folder: ID=%s, OriginalID=%s, Name=%s", folder.ID.Hex(), folder.OriginalID, folder.Name)
-
-	// CRITICAL: Find all sub-folders using the correct field relationship
-	// We need to find folders where the current folder's OriginalID is the parent folder ID
-	subFolderFilter := bson.M{"sub_folder_id": folder.ID.Hex()}
-	log.Printf("Looking for subfolders with filter: %v", subFolderFilter)
-
-	subFolderCursor, err := config.GetFolderCollection().Find(ctx, subFolderFilter)
-	if err != nil {
-		return stats, fmt.Errorf("failed to query sub-folders: %v", err)
-	}
-	defer subFolderCursor.Close(ctx)
-
-	// Process each sub-folder recursively
-	var subFolders []models.Folder
-	if err = subFolderCursor.All(ctx, &subFolders); err != nil {
-		return stats, fmt.Errorf("failed to process sub-folders: %v", err)
-	}
-
-	log.Printf("Found %d subfolders within folder %s", len(subFolders), folder.Name)
-
-	// Recursively delete each subfolder and its contents
-	for _, subFolder := range subFolders {
-		log.Printf("Processing subfolder: %s (ID: %s)", subFolder.Name, subFolder.ID.Hex())
-		subStats, err := deleteFolderAndContents(subFolder)
-		if err != nil {
-			return stats, err
-		}
-		// Accumulate deletion statistics
-		stats.Folders += subStats.Folders
-		stats.Files += subStats.Files
-		stats.Papers += subStats.Papers
-	}
-
-	// Find all files directly in this folder
-	fileFilter := bson.M{"sub_folder_id": folder.ID.Hex()}
-	log.Printf("Looking for files with filter: %v", fileFilter)
-
-	fileCursor, err := config.GetFileCollection().Find(ctx, fileFilter)
-	if err != nil {
-		return stats, fmt.Errorf("failed to query files: %v", err)
-	}
-	defer fileCursor.Close(ctx)
-
-	// Collect all files to delete
-	var files []models.File
-	if err = fileCursor.All(ctx, &files); err != nil {
-		return stats, fmt.Errorf("failed to process files: %v", err)
-	}
-
-	log.Printf("Found %d files to delete in folder %s", len(files), folder.Name)
-
-	// Delete all papers associated with these files
-	for _, file := range files {
-		deleteFileAndContents(file)
-	}
-
-	// Finally, delete the folder itself
-	folderResult, err := config.GetFolderCollection().DeleteOne(ctx, bson.M{"_id": folder.ID})
-	if err != nil {
-		return stats, fmt.Errorf("failed to delete folder: %v", err)
-	}
-
-	if folderResult.DeletedCount == 0 {
-		return stats, fmt.Errorf("folder not found during deletion")
-	}
-	log.Printf("Successfully deleted folder: %s", folder.Name)
-	stats.Folders++
-
-	return stats, nil
-}
-
 func GetFolder(w http.ResponseWriter, r *http.Request) {
 	// Set CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -319,11 +280,15 @@ func GetFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Query database for folders
+	// Query database for folders, optionally narrowed by tags
 	folderCollection := config.GetFolderCollection()
 	filter := bson.M{"room_id": roomID}
+	if err := tagFilter(r, filter); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	cursor, err := folderCollection.Find(context.Background(), filter)
+	cursor, err := folderCollection.Find(context.Background(), filter, positionSort())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to fetch folders: %v", err), http.StatusInternalServerError)
 		return
@@ -337,10 +302,72 @@ func GetFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Broadcast to socket if needed
+	// Broadcast to socket if needed. A tag-filtered list is only for the caller.
 	socketServer := socketio.ServerInstance
-	socketServer.BroadcastToRoom("", roomID, "folder_list_updated", folders)
+	if socketServer != nil && len(filter) == 1 {
+		socketServer.BroadcastToRoom("", roomID, "folder_list_updated", folders)
+	}
 
 	// Encode and return folders
 	json.NewEncoder(w).Encode(folders)
 }
+
+// PathEntry is one element of a breadcrumb path
+type PathEntry struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// maxFolderDepth bounds ancestor walks so a corrupted parent chain cannot loop forever
+const maxFolderDepth = 64
+
+// resolveFolderPath returns the folders from the room root down to folderID,
+// inclusive. The root sentinel or an unknown folder yields an empty path.
+func resolveFolderPath(ctx context.Context, folderID string) []PathEntry {
+	path := []PathEntry{}
+	visited := make(map[string]bool)
+
+	for depth := 0; depth < maxFolderDepth && !visited[folderID]; depth++ {
+		objID, err := primitive.ObjectIDFromHex(folderID)
+		if err != nil {
+			break
+		}
+
+		var folder models.Folder
+		if err := config.GetFolderCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&folder); err != nil {
+			break
+		}
+
+		visited[folderID] = true
+		path = append([]PathEntry{{ID: folderID, Type: models.StarFolder, Name: folder.Name}}, path...)
+		folderID = folder.SubFolderID
+	}
+	return path
+}
+
+// isRootFolder reports whether a sub_folder_id refers to the top level of a room
+func isRootFolder(folderID string) bool {
+	return folderID == "" || folderID == models.RootFolderID
+}
+
+// broadcastFolderList sends the folders of a room to everyone connected to it
+func broadcastFolderList(ctx context.Context, roomID string) {
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		return
+	}
+
+	var folders []models.Folder
+	cursor, err := config.GetFolderCollection().Find(ctx, bson.M{"room_id": roomID}, positionSort())
+	if err != nil {
+		log.Printf("Error fetching folders of room %s: %v", roomID, err)
+		return
+	}
+	cursor.All(ctx, &folders)
+
+	socketServer.BroadcastToRoom("", roomID, "folder_list_updated", map[string]interface{}{
+		"roomID":  roomID,
+		"folders": folders,
+	})
+}
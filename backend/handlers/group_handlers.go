@@ -0,0 +1,722 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CreateGroup creates a named group owned by the caller with an initial list of member emails
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var groupRequest struct {
+		Name   string   `json:"name"`
+		Emails []string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &groupRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if groupRequest.Name == "" {
+		http.Error(w, "Group name is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	memberIDs, notFound := resolveEmails(ctx, groupRequest.Emails)
+
+	group := models.Group{
+		ID:        primitive.NewObjectID(),
+		OwnerID:   userID,
+		Name:      groupRequest.Name,
+		MemberIDs: memberIDs,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if _, err := config.GetGroupCollection().InsertOne(ctx, group); err != nil {
+		log.Printf("MongoDB insertion error: %v", err)
+		http.Error(w, "Failed to create group", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":   "Group created successfully",
+		"group_id":  group.ID.Hex(),
+		"not_found": notFound,
+	})
+}
+
+// GetGroups returns the groups owned by the caller with their members resolved to emails
+func GetGroups(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := config.GetGroupCollection().Find(ctx, bson.M{"owner_id": userID})
+	if err != nil {
+		http.Error(w, "Failed to fetch groups", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var groups []models.Group
+	if err := cursor.All(ctx, &groups); err != nil {
+		http.Error(w, "Failed to decode groups", http.StatusInternalServerError)
+		return
+	}
+
+	type GroupMember struct {
+		Email string `json:"email"`
+		Name  string `json:"name"`
+	}
+
+	response := make([]map[string]interface{}, 0, len(groups))
+	for _, group := range groups {
+		users, err := findUsersByID(ctx, group.MemberIDs)
+		if err != nil {
+			log.Printf("Error finding members of group %s: %v", group.ID.Hex(), err)
+		}
+
+		members := make([]GroupMember, 0, len(users))
+		for _, user := range users {
+			members = append(members, GroupMember{Email: user.Email, Name: user.Name})
+		}
+
+		response = append(response, map[string]interface{}{
+			"id":        group.ID.Hex(),
+			"name":      group.Name,
+			"members":   members,
+			"createdAt": group.CreatedAt,
+			"updatedAt": group.UpdatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func RenameGroup(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var requestRename struct {
+		GroupID string `json:"group_id"`
+		Name    string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &requestRename); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	group, ok := findOwnedGroup(ctx, w, requestRename.GroupID, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAt": time.Now()}}
+	if _, err := config.GetGroupCollection().UpdateOne(ctx, bson.M{"_id": group.ID}, update); err != nil {
+		http.Error(w, "Failed to rename group", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Group renamed successfully",
+		"group_id": group.ID.Hex(),
+	})
+}
+
+// DeleteGroup removes a group and every room grant made through it
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var requestDelete struct {
+		GroupID string `json:"group_id"`
+	}
+	if err := json.Unmarshal(body, &requestDelete); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	group, ok := findOwnedGroup(ctx, w, requestDelete.GroupID, userID)
+	if !ok {
+		return
+	}
+
+	before := snapshotGroupAccess(ctx, group.ID.Hex(), group.MemberIDs)
+
+	grants, err := config.GetRoomGroupCollection().DeleteMany(ctx, bson.M{"group_id": group.ID.Hex()})
+	if err != nil {
+		http.Error(w, "Failed to remove group from rooms", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := config.GetGroupCollection().DeleteOne(ctx, bson.M{"_id": group.ID}); err != nil {
+		http.Error(w, "Failed to delete group", http.StatusInternalServerError)
+		return
+	}
+
+	enforceGroupAccess(ctx, before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Group deleted successfully",
+		"group_id":      group.ID.Hex(),
+		"rooms_removed": grants.DeletedCount,
+	})
+}
+
+// AddGroupMembers adds users to a group by email. They immediately gain
+// access to every room the group has been granted, so the group owner must be
+// able to assign the group's role in each of those rooms.
+func AddGroupMembers(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		GroupID string   `json:"group_id"`
+		Emails  []string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, ok := findOwnedGroup(ctx, w, req.GroupID, userID)
+	if !ok {
+		return
+	}
+
+	// New members get the group's roles, so the owner must be able to
+	// assign each of them
+	if err := checkGroupRolesAssignable(ctx, userID, group.ID.Hex()); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	memberIDs, notFound := resolveEmails(ctx, req.Emails)
+	if len(memberIDs) == 0 {
+		http.Error(w, "No group members could be added", http.StatusBadRequest)
+		return
+	}
+
+	update := bson.M{
+		"$addToSet": bson.M{"member_ids": bson.M{"$each": memberIDs}},
+		"$set":      bson.M{"updatedAt": time.Now()},
+	}
+	if _, err := config.GetGroupCollection().UpdateOne(ctx, bson.M{"_id": group.ID}, update); err != nil {
+		http.Error(w, "Failed to add group members", http.StatusInternalServerError)
+		return
+	}
+
+	for _, roomID := range getGroupRoomIDs(ctx, group.ID.Hex()) {
+		broadcastRoomRoster(ctx, roomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Group members added successfully",
+		"added_count": len(memberIDs),
+		"not_found":   notFound,
+	})
+}
+
+// RemoveGroupMember removes a user from a group, revoking any room access they had through it
+func RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		GroupID string `json:"group_id"`
+		Email   string `json:"email"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, ok := findOwnedGroup(ctx, w, req.GroupID, userID)
+	if !ok {
+		return
+	}
+
+	memberID, err := utils.GetUserIDFromEmail(ctx, req.Email)
+	if err != nil {
+		http.Error(w, "User not found with provided email", http.StatusNotFound)
+		return
+	}
+
+	before := snapshotGroupAccess(ctx, group.ID.Hex(), []string{memberID})
+
+	update := bson.M{
+		"$pull": bson.M{"member_ids": memberID},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+	result, err := config.GetGroupCollection().UpdateOne(ctx, bson.M{"_id": group.ID}, update)
+	if err != nil {
+		http.Error(w, "Failed to remove group member", http.StatusInternalServerError)
+		return
+	}
+
+	if result.ModifiedCount == 0 {
+		http.Error(w, "User is not a member of this group", http.StatusNotFound)
+		return
+	}
+
+	enforceGroupAccess(ctx, before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Group member removed successfully",
+		"group_id": group.ID.Hex(),
+		"userID":   memberID,
+	})
+}
+
+// RoomGroup grants a group a role on a room
+func RoomGroup(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		RoomID  string `json:"room_id"`
+		GroupID string `json:"group_id"`
+		RoleID  string `json:"role_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, req.RoomID, models.PermShare)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := checkRoleAssignable(ctx, userID, req.RoomID, req.RoleID); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	group, ok := findOwnedGroup(ctx, w, req.GroupID, userID)
+	if !ok {
+		return
+	}
+
+	count, err := config.GetRoomGroupCollection().CountDocuments(ctx, bson.M{"room_id": req.RoomID, "group_id": req.GroupID})
+	if err != nil {
+		http.Error(w, "Failed to check room groups", http.StatusInternalServerError)
+		return
+	}
+	if count > 0 {
+		http.Error(w, "Group already has access to this room", http.StatusConflict)
+		return
+	}
+
+	grant := models.RoomGroup{
+		ID:        primitive.NewObjectID(),
+		InviterID: userID,
+		RoomID:    req.RoomID,
+		GroupID:   group.ID.Hex(),
+		RoleID:    req.RoleID,
+		JoinAt:    time.Now(),
+	}
+	if _, err := config.GetRoomGroupCollection().InsertOne(ctx, grant); err != nil {
+		log.Printf("MongoDB insertion error: %v", err)
+		http.Error(w, "Failed to add group to room", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastRoomRoster(ctx, req.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Group added to room successfully",
+		"id":      grant.ID.Hex(),
+	})
+}
+
+func ChangeRoomGroupRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		RoomID  string `json:"room_id"`
+		GroupID string `json:"group_id"`
+		RoleID  string `json:"role_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, req.RoomID, models.PermManageMembers)
+	if !ok {
+		return
+	}
+
+	ctx := context.Background()
+	if err := checkRoleAssignable(ctx, userID, req.RoomID, req.RoleID); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	before := snapshotGroupRoomAccess(ctx, req.RoomID, req.GroupID)
+
+	filter := bson.M{"room_id": req.RoomID, "group_id": req.GroupID}
+	result, err := config.GetRoomGroupCollection().UpdateOne(ctx, filter, bson.M{"$set": bson.M{"role_id": req.RoleID}})
+	if err != nil {
+		http.Error(w, "Failed to update group role", http.StatusInternalServerError)
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		http.Error(w, "Group has no access to this room", http.StatusNotFound)
+		return
+	}
+
+	enforceRoomAccess(ctx, req.RoomID, before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Group role updated successfully",
+		"group_id": req.GroupID,
+		"role_id":  req.RoleID,
+	})
+}
+
+// GetRoomGroups lists the groups granted access to a room
+func GetRoomGroups(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "Missing room_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	cursor, err := config.GetRoomGroupCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		http.Error(w, "Failed to fetch room groups", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var grants []models.RoomGroup
+	if err := cursor.All(ctx, &grants); err != nil {
+		http.Error(w, "Failed to decode room groups", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]map[string]interface{}, 0, len(grants))
+	for _, grant := range grants {
+		group, err := findGroup(ctx, grant.GroupID)
+		if err != nil {
+			log.Printf("Error finding group %s: %v", grant.GroupID, err)
+			continue
+		}
+
+		response = append(response, map[string]interface{}{
+			"group_id":     grant.GroupID,
+			"name":         group.Name,
+			"role_id":      grant.RoleID,
+			"member_count": len(group.MemberIDs),
+			"join_at":      grant.JoinAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RemoveRoomGroup revokes a group's access to a room
+func RemoveRoomGroup(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		RoomID  string `json:"room_id"`
+		GroupID string `json:"group_id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, req.RoomID, models.PermManageMembers); !ok {
+		return
+	}
+
+	ctx := context.Background()
+	before := snapshotGroupRoomAccess(ctx, req.RoomID, req.GroupID)
+
+	result, err := config.GetRoomGroupCollection().DeleteOne(ctx, bson.M{"room_id": req.RoomID, "group_id": req.GroupID})
+	if err != nil {
+		http.Error(w, "Failed to remove group from room", http.StatusInternalServerError)
+		return
+	}
+
+	if result.DeletedCount == 0 {
+		http.Error(w, "Group has no access to this room", http.StatusNotFound)
+		return
+	}
+
+	enforceRoomAccess(ctx, req.RoomID, before)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":  "Group removed from room successfully",
+		"roomID":   req.RoomID,
+		"group_id": req.GroupID,
+	})
+}
+
+// getGroupRoomIDs returns the rooms a group has been granted access to
+// checkGroupRolesAssignable returns a requestError unless the user may assign
+// the role the group holds in every room that grants it
+func checkGroupRolesAssignable(ctx context.Context, userID, groupID string) error {
+	cursor, err := config.GetRoomGroupCollection().Find(ctx, bson.M{"group_id": groupID})
+	if err != nil {
+		log.Printf("Error finding rooms of group %s: %v", groupID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check group roles")
+	}
+
+	var grants []models.RoomGroup
+	if err := cursor.All(ctx, &grants); err != nil {
+		log.Printf("Error decoding rooms of group %s: %v", groupID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check group roles")
+	}
+
+	for _, grant := range grants {
+		if err := checkRoleAssignable(ctx, userID, grant.RoomID, grant.RoleID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getGroupRoomIDs(ctx context.Context, groupID string) []string {
+	cursor, err := config.GetRoomGroupCollection().Find(ctx, bson.M{"group_id": groupID})
+	if err != nil {
+		log.Printf("Error finding rooms of group %s: %v", groupID, err)
+		return nil
+	}
+
+	var grants []models.RoomGroup
+	if err := cursor.All(ctx, &grants); err != nil {
+		log.Printf("Error decoding rooms of group %s: %v", groupID, err)
+		return nil
+	}
+
+	roomIDs := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		roomIDs = append(roomIDs, grant.RoomID)
+	}
+	return roomIDs
+}
+
+// snapshotGroupRoomAccess snapshots the permissions of every group member in one room
+func snapshotGroupRoomAccess(ctx context.Context, roomID, groupID string) map[string]map[string]bool {
+	before := make(map[string]map[string]bool)
+
+	group, err := findGroup(ctx, groupID)
+	if err != nil {
+		return before
+	}
+	for _, memberID := range group.MemberIDs {
+		before[memberID] = snapshotRoomPermissions(ctx, roomID, memberID)
+	}
+	return before
+}
+
+// snapshotGroupAccess snapshots the permissions of the given users in every
+// room the group has been granted, keyed by room ID
+func snapshotGroupAccess(ctx context.Context, groupID string, userIDs []string) map[string]map[string]map[string]bool {
+	before := make(map[string]map[string]map[string]bool)
+	for _, roomID := range getGroupRoomIDs(ctx, groupID) {
+		before[roomID] = make(map[string]map[string]bool, len(userIDs))
+		for _, userID := range userIDs {
+			before[roomID][userID] = snapshotRoomPermissions(ctx, roomID, userID)
+		}
+	}
+	return before
+}
+
+// enforceGroupAccess applies enforceRoomAccess to every room of a snapshotGroupAccess result
+func enforceGroupAccess(ctx context.Context, before map[string]map[string]map[string]bool) {
+	for roomID, users := range before {
+		enforceRoomAccess(ctx, roomID, users)
+	}
+}
+
+func findGroup(ctx context.Context, groupID string) (models.Group, error) {
+	var group models.Group
+
+	objID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return group, fmt.Errorf("invalid group ID: %v", err)
+	}
+
+	err = config.GetGroupCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&group)
+	return group, err
+}
+
+// findOwnedGroup loads a group and writes the appropriate error unless it is owned by userID
+func findOwnedGroup(ctx context.Context, w http.ResponseWriter, groupID, userID string) (models.Group, bool) {
+	group, err := findGroup(ctx, groupID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Group not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		}
+		return group, false
+	}
+
+	if group.OwnerID != userID {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return group, false
+	}
+	return group, true
+}
+
+// resolveEmails maps emails to user IDs, returning the emails that matched no user
+func resolveEmails(ctx context.Context, emails []string) ([]string, []string) {
+	userIDs := make([]string, 0, len(emails))
+	notFound := make([]string, 0)
+
+	for _, email := range emails {
+		id, err := utils.GetUserIDFromEmail(ctx, email)
+		if err != nil {
+			log.Printf("Error getting user ID for email %s: %v", email, err)
+			notFound = append(notFound, email)
+			continue
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, notFound
+}
+
+// findUsersByID loads users by their hex IDs, skipping malformed IDs
+func findUsersByID(ctx context.Context, userIDs []string) ([]models.User, error) {
+	objIDs := make([]primitive.ObjectID, 0, len(userIDs))
+	for _, id := range userIDs {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			continue
+		}
+		objIDs = append(objIDs, objID)
+	}
+
+	users := make([]models.User, 0)
+	if len(objIDs) == 0 {
+		return users, nil
+	}
+
+	cursor, err := config.GetUserCollection().Find(ctx, bson.M{"_id": bson.M{"$in": objIDs}})
+	if err != nil {
+		return users, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &users)
+	return users, err
+}
@@ -0,0 +1,497 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/socketio"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Every stroke and text edit is recorded per user and paper, as the changed
+// items before and after the edit. Undo puts the items of the user's last
+// edit back the way they were, but only the items nobody has changed since:
+// an item someone else edited or deleted is skipped, so undo never removes
+// another user's work. Redo does the same in the other direction.
+
+const (
+	maxHistoryEntries    = 100
+	maxHistoryEntryBytes = 4 << 20
+)
+
+// HistoryResult describes an undo or redo
+type HistoryResult struct {
+	PaperID   string `json:"paper_id"`
+	Command   string `json:"command"`
+	EntryID   string `json:"entry_id"`
+	Kind      string `json:"kind"`
+	Applied   int    `json:"applied"`
+	Skipped   int    `json:"skipped"`
+	Version   int64  `json:"version"`
+	UndoCount int64  `json:"undo_count"`
+	RedoCount int64  `json:"redo_count"`
+}
+
+// UndoPaper undoes the caller's last edit to a paper
+func UndoPaper(w http.ResponseWriter, r *http.Request) {
+	handleHistoryCommand(w, r, "undo")
+}
+
+// RedoPaper redoes the caller's last undone edit to a paper
+func RedoPaper(w http.ResponseWriter, r *http.Request) {
+	handleHistoryCommand(w, r, "redo")
+}
+
+func handleHistoryCommand(w http.ResponseWriter, r *http.Request, command string) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var historyRequest struct {
+		PaperID string `json:"paper_id"`
+	}
+	if err := json.Unmarshal(body, &historyRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	result, err := runHistoryCommand(context.Background(), userID, command, historyRequest.PaperID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	setETag(w, result.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetPaperHistory tells the caller how many of their edits to a paper can be
+// undone and redone, for a device that joins after the edits were made
+func GetPaperHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.Background()
+	paper, err := findHistoryPaper(ctx, r.URL.Query().Get("paper_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, paper.RoomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	undoCount, redoCount, err := countHistory(ctx, paper.ID.Hex(), userID)
+	if err != nil {
+		log.Printf("Error counting history of paper %s: %v", paper.ID.Hex(), err)
+		http.Error(w, "Failed to fetch history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"paper_id":   paper.ID.Hex(),
+		"undo_count": undoCount,
+		"redo_count": redoCount,
+	})
+}
+
+// HistoryCommand runs an undo or redo for the socket server, which cannot
+// import this package
+func HistoryCommand(userID, command, paperID string) (interface{}, error) {
+	return runHistoryCommand(context.Background(), userID, command, paperID)
+}
+
+func findHistoryPaper(ctx context.Context, paperID string) (models.Paper, error) {
+	var paper models.Paper
+	objID, err := primitive.ObjectIDFromHex(paperID)
+	if err != nil {
+		return paper, newRequestError(http.StatusBadRequest, "Invalid paper ID format")
+	}
+	findOptions := options.FindOne().SetProjection(bson.M{"room_id": 1, "file_id": 1, "version": 1})
+	if err := config.GetPaperCollection().FindOne(ctx, bson.M{"_id": objID}, findOptions).Decode(&paper); err != nil {
+		return paper, newRequestError(http.StatusNotFound, "Paper not found")
+	}
+	return paper, nil
+}
+
+// runHistoryCommand undoes or redoes the user's latest edit of the paper
+func runHistoryCommand(ctx context.Context, userID, command, paperID string) (HistoryResult, error) {
+	result := HistoryResult{PaperID: paperID, Command: command}
+	if command != "undo" && command != "redo" {
+		return result, newRequestError(http.StatusBadRequest, "command must be 'undo' or 'redo'")
+	}
+
+	paper, err := findHistoryPaper(ctx, paperID)
+	if err != nil {
+		return result, err
+	}
+	if err := checkPermission(ctx, userID, paper.RoomID, models.PermEdit); err != nil {
+		return result, err
+	}
+
+	autoSnapshot(ctx, paper.FileID, userID)
+
+	undo := command == "undo"
+	var strokeOps []StrokeOp
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		historyCollection := config.GetPaperHistoryCollection()
+		strokeOps = nil
+
+		filter := bson.M{"paper_id": paperID, "user_id": userID, "undone": !undo}
+		findOptions := options.FindOne().SetSort(bson.M{"_id": -1})
+		if !undo {
+			findOptions.SetSort(bson.M{"undone_at": -1})
+		}
+		var entry models.PaperHistoryEntry
+		if err := historyCollection.FindOne(sessCtx, filter, findOptions).Decode(&entry); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return newRequestError(http.StatusConflict, fmt.Sprintf("Nothing to %s", command))
+			}
+			return fmt.Errorf("failed to find history entry: %v", err)
+		}
+		result.EntryID = entry.ID.Hex()
+		result.Kind = entry.Kind
+
+		papers, err := findPapers(sessCtx, bson.M{"_id": paper.ID})
+		if err != nil || len(papers) == 0 {
+			return newRequestError(http.StatusNotFound, "Paper not found")
+		}
+		current := papers[0]
+		result.Version = current.Version
+
+		switch entry.Kind {
+		case models.HistoryStrokes:
+			strokeOps, result.Skipped = revertStrokes(current.DrawingData, entry.Strokes, undo)
+			result.Applied = len(strokeOps)
+			if len(strokeOps) > 0 {
+				if result.Version, _, err = applyStrokeOps(sessCtx, paper.ID, strokeOps, nil); err != nil {
+					return err
+				}
+			}
+
+		case models.HistoryText:
+			var texts []models.TextAnnotation
+			texts, result.Applied, result.Skipped = revertTexts(current.TextData, entry.Texts, undo)
+			if result.Applied > 0 {
+				update := bson.M{"$set": bson.M{"text_data": texts, "updated_at": time.Now()}}
+				if result.Version, err = updateVersioned(sessCtx, config.GetPaperCollection(), models.StarPaper, paper.ID, update, nil, &models.Paper{}); err != nil {
+					return err
+				}
+			}
+		}
+
+		mark := bson.M{"$set": bson.M{"undone": true, "undone_at": time.Now()}}
+		if !undo {
+			mark = bson.M{"$set": bson.M{"undone": false}, "$unset": bson.M{"undone_at": ""}}
+		}
+		if _, err := historyCollection.UpdateOne(sessCtx, bson.M{"_id": entry.ID}, mark); err != nil {
+			return fmt.Errorf("failed to update history entry: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, ok := err.(*requestError); !ok {
+			log.Printf("Error running %s on paper %s: %v", command, paperID, err)
+		}
+		return result, versionedWriteError(err, fmt.Sprintf("Failed to %s", command))
+	}
+
+	if result.UndoCount, result.RedoCount, err = countHistory(ctx, paperID, userID); err != nil {
+		log.Printf("Error counting history of paper %s: %v", paperID, err)
+	}
+
+	if result.Applied > 0 {
+		touchFile(ctx, paper.RoomID, paper.FileID, userID)
+	}
+
+	if socketServer := socketio.ServerInstance; socketServer != nil {
+		if len(strokeOps) > 0 {
+			socketServer.BroadcastToRoom("", paper.RoomID, "stroke_delta", map[string]interface{}{
+				"roomID":   paper.RoomID,
+				"file_id":  paper.FileID,
+				"paper_id": paperID,
+				"user_id":  userID,
+				"version":  result.Version,
+				"ops":      strokeOps,
+			})
+		}
+		if result.Kind == models.HistoryText && result.Applied > 0 {
+			papers, _ := findPapers(ctx, bson.M{"room_id": paper.RoomID})
+			socketServer.BroadcastToRoom("", paper.RoomID, "paper_list_updated", map[string]interface{}{
+				"roomID": paper.RoomID,
+				"papers": papers,
+			})
+		}
+		// Lets the user's other devices update their undo and redo buttons
+		socketServer.BroadcastToRoom("", paper.RoomID, "history_applied", map[string]interface{}{
+			"roomID":  paper.RoomID,
+			"user_id": userID,
+			"result":  result,
+		})
+	}
+	return result, nil
+}
+
+// revertStrokes returns the ops that take the changed strokes back to their
+// state before the edit (undo) or after it (redo). A stroke that is no longer
+// in the state the edit left it in was changed by someone else and is skipped.
+func revertStrokes(current []models.DrawingPoint, changes []models.StrokeChange, undo bool) ([]StrokeOp, int) {
+	strokes := make(map[int]*models.DrawingPoint, len(current))
+	for i := range current {
+		strokes[current[i].ID] = &current[i]
+	}
+
+	var ops []StrokeOp
+	skipped := 0
+	for _, change := range changes {
+		target, expected := change.Before, change.After
+		if !undo {
+			target, expected = change.After, change.Before
+		}
+		if !sameStroke(strokes[change.StrokeID], expected) {
+			skipped++
+			continue
+		}
+		if target == nil {
+			strokeID := change.StrokeID
+			ops = append(ops, StrokeOp{Op: "delete", StrokeID: &strokeID})
+		} else {
+			ops = append(ops, StrokeOp{Op: "add", Stroke: target, replay: true})
+		}
+	}
+	return ops, skipped
+}
+
+// revertTexts is revertStrokes for text annotations. It returns the whole new
+// list, as text is stored in one array.
+func revertTexts(current []models.TextAnnotation, changes []models.TextChange, undo bool) ([]models.TextAnnotation, int, int) {
+	texts := append([]models.TextAnnotation{}, current...)
+	find := func(textID int) int {
+		for i := range texts {
+			if texts[i].ID == textID {
+				return i
+			}
+		}
+		return -1
+	}
+
+	applied, skipped := 0, 0
+	for _, change := range changes {
+		target, expected := change.Before, change.After
+		if !undo {
+			target, expected = change.After, change.Before
+		}
+
+		i := find(change.TextID)
+		var existing *models.TextAnnotation
+		if i >= 0 {
+			existing = &texts[i]
+		}
+		if !sameText(existing, expected) {
+			skipped++
+			continue
+		}
+
+		switch {
+		case target == nil:
+			texts = append(texts[:i], texts[i+1:]...)
+		case i >= 0:
+			texts[i] = *target
+		default:
+			texts = append(texts, *target)
+		}
+		applied++
+	}
+	return texts, applied, skipped
+}
+
+func sameText(a, b *models.TextAnnotation) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// diffStrokes returns the strokes that differ between two full stroke lists
+func diffStrokes(before, after []models.DrawingPoint) []models.StrokeChange {
+	old := make(map[int]*models.DrawingPoint, len(before))
+	for i := range before {
+		old[before[i].ID] = &before[i]
+	}
+
+	var changes []models.StrokeChange
+	kept := make(map[int]bool, len(after))
+	for i := range after {
+		stroke := &after[i]
+		kept[stroke.ID] = true
+		if !sameStroke(old[stroke.ID], stroke) {
+			changes = append(changes, models.StrokeChange{StrokeID: stroke.ID, Before: old[stroke.ID], After: stroke})
+		}
+	}
+	for i := range before {
+		if !kept[before[i].ID] {
+			changes = append(changes, models.StrokeChange{StrokeID: before[i].ID, Before: &before[i]})
+		}
+	}
+	return changes
+}
+
+// diffTexts returns the text annotations that differ between two full lists
+func diffTexts(before, after []models.TextAnnotation) []models.TextChange {
+	old := make(map[int]*models.TextAnnotation, len(before))
+	for i := range before {
+		old[before[i].ID] = &before[i]
+	}
+
+	var changes []models.TextChange
+	kept := make(map[int]bool, len(after))
+	for i := range after {
+		text := &after[i]
+		kept[text.ID] = true
+		if !sameText(old[text.ID], text) {
+			changes = append(changes, models.TextChange{TextID: text.ID, Before: old[text.ID], After: text})
+		}
+	}
+	for i := range before {
+		if !kept[before[i].ID] {
+			changes = append(changes, models.TextChange{TextID: before[i].ID, Before: &before[i]})
+		}
+	}
+	return changes
+}
+
+func recordStrokeHistory(sessCtx mongo.SessionContext, paperID, userID string, changes []models.StrokeChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return recordHistory(sessCtx, models.PaperHistoryEntry{PaperID: paperID, UserID: userID, Kind: models.HistoryStrokes, Strokes: changes})
+}
+
+func recordTextHistory(sessCtx mongo.SessionContext, paperID, userID string, changes []models.TextChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	return recordHistory(sessCtx, models.PaperHistoryEntry{PaperID: paperID, UserID: userID, Kind: models.HistoryText, Texts: changes})
+}
+
+// recordHistory adds an edit to the user's history of a paper, in the
+// transaction that made the edit. A new edit ends the user's redo stack, and
+// only the newest maxHistoryEntries edits are kept.
+func recordHistory(sessCtx mongo.SessionContext, entry models.PaperHistoryEntry) error {
+	historyCollection := config.GetPaperHistoryCollection()
+	mine := bson.M{"paper_id": entry.PaperID, "user_id": entry.UserID}
+
+	redo := bson.M{"paper_id": entry.PaperID, "user_id": entry.UserID, "undone": true}
+	if _, err := historyCollection.DeleteMany(sessCtx, redo); err != nil {
+		return fmt.Errorf("failed to clear redo history: %v", err)
+	}
+
+	// Replacing every stroke of a crowded page can make an edit too large to
+	// keep; it is left out of the history rather than failing the edit
+	if size := historyEntrySize(entry); size > maxHistoryEntryBytes {
+		log.Printf("Not recording %d byte edit of paper %s in the history", size, entry.PaperID)
+		return nil
+	}
+
+	entry.ID = primitive.NewObjectID()
+	entry.CreatedAt = time.Now()
+	if _, err := historyCollection.InsertOne(sessCtx, entry); err != nil {
+		return fmt.Errorf("failed to record history: %v", err)
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.M{"_id": -1}).
+		SetSkip(maxHistoryEntries).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := historyCollection.Find(sessCtx, mine, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query old history: %v", err)
+	}
+	var old []models.PaperHistoryEntry
+	if err := cursor.All(sessCtx, &old); err != nil {
+		return fmt.Errorf("failed to decode old history: %v", err)
+	}
+	if len(old) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, 0, len(old))
+	for _, stale := range old {
+		ids = append(ids, stale.ID)
+	}
+	if _, err := historyCollection.DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return fmt.Errorf("failed to prune history: %v", err)
+	}
+	return nil
+}
+
+func historyEntrySize(entry models.PaperHistoryEntry) int {
+	size := 0
+	for _, change := range entry.Strokes {
+		if change.Before != nil {
+			size += change.Before.PackedSize()
+		}
+		if change.After != nil {
+			size += change.After.PackedSize()
+		}
+	}
+	for _, change := range entry.Texts {
+		// The text plus about 150 bytes for the other fields
+		if change.Before != nil {
+			size += 150 + len(change.Before.Text)
+		}
+		if change.After != nil {
+			size += 150 + len(change.After.Text)
+		}
+	}
+	return size
+}
+
+func countHistory(ctx context.Context, paperID, userID string) (int64, int64, error) {
+	historyCollection := config.GetPaperHistoryCollection()
+	undoCount, err := historyCollection.CountDocuments(ctx, bson.M{"paper_id": paperID, "user_id": userID, "undone": false})
+	if err != nil {
+		return 0, 0, err
+	}
+	redoCount, err := historyCollection.CountDocuments(ctx, bson.M{"paper_id": paperID, "user_id": userID, "undone": true})
+	if err != nil {
+		return 0, 0, err
+	}
+	return undoCount, redoCount, nil
+}
+
+// deletePaperHistory removes the history of deleted papers
+func deletePaperHistory(ctx context.Context, paperIDs []string) error {
+	if len(paperIDs) == 0 {
+		return nil
+	}
+	if _, err := config.GetPaperHistoryCollection().DeleteMany(ctx, bson.M{"paper_id": bson.M{"$in": paperIDs}}); err != nil {
+		return fmt.Errorf("failed to delete paper history: %v", err)
+	}
+	return nil
+}
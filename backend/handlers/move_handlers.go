@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MoveRequest re-parents a folder or file. TargetRoomID defaults to the item's
+// current room; TargetFolderID is a folder ID or the root sentinel.
+// ExpectedVersion, when set, is the version of the item the move is based on.
+type MoveRequest struct {
+	ItemType        string `json:"item_type"`
+	ItemID          string `json:"item_id"`
+	TargetRoomID    string `json:"target_room_id"`
+	TargetFolderID  string `json:"target_folder_id"`
+	ExpectedVersion *int64 `json:"expected_version,omitempty"`
+}
+
+// MoveResult describes a completed move
+type MoveResult struct {
+	ItemType     string `json:"item_type"`
+	ItemID       string `json:"item_id"`
+	SourceRoomID string `json:"source_room_id"`
+	RoomID       string `json:"room_id"`
+	SubFolderID  string `json:"sub_folder_id"`
+	Folders      int64  `json:"folders"`
+	Files        int64  `json:"files"`
+	Papers       int64  `json:"papers"`
+	Version      int64  `json:"version"`
+}
+
+// MoveItem moves a folder or file to another folder, in the same room or in
+// another room. Moving within a room needs edit permission; moving to another
+// room needs delete permission in the source room and create permission in
+// the target room.
+func MoveItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var moveRequest MoveRequest
+	if err := json.Unmarshal(body, &moveRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+	moveRequest.ExpectedVersion, err = expectedVersion(r, moveRequest.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var result MoveResult
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		result, err = moveItem(sessCtx, userID, moveRequest)
+		return err
+	})
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	broadcastFolderList(ctx, result.SourceRoomID)
+	broadcastFileList(ctx, result.SourceRoomID)
+	if result.RoomID != result.SourceRoomID {
+		broadcastFolderList(ctx, result.RoomID)
+		broadcastFileList(ctx, result.RoomID)
+	}
+
+	setETag(w, result.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Item moved successfully",
+		"result":  result,
+	})
+}
+
+// moveItem performs a move without broadcasting so callers can coalesce list updates
+func moveItem(ctx context.Context, userID string, req MoveRequest) (MoveResult, error) {
+	result := MoveResult{ItemType: req.ItemType, ItemID: req.ItemID}
+
+	objID, err := primitive.ObjectIDFromHex(req.ItemID)
+	if err != nil {
+		return result, newRequestError(http.StatusBadRequest, "Invalid item ID format")
+	}
+
+	var item struct {
+		RoomID      string `bson:"room_id"`
+		SubFolderID string `bson:"sub_folder_id"`
+		Version     int64  `bson:"version"`
+	}
+	var current interface{}
+	var collection *mongo.Collection
+	switch req.ItemType {
+	case models.StarFolder:
+		collection, current = config.GetFolderCollection(), &models.Folder{}
+	case models.StarFile:
+		collection, current = config.GetFileCollection(), &models.File{}
+	default:
+		return result, newRequestError(http.StatusBadRequest, "item_type must be 'folder' or 'file'")
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return result, newRequestError(http.StatusNotFound, fmt.Sprintf("%s not found", req.ItemType))
+	}
+	result.Version = item.Version
+
+	result.SourceRoomID = item.RoomID
+	result.RoomID = req.TargetRoomID
+	if result.RoomID == "" {
+		result.RoomID = item.RoomID
+	}
+	result.SubFolderID = req.TargetFolderID
+	if isRootFolder(result.SubFolderID) {
+		result.SubFolderID = models.RootFolderID
+	}
+	crossRoom := result.RoomID != result.SourceRoomID
+
+	if crossRoom {
+		if err := checkPermission(ctx, userID, result.SourceRoomID, models.PermDelete); err != nil {
+			return result, err
+		}
+		if err := checkPermission(ctx, userID, result.RoomID, models.PermCreate); err != nil {
+			return result, err
+		}
+	} else if err := checkPermission(ctx, userID, result.SourceRoomID, models.PermEdit); err != nil {
+		return result, err
+	}
+
+	if !isRootFolder(result.SubFolderID) {
+		targetObjID, err := primitive.ObjectIDFromHex(result.SubFolderID)
+		if err != nil {
+			return result, newRequestError(http.StatusBadRequest, "Invalid target folder ID format")
+		}
+
+		var target models.Folder
+		if err := config.GetFolderCollection().FindOne(ctx, bson.M{"_id": targetObjID}).Decode(&target); err != nil {
+			return result, newRequestError(http.StatusNotFound, "Target folder not found")
+		}
+		if target.RoomID != result.RoomID {
+			return result, newRequestError(http.StatusBadRequest, "Target folder is not in the target room")
+		}
+
+		// A folder cannot be moved into itself or anything below it
+		if req.ItemType == models.StarFolder {
+			for _, ancestor := range resolveFolderPath(ctx, result.SubFolderID) {
+				if ancestor.ID == req.ItemID {
+					return result, newRequestError(http.StatusConflict, "Cannot move a folder into its own subtree")
+				}
+			}
+		}
+	}
+
+	if req.ExpectedVersion != nil && *req.ExpectedVersion != item.Version {
+		return result, currentVersionError(ctx, collection, req.ItemType, objID, req.ExpectedVersion, current)
+	}
+	if !crossRoom && item.SubFolderID == result.SubFolderID {
+		return result, nil
+	}
+
+	if crossRoom {
+		if err := moveSubtreeToRoom(ctx, req.ItemType, req.ItemID, result.SourceRoomID, result.RoomID, &result); err != nil {
+			log.Printf("Error moving %s %s to room %s: %v", req.ItemType, req.ItemID, result.RoomID, err)
+			return result, newRequestError(http.StatusInternalServerError, "Failed to move item contents")
+		}
+	}
+
+	// The item goes to the end of its new parent
+	position, err := nextPosition(ctx, collection, result.RoomID, result.SubFolderID)
+	if err != nil {
+		return result, newRequestError(http.StatusInternalServerError, "Failed to compute position")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"room_id":       result.RoomID,
+		"sub_folder_id": result.SubFolderID,
+		"position":      position,
+		"updatedAt":     time.Now(),
+	}}
+	if crossRoom {
+		update["$unset"] = bson.M{"tags": ""}
+	}
+	result.Version, err = updateVersioned(ctx, collection, req.ItemType, objID, update, req.ExpectedVersion, current)
+	if err != nil {
+		return result, versionedWriteError(err, "Failed to move item")
+	}
+
+	if crossRoom && req.ItemType == models.StarFolder {
+		result.Folders++
+	} else if crossRoom {
+		result.Files++
+	}
+
+	return result, nil
+}
+
+// moveSubtreeToRoom rewrites room_id on every folder, file and paper below the
+// moved item, and on the stars that point at them. The item itself is left to
+// the caller, which checks its version.
+func moveSubtreeToRoom(ctx context.Context, itemType, itemID, sourceRoomID, targetRoomID string, result *MoveResult) error {
+	var folderIDs, fileIDs []string
+
+	if itemType == models.StarFolder {
+		var err error
+		folderIDs, err = collectFolderSubtree(ctx, itemID)
+		if err != nil {
+			return err
+		}
+
+		cursor, err := config.GetFileCollection().Find(ctx, bson.M{"sub_folder_id": bson.M{"$in": folderIDs}})
+		if err != nil {
+			return fmt.Errorf("failed to query files: %v", err)
+		}
+		var files []models.File
+		if err := cursor.All(ctx, &files); err != nil {
+			return fmt.Errorf("failed to decode files: %v", err)
+		}
+		for _, file := range files {
+			fileIDs = append(fileIDs, file.ID.Hex())
+		}
+	} else {
+		fileIDs = []string{itemID}
+	}
+
+	cursor, err := config.GetPaperCollection().Find(ctx, bson.M{"file_id": bson.M{"$in": fileIDs}})
+	if err != nil {
+		return fmt.Errorf("failed to query papers: %v", err)
+	}
+	var papers []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &papers); err != nil {
+		return fmt.Errorf("failed to decode papers: %v", err)
+	}
+	paperIDs := make([]string, 0, len(papers))
+	for _, paper := range papers {
+		paperIDs = append(paperIDs, paper.ID.Hex())
+	}
+
+	setRoom := bson.M{"$set": bson.M{"room_id": targetRoomID}}
+	setRoomVersioned := bumpVersion(bson.M{"$set": bson.M{"room_id": targetRoomID}})
+	// Tags are defined per room, so they do not follow items to another room
+	setRoomUntagged := bumpVersion(bson.M{"$set": bson.M{"room_id": targetRoomID}, "$unset": bson.M{"tags": ""}})
+
+	itemObjID, _ := primitive.ObjectIDFromHex(itemID)
+	belowItem := func(ids []string) bson.M {
+		return bson.M{"_id": bson.M{"$in": toObjectIDs(ids), "$ne": itemObjID}}
+	}
+
+	folderResult, err := config.GetFolderCollection().UpdateMany(ctx, belowItem(folderIDs), setRoomUntagged)
+	if err != nil {
+		return fmt.Errorf("failed to move folders: %v", err)
+	}
+	fileResult, err := config.GetFileCollection().UpdateMany(ctx, belowItem(fileIDs), setRoomUntagged)
+	if err != nil {
+		return fmt.Errorf("failed to move files: %v", err)
+	}
+	paperResult, err := config.GetPaperCollection().UpdateMany(ctx, bson.M{"file_id": bson.M{"$in": fileIDs}}, setRoomVersioned)
+	if err != nil {
+		return fmt.Errorf("failed to move papers: %v", err)
+	}
+
+	itemIDs := append(append(append([]string{}, folderIDs...), fileIDs...), paperIDs...)
+	starFilter := bson.M{"room_id": sourceRoomID, "item_id": bson.M{"$in": itemIDs}}
+	if _, err := config.GetStarredCollection().UpdateMany(ctx, starFilter, setRoom); err != nil {
+		return fmt.Errorf("failed to move stars: %v", err)
+	}
+	if _, err := config.GetFileActivityCollection().UpdateMany(ctx, bson.M{"file_id": bson.M{"$in": fileIDs}}, setRoom); err != nil {
+		return fmt.Errorf("failed to move file activity: %v", err)
+	}
+
+	result.Folders = folderResult.ModifiedCount
+	result.Files = fileResult.ModifiedCount
+	result.Papers = paperResult.ModifiedCount
+	return nil
+}
+
+// collectFolderSubtree returns the folder and every folder below it
+func collectFolderSubtree(ctx context.Context, folderID string) ([]string, error) {
+	folderIDs := []string{folderID}
+	seen := map[string]bool{folderID: true}
+	level := []string{folderID}
+
+	for depth := 0; len(level) > 0 && depth < maxFolderDepth; depth++ {
+		cursor, err := config.GetFolderCollection().Find(ctx, bson.M{"sub_folder_id": bson.M{"$in": level}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query sub-folders: %v", err)
+		}
+
+		var children []models.Folder
+		if err := cursor.All(ctx, &children); err != nil {
+			return nil, fmt.Errorf("failed to decode sub-folders: %v", err)
+		}
+
+		level = level[:0:0]
+		for _, child := range children {
+			childID := child.ID.Hex()
+			if seen[childID] {
+				continue
+			}
+			seen[childID] = true
+			folderIDs = append(folderIDs, childID)
+			level = append(level, childID)
+		}
+	}
+	return folderIDs, nil
+}
+
+func toObjectIDs(ids []string) []primitive.ObjectID {
+	objIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+			objIDs = append(objIDs, objID)
+		}
+	}
+	return objIDs
+}
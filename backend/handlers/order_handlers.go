@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ReorderRequest places a folder or file right after AfterID among its
+// siblings. An empty AfterID moves the item to the top.
+type ReorderRequest struct {
+	ItemType        string `json:"item_type"`
+	ItemID          string `json:"item_id"`
+	AfterID         string `json:"after_id"`
+	ExpectedVersion *int64 `json:"expected_version"`
+}
+
+// sibling is the part of a folder or file needed to order it
+type sibling struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Position string             `bson:"position"`
+}
+
+// ReorderItem changes the manual position of a folder or file within its
+// parent folder. Only the moved item is rewritten, unless its siblings have
+// never been ordered, in which case they get positions in their current order.
+func ReorderItem(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var reorderRequest ReorderRequest
+	if err := json.Unmarshal(body, &reorderRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	var collection *mongo.Collection
+	var current interface{}
+	switch reorderRequest.ItemType {
+	case models.StarFolder:
+		collection, current = config.GetFolderCollection(), &models.Folder{}
+	case models.StarFile:
+		collection, current = config.GetFileCollection(), &models.File{}
+	default:
+		http.Error(w, "item_type must be 'folder' or 'file'", http.StatusBadRequest)
+		return
+	}
+
+	objID, err := primitive.ObjectIDFromHex(reorderRequest.ItemID)
+	if err != nil {
+		http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var item struct {
+		RoomID      string `bson:"room_id"`
+		SubFolderID string `bson:"sub_folder_id"`
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		http.Error(w, fmt.Sprintf("%s not found", reorderRequest.ItemType), http.StatusNotFound)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, item.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, reorderRequest.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	position, err := positionAfter(ctx, collection, item.RoomID, item.SubFolderID, objID, reorderRequest.AfterID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"position": position, "updatedAt": time.Now()}}
+	version, err := updateVersioned(ctx, collection, reorderRequest.ItemType, objID, update, expected, current)
+	if err != nil {
+		writeRequestError(w, versionedWriteError(err, "Failed to reorder item"))
+		return
+	}
+
+	if reorderRequest.ItemType == models.StarFolder {
+		broadcastFolderList(ctx, item.RoomID)
+	} else {
+		broadcastFileList(ctx, item.RoomID)
+	}
+
+	setETag(w, version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Item reordered successfully",
+		"item_id":  reorderRequest.ItemID,
+		"position": position,
+		"version":  version,
+	})
+}
+
+// positionAfter returns a position that places an item right after afterID
+// among the other children of parentID
+func positionAfter(ctx context.Context, collection *mongo.Collection, roomID, parentID string, itemID primitive.ObjectID, afterID string) (string, error) {
+	siblings, err := loadSiblings(ctx, collection, roomID, parentID, itemID)
+	if err != nil {
+		log.Printf("Error loading siblings in room %s: %v", roomID, err)
+		return "", newRequestError(http.StatusInternalServerError, "Failed to load sibling items")
+	}
+
+	index := -1
+	if afterID != "" {
+		for i, s := range siblings {
+			if s.ID.Hex() == afterID {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return "", newRequestError(http.StatusBadRequest, "after_id is not a sibling of the item")
+		}
+	}
+
+	before, after := "", ""
+	if index >= 0 {
+		before = siblings[index].Position
+	}
+	if index+1 < len(siblings) {
+		after = siblings[index+1].Position
+	}
+
+	position, err := utils.KeyBetween(before, after)
+	if err != nil {
+		return "", newRequestError(http.StatusInternalServerError, "Failed to compute position")
+	}
+	return position, nil
+}
+
+// loadSiblings returns the children of parentID except the item, in display
+// order. Siblings without a position are given one first, so that every key
+// in the result is set and strictly increasing.
+func loadSiblings(ctx context.Context, collection *mongo.Collection, roomID, parentID string, itemID primitive.ObjectID) ([]sibling, error) {
+	filter := siblingFilter(roomID, parentID)
+	filter["_id"] = bson.M{"$ne": itemID}
+
+	var siblings []sibling
+	cursor, err := collection.Find(ctx, filter, positionSort().SetProjection(bson.M{"position": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &siblings); err != nil {
+		return nil, err
+	}
+
+	ordered := true
+	for i, s := range siblings {
+		if s.Position == "" || (i > 0 && s.Position <= siblings[i-1].Position) {
+			ordered = false
+			break
+		}
+	}
+	if ordered {
+		return siblings, nil
+	}
+
+	keys, err := utils.KeysBetween("", "", len(siblings))
+	if err != nil {
+		return nil, err
+	}
+	writes := make([]mongo.WriteModel, 0, len(siblings))
+	for i := range siblings {
+		siblings[i].Position = keys[i]
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": siblings[i].ID}).
+			SetUpdate(bson.M{"$set": bson.M{"position": keys[i]}}))
+	}
+	if _, err := collection.BulkWrite(ctx, writes); err != nil {
+		return nil, err
+	}
+	return siblings, nil
+}
+
+// nextPosition returns a position after every existing child of parentID
+func nextPosition(ctx context.Context, collection *mongo.Collection, roomID, parentID string) (string, error) {
+	var last sibling
+	findOptions := options.FindOne().
+		SetSort(bson.M{"position": -1}).
+		SetProjection(bson.M{"position": 1})
+	err := collection.FindOne(ctx, siblingFilter(roomID, parentID), findOptions).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	return utils.KeyBetween(last.Position, "")
+}
+
+// siblingFilter matches the children of parentID. Root items may be stored
+// with the root sentinel, an empty string or no parent at all.
+func siblingFilter(roomID, parentID string) bson.M {
+	if isRootFolder(parentID) {
+		return bson.M{"room_id": roomID, "sub_folder_id": bson.M{"$in": bson.A{models.RootFolderID, "", nil}}}
+	}
+	return bson.M{"room_id": roomID, "sub_folder_id": parentID}
+}
+
+// positionSort orders folders or files by manual position. Items that were
+// never ordered sort first, oldest first.
+func positionSort() *options.FindOptions {
+	return options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "createdAt", Value: 1}})
+}
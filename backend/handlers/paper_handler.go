@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"time"
 
@@ -16,6 +17,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func AddPaper(w http.ResponseWriter, r *http.Request) {
@@ -42,10 +44,22 @@ func AddPaper(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	// The file decides the room, so papers cannot be added to another room's file
+	file, err := findVersionedFile(r.Context(), paperRequest.FileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, file.RoomID, models.PermCreate)
+	if !ok {
+		return
+	}
+
 	paper := models.Paper{
 		ID:              primitive.NewObjectID(),
 		OriginalID:      paperRequest.PaperID,
-		RoomID:          paperRequest.RoomID,
+		RoomID:          file.RoomID,
 		FileID:          paperRequest.FileID,
 		TemplateID:      paperRequest.TemplateID,
 		PageNumber:      paperRequest.PageNumber,
@@ -63,16 +77,17 @@ func AddPaper(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
+	refreshFileMetadata(context.Background(), paper.RoomID, paper.FileID)
+
 	// 🔥 **Emit to all users in the room**
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
 		// Fetch updated folder list
-		var papers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"room_id": paperRequest.RoomID})
-		cursor.All(context.Background(), &papers)
+		papers, _ := findPapers(context.Background(), bson.M{"room_id": file.RoomID})
 
-		socketServer.BroadcastToRoom("", paperRequest.RoomID, "paper_list_updated", map[string]interface{}{
-			"roomID": paperRequest.RoomID,
+		socketServer.BroadcastToRoom("", file.RoomID, "paper_list_updated", map[string]interface{}{
+			"roomID": file.RoomID,
 			"papers": papers,
 		})
 	}
@@ -109,6 +124,18 @@ func InsertPaperAt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The file decides the room, so papers cannot be added to another room's file
+	file, err := findVersionedFile(r.Context(), insertRequest.FileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, file.RoomID, models.PermCreate)
+	if !ok {
+		return
+	}
+
 	// Calculate the new page number (position + 1)
 	newPageNumber := insertRequest.InsertPosition + 1
 
@@ -116,7 +143,7 @@ func InsertPaperAt(w http.ResponseWriter, r *http.Request) {
 	paper := models.Paper{
 		ID:              primitive.NewObjectID(),
 		OriginalID:      insertRequest.PaperID, // Generate a new unique ID
-		RoomID:          insertRequest.RoomID,
+		RoomID:          file.RoomID,
 		FileID:          insertRequest.FileID,
 		TemplateID:      insertRequest.TemplateID,
 		PageNumber:      newPageNumber,
@@ -139,7 +166,7 @@ func InsertPaperAt(w http.ResponseWriter, r *http.Request) {
 		"$inc": bson.M{"page_number": 1},
 	}
 
-	_, err = paperCollection.UpdateMany(context.Background(), filter, update)
+	_, err = paperCollection.UpdateMany(context.Background(), filter, bumpVersion(update))
 	if err != nil {
 		http.Error(w, "Failed to update existing papers", http.StatusInternalServerError)
 		return
@@ -152,16 +179,17 @@ func InsertPaperAt(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
+	refreshFileMetadata(context.Background(), paper.RoomID, paper.FileID)
+
 	// Broadcast to all users in the room
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
 		// Fetch updated paper list
-		var papers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"room_id": insertRequest.RoomID})
-		cursor.All(context.Background(), &papers)
+		papers, _ := findPapers(context.Background(), bson.M{"room_id": file.RoomID})
 
-		socketServer.BroadcastToRoom("", insertRequest.RoomID, "paper_list_updated", map[string]interface{}{
-			"roomID": insertRequest.RoomID,
+		socketServer.BroadcastToRoom("", file.RoomID, "paper_list_updated", map[string]interface{}{
+			"roomID": file.RoomID,
 			"papers": papers,
 		})
 	}
@@ -217,32 +245,51 @@ func AddDrawingPoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	paperCollection := config.GetPaperCollection()
+	var paper models.Paper
+	filter := bson.M{"_id": paperObjID}
+	err = paperCollection.FindOne(context.Background(), filter).Decode(&paper)
+
+	// If paper not found, return an error
+	if err != nil {
+		http.Error(w, "Paper not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, paper.RoomID, models.PermEdit)
+	if !ok {
+		return
+	}
+
+	// The body is the whole list, so the expected version comes as If-Match
+	expected, err := expectedVersion(r, nil)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	autoSnapshot(context.Background(), paper.FileID, userID)
+
 	// Validate input
 	if len(drawingRequests) == 0 {
 		// If no drawing data, set the drawing data to null in the database
-		update := bson.M{
-			"$set": bson.M{
-				"drawing_data": nil,
-				"updated_at":   time.Now(),
-			},
-		}
-
-		// Update the paper with null drawing data
-		paperCollection := config.GetPaperCollection()
-		_, err := paperCollection.UpdateOne(context.Background(), bson.M{"_id": paperObjID}, update)
+		version, err := replaceDrawingData(paperObjID, nil, expected, &paper, userID)
 		if err != nil {
-			http.Error(w, "Failed to update paper", http.StatusInternalServerError)
+			writeRequestError(w, versionedWriteError(err, "Failed to update paper"))
 			return
 		}
+		touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
 
 		// Send success response for no drawing data
+		setETag(w, version)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
+		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message":   "Drawing data set to null successfully",
 			"paper_id":  paperID,
 			"room_id":   "", // Empty room_id for no data
 			"file_id":   "", // Empty file_id for no data
 			"timestamp": time.Now().Format(time.RFC3339),
+			"version":   version,
 		})
 		return
 	}
@@ -272,41 +319,22 @@ func AddDrawingPoint(w http.ResponseWriter, r *http.Request) {
 			Width:   req.Data.Width,
 			Tool:    req.Data.Tool,
 		}
+		simplifyStroke(&drawingPoint)
 		drawingPoints = append(drawingPoints, drawingPoint)
 	}
 
 	// Replace drawing points instead of appending
-	paperCollection := config.GetPaperCollection()
-	var paper models.Paper
-	filter := bson.M{"_id": paperObjID}
-	err = paperCollection.FindOne(context.Background(), filter).Decode(&paper)
-
-	// If paper not found, return an error
+	version, err := replaceDrawingData(paperObjID, drawingPoints, expected, &paper, userID)
 	if err != nil {
-		http.Error(w, "Paper not found", http.StatusNotFound)
-		return
-	}
-
-	// Update the paper's drawing data and timestamp
-	update := bson.M{
-		"$set": bson.M{
-			"drawing_data": drawingPoints,
-			"updated_at":   time.Now(),
-		},
-	}
-
-	_, err = paperCollection.UpdateOne(context.Background(), filter, update)
-	if err != nil {
-		http.Error(w, "Failed to update paper", http.StatusInternalServerError)
+		writeRequestError(w, versionedWriteError(err, "Failed to update paper"))
 		return
 	}
+	touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
 
 	// Broadcast to socket if needed
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
-		var papers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"room_id": paper.RoomID})
-		cursor.All(context.Background(), &papers)
+		papers, _ := findPapers(context.Background(), bson.M{"room_id": paper.RoomID})
 
 		socketServer.BroadcastToRoom("", paper.RoomID, "paper_list_updated", map[string]interface{}{
 			"roomID": paper.RoomID,
@@ -315,16 +343,73 @@ func AddDrawingPoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send success response
+	setETag(w, version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":   "Drawing data replaced successfully",
 		"paper_id":  paperID,
 		"room_id":   paper.RoomID,
 		"file_id":   paper.FileID,
 		"timestamp": time.Now().Format(time.RFC3339),
+		"version":   version,
 	})
 }
 
+// replaceDrawingData stores drawingPoints as all the strokes of a paper,
+// inline or in chunks depending on their size, bumps its version and records
+// the strokes that changed in the user's history
+func replaceDrawingData(paperObjID primitive.ObjectID, drawingPoints []models.DrawingPoint, expected *int64, paper *models.Paper, userID string) (int64, error) {
+	var version int64
+	err := config.RunInTransaction(context.Background(), func(sessCtx mongo.SessionContext) error {
+		current, err := findPapers(sessCtx, bson.M{"_id": paperObjID})
+		if err != nil {
+			return fmt.Errorf("failed to load strokes: %v", err)
+		}
+		var before []models.DrawingPoint
+		if len(current) > 0 {
+			before = current[0].DrawingData
+		}
+
+		update, err := replaceStrokes(sessCtx, paperObjID.Hex(), drawingPoints)
+		if err != nil {
+			return err
+		}
+		update["$set"].(bson.M)["updated_at"] = time.Now()
+
+		if version, err = updateVersioned(sessCtx, config.GetPaperCollection(), models.StarPaper, paperObjID, update, expected, paper); err != nil {
+			return err
+		}
+		return recordStrokeHistory(sessCtx, paperObjID.Hex(), userID, diffStrokes(before, drawingPoints))
+	})
+	return version, err
+}
+
+// replaceTextData stores texts as all the text annotations of a paper, bumps
+// its version and records the annotations that changed in the user's history
+func replaceTextData(paperObjID primitive.ObjectID, texts []models.TextAnnotation, expected *int64, paper *models.Paper, userID string) (int64, error) {
+	var version int64
+	err := config.RunInTransaction(context.Background(), func(sessCtx mongo.SessionContext) error {
+		var current models.Paper
+		findOptions := options.FindOne().SetProjection(bson.M{"text_data": 1})
+		if err := config.GetPaperCollection().FindOne(sessCtx, bson.M{"_id": paperObjID}, findOptions).Decode(&current); err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("failed to load text: %v", err)
+		}
+
+		update := bson.M{
+			"$set": bson.M{
+				"text_data":  texts,
+				"updated_at": time.Now(),
+			},
+		}
+		var err error
+		if version, err = updateVersioned(sessCtx, config.GetPaperCollection(), models.StarPaper, paperObjID, update, expected, paper); err != nil {
+			return err
+		}
+		return recordTextHistory(sessCtx, paperObjID.Hex(), userID, diffTexts(current.TextData, texts))
+	})
+	return version, err
+}
+
 func AddTextAnnotation(w http.ResponseWriter, r *http.Request) {
 	paperID := r.Header.Get("paper_id")
 	if paperID == "" {
@@ -370,32 +455,51 @@ func AddTextAnnotation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	paperCollection := config.GetPaperCollection()
+	var paper models.Paper
+	filter := bson.M{"_id": paperObjID}
+	err = paperCollection.FindOne(context.Background(), filter).Decode(&paper)
+
+	// If paper not found, return an error
+	if err != nil {
+		http.Error(w, "Paper not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, paper.RoomID, models.PermEdit)
+	if !ok {
+		return
+	}
+
+	// The body is the whole list, so the expected version comes as If-Match
+	expected, err := expectedVersion(r, nil)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	autoSnapshot(context.Background(), paper.FileID, userID)
+
 	// Validate input
 	if len(textRequests) == 0 {
 		// If no drawing data, set the drawing data to null in the database
-		update := bson.M{
-			"$set": bson.M{
-				"text_data":  nil,
-				"updated_at": time.Now(),
-			},
-		}
-
-		// Update the paper with null drawing data
-		paperCollection := config.GetPaperCollection()
-		_, err := paperCollection.UpdateOne(context.Background(), bson.M{"_id": paperObjID}, update)
+		version, err := replaceTextData(paperObjID, nil, expected, &paper, userID)
 		if err != nil {
-			http.Error(w, "Failed to update paper", http.StatusInternalServerError)
+			writeRequestError(w, versionedWriteError(err, "Failed to update paper"))
 			return
 		}
+		touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
 
 		// Send success response for no drawing data
+		setETag(w, version)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
+		json.NewEncoder(w).Encode(map[string]interface{}{
 			"message":   "Text data set to null successfully",
 			"paper_id":  paperID,
 			"room_id":   "", // Empty room_id for no data
 			"file_id":   "", // Empty file_id for no data
 			"timestamp": time.Now().Format(time.RFC3339),
+			"version":   version,
 		})
 		return
 	}
@@ -426,37 +530,17 @@ func AddTextAnnotation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Replace drawing points instead of appending
-	paperCollection := config.GetPaperCollection()
-	var paper models.Paper
-	filter := bson.M{"_id": paperObjID}
-	err = paperCollection.FindOne(context.Background(), filter).Decode(&paper)
-
-	// If paper not found, return an error
-	if err != nil {
-		http.Error(w, "Paper not found", http.StatusNotFound)
-		return
-	}
-
-	// Update the paper's drawing data and timestamp
-	update := bson.M{
-		"$set": bson.M{
-			"text_data":  textAnnotations,
-			"updated_at": time.Now(),
-		},
-	}
-
-	_, err = paperCollection.UpdateOne(context.Background(), filter, update)
+	version, err := replaceTextData(paperObjID, textAnnotations, expected, &paper, userID)
 	if err != nil {
-		http.Error(w, "Failed to update paper", http.StatusInternalServerError)
+		writeRequestError(w, versionedWriteError(err, "Failed to update paper"))
 		return
 	}
+	touchFile(context.Background(), paper.RoomID, paper.FileID, userID)
 
 	// Broadcast to socket if needed
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
-		var papers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"room_id": paper.RoomID})
-		cursor.All(context.Background(), &papers)
+		papers, _ := findPapers(context.Background(), bson.M{"room_id": paper.RoomID})
 
 		socketServer.BroadcastToRoom("", paper.RoomID, "paper_list_updated", map[string]interface{}{
 			"roomID": paper.RoomID,
@@ -465,13 +549,15 @@ func AddTextAnnotation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Send success response
+	setETag(w, version)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message":   "Text data replaced successfully",
 		"paper_id":  paperID,
 		"room_id":   paper.RoomID,
 		"file_id":   paper.FileID,
 		"timestamp": time.Now().Format(time.RFC3339),
+		"version":   version,
 	})
 }
 
@@ -483,7 +569,8 @@ func DeletePaper(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var paperRequest struct {
-		PaperID string `json:"paper_id"`
+		PaperID         string `json:"paper_id"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 	if err := json.Unmarshal(body, &paperRequest); err != nil {
 		http.Error(w, "Invalid request format", http.StatusBadRequest)
@@ -512,58 +599,71 @@ func DeletePaper(w http.ResponseWriter, r *http.Request) {
 	roomID := paperToDelete.RoomID
 	deletedPageNumber := paperToDelete.PageNumber
 
-	// Delete the paper
-	result, err := paperCollection.DeleteOne(context.Background(), bson.M{"_id": objID})
-	if err != nil {
-		http.Error(w, "Failed to delete paper", http.StatusInternalServerError)
+	userID, ok := requirePermission(w, r, roomID, models.PermDelete)
+	if !ok {
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		http.Error(w, "Paper not found", http.StatusNotFound)
+	expected, err := expectedVersion(r, paperRequest.ExpectedVersion)
+	if err == nil {
+		err = compareVersion(models.StarPaper, paperToDelete.Version, expected, paperToDelete)
+	}
+	if err != nil {
+		writeRequestError(w, err)
 		return
 	}
 
-	// Get all remaining papers with the same file ID to update their page numbers
-	var remainingPapers []models.Paper
-	cursor, err := paperCollection.Find(
-		context.Background(),
-		bson.M{"file_id": fileID},
-	)
-	if err != nil {
-		// Even if this fails, we've already deleted the paper, so just log the error
-		fmt.Printf("Error fetching remaining papers: %v\n", err)
-	} else {
-		defer cursor.Close(context.Background())
-		if err = cursor.All(context.Background(), &remainingPapers); err == nil {
-			// Create bulk write operations to update page numbers
-			var bulkWrites []mongo.WriteModel
-
-			// For each paper with page number > deleted page number, decrement its page number
-			for _, paper := range remainingPapers {
-				if paper.PageNumber > deletedPageNumber {
-					bulkWrites = append(bulkWrites, mongo.NewUpdateOneModel().
-						SetFilter(bson.M{"_id": paper.ID}).
-						SetUpdate(bson.M{"$set": bson.M{"page_number": paper.PageNumber - 1}}))
-				}
-			}
+	autoSnapshot(context.Background(), fileID, userID)
 
-			// Execute bulk write operations if there are any
-			if len(bulkWrites) > 0 {
-				_, bulkErr := paperCollection.BulkWrite(context.Background(), bulkWrites)
-				if bulkErr != nil {
-					fmt.Printf("Error updating page numbers: %v\n", bulkErr)
-				}
-			}
+	// Delete the paper with its strokes, history, thumbnails and stars, and
+	// close the gap in the page numbers, unless it changed since it was checked
+	err = config.RunInTransaction(context.Background(), func(sessCtx mongo.SessionContext) error {
+		deleteFilter := bson.M{"_id": objID}
+		if expected != nil {
+			deleteFilter["version"] = versionMatch(*expected)
+		}
+		result, err := paperCollection.DeleteOne(sessCtx, deleteFilter)
+		if err != nil {
+			return fmt.Errorf("failed to delete paper: %v", err)
+		}
+		if result.DeletedCount == 0 {
+			return currentVersionError(sessCtx, paperCollection, models.StarPaper, objID, expected, &models.Paper{})
+		}
+
+		paperIDs := []string{paperRequest.PaperID}
+		if err := deleteStrokeChunks(sessCtx, paperIDs); err != nil {
+			return err
+		}
+		if err := deletePaperHistory(sessCtx, paperIDs); err != nil {
+			return err
 		}
+		if err := deletePaperThumbnails(sessCtx, paperIDs); err != nil {
+			return err
+		}
+		starFilter := bson.M{"item_type": models.StarPaper, "item_id": paperRequest.PaperID}
+		if _, err := config.GetStarredCollection().DeleteMany(sessCtx, starFilter); err != nil {
+			return fmt.Errorf("failed to delete stars: %v", err)
+		}
+
+		laterPages := bson.M{"file_id": fileID, "page_number": bson.M{"$gt": deletedPageNumber}}
+		if _, err := paperCollection.UpdateMany(sessCtx, laterPages, bumpVersion(bson.M{"$inc": bson.M{"page_number": -1}})); err != nil {
+			return fmt.Errorf("failed to update page numbers: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error deleting paper %s: %v", paperRequest.PaperID, err)
+		writeRequestError(w, versionedWriteError(err, "Failed to delete paper"))
+		return
 	}
 
+	touchFile(context.Background(), roomID, fileID, userID)
+	refreshFileMetadata(context.Background(), roomID, fileID)
+
 	// Broadcast updated paper list
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
-		var updatedPapers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"file_id": fileID})
-		cursor.All(context.Background(), &updatedPapers)
+		updatedPapers, _ := findPapers(context.Background(), bson.M{"file_id": fileID})
 
 		socketServer.BroadcastToRoom("", roomID, "paper_list_updated", map[string]interface{}{
 			"roomID": roomID,
@@ -625,6 +725,16 @@ func SwapPaper(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(filePapers) == 0 {
+		http.Error(w, "No papers found for file", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, filePapers[0].RoomID, models.PermReorder)
+	if !ok {
+		return
+	}
+
 	// Check if indices are valid
 	if request.FromIndex < 0 || request.FromIndex >= len(filePapers)+1 ||
 		request.ToIndex < 0 || request.ToIndex >= len(filePapers)+1 {
@@ -655,7 +765,7 @@ func SwapPaper(w http.ResponseWriter, r *http.Request) {
 			if paper, ok := papersByPageNumber[pageNum]; ok {
 				bulkWrites = append(bulkWrites, mongo.NewUpdateOneModel().
 					SetFilter(bson.M{"_id": paper.ID}).
-					SetUpdate(bson.M{"$set": bson.M{"page_number": pageNum - 1}}))
+					SetUpdate(bumpVersion(bson.M{"$set": bson.M{"page_number": pageNum - 1}})))
 			}
 		}
 	} else {
@@ -664,7 +774,7 @@ func SwapPaper(w http.ResponseWriter, r *http.Request) {
 			if paper, ok := papersByPageNumber[pageNum]; ok {
 				bulkWrites = append(bulkWrites, mongo.NewUpdateOneModel().
 					SetFilter(bson.M{"_id": paper.ID}).
-					SetUpdate(bson.M{"$set": bson.M{"page_number": pageNum + 1}}))
+					SetUpdate(bumpVersion(bson.M{"$set": bson.M{"page_number": pageNum + 1}})))
 			}
 		}
 	}
@@ -672,7 +782,7 @@ func SwapPaper(w http.ResponseWriter, r *http.Request) {
 	// Move the paper to the target position
 	bulkWrites = append(bulkWrites, mongo.NewUpdateOneModel().
 		SetFilter(bson.M{"_id": fromPaper.ID}).
-		SetUpdate(bson.M{"$set": bson.M{"page_number": request.ToIndex}}))
+		SetUpdate(bumpVersion(bson.M{"$set": bson.M{"page_number": request.ToIndex}})))
 
 	// Execute bulk write operations
 	if len(bulkWrites) > 0 {
@@ -683,12 +793,14 @@ func SwapPaper(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The first page, and with it the thumbnail, may have changed
+	touchFile(context.Background(), filePapers[0].RoomID, request.FileID, userID)
+	refreshFileMetadata(context.Background(), filePapers[0].RoomID, request.FileID)
+
 	// Broadcast updated paper list
 	socketServer := socketio.ServerInstance
 	if socketServer != nil {
-		var updatedPapers []models.Paper
-		cursor, _ := paperCollection.Find(context.Background(), bson.M{"file_id": request.FileID})
-		cursor.All(context.Background(), &updatedPapers)
+		updatedPapers, _ := findPapers(context.Background(), bson.M{"file_id": request.FileID})
 
 		if len(updatedPapers) > 0 {
 			roomID := updatedPapers[0].RoomID
@@ -717,23 +829,12 @@ func GetPaper(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Query database for folders
-	paperCollection := config.GetPaperCollection()
-	filter := bson.M{"room_id": roomID}
-
-	cursor, err := paperCollection.Find(context.Background(), filter)
+	// Query database for papers, with their strokes
+	papers, err := findPapers(context.Background(), bson.M{"room_id": roomID})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to fetch papers: %v", err), http.StatusInternalServerError)
 		return
 	}
-	defer cursor.Close(context.Background())
-
-	// Decode results into slice
-	var papers []models.Paper
-	if err = cursor.All(context.Background(), &papers); err != nil {
-		http.Error(w, fmt.Sprintf("Failed to decode papers: %v", err), http.StatusInternalServerError)
-		return
-	}
 
 	// Broadcast to socket if needed
 	socketServer := socketio.ServerInstance
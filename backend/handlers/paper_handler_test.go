@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// insertTestFile creates a file at the root of the room and returns its ID
+func insertTestFile(t *testing.T, ctx context.Context, roomID string) string {
+	t.Helper()
+	file := models.File{ID: primitive.NewObjectID(), RoomID: roomID, Name: "file", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	if _, err := config.GetFileCollection().InsertOne(ctx, file); err != nil {
+		t.Fatalf("Failed to insert file: %v", err)
+	}
+	return file.ID.Hex()
+}
+
+func TestAddPaperChecksTheFilesRoom(t *testing.T) {
+	ctx := connectTestDB(t)
+	owner, outsider := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	roomID := insertTestRoom(t, ctx, owner)
+	fileID := insertTestFile(t, ctx, roomID)
+	outsiderRoomID := insertTestRoom(t, ctx, outsider)
+	outsiderFileID := insertTestFile(t, ctx, outsiderRoomID)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		userID  string
+		body    map[string]interface{}
+		want    int
+	}{
+		{
+			name:    "add to a file in another room",
+			handler: AddPaper, userID: outsider,
+			body: map[string]interface{}{"room_id": outsiderRoomID, "file_id": fileID, "page_number": 1},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "insert into a file in another room",
+			handler: InsertPaperAt, userID: outsider,
+			body: map[string]interface{}{"room_id": outsiderRoomID, "file_id": fileID},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "missing file",
+			handler: AddPaper, userID: outsider,
+			body: map[string]interface{}{"room_id": outsiderRoomID, "file_id": primitive.NewObjectID().Hex()},
+			want: http.StatusNotFound,
+		},
+		{
+			name:    "own file, whatever room the body names",
+			handler: AddPaper, userID: outsider,
+			body: map[string]interface{}{"room_id": roomID, "file_id": outsiderFileID, "page_number": 1},
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(tt.handler, tt.userID, http.MethodPost, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+
+	if count, _ := config.GetPaperCollection().CountDocuments(ctx, bson.M{"file_id": fileID}); count != 0 {
+		t.Errorf("%d papers were added to the other room's file", count)
+	}
+	var paper models.Paper
+	if err := config.GetPaperCollection().FindOne(ctx, bson.M{"file_id": outsiderFileID}).Decode(&paper); err != nil {
+		t.Fatalf("paper was not added: %v", err)
+	}
+	if paper.RoomID != outsiderRoomID {
+		t.Errorf("paper room = %s, want the file's room %s", paper.RoomID, outsiderRoomID)
+	}
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/render"
+	"backend/socketio"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Limits on a PDF uploaded for import. Pages may be up to 200 inches on a
+// side, the largest PDF allows without a UserUnit.
+const (
+	maxPDFImportSize  = 100 << 20 // 100MB
+	maxPDFImportPages = 1000
+	maxPDFPageSide    = 14400 // points
+)
+
+// pdfImportProgress is one line of the progress of a PDF import
+type pdfImportProgress struct {
+	Status   string   `json:"status"` // started, page, done or error
+	Page     int      `json:"page,omitempty"`
+	Pages    int      `json:"pages"`
+	Warning  string   `json:"warning,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	FileID   string   `json:"file_id,omitempty"`
+	PaperIDs []string `json:"paper_ids,omitempty"`
+}
+
+// ExportFilePDF renders the papers of a file as a PDF, one page per paper in
+// page order. pages picks pages by position, such as "1-3,5", and
+// annotations_only=true leaves out templates and background images.
+func ExportFilePDF(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	query := r.URL.Query()
+	file, err := findVersionedFile(ctx, query.Get("file_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, ok := requirePermission(w, r, file.RoomID, models.PermExport); !ok {
+		return
+	}
+
+	annotationsOnly := false
+	if value := query.Get("annotations_only"); value != "" {
+		if annotationsOnly, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid annotations_only", http.StatusBadRequest)
+			return
+		}
+	}
+
+	papers, err := findFilePapers(ctx, file.ID.Hex())
+	if err != nil {
+		log.Printf("Error fetching papers of file %s: %v", file.ID.Hex(), err)
+		http.Error(w, "Failed to fetch papers", http.StatusInternalServerError)
+		return
+	}
+	papers, err = selectPages(papers, query.Get("pages"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(papers) == 0 {
+		http.Error(w, "File has no pages", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name+".pdf"))
+
+	pdf := render.NewPDFWriter(w, render.PDFOptions{AnnotationsOnly: annotationsOnly})
+	for _, paper := range papers {
+		var background *render.PDFImage
+		if !annotationsOnly {
+			background = loadPDFBackground(paper)
+		}
+		if err := pdf.AddPage(paper, background); err != nil {
+			log.Printf("Error writing PDF of file %s: %v", file.ID.Hex(), err)
+			return
+		}
+	}
+	if err := pdf.Close(); err != nil {
+		log.Printf("Error writing PDF of file %s: %v", file.ID.Hex(), err)
+	}
+}
+
+// findFilePapers returns the papers of a file in page order, with their strokes
+func findFilePapers(ctx context.Context, fileID string) ([]models.Paper, error) {
+	return findPapers(ctx, bson.M{"file_id": fileID}, options.Find().SetSort(bson.M{"page_number": 1}))
+}
+
+// selectPages keeps the papers at the 1-based positions listed in spec, a
+// comma separated list of pages and ranges. An empty spec keeps them all.
+func selectPages(papers []models.Paper, spec string) ([]models.Paper, error) {
+	if strings.TrimSpace(spec) == "" {
+		return papers, nil
+	}
+
+	selected := make([]bool, len(papers))
+	for _, part := range strings.Split(spec, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		from, err := strconv.Atoi(strings.TrimSpace(first))
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(strings.TrimSpace(last))
+		}
+		if err != nil || from < 1 || to < from || to > len(papers) {
+			return nil, newRequestError(http.StatusBadRequest, fmt.Sprintf("Invalid page range %q", part))
+		}
+		for page := from; page <= to; page++ {
+			selected[page-1] = true
+		}
+	}
+
+	var result []models.Paper
+	for i, paper := range papers {
+		if selected[i] {
+			result = append(result, paper)
+		}
+	}
+	return result, nil
+}
+
+// loadPDFBackground downloads the paper's background image for a PDF. A
+// background that cannot be loaded is logged and left out.
+func loadPDFBackground(paper models.Paper) *render.PDFImage {
+	if paper.BackgroundImage == "" {
+		return nil
+	}
+	blob, err := DownloadByURL(paper.BackgroundImage)
+	if err != nil {
+		log.Printf("Failed to download background of paper %s: %v", paper.ID.Hex(), err)
+		return nil
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err == nil {
+		var img *render.PDFImage
+		if img, err = render.NewPDFImage(data); err == nil {
+			return img
+		}
+	}
+	log.Printf("Failed to read background of paper %s: %v", paper.ID.Hex(), err)
+	return nil
+}
+
+// ImportFilePDF creates a file from an uploaded PDF with one paper per page,
+// each sized like its page and with the rendered page as its background.
+//
+// Everything that can be checked up front fails with a 4xx and a plain text
+// error: the upload, permissions, sub_folder_id, a PDF that cannot be read
+// or is encrypted, and the number and size of its pages. After that the
+// response is 200 with a stream of JSON lines: one when the import starts,
+// one per page, and a last one whose status is done, with the new file and
+// papers, or error, in which case nothing was imported. Clients must read
+// the status of the last line. Pages whose background could not be made are
+// imported blank with a warning.
+func ImportFilePDF(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPDFImportSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "PDF too big", http.StatusBadRequest)
+		return
+	}
+
+	roomID := r.FormValue("room_id")
+	subFolderID := r.FormValue("sub_folder_id")
+	userID, ok := requirePermission(w, r, roomID, models.PermCreate)
+	if !ok {
+		return
+	}
+	if !isRootFolder(subFolderID) {
+		folderObjID, err := primitive.ObjectIDFromHex(subFolderID)
+		if err != nil {
+			http.Error(w, "Invalid sub_folder_id", http.StatusBadRequest)
+			return
+		}
+		var folder models.Folder
+		if err := config.GetFolderCollection().FindOne(r.Context(), bson.M{"_id": folderObjID}).Decode(&folder); err != nil {
+			http.Error(w, "Folder not found", http.StatusNotFound)
+			return
+		}
+		if folder.RoomID != roomID {
+			http.Error(w, "Folder is not in the room", http.StatusBadRequest)
+			return
+		}
+	}
+
+	upload, handler, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Error retrieving the file", http.StatusBadRequest)
+		return
+	}
+	defer upload.Close()
+
+	data, err := io.ReadAll(upload)
+	if err != nil {
+		http.Error(w, "Error reading the file", http.StatusBadRequest)
+		return
+	}
+	source, err := render.OpenPDF(data)
+	if errors.Is(err, render.ErrEncryptedPDF) {
+		http.Error(w, "Encrypted PDFs are not supported", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading PDF %s: %v", handler.Filename, err)
+		http.Error(w, "Invalid PDF", http.StatusBadRequest)
+		return
+	}
+	defer source.Close()
+	if len(source.Pages) == 0 {
+		http.Error(w, "PDF has no pages", http.StatusBadRequest)
+		return
+	}
+	if len(source.Pages) > maxPDFImportPages {
+		http.Error(w, fmt.Sprintf("PDF has more than %d pages", maxPDFImportPages), http.StatusRequestEntityTooLarge)
+		return
+	}
+	for i, page := range source.Pages {
+		if !(page.Width >= 1 && page.Height >= 1) || page.Width > maxPDFPageSide || page.Height > maxPDFPageSide {
+			http.Error(w, fmt.Sprintf("Page %d has an unsupported size", i+1), http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = strings.TrimSuffix(handler.Filename, path.Ext(handler.Filename))
+	}
+	originalID := r.FormValue("file_id")
+	if originalID == "" {
+		originalID = utils.NewUUID()
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	report := func(progress pdfImportProgress) {
+		progress.Pages = len(source.Pages)
+		encoder.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	report(pdfImportProgress{Status: "started"})
+
+	now := time.Now()
+	fileID := primitive.NewObjectID()
+	papers := make([]models.Paper, 0, len(source.Pages))
+	backgrounds := make(map[string]string, len(source.Pages))
+	for i, page := range source.Pages {
+		if r.Context().Err() != nil {
+			log.Printf("PDF import of %s cancelled at page %d", handler.Filename, i+1)
+			deleteUploadedAssets(backgrounds)
+			return
+		}
+
+		paper := models.Paper{
+			ID:         primitive.NewObjectID(),
+			OriginalID: utils.NewUUID(),
+			RoomID:     roomID,
+			FileID:     fileID.Hex(),
+			TemplateID: "plain",
+			PageNumber: i + 1,
+			Width:      page.Width,
+			Height:     page.Height,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		progress := pdfImportProgress{Status: "page", Page: i + 1}
+
+		background, ext, err := source.Background(r.Context(), i)
+		if err != nil {
+			log.Printf("No background for page %d of %s: %v", i+1, handler.Filename, err)
+			progress.Warning = "Page imported without a background"
+		} else {
+			blobName := fmt.Sprintf("%s_%s_page_%d.%s", utils.NewUUID(), strings.ReplaceAll(name, "/", "_"), i+1, ext)
+			url, err := UploadToAzureBlob(bytes.NewReader(background), blobName)
+			if err != nil {
+				log.Printf("Failed to upload page %d of %s: %v", i+1, handler.Filename, err)
+				deleteUploadedAssets(backgrounds)
+				report(pdfImportProgress{Status: "error", Page: i + 1, Error: "Failed to upload page background"})
+				return
+			}
+			backgrounds[paper.ID.Hex()] = url
+			paper.BackgroundImage = url
+		}
+
+		papers = append(papers, paper)
+		report(progress)
+	}
+
+	ctx := context.Background()
+	fileCollection := config.GetFileCollection()
+	position, err := nextPosition(ctx, fileCollection, roomID, subFolderID)
+	if err != nil {
+		log.Printf("Failed to compute position of imported file %s: %v", handler.Filename, err)
+		deleteUploadedAssets(backgrounds)
+		report(pdfImportProgress{Status: "error", Error: "Failed to compute file position"})
+		return
+	}
+
+	file := models.File{
+		ID:           fileID,
+		OriginalID:   originalID,
+		RoomID:       roomID,
+		SubFolderID:  subFolderID,
+		Name:         name,
+		Position:     position,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		PageCount:    len(papers),
+		ThumbnailURL: models.PaperThumbnailURL(papers[0].ID.Hex()),
+	}
+	documents := make([]interface{}, 0, len(papers))
+	paperIDs := make([]string, 0, len(papers))
+	for _, paper := range papers {
+		documents = append(documents, paper)
+		paperIDs = append(paperIDs, paper.ID.Hex())
+	}
+
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		if _, err := fileCollection.InsertOne(sessCtx, file); err != nil {
+			return err
+		}
+		_, err := config.GetPaperCollection().InsertMany(sessCtx, documents)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to save PDF import of %s: %v", handler.Filename, err)
+		deleteUploadedAssets(backgrounds)
+		report(pdfImportProgress{Status: "error", Error: "Failed to add file"})
+		return
+	}
+
+	touchFile(ctx, roomID, fileID.Hex(), userID)
+	broadcastFileList(ctx, roomID)
+
+	// 🔥 **Emit to all users in the room**, once for the whole file
+	if socketServer := socketio.ServerInstance; socketServer != nil {
+		roomPapers, _ := findPapers(ctx, bson.M{"room_id": roomID})
+		socketServer.BroadcastToRoom("", roomID, "paper_list_updated", map[string]interface{}{
+			"roomID": roomID,
+			"papers": roomPapers,
+		})
+	}
+
+	report(pdfImportProgress{Status: "done", FileID: fileID.Hex(), PaperIDs: paperIDs})
+}
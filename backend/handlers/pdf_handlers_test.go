@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"backend/models"
+)
+
+func TestSelectPages(t *testing.T) {
+	papers := make([]models.Paper, 5)
+	for i := range papers {
+		// Page numbers may have gaps, pages are picked by position
+		papers[i].PageNumber = (i + 1) * 10
+	}
+
+	tests := []struct {
+		spec    string
+		want    []int // page numbers
+		wantErr bool
+	}{
+		{spec: "", want: []int{10, 20, 30, 40, 50}},
+		{spec: "  ", want: []int{10, 20, 30, 40, 50}},
+		{spec: "2", want: []int{20}},
+		{spec: "1-3", want: []int{10, 20, 30}},
+		{spec: "5, 1", want: []int{10, 50}},
+		{spec: "2-4,3", want: []int{20, 30, 40}},
+		{spec: " 4 - 5 ", want: []int{40, 50}},
+		{spec: "0", wantErr: true},
+		{spec: "6", wantErr: true},
+		{spec: "3-2", wantErr: true},
+		{spec: "1-", wantErr: true},
+		{spec: "a", wantErr: true},
+		{spec: "1,,2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			selected, err := selectPages(papers, tt.spec)
+			if tt.wantErr {
+				if err == nil || errorStatus(err) != http.StatusBadRequest {
+					t.Fatalf("selectPages(%q) error = %v, want a 400", tt.spec, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectPages(%q) failed: %v", tt.spec, err)
+			}
+			got := make([]int, len(selected))
+			for i, paper := range selected {
+				got[i] = paper.PageNumber
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectPages(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	defaultRecentLimit = 20
+	maxRecentLimit     = 100
+)
+
+// RecentEntry is a file in the caller's recents, with where it lives and
+// when the caller last opened and edited it
+type RecentEntry struct {
+	File         models.File `json:"file"`
+	RoomID       string      `json:"room_id"`
+	RoomName     string      `json:"room_name"`
+	Path         []PathEntry `json:"path"`
+	LastOpenedAt *time.Time  `json:"last_opened_at,omitempty"`
+	LastEditedAt *time.Time  `json:"last_edited_at,omitempty"`
+}
+
+// GetRecentFiles lists the files the caller opened or edited most recently,
+// across every room they can still access. kind is "opened", "edited" or
+// "any" (default); limit and offset page through the list.
+func GetRecentFiles(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	sortField := "last_activity_at"
+	match := bson.M{"user_id": userID, "hidden": bson.M{"$ne": true}}
+	switch query.Get("kind") {
+	case "", "any":
+	case "opened":
+		sortField = "last_opened_at"
+		match[sortField] = bson.M{"$exists": true}
+	case "edited":
+		sortField = "last_edited_at"
+		match[sortField] = bson.M{"$exists": true}
+	default:
+		http.Error(w, "kind must be 'opened', 'edited' or 'any'", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultRecentLimit
+	if limitParam := query.Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > maxRecentLimit {
+		limit = maxRecentLimit
+	}
+	offset := 0
+	if offsetParam := query.Get("offset"); offsetParam != "" {
+		if parsed, err := strconv.Atoi(offsetParam); err == nil && parsed > 0 {
+			offset = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rooms, err := getAccessibleRooms(ctx, userID)
+	if err != nil {
+		log.Printf("Error resolving rooms of %s: %v", userID, err)
+		http.Error(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+	roomIDs := make([]string, 0, len(rooms))
+	for roomID := range rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	match["room_id"] = bson.M{"$in": roomIDs}
+
+	// Join the files so deleted ones drop out before paging
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$addFields": bson.M{
+			"last_activity_at": bson.M{"$max": bson.A{"$last_opened_at", "$last_edited_at"}},
+			"file_obj_id": bson.M{"$convert": bson.M{
+				"input": "$file_id", "to": "objectId", "onError": nil, "onNull": nil,
+			}},
+		}},
+		{"$sort": bson.D{{Key: sortField, Value: -1}, {Key: "_id", Value: -1}}},
+		{"$lookup": bson.M{
+			"from":         config.GetFileCollection().Name(),
+			"localField":   "file_obj_id",
+			"foreignField": "_id",
+			"as":           "file",
+		}},
+		{"$unwind": "$file"},
+		{"$match": bson.M{"file.room_id": bson.M{"$in": roomIDs}}},
+		{"$skip": offset},
+		// One extra tells whether there is another page
+		{"$limit": limit + 1},
+	}
+
+	cursor, err := config.GetFileActivityCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Printf("Error fetching recents of %s: %v", userID, err)
+		http.Error(w, "Failed to fetch recent files", http.StatusInternalServerError)
+		return
+	}
+
+	var activities []struct {
+		models.FileActivity `bson:",inline"`
+		File                models.File `bson:"file"`
+	}
+	if err := cursor.All(ctx, &activities); err != nil {
+		http.Error(w, "Failed to decode recent files", http.StatusInternalServerError)
+		return
+	}
+
+	hasMore := len(activities) > limit
+	if hasMore {
+		activities = activities[:limit]
+	}
+
+	entries := make([]RecentEntry, 0, len(activities))
+	for _, activity := range activities {
+		entry := RecentEntry{
+			File:     activity.File,
+			RoomID:   activity.File.RoomID,
+			RoomName: rooms[activity.File.RoomID].Name,
+			Path:     resolveFolderPath(ctx, activity.File.SubFolderID),
+		}
+		if !activity.LastOpenedAt.IsZero() {
+			openedAt := activity.LastOpenedAt
+			entry.LastOpenedAt = &openedAt
+			entry.File.LastOpenedAt = &openedAt
+		}
+		if !activity.LastEditedAt.IsZero() {
+			editedAt := activity.LastEditedAt
+			entry.LastEditedAt = &editedAt
+		}
+		entries = append(entries, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"entries":  entries,
+		"offset":   offset,
+		"limit":    limit,
+		"has_more": hasMore,
+	})
+}
+
+// RemoveRecentFiles hides files from the caller's recents, or every file when
+// all is set. A hidden file reappears the next time it is opened or edited.
+func RemoveRecentFiles(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var removeRequest struct {
+		FileIDs []string `json:"file_ids"`
+		All     bool     `json:"all"`
+	}
+	if err := json.Unmarshal(body, &removeRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	if !removeRequest.All {
+		if len(removeRequest.FileIDs) == 0 {
+			http.Error(w, "file_ids or all is required", http.StatusBadRequest)
+			return
+		}
+		filter["file_id"] = bson.M{"$in": removeRequest.FileIDs}
+	}
+
+	result, err := config.GetFileActivityCollection().UpdateMany(context.Background(), filter, bson.M{"$set": bson.M{"hidden": true}})
+	if err != nil {
+		http.Error(w, "Failed to remove recent files", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Recent files removed successfully",
+		"removed": result.ModifiedCount,
+	})
+}
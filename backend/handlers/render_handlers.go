@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/render"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Sizes in pixels of rendered papers. Thumbnails are cached per size, so
+// they are kept small; previews are rendered on every request.
+const (
+	thumbnailDefaultWidth = 320
+	thumbnailMaxSize      = 1024
+	previewDefaultWidth   = 1240
+	previewMaxSize        = 4096
+)
+
+// thumbnailSizes are the long sides thumbnails are rendered at, so that a
+// paper has at most this many cached thumbnails whatever sizes are asked for
+var thumbnailSizes = []int{160, 320, 640, thumbnailMaxSize}
+
+// GetPaperThumbnail returns a cached PNG of a paper. The size is set by
+// width and/or height and rounded up to the nearest of thumbnailSizes on the
+// long side, keeping the paper's aspect ratio.
+func GetPaperThumbnail(w http.ResponseWriter, r *http.Request) {
+	servePaperImage(w, r, thumbnailDefaultWidth, thumbnailMaxSize, true)
+}
+
+// GetPaperPreview renders a PNG of a paper at up to previewMaxSize
+func GetPaperPreview(w http.ResponseWriter, r *http.Request) {
+	servePaperImage(w, r, previewDefaultWidth, previewMaxSize, false)
+}
+
+func servePaperImage(w http.ResponseWriter, r *http.Request, defaultWidth, maxSize int, cached bool) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.Background()
+	query := r.URL.Query()
+	paper, err := findHistoryPaper(ctx, query.Get("paper_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, paper.RoomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	width, err := sizeParam(query.Get("width"))
+	if err != nil {
+		http.Error(w, "Invalid width", http.StatusBadRequest)
+		return
+	}
+	height, err := sizeParam(query.Get("height"))
+	if err != nil {
+		http.Error(w, "Invalid height", http.StatusBadRequest)
+		return
+	}
+
+	// Renderings only change with the paper, so the version is the ETag
+	etag := strconv.Quote(strconv.FormatInt(paper.Version, 10))
+	if r.Header.Get("If-None-Match") == etag {
+		setETag(w, paper.Version)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	papers, err := findPapers(ctx, bson.M{"_id": paper.ID})
+	if err != nil || len(papers) == 0 {
+		http.Error(w, "Paper not found", http.StatusNotFound)
+		return
+	}
+	paper = papers[0]
+	width, height = renderSize(paper, width, height, defaultWidth, maxSize)
+
+	var data []byte
+	if cached {
+		width, height = thumbnailSize(paper, width, height)
+		data, err = paperThumbnail(ctx, paper, width, height)
+	} else {
+		data, err = renderPaperPNG(paper, width, height)
+	}
+	if err != nil {
+		log.Printf("Error rendering paper %s: %v", paper.ID.Hex(), err)
+		http.Error(w, "Failed to render paper", http.StatusInternalServerError)
+		return
+	}
+
+	setETag(w, paper.Version)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write(data)
+}
+
+// sizeParam parses an optional size in pixels, 0 when it is missing
+func sizeParam(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size, nil
+}
+
+// renderSize fills in a missing width or height from the paper's aspect
+// ratio and scales the result down to fit in maxSize
+func renderSize(paper models.Paper, width, height, defaultWidth, maxSize int) (int, int) {
+	pageWidth, pageHeight := render.PageSize(paper)
+	switch {
+	case width == 0 && height == 0:
+		width = defaultWidth
+		fallthrough
+	case height == 0:
+		height = int(math.Round(float64(width) * pageHeight / pageWidth))
+	case width == 0:
+		width = int(math.Round(float64(height) * pageWidth / pageHeight))
+	}
+
+	if width > maxSize || height > maxSize {
+		scale := float64(maxSize) / float64(max(width, height))
+		width = int(math.Round(float64(width) * scale))
+		height = int(math.Round(float64(height) * scale))
+	}
+	return max(width, 1), max(height, 1)
+}
+
+// thumbnailSize snaps a size to the smallest of thumbnailSizes that holds its
+// long side, with the paper's aspect ratio
+func thumbnailSize(paper models.Paper, width, height int) (int, int) {
+	long := max(width, height)
+	size := thumbnailSizes[len(thumbnailSizes)-1]
+	for _, bucket := range thumbnailSizes {
+		if bucket >= long {
+			size = bucket
+			break
+		}
+	}
+
+	pageWidth, pageHeight := render.PageSize(paper)
+	if pageWidth >= pageHeight {
+		return renderSize(paper, size, 0, size, size)
+	}
+	return renderSize(paper, 0, size, size, size)
+}
+
+// paperThumbnail returns the cached rendering of the paper at this size and
+// version, rendering and caching it if there is none
+func paperThumbnail(ctx context.Context, paper models.Paper, width, height int) ([]byte, error) {
+	collection := config.GetPaperThumbnailCollection()
+	paperID := paper.ID.Hex()
+
+	var thumbnail models.PaperThumbnail
+	filter := bson.M{"paper_id": paperID, "width": width, "height": height, "version": paper.Version}
+	err := collection.FindOne(ctx, filter).Decode(&thumbnail)
+	if err == nil {
+		return thumbnail.Data, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	data, err := renderPaperPNG(paper, width, height)
+	if err != nil {
+		return nil, err
+	}
+
+	// Thumbnails of older versions are stale at every size, and ones cached
+	// before sizes were snapped to thumbnailSizes are not asked for again
+	stale := bson.M{"paper_id": paperID, "$or": bson.A{
+		bson.M{"version": bson.M{"$lt": paper.Version}},
+		bson.M{"width": bson.M{"$nin": thumbnailSizes}, "height": bson.M{"$nin": thumbnailSizes}},
+	}}
+	if _, err := collection.DeleteMany(ctx, stale); err != nil {
+		log.Printf("Failed to delete stale thumbnails of paper %s: %v", paperID, err)
+	}
+	update := bson.M{
+		"$set":         bson.M{"version": paper.Version, "data": data, "created_at": time.Now()},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+	}
+	key := bson.M{"paper_id": paperID, "width": width, "height": height, "version": bson.M{"$lte": paper.Version}}
+	if _, err := collection.UpdateOne(ctx, key, update, options.Update().SetUpsert(true)); err != nil && !mongo.IsDuplicateKeyError(err) {
+		log.Printf("Failed to cache thumbnail of paper %s: %v", paperID, err)
+	}
+	return data, nil
+}
+
+// renderPaperPNG rasterizes a paper with its strokes hydrated
+func renderPaperPNG(paper models.Paper, width, height int) ([]byte, error) {
+	img, err := render.Rasterize(paper, render.Options{
+		Width:      width,
+		Height:     height,
+		Background: loadBackground(paper),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadBackground downloads and decodes the paper's background image. A
+// background that cannot be loaded is logged and left out.
+func loadBackground(paper models.Paper) image.Image {
+	if paper.BackgroundImage == "" {
+		return nil
+	}
+	blob, err := DownloadByURL(paper.BackgroundImage)
+	if err != nil {
+		log.Printf("Failed to download background of paper %s: %v", paper.ID.Hex(), err)
+		return nil
+	}
+	defer blob.Close()
+
+	img, err := render.DecodeImage(blob)
+	if err != nil {
+		log.Printf("Failed to decode background of paper %s: %v", paper.ID.Hex(), err)
+		return nil
+	}
+	return img
+}
+
+// deletePaperThumbnails removes the cached thumbnails of deleted papers
+func deletePaperThumbnails(ctx context.Context, paperIDs []string) error {
+	if len(paperIDs) == 0 {
+		return nil
+	}
+	if _, err := config.GetPaperThumbnailCollection().DeleteMany(ctx, bson.M{"paper_id": bson.M{"$in": paperIDs}}); err != nil {
+		return fmt.Errorf("failed to delete thumbnails: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// requirePermission authenticates the request and checks that the caller holds
+// the permission in the room. It writes the error response and returns false
+// when the check fails.
+func requirePermission(w http.ResponseWriter, r *http.Request, roomID, permission string) (string, bool) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if err := checkPermission(r.Context(), userID, roomID, permission); err != nil {
+		writeRequestError(w, err)
+		return "", false
+	}
+	return userID, true
+}
+
+// checkPermission returns a requestError unless the user holds the permission in the room
+func checkPermission(ctx context.Context, userID, roomID, permission string) error {
+	allowed, err := utils.HasRoomPermission(ctx, userID, roomID, permission)
+	if err != nil && err != utils.ErrNoRoomAccess {
+		log.Printf("Error checking %s permission in room %s: %v", permission, roomID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check permissions")
+	}
+
+	if !allowed {
+		return newRequestError(http.StatusForbidden, fmt.Sprintf("Permission denied: %s", permission))
+	}
+	return nil
+}
+
+// checkRoleAssignable returns a requestError unless the user may give roleID
+// to members or groups of the room. Anything but the read role takes
+// manage_members, so that invite and share alone cannot hand out stronger
+// roles, and no role may grant a permission the user does not hold.
+func checkRoleAssignable(ctx context.Context, userID, roomID, roleID string) error {
+	valid, err := utils.IsValidRoomRole(ctx, roleID, roomID)
+	if err != nil {
+		log.Printf("Error checking role %s in room %s: %v", roleID, roomID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check role")
+	}
+	if !valid {
+		return newRequestError(http.StatusBadRequest, "Invalid role")
+	}
+
+	if roleID != models.RoleRead {
+		if err := checkPermission(ctx, userID, roomID, models.PermManageMembers); err != nil {
+			return err
+		}
+	}
+
+	rolePermissions, err := utils.GetRolePermissions(ctx, roleID, roomID)
+	if err != nil {
+		log.Printf("Error checking role %s in room %s: %v", roleID, roomID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check role")
+	}
+	return checkPermissionsHeld(ctx, userID, roomID, rolePermissions)
+}
+
+// checkPermissionsHeld returns a requestError unless the user holds every one
+// of the permissions a role would grant in the room
+func checkPermissionsHeld(ctx context.Context, userID, roomID string, permissions []string) error {
+	granted, err := utils.GetUserPermissionsInRoom(ctx, userID, roomID)
+	if err != nil {
+		log.Printf("Error checking permissions in room %s: %v", roomID, err)
+		return newRequestError(http.StatusInternalServerError, "Failed to check permissions")
+	}
+	if permission := missingPermission(granted, permissions); permission != "" {
+		return newRequestError(http.StatusForbidden, fmt.Sprintf("Permission denied: role grants %s", permission))
+	}
+	return nil
+}
+
+// missingPermission returns the first of the permissions that is not granted,
+// or "" when all of them are
+func missingPermission(granted map[string]bool, permissions []string) string {
+	for _, permission := range permissions {
+		if !granted[permission] {
+			return permission
+		}
+	}
+	return ""
+}
+
+// AddRole defines a custom role with its own permission set in a room. The
+// role may only grant permissions the caller holds.
+func AddRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var roleRequest struct {
+		RoomID      string   `json:"room_id"`
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.Unmarshal(body, &roleRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, roleRequest.RoomID, models.PermManageMembers)
+	if !ok {
+		return
+	}
+
+	if roleRequest.Name == "" {
+		http.Error(w, "Role name is required", http.StatusBadRequest)
+		return
+	}
+
+	permissions, err := validatePermissions(roleRequest.Permissions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkPermissionsHeld(r.Context(), userID, roleRequest.RoomID, permissions); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	role := models.Role{
+		ID:          primitive.NewObjectID(),
+		RoomID:      roleRequest.RoomID,
+		Name:        roleRequest.Name,
+		Permissions: permissions,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	role.RoleID = role.ID.Hex()
+
+	if _, err := config.GetRoleCollection().InsertOne(context.Background(), role); err != nil {
+		log.Printf("MongoDB insertion error: %v", err)
+		http.Error(w, "Failed to add role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Role added successfully",
+		"role_id": role.RoleID,
+	})
+}
+
+// GetRoles lists the built-in roles followed by the custom roles of a room
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "Missing room_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	roles := make([]models.Role, 0)
+	for _, roleID := range []string{models.RoleOwner, models.RoleWrite, models.RoleRead} {
+		roles = append(roles, models.Role{
+			RoleID:      roleID,
+			Name:        roleID,
+			Permissions: models.BuiltInRoles[roleID],
+			BuiltIn:     true,
+		})
+	}
+
+	cursor, err := config.GetRoleCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		http.Error(w, "Failed to fetch roles", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var customRoles []models.Role
+	if err := cursor.All(ctx, &customRoles); err != nil {
+		http.Error(w, "Failed to decode roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(append(roles, customRoles...))
+}
+
+// UpdateRole renames a custom role or replaces its permissions. Only the room
+// owner may edit a role they hold, and the new permissions must be a subset
+// of the caller's.
+func UpdateRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var roleRequest struct {
+		RoleID      string    `json:"role_id"`
+		Name        string    `json:"name"`
+		Permissions *[]string `json:"permissions"`
+	}
+	if err := json.Unmarshal(body, &roleRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	role, ok := findCustomRole(w, roleRequest.RoleID)
+	if !ok {
+		return
+	}
+
+	userID, ok := requirePermission(w, r, role.RoomID, models.PermManageMembers)
+	if !ok {
+		return
+	}
+
+	// The owner holds only the owner role, so this stops everyone else from
+	// widening their own permissions
+	held, err := utils.GetUserRolesInRoom(r.Context(), userID, role.RoomID)
+	if err != nil {
+		log.Printf("Error checking roles in room %s: %v", role.RoomID, err)
+		http.Error(w, "Failed to check permissions", http.StatusInternalServerError)
+		return
+	}
+	for _, roleID := range held {
+		if roleID == role.RoleID {
+			http.Error(w, "Cannot edit a role you hold", http.StatusForbidden)
+			return
+		}
+	}
+
+	// Only the fields present in the request change
+	set := bson.M{"updatedAt": time.Now()}
+	if roleRequest.Permissions != nil {
+		permissions, err := validatePermissions(*roleRequest.Permissions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := checkPermissionsHeld(r.Context(), userID, role.RoomID, permissions); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		set["permissions"] = permissions
+	}
+	if roleRequest.Name != "" {
+		set["name"] = roleRequest.Name
+	}
+
+	if _, err := config.GetRoleCollection().UpdateOne(context.Background(), bson.M{"_id": role.ID}, bson.M{"$set": set}); err != nil {
+		http.Error(w, "Failed to update role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Role updated successfully",
+		"role_id": role.RoleID,
+	})
+}
+
+// DeleteRole removes a custom role. Roles still assigned to a member or group cannot be deleted.
+func DeleteRole(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var roleRequest struct {
+		RoleID string `json:"role_id"`
+	}
+	if err := json.Unmarshal(body, &roleRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	role, ok := findCustomRole(w, roleRequest.RoleID)
+	if !ok {
+		return
+	}
+
+	if _, ok := requirePermission(w, r, role.RoomID, models.PermManageMembers); !ok {
+		return
+	}
+
+	ctx := context.Background()
+	inUse := bson.M{"room_id": role.RoomID, "role_id": role.RoleID}
+	members, err := config.GetRoomMemberCollection().CountDocuments(ctx, inUse)
+	if err != nil {
+		http.Error(w, "Failed to check role usage", http.StatusInternalServerError)
+		return
+	}
+	groups, err := config.GetRoomGroupCollection().CountDocuments(ctx, inUse)
+	if err != nil {
+		http.Error(w, "Failed to check role usage", http.StatusInternalServerError)
+		return
+	}
+	if members+groups > 0 {
+		http.Error(w, "Role is still assigned to room members", http.StatusConflict)
+		return
+	}
+
+	if _, err := config.GetRoleCollection().DeleteOne(ctx, bson.M{"_id": role.ID}); err != nil {
+		http.Error(w, "Failed to delete role", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Role deleted successfully",
+		"role_id": role.RoleID,
+	})
+}
+
+// findCustomRole loads a custom role by ID, writing an error for unknown or built-in roles
+func findCustomRole(w http.ResponseWriter, roleID string) (models.Role, bool) {
+	var role models.Role
+
+	if _, builtIn := models.BuiltInRoles[roleID]; builtIn {
+		http.Error(w, "Built-in roles cannot be modified", http.StatusBadRequest)
+		return role, false
+	}
+
+	err := config.GetRoleCollection().FindOne(context.Background(), bson.M{"role_id": roleID}).Decode(&role)
+	if err != nil || role.BuiltIn {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return role, false
+	}
+	return role, true
+}
+
+// validatePermissions rejects unknown permissions and removes duplicates
+func validatePermissions(permissions []string) ([]string, error) {
+	known := make(map[string]bool, len(models.AllPermissions))
+	for _, permission := range models.AllPermissions {
+		known[permission] = true
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !known[permission] {
+			return nil, fmt.Errorf("unknown permission: %s", permission)
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			result = append(result, permission)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMissingPermission(t *testing.T) {
+	granted := map[string]bool{models.PermInvite: true, models.PermManageMembers: true}
+	tests := []struct {
+		permissions []string
+		want        string
+	}{
+		{permissions: nil, want: ""},
+		{permissions: []string{models.PermInvite}, want: ""},
+		{permissions: []string{models.PermInvite, models.PermManageMembers}, want: ""},
+		{permissions: []string{models.PermInvite, models.PermDelete, models.PermEdit}, want: models.PermDelete},
+	}
+	for _, tt := range tests {
+		if got := missingPermission(granted, tt.permissions); got != tt.want {
+			t.Errorf("missingPermission(%v) = %q, want %q", tt.permissions, got, tt.want)
+		}
+	}
+}
+
+// insertTestRole defines a custom role in the room and returns its ID
+func insertTestRole(t *testing.T, ctx context.Context, roomID string, permissions ...string) string {
+	t.Helper()
+	role := models.Role{ID: primitive.NewObjectID(), RoomID: roomID, Name: "custom", Permissions: permissions, CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	role.RoleID = role.ID.Hex()
+	if _, err := config.GetRoleCollection().InsertOne(ctx, role); err != nil {
+		t.Fatalf("Failed to insert role: %v", err)
+	}
+	return role.RoleID
+}
+
+func TestRolePermissionsStayWithinTheCallers(t *testing.T) {
+	ctx := connectTestDB(t)
+	owner, manager := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	roomID := insertTestRoom(t, ctx, owner)
+	managerRole := insertTestRole(t, ctx, roomID, models.PermManageMembers, models.PermInvite)
+	otherRole := insertTestRole(t, ctx, roomID, models.PermInvite)
+	insertTestMember(t, ctx, roomID, manager, managerRole)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		userID  string
+		body    map[string]interface{}
+		want    int
+	}{
+		{
+			name:    "add a role within the caller's permissions",
+			handler: AddRole, userID: manager,
+			body: map[string]interface{}{"room_id": roomID, "name": "inviter", "permissions": []string{models.PermInvite}},
+			want: http.StatusOK,
+		},
+		{
+			name:    "add a role granting more than the caller holds",
+			handler: AddRole, userID: manager,
+			body: map[string]interface{}{"room_id": roomID, "name": "deleter", "permissions": []string{models.PermInvite, models.PermDelete}},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "widen a role past the caller's permissions",
+			handler: UpdateRole, userID: manager,
+			body: map[string]interface{}{"role_id": otherRole, "permissions": []string{models.PermEdit}},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "change a role within the caller's permissions",
+			handler: UpdateRole, userID: manager,
+			body: map[string]interface{}{"role_id": otherRole, "permissions": []string{models.PermManageMembers}},
+			want: http.StatusOK,
+		},
+		{
+			name:    "edit a role the caller holds",
+			handler: UpdateRole, userID: manager,
+			body: map[string]interface{}{"role_id": managerRole, "permissions": []string{models.PermManageMembers, models.PermShare}},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "rename a role the caller holds",
+			handler: UpdateRole, userID: manager,
+			body: map[string]interface{}{"role_id": managerRole, "name": "renamed"},
+			want: http.StatusForbidden,
+		},
+		{
+			name:    "owner edits any role",
+			handler: UpdateRole, userID: owner,
+			body: map[string]interface{}{"role_id": managerRole, "permissions": models.AllPermissions},
+			want: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveAs(tt.handler, tt.userID, http.MethodPost, tt.body)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d (%s), want %d", w.Code, w.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -94,13 +94,6 @@ func RoomMember(w http.ResponseWriter, r *http.Request) {
 */
 
 func RoomMember(w http.ResponseWriter, r *http.Request) {
-	// Verify user is authenticated
-	userID, err := utils.GetUserIDFromToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	// Read the request body once
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -126,6 +119,17 @@ func RoomMember(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Received request: %+v", roomMemberRequest)
 
+	// Verify user is authenticated and may invite people to this room
+	userID, ok := requirePermission(w, r, roomMemberRequest.RoomID, models.PermInvite)
+	if !ok {
+		return
+	}
+
+	if err := checkRoleAssignable(r.Context(), userID, roomMemberRequest.RoomID, roomMemberRequest.RoleID); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
 	// Prepare a slice to store insertion results
 	var insertedDocuments []primitive.ObjectID
 
@@ -199,12 +203,31 @@ func ChangeRoomMemberRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	userID, ok := requirePermission(w, r, req.RoomID, models.PermManageMembers)
+	if !ok {
+		return
+	}
+
+	// Refuse the whole change if any role would grant more than the caller
+	// holds. Unknown roles are skipped below.
+	for _, member := range req.Members {
+		role, ok := member["role"].(string)
+		if !ok {
+			continue
+		}
+		if err := checkRoleAssignable(r.Context(), userID, req.RoomID, role); err != nil && errorStatus(err) != http.StatusBadRequest {
+			writeRequestError(w, err)
+			return
+		}
+	}
+
 	// Get collections
 	roomMemberCollection := config.GetRoomMemberCollection()
 
 	// Counter for successful updates
 	successCount := 0
-	updatedMembers := make([]map[string]interface{}, 0)
+	updatedUserIDs := make([]string, 0)
+	before := make(map[string]map[string]bool)
 
 	for _, member := range req.Members {
 		email, ok := member["email"].(string)
@@ -217,16 +240,12 @@ func ChangeRoomMemberRole(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		// Validate role
-		if role != "write" && role != "read" && role != "owner" {
+		// Validate role against the built-in and custom roles of this room.
+		// The owner role is never assignable.
+		if validRole, err := utils.IsValidRoomRole(r.Context(), role, req.RoomID); err != nil || !validRole {
 			continue // Skip invalid roles
 		}
 
-		// Skip updating owner roles
-		if role == "owner" {
-			continue
-		}
-
 		// Get user ID from email
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -247,6 +266,10 @@ func ChangeRoomMemberRole(w http.ResponseWriter, r *http.Request) {
 		}
 		fmt.Print("filter=", filter, "\n")
 
+		if _, seen := before[emailID]; !seen {
+			before[emailID] = snapshotRoomPermissions(ctx, req.RoomID, emailID)
+		}
+
 		update := bson.M{
 			"$set": bson.M{"role_id": role},
 		}
@@ -260,11 +283,7 @@ func ChangeRoomMemberRole(w http.ResponseWriter, r *http.Request) {
 
 		if result.ModifiedCount > 0 {
 			successCount++
-
-			updatedMembers = append(updatedMembers, map[string]interface{}{
-				"email": email,
-				"role":  role,
-			})
+			updatedUserIDs = append(updatedUserIDs, emailID)
 		}
 	}
 
@@ -273,16 +292,12 @@ func ChangeRoomMemberRole(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	socketServer := socketio.ServerInstance
-	if socketServer != nil {
-		socketServer.BroadcastToRoom("", req.RoomID, "room_members_updated", map[string]interface{}{
-			"roomID":  req.RoomID,
-			"members": updatedMembers,
-		})
-		log.Printf("Broadcasted role updates to room %s", req.RoomID)
-	} else {
-		log.Println("⚠️ Socket.IO server instance not available")
+	// Downgraded members are moved out of the live room and everyone gets the new roster
+	changed := make(map[string]map[string]bool, len(updatedUserIDs))
+	for _, id := range updatedUserIDs {
+		changed[id] = before[id]
 	}
+	enforceRoomAccess(context.Background(), req.RoomID, changed)
 
 	// Return success response
 	w.Header().Set("Content-Type", "application/json")
@@ -351,8 +366,17 @@ func RemoveRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Anyone may leave a room; removing someone else requires manage_members
+	if memberID != userID {
+		if _, ok := requirePermission(w, r, req.RoomID, models.PermManageMembers); !ok {
+			return
+		}
+	}
+
 	log.Printf("Using userID %s to remove member from room %s", memberID, req.RoomID)
 
+	before := snapshotRoomPermissions(context.Background(), req.RoomID, memberID)
+
 	filter := bson.M{
 		"room_id":     req.RoomID,
 		"shared_with": memberID,
@@ -370,14 +394,8 @@ func RemoveRoomMember(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// socketServer := socketio.ServerInstance
-	// if socketServer != nil {
-	// 	socketServer.BroadcastToRoom("", req.RoomID, "room_member_removed", map[string]interface{}{
-	// 		"roomID": req.RoomID,
-	// 		"userID": userID,
-	// 	})
-	// 	log.Printf("Broadcasted member removal from room %s", req.RoomID)
-	// }
+	// The member may still have access through a group, in which case they stay connected
+	enforceRoomAccess(context.Background(), req.RoomID, map[string]map[string]bool{memberID: before})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -402,25 +420,6 @@ func GetRoomMembersInRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	roomMemberCollection := config.GetRoomMemberCollection()
-	userCollection := config.GetUserCollection()
-
-	filter := bson.M{"room_id": roomID}
-	cursor, err := roomMemberCollection.Find(context.Background(), filter)
-	if err != nil {
-		log.Printf("MongoDB find error: %v", err)
-		http.Error(w, "Failed to retrieve room members", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(context.Background())
-
-	var roomMembers []models.RoomMembers
-	if err := cursor.All(context.Background(), &roomMembers); err != nil {
-		log.Printf("MongoDB decode error: %v", err)
-		http.Error(w, "Failed to decode room members", http.StatusInternalServerError)
-		return
-	}
-
 	roomCollection := config.GetRoomCollection()
 	var room models.Room
 
@@ -438,10 +437,40 @@ func GetRoomMembersInRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type MemberInfo struct {
-		Email string `json:"email"`
-		Name  string `json:"name"`
-		Role  string `json:"role"`
+	membersResponse, err := getRoomRoster(context.Background(), room)
+	if err != nil {
+		log.Printf("Error building roster for room %s: %v", roomID, err)
+		http.Error(w, "Failed to retrieve room members", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(membersResponse)
+
+}
+
+// MemberInfo is one entry of a room roster
+type MemberInfo struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Group string `json:"group,omitempty"`
+}
+
+// getRoomRoster lists the owner, the direct members and the members granted access through groups
+func getRoomRoster(ctx context.Context, room models.Room) ([]MemberInfo, error) {
+	userCollection := config.GetUserCollection()
+	roomID := room.ID.Hex()
+
+	cursor, err := config.GetRoomMemberCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve room members: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var roomMembers []models.RoomMembers
+	if err := cursor.All(ctx, &roomMembers); err != nil {
+		return nil, fmt.Errorf("failed to decode room members: %v", err)
 	}
 
 	var ownerUser models.User
@@ -450,7 +479,7 @@ func GetRoomMembersInRoom(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error converting owner ID to ObjectID: %v", err)
 		// Continue without owner info
 	} else {
-		err = userCollection.FindOne(context.Background(), bson.M{"_id": ownerObjectID}).Decode(&ownerUser)
+		err = userCollection.FindOne(ctx, bson.M{"_id": ownerObjectID}).Decode(&ownerUser)
 		if err != nil {
 			log.Printf("Error finding owner user: %v", err)
 			// Continue without owner info
@@ -472,7 +501,7 @@ func GetRoomMembersInRoom(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		err = userCollection.FindOne(context.Background(), bson.M{"_id": userObjectID}).Decode(&user)
+		err = userCollection.FindOne(ctx, bson.M{"_id": userObjectID}).Decode(&user)
 		if err != nil {
 			log.Printf("Error finding user: %v", err)
 			continue
@@ -486,7 +515,144 @@ func GetRoomMembersInRoom(w http.ResponseWriter, r *http.Request) {
 
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(membersResponse)
+	// Members granted access through a group are listed once per group, tagged with the group name
+	groupCursor, err := config.GetRoomGroupCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve room groups: %v", err)
+	}
+	defer groupCursor.Close(ctx)
+
+	var roomGroups []models.RoomGroup
+	if err := groupCursor.All(ctx, &roomGroups); err != nil {
+		return nil, fmt.Errorf("failed to decode room groups: %v", err)
+	}
+
+	for _, grant := range roomGroups {
+		group, err := findGroup(ctx, grant.GroupID)
+		if err != nil {
+			log.Printf("Error finding group %s: %v", grant.GroupID, err)
+			continue
+		}
+
+		users, err := findUsersByID(ctx, group.MemberIDs)
+		if err != nil {
+			log.Printf("Error finding group members: %v", err)
+			continue
+		}
+
+		for _, user := range users {
+			membersResponse = append(membersResponse, MemberInfo{
+				Email: user.Email,
+				Name:  user.Name,
+				Role:  grant.RoleID,
+				Group: group.Name,
+			})
+		}
+	}
+
+	return membersResponse, nil
+}
+
+// snapshotRoomPermissions returns the user's current permissions in the room,
+// or nil when they have no access. Take it before changing a grant and pass it
+// to enforceRoomAccess afterwards.
+func snapshotRoomPermissions(ctx context.Context, roomID, userID string) map[string]bool {
+	permissions, err := utils.GetUserPermissionsInRoom(ctx, userID, roomID)
+	if err != nil {
+		if err != utils.ErrNoRoomAccess {
+			log.Printf("Error resolving permissions of %s in room %s: %v", userID, roomID, err)
+		}
+		return nil
+	}
+	return permissions
+}
+
+// enforceRoomAccess compares each user's permissions against the snapshot taken
+// before a grant changed. Users who lost access to the room are removed from its
+// live socket channels and told with "room_member_removed"; users who lost
+// permissions are also moved out and told their new role with "room_role_changed"
+// so they rejoin with it. The remaining members receive the updated roster.
+func enforceRoomAccess(ctx context.Context, roomID string, before map[string]map[string]bool) {
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		log.Println("⚠️ Socket.IO server instance not available")
+		return
+	}
+
+	for userID, oldPermissions := range before {
+		if oldPermissions == nil {
+			continue
+		}
 
+		newPermissions, err := utils.GetUserPermissionsInRoom(ctx, userID, roomID)
+		if err == utils.ErrNoRoomAccess {
+			socketio.DisconnectUserFromRoom(roomID, userID, "room_member_removed", map[string]interface{}{
+				"roomID": roomID,
+				"userID": userID,
+			})
+			socketServer.BroadcastToRoom("", roomID, "room_member_removed", map[string]interface{}{
+				"roomID": roomID,
+				"userID": userID,
+			})
+			log.Printf("Removed user %s from live room %s", userID, roomID)
+			continue
+		}
+		if err != nil {
+			log.Printf("Error resolving permissions of %s in room %s: %v", userID, roomID, err)
+			continue
+		}
+
+		downgraded := false
+		for permission := range oldPermissions {
+			if !newPermissions[permission] {
+				downgraded = true
+				break
+			}
+		}
+		if !downgraded {
+			continue
+		}
+
+		role, _ := utils.GetUserRoleInRoom(ctx, userID, roomID)
+		socketio.DisconnectUserFromRoom(roomID, userID, "room_role_changed", map[string]interface{}{
+			"roomID":      roomID,
+			"role":        role,
+			"permissions": utils.PermissionList(newPermissions),
+		})
+		log.Printf("Moved downgraded user %s out of live room %s", userID, roomID)
+	}
+
+	broadcastRoomRoster(ctx, roomID)
+}
+
+// broadcastRoomRoster sends the full member list of a room to everyone connected to it
+func broadcastRoomRoster(ctx context.Context, roomID string) {
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		log.Println("⚠️ Socket.IO server instance not available")
+		return
+	}
+
+	roomObjectID, err := primitive.ObjectIDFromHex(roomID)
+	if err != nil {
+		return
+	}
+
+	var room models.Room
+	if err := config.GetRoomCollection().FindOne(ctx, bson.M{"_id": roomObjectID}).Decode(&room); err != nil {
+		log.Printf("Error finding room %s for roster broadcast: %v", roomID, err)
+		return
+	}
+
+	members, err := getRoomRoster(ctx, room)
+	if err != nil {
+		log.Printf("Error building roster for room %s: %v", roomID, err)
+		return
+	}
+
+	socketServer.BroadcastToRoom("", roomID, "room_members_updated", map[string]interface{}{
+		"roomID":  roomID,
+		"members": members,
+	})
+	log.Printf("Broadcasted roster of room %s", roomID)
 }
@@ -73,23 +73,6 @@ func AddRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("Failed to add Room: %v", err), http.StatusInternalServerError)
 		return
 	}
-	// Create a default favorite entry for this room
-	favorite := models.Favorite{
-		ID:        primitive.NewObjectID(),
-		UserID:    userID,
-		RoomID:    Room.ID.Hex(),
-		IsFav:     false,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
-	}
-
-	favoriteCollection := config.GetFavoriteCollection()
-	_, err = favoriteCollection.InsertOne(context.Background(), favorite)
-	if err != nil {
-		log.Printf("Warning: Failed to create default favorite status: %v", err)
-		// Continue even if this fails
-	}
-
 	log.Printf("Room inserted with ID: %v", result.InsertedID)
 
 	// Return success response
@@ -108,8 +91,9 @@ func RenameRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestRename struct {
-		RoomID string `json:"room_id"`
-		Name   string `json:"name"`
+		RoomID          string `json:"room_id"`
+		Name            string `json:"name"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 
 	if err := json.Unmarshal(body, &requestRename); err != nil {
@@ -125,22 +109,34 @@ func RenameRoom(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid room ID", http.StatusBadRequest)
 		return
 	}
-	filter := bson.M{"_id": roomID}
-	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAT": time.Now()}}
 
-	_, err = roomCollection.UpdateOne(context.Background(), filter, update)
+	if _, ok := requirePermission(w, r, requestRename.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, requestRename.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	var room models.Room
+	update := bson.M{"$set": bson.M{"name": requestRename.Name, "updatedAt": time.Now()}}
+	version, err := updateVersioned(context.Background(), roomCollection, models.StarRoom, roomID, update, expected, &room)
 	if err != nil {
 		log.Printf("Error updating room: %v", err)
-		http.Error(w, "Failed to update room name", http.StatusInternalServerError)
+		writeRequestError(w, versionedWriteError(err, "Failed to update room name"))
 		return
 	}
 
 	// Return success response with stats
+	setETag(w, version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"message": "Rename room successfully",
 		"roomId":  roomID.Hex(),
+		"version": version,
 	})
 }
 
@@ -152,7 +148,9 @@ func DeleteRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var requestDelete struct {
-		RoomID string `json:"room_id"`
+		RoomID          string `json:"room_id"`
+		DryRun          bool   `json:"dry_run"`
+		ExpectedVersion *int64 `json:"expected_version"`
 	}
 	if err := json.Unmarshal(body, &requestDelete); err != nil {
 		log.Printf("Error decoding request: %v", err)
@@ -175,9 +173,51 @@ func DeleteRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deletedStats, err := DeleteRoomAndContent(room)
+	// Only the owner can delete the whole room, whatever permissions a custom role grants
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if room.OwnerID != userID {
+		http.Error(w, "Only the room owner can delete the room", http.StatusForbidden)
+		return
+	}
+
+	expected, err := expectedVersion(r, requestDelete.ExpectedVersion)
+	if err == nil {
+		err = compareVersion(models.StarRoom, room.Version, expected, room)
+	}
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	plan, err := planRoomDelete(ctx, room)
+	if err != nil {
+		log.Printf("Error collecting contents of room %s: %v", room.ID.Hex(), err)
+		http.Error(w, "Failed to collect room contents", http.StatusInternalServerError)
+		return
+	}
+
+	if requestDelete.DryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "Nothing was deleted",
+			"dry_run": true,
+			"preview": plan.preview(),
+		})
+		return
+	}
+
+	plan.expected = expected
+	deletedStats, err := plan.execute(ctx)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Error deleting room %s: %v", room.ID.Hex(), err)
+		writeRequestError(w, versionedWriteError(err, "Failed to delete room"))
 		return
 	}
 
@@ -197,69 +237,6 @@ type DeletionRoomStats struct {
 	Papers  int64 `json:"papers"`
 }
 
-func DeleteRoomAndContent(room models.Room) (DeletionRoomStats, error) {
-	ctx := context.Background()
-	stats := DeletionRoomStats{}
-
-	FolderFilter := bson.M{"room_id": room.ID.Hex()}
-
-	FolderCursor, err := config.GetFolderCollection().Find(ctx, FolderFilter)
-	if err != nil {
-		return stats, fmt.Errorf("failed to query sub-folders: %v", err)
-	}
-	defer FolderCursor.Close(ctx)
-
-	var folders []models.Folder
-	if err = FolderCursor.All(ctx, &folders); err != nil {
-		return stats, fmt.Errorf("failed to process sub-folders: %v", err)
-	}
-
-	for _, folder := range folders {
-		substats, err := deleteFolderAndContents(folder)
-		if err != nil {
-			return stats, err
-		}
-
-		stats.Folders += substats.Folders
-		stats.Files += substats.Files
-		stats.Papers += substats.Papers
-	}
-
-	fileFileter := bson.M{"room_id": room.ID.Hex()}
-	fileCursor, err := config.GetFileCollection().Find(ctx, fileFileter)
-	if err != nil {
-		return stats, fmt.Errorf("failed to query files: %v", err)
-	}
-	defer fileCursor.Close(ctx)
-
-	var files []models.File
-	if err = fileCursor.All(ctx, &files); err != nil {
-		return stats, fmt.Errorf("failed to process files: %v", err)
-	}
-
-	for _, file := range files {
-		substats, err := deleteFileAndContents(file)
-		if err != nil {
-			return stats, err
-		}
-		stats.Files += substats.Files
-		stats.Papers += substats.Papers
-	}
-
-	roomResult, err := config.GetRoomCollection().DeleteOne(ctx, bson.M{"_id": room.ID})
-	if err != nil {
-		return stats, fmt.Errorf("failed to delete folder: %v", err)
-	}
-
-	if roomResult.DeletedCount == 0 {
-		return stats, fmt.Errorf("folder not found during deletion")
-	}
-	log.Printf("Successfully deleted folder: %s", room.Name)
-	stats.Rooms++
-
-	return stats, nil
-}
-
 // Add Get shared room with other users
 func GetRooms(w http.ResponseWriter, r *http.Request) {
 	// Verify user is authenticated
@@ -271,8 +248,6 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 
 	// Get room collections
 	roomCollection := config.GetRoomCollection()
-	roomMemberCollection := config.GetRoomMemberCollection()
-	favoriteCollection := config.GetFavoriteCollection()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -296,32 +271,17 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sharedRoomMembersFilter := bson.M{"shared_with": userID}
-	sharedRoomMembersCursor, err := roomMemberCollection.Find(ctx, sharedRoomMembersFilter)
+	// Rooms shared directly through Room_Member or through a group the user belongs to
+	roleIDMap, err := utils.GetSharedRoomRoles(ctx, userID) // Maps room ID to role ID
 	if err != nil {
-		log.Println("Database query error:", err)
-		http.Error(w, "Failed to fetch rooms", http.StatusInternalServerError)
 		log.Printf("Error finding shared room members: %v", err)
 		http.Error(w, "Failed to fetch shared room members", http.StatusInternalServerError)
 		return
 	}
-	defer sharedRoomMembersCursor.Close(ctx)
 
-	// Decode shared room members
-	var sharedRoomMembers []models.RoomMembers
-	if err = sharedRoomMembersCursor.All(ctx, &sharedRoomMembers); err != nil {
-		log.Printf("Error decoding shared room members: %v", err)
-		http.Error(w, "Failed to decode shared rooms", http.StatusInternalServerError)
-		return
-	}
-
-	// Create maps to store room_id and role_id for each shared room
-	sharedRoomIDs := make([]string, 0)
-	roleIDMap := make(map[string]string) // Maps room ID to role ID
-
-	for _, member := range sharedRoomMembers {
-		sharedRoomIDs = append(sharedRoomIDs, member.RoomID)
-		roleIDMap[member.RoomID] = member.RoleID // Store the role ID for each room
+	sharedRoomIDs := make([]string, 0, len(roleIDMap))
+	for roomID := range roleIDMap {
+		sharedRoomIDs = append(sharedRoomIDs, roomID)
 	}
 
 	allRooms := ownedRooms
@@ -338,7 +298,8 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 
 	// Find shared rooms details
 	if len(sharedRoomIDs) > 0 {
-		sharedRoomsFilter := bson.M{"_id": bson.M{"$in": sharedRoomObjIDs}}
+		// Skip owned rooms that are also granted back to the owner through a group
+		sharedRoomsFilter := bson.M{"_id": bson.M{"$in": sharedRoomObjIDs}, "owner_id": bson.M{"$ne": userID}}
 
 		sharedRoomsCursor, err := roomCollection.Find(ctx, sharedRoomsFilter)
 		if err != nil {
@@ -358,6 +319,13 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 		allRooms = append(allRooms, sharedRooms...)
 	}
 
+	favoriteRooms, err := getFavoriteRoomIDs(ctx, userID)
+	if err != nil {
+		log.Printf("Error finding starred rooms: %v", err)
+		http.Error(w, "Failed to fetch favorite rooms", http.StatusInternalServerError)
+		return
+	}
+
 	roomsWithFav := make([]map[string]interface{}, 0)
 
 	for _, room := range allRooms {
@@ -376,15 +344,14 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		var favorite models.Favorite
-		favFilter := bson.M{"user_id": userID, "room_id": room.ID.Hex()}
-		err = favoriteCollection.FindOne(ctx, favFilter).Decode(&favorite)
-
-		if err == nil {
-			roomData["is_favorite"] = favorite.IsFav
-		} else {
-			roomData["is_favorite"] = false
+		// Let the client enable or disable actions without knowing custom role definitions
+		permissions, err := utils.GetUserPermissionsInRoom(ctx, userID, room.ID.Hex())
+		if err != nil {
+			log.Printf("Error resolving permissions for room %s: %v", room.ID.Hex(), err)
 		}
+		roomData["permissions"] = utils.PermissionList(permissions)
+
+		roomData["is_favorite"] = favoriteRooms[room.ID.Hex()]
 
 		roomsWithFav = append(roomsWithFav, roomData)
 	}
@@ -394,6 +361,42 @@ func GetRooms(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(roomsWithFav)
 }
 
+// getAccessibleRooms returns every room the user owns or has been granted, keyed by room ID
+func getAccessibleRooms(ctx context.Context, userID string) (map[string]models.Room, error) {
+	roleIDMap, err := utils.GetSharedRoomRoles(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedRoomObjIDs := make([]primitive.ObjectID, 0, len(roleIDMap))
+	for roomID := range roleIDMap {
+		if objID, err := primitive.ObjectIDFromHex(roomID); err == nil {
+			sharedRoomObjIDs = append(sharedRoomObjIDs, objID)
+		}
+	}
+
+	filter := bson.M{"$or": []bson.M{
+		{"owner_id": userID},
+		{"_id": bson.M{"$in": sharedRoomObjIDs}},
+	}}
+	cursor, err := config.GetRoomCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rooms: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var rooms []models.Room
+	if err := cursor.All(ctx, &rooms); err != nil {
+		return nil, fmt.Errorf("failed to decode rooms: %v", err)
+	}
+
+	roomsByID := make(map[string]models.Room, len(rooms))
+	for _, room := range rooms {
+		roomsByID[room.ID.Hex()] = room
+	}
+	return roomsByID, nil
+}
+
 func GetSharedRoomID(w http.ResponseWriter, r *http.Request) {
 
 	originalID := r.URL.Query().Get("original_id")
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// SearchHit is one match. Folder and file hits carry the item's folder path;
+// annotation hits carry the paper, page and position of the matching text.
+type SearchHit struct {
+	Type         string         `json:"type"`
+	ID           string         `json:"id"`
+	Name         string         `json:"name,omitempty"`
+	Text         string         `json:"text,omitempty"`
+	RoomID       string         `json:"room_id"`
+	RoomName     string         `json:"room_name"`
+	FileID       string         `json:"file_id,omitempty"`
+	FileName     string         `json:"file_name,omitempty"`
+	PaperID      string         `json:"paper_id,omitempty"`
+	PageNumber   int            `json:"page_number,omitempty"`
+	AnnotationID int            `json:"annotation_id,omitempty"`
+	Position     *models.Offset `json:"position,omitempty"`
+	Path         []PathEntry    `json:"path,omitempty"`
+}
+
+// Search matches folder names, file names and text annotations, case-insensitively,
+// in one room (room_id) or in every room the caller can access
+func Search(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Missing q parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsed, err := strconv.Atoi(limitParam); err == nil && parsed > 0 {
+			limit = parsed
+		}
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	rooms, err := getAccessibleRooms(ctx, userID)
+	if err != nil {
+		log.Printf("Error resolving rooms of %s: %v", userID, err)
+		http.Error(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+
+	if roomID := r.URL.Query().Get("room_id"); roomID != "" {
+		room, ok := rooms[roomID]
+		if !ok {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		rooms = map[string]models.Room{roomID: room}
+	}
+
+	roomIDs := make([]string, 0, len(rooms))
+	for roomID := range rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+
+	hits, truncated, err := searchRooms(ctx, rooms, roomIDs, query, limit)
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		http.Error(w, "Failed to search", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":     query,
+		"hits":      hits,
+		"truncated": truncated,
+	})
+}
+
+// searchRooms returns up to limit hits: folders first, then files, then annotations
+func searchRooms(ctx context.Context, rooms map[string]models.Room, roomIDs []string, query string, limit int) ([]SearchHit, bool, error) {
+	hits := make([]SearchHit, 0)
+	if len(roomIDs) == 0 {
+		return hits, false, nil
+	}
+
+	pattern := primitive.Regex{Pattern: regexp.QuoteMeta(query), Options: "i"}
+	lowerQuery := strings.ToLower(query)
+	// Fetch one extra so we can tell whether the results were cut off
+	findOptions := options.Find().SetLimit(int64(limit + 1)).SetSort(bson.M{"name": 1})
+
+	var folders []models.Folder
+	cursor, err := config.GetFolderCollection().Find(ctx, bson.M{"room_id": bson.M{"$in": roomIDs}, "name": pattern}, findOptions)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, false, err
+	}
+	for _, folder := range folders {
+		hits = append(hits, SearchHit{
+			Type:     models.StarFolder,
+			ID:       folder.ID.Hex(),
+			Name:     folder.Name,
+			RoomID:   folder.RoomID,
+			RoomName: rooms[folder.RoomID].Name,
+			Path:     resolveFolderPath(ctx, folder.SubFolderID),
+		})
+	}
+	if len(hits) > limit {
+		return hits[:limit], true, nil
+	}
+
+	var files []models.File
+	cursor, err = config.GetFileCollection().Find(ctx, bson.M{"room_id": bson.M{"$in": roomIDs}, "name": pattern}, findOptions)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, false, err
+	}
+	for _, file := range files {
+		hits = append(hits, SearchHit{
+			Type:     models.StarFile,
+			ID:       file.ID.Hex(),
+			Name:     file.Name,
+			RoomID:   file.RoomID,
+			RoomName: rooms[file.RoomID].Name,
+			FileID:   file.ID.Hex(),
+			FileName: file.Name,
+			Path:     resolveFolderPath(ctx, file.SubFolderID),
+		})
+	}
+	if len(hits) > limit {
+		return hits[:limit], true, nil
+	}
+
+	// Only the annotation fields are needed, strokes can be large
+	paperOptions := options.Find().
+		SetProjection(bson.M{"room_id": 1, "file_id": 1, "page_number": 1, "text_data": 1}).
+		SetSort(bson.D{{Key: "file_id", Value: 1}, {Key: "page_number", Value: 1}})
+	var papers []models.Paper
+	cursor, err = config.GetPaperCollection().Find(ctx, bson.M{"room_id": bson.M{"$in": roomIDs}, "text_data.text": pattern}, paperOptions)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := cursor.All(ctx, &papers); err != nil {
+		return nil, false, err
+	}
+
+	fileNames, err := getFileNames(ctx, papers)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, paper := range papers {
+		for _, annotation := range paper.TextData {
+			if !strings.Contains(strings.ToLower(annotation.Text), lowerQuery) {
+				continue
+			}
+			if len(hits) == limit {
+				return hits, true, nil
+			}
+
+			position := annotation.Position
+			hits = append(hits, SearchHit{
+				Type:         "annotation",
+				ID:           paper.ID.Hex(),
+				Text:         annotation.Text,
+				RoomID:       paper.RoomID,
+				RoomName:     rooms[paper.RoomID].Name,
+				FileID:       paper.FileID,
+				FileName:     fileNames[paper.FileID],
+				PaperID:      paper.ID.Hex(),
+				PageNumber:   paper.PageNumber,
+				AnnotationID: annotation.ID,
+				Position:     &position,
+			})
+		}
+	}
+
+	return hits, false, nil
+}
+
+// getFileNames returns the names of the files the papers belong to, keyed by file ID
+func getFileNames(ctx context.Context, papers []models.Paper) (map[string]string, error) {
+	fileIDs := make([]string, 0, len(papers))
+	for _, paper := range papers {
+		fileIDs = append(fileIDs, paper.FileID)
+	}
+
+	names := make(map[string]string, len(fileIDs))
+	if len(fileIDs) == 0 {
+		return names, nil
+	}
+
+	var files []models.File
+	cursor, err := config.GetFileCollection().Find(ctx, bson.M{"_id": bson.M{"$in": toObjectIDs(fileIDs)}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		names[file.ID.Hex()] = file.Name
+	}
+	return names, nil
+}
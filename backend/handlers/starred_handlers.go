@@ -0,0 +1,421 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StarredItemInfo is a starred item with the context needed to open it
+type StarredItemInfo struct {
+	ID         string      `json:"id"`
+	ItemType   string      `json:"item_type"`
+	ItemID     string      `json:"item_id"`
+	Name       string      `json:"name"`
+	RoomID     string      `json:"room_id"`
+	RoomName   string      `json:"room_name"`
+	Path       []PathEntry `json:"path"`
+	FileID     string      `json:"file_id,omitempty"`
+	PageNumber int         `json:"page_number,omitempty"`
+	Position   int         `json:"position"`
+	CreatedAt  time.Time   `json:"createdAt"`
+}
+
+// StarItem stars a room, folder, file or paper for the caller. Starring an
+// item twice is a no-op; new stars go to the end of the list.
+func StarItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var starRequest struct {
+		ItemType string `json:"item_type"`
+		ItemID   string `json:"item_id"`
+	}
+	if err := json.Unmarshal(body, &starRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roomID, err := resolveStarRoom(ctx, starRequest.ItemType, starRequest.ItemID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	star, err := addStar(ctx, userID, starRequest.ItemType, starRequest.ItemID, roomID)
+	if err != nil {
+		log.Printf("Error starring %s %s: %v", starRequest.ItemType, starRequest.ItemID, err)
+		http.Error(w, "Failed to star item", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(star)
+}
+
+// UnstarItem removes an item from the caller's starred list
+func UnstarItem(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var starRequest struct {
+		ItemType string `json:"item_type"`
+		ItemID   string `json:"item_id"`
+	}
+	if err := json.Unmarshal(body, &starRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := removeStar(context.Background(), userID, starRequest.ItemType, starRequest.ItemID)
+	if err != nil {
+		http.Error(w, "Failed to unstar item", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Item is not starred", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":   "Item unstarred successfully",
+		"item_type": starRequest.ItemType,
+		"item_id":   starRequest.ItemID,
+	})
+}
+
+// ReorderStarredItems sets the order of the caller's starred items. star_ids
+// lists star IDs in the new order; stars not listed keep their relative order
+// after the listed ones.
+func ReorderStarredItems(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var orderRequest struct {
+		StarIDs []string `json:"star_ids"`
+	}
+	if err := json.Unmarshal(body, &orderRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stars, err := findStars(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		http.Error(w, "Failed to fetch starred items", http.StatusInternalServerError)
+		return
+	}
+
+	byID := make(map[string]models.StarredItem, len(stars))
+	for _, star := range stars {
+		byID[star.ID.Hex()] = star
+	}
+
+	ordered := make([]models.StarredItem, 0, len(stars))
+	listed := make(map[string]bool, len(orderRequest.StarIDs))
+	for _, starID := range orderRequest.StarIDs {
+		star, ok := byID[starID]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Unknown starred item: %s", starID), http.StatusBadRequest)
+			return
+		}
+		if listed[starID] {
+			continue
+		}
+		listed[starID] = true
+		ordered = append(ordered, star)
+	}
+	for _, star := range stars {
+		if !listed[star.ID.Hex()] {
+			ordered = append(ordered, star)
+		}
+	}
+
+	var bulkWrites []mongo.WriteModel
+	for position, star := range ordered {
+		if star.Position == position {
+			continue
+		}
+		bulkWrites = append(bulkWrites, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": star.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"position": position}}))
+	}
+
+	if len(bulkWrites) > 0 {
+		if _, err := config.GetStarredCollection().BulkWrite(ctx, bulkWrites); err != nil {
+			http.Error(w, "Failed to reorder starred items", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":       "Starred items reordered successfully",
+		"updated_count": len(bulkWrites),
+	})
+}
+
+// GetStarredItems lists the caller's starred items across all rooms in their
+// custom order, with the room name and folder path of each item. Items in
+// rooms the caller can no longer access, or that no longer exist, are skipped.
+func GetStarredItems(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if itemType := r.URL.Query().Get("item_type"); itemType != "" {
+		filter["item_type"] = itemType
+	}
+
+	stars, err := findStars(ctx, filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch starred items", http.StatusInternalServerError)
+		return
+	}
+
+	rooms, err := getAccessibleRooms(ctx, userID)
+	if err != nil {
+		log.Printf("Error resolving rooms of %s: %v", userID, err)
+		http.Error(w, "Failed to fetch rooms", http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]StarredItemInfo, 0, len(stars))
+	for _, star := range stars {
+		room, ok := rooms[star.RoomID]
+		if !ok {
+			continue
+		}
+
+		item := StarredItemInfo{
+			ID:        star.ID.Hex(),
+			ItemType:  star.ItemType,
+			ItemID:    star.ItemID,
+			RoomID:    star.RoomID,
+			RoomName:  room.Name,
+			Path:      []PathEntry{},
+			Position:  star.Position,
+			CreatedAt: star.CreatedAt,
+		}
+
+		if err := fillStarContext(ctx, &item, room); err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(items)
+}
+
+// fillStarContext sets the name and path of a starred item. It returns an
+// error when the item no longer exists.
+func fillStarContext(ctx context.Context, item *StarredItemInfo, room models.Room) error {
+	objID, err := primitive.ObjectIDFromHex(item.ItemID)
+	if err != nil {
+		return err
+	}
+
+	switch item.ItemType {
+	case models.StarRoom:
+		item.Name = room.Name
+
+	case models.StarFolder:
+		var folder models.Folder
+		if err := config.GetFolderCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&folder); err != nil {
+			return err
+		}
+		item.Name = folder.Name
+		item.Path = resolveFolderPath(ctx, folder.SubFolderID)
+
+	case models.StarFile:
+		var file models.File
+		if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+			return err
+		}
+		item.Name = file.Name
+		item.Path = resolveFolderPath(ctx, file.SubFolderID)
+
+	case models.StarPaper:
+		var paper models.Paper
+		if err := config.GetPaperCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&paper); err != nil {
+			return err
+		}
+
+		fileObjID, err := primitive.ObjectIDFromHex(paper.FileID)
+		if err != nil {
+			return err
+		}
+		var file models.File
+		if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": fileObjID}).Decode(&file); err != nil {
+			return err
+		}
+
+		item.Name = fmt.Sprintf("%s - page %d", file.Name, paper.PageNumber)
+		item.FileID = paper.FileID
+		item.PageNumber = paper.PageNumber
+		item.Path = append(resolveFolderPath(ctx, file.SubFolderID), PathEntry{ID: paper.FileID, Type: models.StarFile, Name: file.Name})
+
+	default:
+		return fmt.Errorf("unknown item type: %s", item.ItemType)
+	}
+	return nil
+}
+
+// resolveStarRoom checks that the item exists and returns the room it belongs to
+func resolveStarRoom(ctx context.Context, itemType, itemID string) (string, error) {
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return "", fmt.Errorf("invalid item ID format")
+	}
+
+	var collection *mongo.Collection
+	switch itemType {
+	case models.StarRoom:
+		return itemID, config.GetRoomCollection().FindOne(ctx, bson.M{"_id": objID}).Err()
+	case models.StarFolder:
+		collection = config.GetFolderCollection()
+	case models.StarFile:
+		collection = config.GetFileCollection()
+	case models.StarPaper:
+		collection = config.GetPaperCollection()
+	default:
+		return "", fmt.Errorf("unknown item type: %s", itemType)
+	}
+
+	var item struct {
+		RoomID string `bson:"room_id"`
+	}
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&item); err != nil {
+		return "", fmt.Errorf("%s not found", itemType)
+	}
+	return item.RoomID, nil
+}
+
+// addStar stars an item at the end of the user's list, returning the existing
+// star if the item is already starred
+func addStar(ctx context.Context, userID, itemType, itemID, roomID string) (models.StarredItem, error) {
+	starredCollection := config.GetStarredCollection()
+
+	var star models.StarredItem
+	filter := bson.M{"user_id": userID, "item_type": itemType, "item_id": itemID}
+	err := starredCollection.FindOne(ctx, filter).Decode(&star)
+	if err == nil {
+		return star, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return star, err
+	}
+
+	position := 0
+	var last models.StarredItem
+	lastOptions := options.FindOne().SetSort(bson.M{"position": -1})
+	if err := starredCollection.FindOne(ctx, bson.M{"user_id": userID}, lastOptions).Decode(&last); err == nil {
+		position = last.Position + 1
+	}
+
+	star = models.StarredItem{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		ItemType:  itemType,
+		ItemID:    itemID,
+		RoomID:    roomID,
+		Position:  position,
+		CreatedAt: time.Now(),
+	}
+	_, err = starredCollection.InsertOne(ctx, star)
+	return star, err
+}
+
+// removeStar unstars an item and reports whether it was starred
+func removeStar(ctx context.Context, userID, itemType, itemID string) (bool, error) {
+	filter := bson.M{"user_id": userID, "item_type": itemType, "item_id": itemID}
+	result, err := config.GetStarredCollection().DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+// deleteStars removes every user's stars on the given items, used when the items are deleted
+func deleteStars(ctx context.Context, itemType string, itemIDs ...string) {
+	if len(itemIDs) == 0 {
+		return
+	}
+	filter := bson.M{"item_type": itemType, "item_id": bson.M{"$in": itemIDs}}
+	if _, err := config.GetStarredCollection().DeleteMany(ctx, filter); err != nil {
+		log.Printf("Failed to remove stars of deleted %s items: %v", itemType, err)
+	}
+}
+
+func findStars(ctx context.Context, filter bson.M) ([]models.StarredItem, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "createdAt", Value: 1}})
+	cursor, err := config.GetStarredCollection().Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stars []models.StarredItem
+	if err := cursor.All(ctx, &stars); err != nil {
+		return nil, err
+	}
+	return stars, nil
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/socketio"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxStrokeOps = 1000
+
+// StrokeOp is one change to the strokes of a paper. Op is "add", "update" or
+// "delete"; add and update carry the whole stroke, delete only its ID. Adding
+// a stroke whose ID already exists replaces it, so a retried request is
+// harmless.
+type StrokeOp struct {
+	Op       string               `json:"op"`
+	Stroke   *models.DrawingPoint `json:"stroke,omitempty"`
+	StrokeID *int                 `json:"stroke_id,omitempty"`
+
+	// replay marks strokes restored from history, which were simplified
+	// when they were first stored and must come back unchanged
+	replay bool
+}
+
+// StrokeOpsRequest applies Ops to one paper, in order and all or nothing
+type StrokeOpsRequest struct {
+	PaperID         string     `json:"paper_id"`
+	Ops             []StrokeOp `json:"ops"`
+	ExpectedVersion *int64     `json:"expected_version"`
+}
+
+// ApplyStrokeOps adds, updates and deletes individual strokes of a paper
+// instead of replacing all of them. The paper gets one new version per
+// request, and the room receives a stroke_delta event with only the changed
+// strokes.
+func ApplyStrokeOps(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var strokeRequest StrokeOpsRequest
+	if err := json.Unmarshal(body, &strokeRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if len(strokeRequest.Ops) == 0 {
+		http.Error(w, "ops is required", http.StatusBadRequest)
+		return
+	}
+	if len(strokeRequest.Ops) > maxStrokeOps {
+		http.Error(w, fmt.Sprintf("A request can hold at most %d stroke operations", maxStrokeOps), http.StatusBadRequest)
+		return
+	}
+	for i := range strokeRequest.Ops {
+		if err := normalizeStrokeOp(&strokeRequest.Ops[i]); err != nil {
+			http.Error(w, fmt.Sprintf("op %d: %v", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	paperObjID, err := primitive.ObjectIDFromHex(strokeRequest.PaperID)
+	if err != nil {
+		http.Error(w, "Invalid paper ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Strokes can be large and are not needed to check access
+	var paper models.Paper
+	findOptions := options.FindOne().SetProjection(bson.M{"room_id": 1, "file_id": 1})
+	if err := config.GetPaperCollection().FindOne(ctx, bson.M{"_id": paperObjID}, findOptions).Decode(&paper); err != nil {
+		http.Error(w, "Paper not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, paper.RoomID, models.PermEdit)
+	if !ok {
+		return
+	}
+
+	expected, err := expectedVersion(r, strokeRequest.ExpectedVersion)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	autoSnapshot(ctx, paper.FileID, userID)
+
+	var version int64
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var changes []models.StrokeChange
+		var err error
+		version, changes, err = applyStrokeOps(sessCtx, paperObjID, strokeRequest.Ops, expected)
+		if err != nil {
+			return err
+		}
+		return recordStrokeHistory(sessCtx, strokeRequest.PaperID, userID, changes)
+	})
+	if err != nil {
+		log.Printf("Error applying stroke operations to paper %s: %v", strokeRequest.PaperID, err)
+		writeRequestError(w, versionedWriteError(err, "Failed to apply stroke operations"))
+		return
+	}
+
+	touchFile(ctx, paper.RoomID, paper.FileID, userID)
+
+	if socketServer := socketio.ServerInstance; socketServer != nil {
+		socketServer.BroadcastToRoom("", paper.RoomID, "stroke_delta", map[string]interface{}{
+			"roomID":   paper.RoomID,
+			"file_id":  paper.FileID,
+			"paper_id": strokeRequest.PaperID,
+			"user_id":  userID,
+			"version":  version,
+			"ops":      strokeRequest.Ops,
+		})
+	}
+
+	setETag(w, version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Stroke operations applied successfully",
+		"paper_id": strokeRequest.PaperID,
+		"applied":  len(strokeRequest.Ops),
+		"version":  version,
+	})
+}
+
+// normalizeStrokeOp checks an op, and fills in the type of the stroke of an
+// add or update. Delete ops may name the stroke by stroke_id or by a stroke
+// holding only its ID.
+func normalizeStrokeOp(op *StrokeOp) error {
+	switch op.Op {
+	case "add", "update":
+		if op.Stroke == nil {
+			return fmt.Errorf("%s needs a stroke", op.Op)
+		}
+		if len(op.Stroke.Offsets) == 0 {
+			return fmt.Errorf("stroke %d has no offsets", op.Stroke.ID)
+		}
+		if op.Stroke.Type == "" {
+			op.Stroke.Type = "drawing"
+		}
+		op.StrokeID = nil
+	case "delete":
+		if op.StrokeID == nil && op.Stroke != nil {
+			op.StrokeID = &op.Stroke.ID
+		}
+		if op.StrokeID == nil {
+			return fmt.Errorf("delete needs a stroke_id")
+		}
+		op.Stroke = nil
+	default:
+		return fmt.Errorf("op must be 'add', 'update' or 'delete'")
+	}
+	return nil
+}
+
+// applyStrokeOps claims the next version of the paper and then applies the
+// ops one by one, in the caller's transaction. Claiming the version first
+// makes concurrent requests on the same paper conflict and retry instead of
+// interleaving. Added and updated strokes are simplified unless they are
+// replayed from history. It returns every stroke the ops changed, as it was
+// before and after them.
+func applyStrokeOps(sessCtx mongo.SessionContext, paperObjID primitive.ObjectID, ops []StrokeOp, expected *int64) (int64, []models.StrokeChange, error) {
+	paperCollection := config.GetPaperCollection()
+
+	filter := bson.M{"_id": paperObjID}
+	if expected != nil {
+		filter["version"] = versionMatch(*expected)
+	}
+	update := bumpVersion(bson.M{"$set": bson.M{"updated_at": time.Now()}})
+	claimOptions := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"version": 1, "stroke_storage": 1})
+
+	var claimed struct {
+		Version       int64  `bson:"version"`
+		StrokeStorage string `bson:"stroke_storage"`
+	}
+	err := paperCollection.FindOneAndUpdate(sessCtx, filter, update, claimOptions).Decode(&claimed)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil, currentVersionError(sessCtx, paperCollection, models.StarPaper, paperObjID, expected, &models.Paper{})
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to claim paper version: %v", err)
+	}
+
+	chunked := claimed.StrokeStorage == models.StrokesChunked
+	if !chunked {
+		if chunked, err = chunkLargePaper(sessCtx, paperObjID); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// The strokes are an array in the paper or in one of its chunks
+	paperID := paperObjID.Hex()
+	collection, owner, field := paperCollection, bson.M{"_id": paperObjID}, "drawing_data"
+	if chunked {
+		collection, owner, field = config.GetPaperStrokeCollection(), bson.M{"paper_id": paperID}, "strokes"
+	} else {
+		// Clearing the strokes with the drawing endpoint stores null, which
+		// $push cannot extend
+		notArray := bson.M{"_id": paperObjID, "drawing_data": bson.M{"$not": bson.M{"$type": "array"}}}
+		if _, err := paperCollection.UpdateOne(sessCtx, notArray, bson.M{"$set": bson.M{"drawing_data": bson.A{}}}); err != nil {
+			return 0, nil, fmt.Errorf("failed to prepare strokes: %v", err)
+		}
+	}
+	holding := func(strokeID int) bson.M {
+		filter := bson.M{field + ".id": strokeID}
+		for key, value := range owner {
+			filter[key] = value
+		}
+		return filter
+	}
+
+	// The state of every stroke before its first op, to record the change
+	before := make(map[int]*models.DrawingPoint)
+	var order []int
+	for _, op := range ops {
+		strokeID := op.opStrokeID()
+		if _, seen := before[strokeID]; seen {
+			continue
+		}
+		stroke, err := findStroke(sessCtx, collection, holding(strokeID), field)
+		if err != nil {
+			return 0, nil, err
+		}
+		before[strokeID] = stroke
+		order = append(order, strokeID)
+	}
+
+	// The strokes as the ops so far left them, so that a chunk's size
+	// changes by the difference a replaced or deleted stroke makes
+	current := make(map[int]*models.DrawingPoint, len(before))
+	for strokeID, stroke := range before {
+		current[strokeID] = stroke
+	}
+	currentSize := func(strokeID int) int {
+		if stroke := current[strokeID]; stroke != nil {
+			return stroke.PackedSize()
+		}
+		return 0
+	}
+
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "update":
+			if !op.replay {
+				simplifyStroke(op.Stroke)
+			}
+			set := bson.M{"$set": bson.M{field + ".$": op.Stroke}}
+			if chunked {
+				set["$inc"] = bson.M{"size": op.Stroke.PackedSize() - currentSize(op.Stroke.ID)}
+			}
+			result, err := collection.UpdateOne(sessCtx, holding(op.Stroke.ID), set)
+			if err != nil {
+				return 0, nil, fmt.Errorf("failed to update stroke %d: %v", op.Stroke.ID, err)
+			}
+			if result.MatchedCount == 0 {
+				if op.Op == "update" {
+					return 0, nil, newRequestError(http.StatusNotFound, fmt.Sprintf("op %d: stroke %d not found", i, op.Stroke.ID))
+				}
+				if chunked {
+					err = appendChunkedStroke(sessCtx, paperID, *op.Stroke)
+				} else {
+					_, err = paperCollection.UpdateOne(sessCtx, owner, bson.M{"$push": bson.M{"drawing_data": op.Stroke}})
+				}
+				if err != nil {
+					return 0, nil, fmt.Errorf("failed to add stroke %d: %v", op.Stroke.ID, err)
+				}
+			}
+			current[op.Stroke.ID] = op.Stroke
+
+		case "delete":
+			pull := bson.M{"$pull": bson.M{field: bson.M{"id": *op.StrokeID}}}
+			if chunked {
+				pull["$inc"] = bson.M{"count": -1, "size": -currentSize(*op.StrokeID)}
+			}
+			if _, err := collection.UpdateOne(sessCtx, holding(*op.StrokeID), pull); err != nil {
+				return 0, nil, fmt.Errorf("failed to delete stroke %d: %v", *op.StrokeID, err)
+			}
+			current[*op.StrokeID] = nil
+		}
+	}
+	return claimed.Version, strokeChanges(before, order, ops), nil
+}
+
+// opStrokeID is the ID of the stroke an op changes
+func (op StrokeOp) opStrokeID() int {
+	if op.StrokeID != nil {
+		return *op.StrokeID
+	}
+	return op.Stroke.ID
+}
+
+// findStroke returns the stroke matched by filter in the strokes array named
+// field, or nil when there is none
+func findStroke(sessCtx mongo.SessionContext, collection *mongo.Collection, filter bson.M, field string) (*models.DrawingPoint, error) {
+	findOptions := options.FindOne().SetProjection(bson.M{field + ".$": 1})
+	raw, err := collection.FindOne(sessCtx, filter, findOptions).Raw()
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stroke: %v", err)
+	}
+
+	strokes, ok := raw.Lookup(field).ArrayOK()
+	if !ok {
+		return nil, nil
+	}
+	value, err := strokes.IndexErr(0)
+	if err != nil {
+		return nil, nil
+	}
+	var stroke models.DrawingPoint
+	if err := value.Value().Unmarshal(&stroke); err != nil {
+		return nil, fmt.Errorf("failed to decode stroke: %v", err)
+	}
+	return &stroke, nil
+}
+
+// strokeChanges pairs the state of each stroke before the ops with its state
+// after them, leaving out strokes the ops did not change
+func strokeChanges(before map[int]*models.DrawingPoint, order []int, ops []StrokeOp) []models.StrokeChange {
+	after := make(map[int]*models.DrawingPoint, len(before))
+	for strokeID, stroke := range before {
+		after[strokeID] = stroke
+	}
+	for _, op := range ops {
+		if op.Op == "delete" {
+			after[*op.StrokeID] = nil
+		} else {
+			after[op.Stroke.ID] = op.Stroke
+		}
+	}
+
+	var changes []models.StrokeChange
+	for _, strokeID := range order {
+		if sameStroke(before[strokeID], after[strokeID]) {
+			continue
+		}
+		changes = append(changes, models.StrokeChange{StrokeID: strokeID, Before: before[strokeID], After: after[strokeID]})
+	}
+	return changes
+}
+
+// sameStroke compares strokes as they are stored, with offsets rounded to
+// models.OffsetPrecision. nil is a stroke that does not exist.
+func sameStroke(a, b *models.DrawingPoint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.ID == b.ID && a.Type == b.Type && a.Color == b.Color && a.Width == b.Width && a.Tool == b.Tool &&
+		bytes.Equal(models.EncodeOffsets(a.Offsets), models.EncodeOffsets(b.Offsets))
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Strokes are simplified when they are saved and stored with packed offsets
+// (see models.EncodeOffsets). A paper keeps them inline in drawing_data until
+// they outgrow the inline limit, well below MongoDB's 16 MB document limit;
+// after that they live in Paper_Strokes, in chunks that findPapers joins back
+// into drawing_data, so clients never see the difference.
+
+const (
+	defaultStrokeTolerance   = 0.5
+	defaultInlineStrokeLimit = 4 << 20
+	strokeChunkCount         = 256
+	strokeChunkBytes         = 1 << 20
+)
+
+type strokeConfig struct {
+	tolerance   float64
+	alwaysChunk bool
+	inlineLimit int
+}
+
+var (
+	strokeConfigOnce sync.Once
+	strokeSettings   strokeConfig
+)
+
+// getStrokeConfig reads the stroke settings from the environment the first
+// time they are needed:
+//
+//	STROKE_SIMPLIFY_TOLERANCE  maximum distance a dropped point may lie from
+//	                           the simplified stroke, 0 keeps every point
+//	STROKE_STORAGE             "chunked" stores every paper's strokes in chunks
+//	STROKE_INLINE_LIMIT_KB     size above which a paper's strokes are chunked
+func getStrokeConfig() strokeConfig {
+	strokeConfigOnce.Do(func() {
+		strokeSettings = strokeConfig{
+			tolerance:   defaultStrokeTolerance,
+			alwaysChunk: os.Getenv("STROKE_STORAGE") == models.StrokesChunked,
+			inlineLimit: defaultInlineStrokeLimit,
+		}
+		if value := os.Getenv("STROKE_SIMPLIFY_TOLERANCE"); value != "" {
+			tolerance, err := strconv.ParseFloat(value, 64)
+			if err != nil || tolerance < 0 {
+				log.Printf("Ignoring invalid STROKE_SIMPLIFY_TOLERANCE %q", value)
+			} else {
+				strokeSettings.tolerance = tolerance
+			}
+		}
+		if value := os.Getenv("STROKE_INLINE_LIMIT_KB"); value != "" {
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit <= 0 {
+				log.Printf("Ignoring invalid STROKE_INLINE_LIMIT_KB %q", value)
+			} else {
+				strokeSettings.inlineLimit = limit << 10
+			}
+		}
+	})
+	return strokeSettings
+}
+
+// simplifyStroke drops the points of a stroke the configured tolerance allows
+func simplifyStroke(stroke *models.DrawingPoint) {
+	stroke.Offsets = utils.SimplifyOffsets(stroke.Offsets, getStrokeConfig().tolerance)
+}
+
+func strokesSize(strokes []models.DrawingPoint) int {
+	size := 0
+	for _, stroke := range strokes {
+		size += stroke.PackedSize()
+	}
+	return size
+}
+
+// needsChunks reports whether strokes are too large to store inline
+func needsChunks(strokes []models.DrawingPoint) bool {
+	settings := getStrokeConfig()
+	return settings.alwaysChunk || strokesSize(strokes) > settings.inlineLimit
+}
+
+// findPapers returns the papers matching filter with their strokes, wherever
+// they are stored
+func findPapers(ctx context.Context, filter interface{}, opts ...*options.FindOptions) ([]models.Paper, error) {
+	cursor, err := config.GetPaperCollection().Find(ctx, filter, opts...)
+	if err != nil {
+		return nil, err
+	}
+	var papers []models.Paper
+	if err := cursor.All(ctx, &papers); err != nil {
+		return nil, err
+	}
+	return papers, hydrateStrokes(ctx, papers)
+}
+
+// hydrateStrokes fills in the strokes of chunked papers
+func hydrateStrokes(ctx context.Context, papers []models.Paper) error {
+	positions := make(map[string]int)
+	var paperIDs []string
+	for i := range papers {
+		if papers[i].StrokeStorage == models.StrokesChunked {
+			positions[papers[i].ID.Hex()] = i
+			paperIDs = append(paperIDs, papers[i].ID.Hex())
+			papers[i].DrawingData = nil
+		}
+	}
+	if len(paperIDs) == 0 {
+		return nil
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "paper_id", Value: 1}, {Key: "index", Value: 1}})
+	cursor, err := config.GetPaperStrokeCollection().Find(ctx, bson.M{"paper_id": bson.M{"$in": paperIDs}}, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query stroke chunks: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var chunk models.StrokeChunk
+		if err := cursor.Decode(&chunk); err != nil {
+			return fmt.Errorf("failed to decode stroke chunk: %v", err)
+		}
+		paper := &papers[positions[chunk.PaperID]]
+		paper.DrawingData = append(paper.DrawingData, chunk.Strokes...)
+	}
+	return cursor.Err()
+}
+
+// chunkStrokes splits strokes into chunks of at most strokeChunkCount
+// strokes and about strokeChunkBytes
+func chunkStrokes(paperID string, strokes []models.DrawingPoint) []interface{} {
+	var chunks []interface{}
+	current := models.StrokeChunk{PaperID: paperID}
+	for _, stroke := range strokes {
+		size := stroke.PackedSize()
+		if current.Count > 0 && (current.Count >= strokeChunkCount || current.Size+size > strokeChunkBytes) {
+			chunks = append(chunks, current)
+			current = models.StrokeChunk{PaperID: paperID, Index: current.Index + 1}
+		}
+		current.Strokes = append(current.Strokes, stroke)
+		current.Count++
+		current.Size += size
+	}
+	if current.Count > 0 {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// replaceStrokes makes strokes the only strokes of a paper and returns the
+// update that points the paper document at them. Run it in the transaction
+// that applies the update.
+func replaceStrokes(sessCtx mongo.SessionContext, paperID string, strokes []models.DrawingPoint) (bson.M, error) {
+	if err := deleteStrokeChunks(sessCtx, []string{paperID}); err != nil {
+		return nil, err
+	}
+	if !needsChunks(strokes) {
+		return bson.M{"$set": bson.M{"drawing_data": strokes}, "$unset": bson.M{"stroke_storage": ""}}, nil
+	}
+
+	if chunks := chunkStrokes(paperID, strokes); len(chunks) > 0 {
+		if _, err := config.GetPaperStrokeCollection().InsertMany(sessCtx, chunks); err != nil {
+			return nil, fmt.Errorf("failed to insert stroke chunks: %v", err)
+		}
+	}
+	return bson.M{"$set": bson.M{"drawing_data": bson.A{}, "stroke_storage": models.StrokesChunked}}, nil
+}
+
+// storeNewPaperStrokes moves the strokes of papers about to be inserted, like
+// copies and imports, into chunks where they are too large to store inline
+func storeNewPaperStrokes(ctx context.Context, papers []models.Paper) error {
+	for i := range papers {
+		papers[i].StrokeStorage = ""
+		if !needsChunks(papers[i].DrawingData) {
+			continue
+		}
+		if chunks := chunkStrokes(papers[i].ID.Hex(), papers[i].DrawingData); len(chunks) > 0 {
+			if _, err := config.GetPaperStrokeCollection().InsertMany(ctx, chunks); err != nil {
+				return fmt.Errorf("failed to insert stroke chunks: %v", err)
+			}
+		}
+		papers[i].DrawingData = []models.DrawingPoint{}
+		papers[i].StrokeStorage = models.StrokesChunked
+	}
+	return nil
+}
+
+// deleteStrokeChunks removes the chunked strokes of deleted papers
+func deleteStrokeChunks(ctx context.Context, paperIDs []string) error {
+	if len(paperIDs) == 0 {
+		return nil
+	}
+	if _, err := config.GetPaperStrokeCollection().DeleteMany(ctx, bson.M{"paper_id": bson.M{"$in": paperIDs}}); err != nil {
+		return fmt.Errorf("failed to delete stroke chunks: %v", err)
+	}
+	return nil
+}
+
+// chunkLargePaper moves the inline strokes of a paper into chunks when they
+// have outgrown the inline limit, so that single-stroke changes keep working
+// on pages with a lot of ink. It reports whether the paper is now chunked.
+func chunkLargePaper(sessCtx mongo.SessionContext, paperObjID primitive.ObjectID) (bool, error) {
+	paperCollection := config.GetPaperCollection()
+
+	if !getStrokeConfig().alwaysChunk {
+		pipeline := []bson.M{
+			{"$match": bson.M{"_id": paperObjID}},
+			{"$project": bson.M{"size": bson.M{"$bsonSize": "$$ROOT"}}},
+		}
+		cursor, err := paperCollection.Aggregate(sessCtx, pipeline)
+		if err != nil {
+			return false, fmt.Errorf("failed to measure paper: %v", err)
+		}
+		var sizes []struct {
+			Size int `bson:"size"`
+		}
+		if err := cursor.All(sessCtx, &sizes); err != nil {
+			return false, fmt.Errorf("failed to measure paper: %v", err)
+		}
+		if len(sizes) == 0 || sizes[0].Size <= getStrokeConfig().inlineLimit {
+			return false, nil
+		}
+	}
+
+	var paper models.Paper
+	findOptions := options.FindOne().SetProjection(bson.M{"drawing_data": 1})
+	if err := paperCollection.FindOne(sessCtx, bson.M{"_id": paperObjID}, findOptions).Decode(&paper); err != nil {
+		return false, fmt.Errorf("failed to load strokes: %v", err)
+	}
+
+	if chunks := chunkStrokes(paperObjID.Hex(), paper.DrawingData); len(chunks) > 0 {
+		if _, err := config.GetPaperStrokeCollection().InsertMany(sessCtx, chunks); err != nil {
+			return false, fmt.Errorf("failed to insert stroke chunks: %v", err)
+		}
+	}
+	update := bson.M{"$set": bson.M{"drawing_data": bson.A{}, "stroke_storage": models.StrokesChunked}}
+	if _, err := paperCollection.UpdateOne(sessCtx, bson.M{"_id": paperObjID}, update); err != nil {
+		return false, fmt.Errorf("failed to chunk strokes: %v", err)
+	}
+	return true, nil
+}
+
+// appendChunkedStroke adds a stroke after the last one of a chunked paper
+func appendChunkedStroke(sessCtx mongo.SessionContext, paperID string, stroke models.DrawingPoint) error {
+	chunkCollection := config.GetPaperStrokeCollection()
+	size := stroke.PackedSize()
+
+	var last models.StrokeChunk
+	findOptions := options.FindOne().
+		SetSort(bson.M{"index": -1}).
+		SetProjection(bson.M{"index": 1, "count": 1, "size": 1})
+	err := chunkCollection.FindOne(sessCtx, bson.M{"paper_id": paperID}, findOptions).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return fmt.Errorf("failed to find last stroke chunk: %v", err)
+	}
+
+	if err == nil && last.Count < strokeChunkCount && last.Size+size <= strokeChunkBytes {
+		update := bson.M{"$push": bson.M{"strokes": stroke}, "$inc": bson.M{"count": 1, "size": size}}
+		if _, err := chunkCollection.UpdateOne(sessCtx, bson.M{"_id": last.ID}, update); err != nil {
+			return fmt.Errorf("failed to append stroke: %v", err)
+		}
+		return nil
+	}
+
+	chunk := models.StrokeChunk{PaperID: paperID, Count: 1, Size: size, Strokes: []models.DrawingPoint{stroke}}
+	if err == nil {
+		chunk.Index = last.Index + 1
+	}
+	if _, err := chunkCollection.InsertOne(sessCtx, chunk); err != nil {
+		return fmt.Errorf("failed to insert stroke chunk: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+	"backend/render"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// svgExportOptions are the query parameters of the SVG exports:
+// annotations_only=true leaves out templates and background images, and
+// background=link links background images instead of embedding them.
+type svgExportOptions struct {
+	annotationsOnly bool
+	embed           bool
+}
+
+func parseSVGExportOptions(query url.Values) (svgExportOptions, error) {
+	opts := svgExportOptions{embed: true}
+	if value := query.Get("annotations_only"); value != "" {
+		annotationsOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, newRequestError(http.StatusBadRequest, "Invalid annotations_only")
+		}
+		opts.annotationsOnly = annotationsOnly
+	}
+	switch query.Get("background") {
+	case "", "embed":
+	case "link":
+		opts.embed = false
+	default:
+		return opts, newRequestError(http.StatusBadRequest, "Invalid background, expected embed or link")
+	}
+	return opts, nil
+}
+
+// svgOptions prepares the rendering of one paper, downloading its
+// background when it is embedded. A background that cannot be downloaded is
+// logged and linked instead.
+func (opts svgExportOptions) svgOptions(paper models.Paper) render.SVGOptions {
+	options := render.SVGOptions{AnnotationsOnly: opts.annotationsOnly}
+	if !opts.embed || opts.annotationsOnly || paper.BackgroundImage == "" {
+		return options
+	}
+	blob, err := DownloadByURL(paper.BackgroundImage)
+	if err != nil {
+		log.Printf("Failed to download background of paper %s: %v", paper.ID.Hex(), err)
+		return options
+	}
+	defer blob.Close()
+	if options.Background, err = io.ReadAll(blob); err != nil {
+		log.Printf("Failed to read background of paper %s: %v", paper.ID.Hex(), err)
+		options.Background = nil
+	}
+	return options
+}
+
+// svgFileName names the SVG of a page of a file by its 1-based position, as
+// pages are picked for exports
+func svgFileName(fileName string, position int) string {
+	return fmt.Sprintf("%s_page_%d.svg", strings.ReplaceAll(fileName, "/", "_"), position)
+}
+
+// pagePosition returns the 1-based position of a paper in its file's page order
+func pagePosition(ctx context.Context, paper models.Paper) (int, error) {
+	before, err := config.GetPaperCollection().CountDocuments(ctx, bson.M{"file_id": paper.FileID, "page_number": bson.M{"$lt": paper.PageNumber}})
+	if err != nil {
+		return 0, err
+	}
+	return int(before) + 1, nil
+}
+
+// ExportPaperSVG returns a paper as an SVG document
+func ExportPaperSVG(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	query := r.URL.Query()
+	paper, err := findHistoryPaper(ctx, query.Get("paper_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, ok := requirePermission(w, r, paper.RoomID, models.PermExport); !ok {
+		return
+	}
+	opts, err := parseSVGExportOptions(query)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	papers, err := findPapers(ctx, bson.M{"_id": paper.ID})
+	if err != nil || len(papers) == 0 {
+		http.Error(w, "Paper not found", http.StatusNotFound)
+		return
+	}
+	paper = papers[0]
+
+	fileName := "paper"
+	if file, err := findVersionedFile(ctx, paper.FileID); err == nil {
+		fileName = file.Name
+	}
+	position, err := pagePosition(ctx, paper)
+	if err != nil {
+		log.Printf("Error finding position of paper %s: %v", paper.ID.Hex(), err)
+		http.Error(w, "Failed to fetch papers", http.StatusInternalServerError)
+		return
+	}
+
+	var svg bytes.Buffer
+	if err := render.WriteSVG(&svg, paper, opts.svgOptions(paper)); err != nil {
+		log.Printf("Error rendering SVG of paper %s: %v", paper.ID.Hex(), err)
+		http.Error(w, "Failed to render paper", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", svgFileName(fileName, position)))
+	w.Write(svg.Bytes())
+}
+
+// ExportFileSVG returns the papers of a file as a zip of SVG documents, one
+// per page. pages picks pages by position as for PDF exports.
+func ExportFileSVG(w http.ResponseWriter, r *http.Request) {
+	ctx := context.Background()
+	query := r.URL.Query()
+	file, err := findVersionedFile(ctx, query.Get("file_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, ok := requirePermission(w, r, file.RoomID, models.PermExport); !ok {
+		return
+	}
+	opts, err := parseSVGExportOptions(query)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	papers, err := findFilePapers(ctx, file.ID.Hex())
+	if err != nil {
+		log.Printf("Error fetching papers of file %s: %v", file.ID.Hex(), err)
+		http.Error(w, "Failed to fetch papers", http.StatusInternalServerError)
+		return
+	}
+	positions := make(map[string]int, len(papers))
+	for i, paper := range papers {
+		positions[paper.ID.Hex()] = i + 1
+	}
+	papers, err = selectPages(papers, query.Get("pages"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if len(papers) == 0 {
+		http.Error(w, "File has no pages", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name+".zip"))
+
+	archive := zip.NewWriter(w)
+	defer archive.Close()
+	for _, paper := range papers {
+		entry, err := archive.Create(svgFileName(file.Name, positions[paper.ID.Hex()]))
+		if err == nil {
+			err = render.WriteSVG(entry, paper, opts.svgOptions(paper))
+		}
+		if err != nil {
+			log.Printf("Error writing SVG export of file %s: %v", file.ID.Hex(), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/socketio"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ItemRef identifies a folder, file or paper
+type ItemRef struct {
+	ItemType string `json:"item_type"`
+	ItemID   string `json:"item_id"`
+}
+
+// AddTag creates a tag in a room. Tag names are unique per room, ignoring case.
+func AddTag(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var tagRequest struct {
+		RoomID string `json:"room_id"`
+		Name   string `json:"name"`
+		Color  int    `json:"color"`
+	}
+	if err := json.Unmarshal(body, &tagRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, tagRequest.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	tagRequest.Name = strings.TrimSpace(tagRequest.Name)
+	if tagRequest.Name == "" {
+		http.Error(w, "Tag name is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if err := checkTagNameFree(ctx, tagRequest.RoomID, tagRequest.Name, primitive.NilObjectID); err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	tag := models.Tag{
+		ID:        primitive.NewObjectID(),
+		RoomID:    tagRequest.RoomID,
+		Name:      tagRequest.Name,
+		Color:     tagRequest.Color,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if _, err := config.GetTagCollection().InsertOne(ctx, tag); err != nil {
+		log.Printf("MongoDB insertion error: %v", err)
+		http.Error(w, "Failed to add tag", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastTagList(ctx, tag.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Tag added successfully",
+		"tag_id":  tag.ID.Hex(),
+	})
+}
+
+// GetTags lists the tags of a room
+func GetTags(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "Missing room_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	tags, err := findRoomTags(ctx, roomID)
+	if err != nil {
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// UpdateTag renames or recolors a tag
+func UpdateTag(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var tagRequest struct {
+		TagID string `json:"tag_id"`
+		Name  string `json:"name"`
+		Color *int   `json:"color"`
+	}
+	if err := json.Unmarshal(body, &tagRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	tag, err := findTag(ctx, tagRequest.TagID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, tag.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if name := strings.TrimSpace(tagRequest.Name); name != "" {
+		if err := checkTagNameFree(ctx, tag.RoomID, name, tag.ID); err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		set["name"] = name
+	}
+	if tagRequest.Color != nil {
+		set["color"] = *tagRequest.Color
+	}
+
+	if _, err := config.GetTagCollection().UpdateOne(ctx, bson.M{"_id": tag.ID}, bson.M{"$set": set}); err != nil {
+		http.Error(w, "Failed to update tag", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastTagList(ctx, tag.RoomID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Tag updated successfully",
+		"tag_id":  tag.ID.Hex(),
+	})
+}
+
+// DeleteTag removes a tag and detaches it from every folder and file
+func DeleteTag(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var tagRequest struct {
+		TagID string `json:"tag_id"`
+	}
+	if err := json.Unmarshal(body, &tagRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	tag, err := findTag(ctx, tagRequest.TagID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, tag.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	filter := bson.M{"room_id": tag.RoomID, "tags": tag.ID.Hex()}
+	update := bumpVersion(bson.M{"$pull": bson.M{"tags": tag.ID.Hex()}})
+	folderResult, err := config.GetFolderCollection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		http.Error(w, "Failed to detach tag from folders", http.StatusInternalServerError)
+		return
+	}
+	fileResult, err := config.GetFileCollection().UpdateMany(ctx, filter, update)
+	if err != nil {
+		http.Error(w, "Failed to detach tag from files", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := config.GetTagCollection().DeleteOne(ctx, bson.M{"_id": tag.ID}); err != nil {
+		http.Error(w, "Failed to delete tag", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastTagList(ctx, tag.RoomID)
+	if folderResult.ModifiedCount > 0 {
+		broadcastFolderList(ctx, tag.RoomID)
+	}
+	if fileResult.ModifiedCount > 0 {
+		broadcastFileList(ctx, tag.RoomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":         "Tag deleted successfully",
+		"tag_id":          tag.ID.Hex(),
+		"folders_updated": folderResult.ModifiedCount,
+		"files_updated":   fileResult.ModifiedCount,
+	})
+}
+
+// TagItems attaches tags to folders and files of a room (POST), or detaches
+// them (DELETE)
+func TagItems(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var tagRequest struct {
+		RoomID string    `json:"room_id"`
+		TagIDs []string  `json:"tag_ids"`
+		Items  []ItemRef `json:"items"`
+	}
+	if err := json.Unmarshal(body, &tagRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := requirePermission(w, r, tagRequest.RoomID, models.PermEdit); !ok {
+		return
+	}
+
+	ctx := context.Background()
+	attach := r.Method != http.MethodDelete
+	folders, files, err := applyTags(ctx, tagRequest.RoomID, tagRequest.TagIDs, tagRequest.Items, attach)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if folders > 0 {
+		broadcastFolderList(ctx, tagRequest.RoomID)
+	}
+	if files > 0 {
+		broadcastFileList(ctx, tagRequest.RoomID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":         "Tags updated successfully",
+		"folders_updated": folders,
+		"files_updated":   files,
+	})
+}
+
+// applyTags adds or removes tags on folders and files of a room and returns
+// how many folders and files changed. It does not broadcast.
+func applyTags(ctx context.Context, roomID string, tagIDs []string, items []ItemRef, attach bool) (int64, int64, error) {
+	if len(tagIDs) == 0 || len(items) == 0 {
+		return 0, 0, newRequestError(http.StatusBadRequest, "tag_ids and items are required")
+	}
+	tagIDs = uniqueStrings(tagIDs)
+
+	count, err := config.GetTagCollection().CountDocuments(ctx, bson.M{"_id": bson.M{"$in": toObjectIDs(tagIDs)}, "room_id": roomID})
+	if err != nil {
+		return 0, 0, newRequestError(http.StatusInternalServerError, "Failed to check tags")
+	}
+	if int(count) != len(tagIDs) {
+		return 0, 0, newRequestError(http.StatusBadRequest, "Unknown tag for this room")
+	}
+
+	var folderIDs, fileIDs []string
+	for _, item := range items {
+		switch item.ItemType {
+		case models.StarFolder:
+			folderIDs = append(folderIDs, item.ItemID)
+		case models.StarFile:
+			fileIDs = append(fileIDs, item.ItemID)
+		default:
+			return 0, 0, newRequestError(http.StatusBadRequest, fmt.Sprintf("Cannot tag item type: %s", item.ItemType))
+		}
+	}
+
+	// Only items that actually change get a new version
+	update := bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tagIDs}}}
+	changes := bson.M{"$not": bson.M{"$all": tagIDs}}
+	if !attach {
+		update = bson.M{"$pull": bson.M{"tags": bson.M{"$in": tagIDs}}}
+		changes = bson.M{"$in": tagIDs}
+	}
+	bumpVersion(update)
+
+	apply := func(collection *mongo.Collection, ids []string) (int64, error) {
+		if len(ids) == 0 {
+			return 0, nil
+		}
+		filter := bson.M{"_id": bson.M{"$in": toObjectIDs(ids)}, "room_id": roomID, "tags": changes}
+		result, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return 0, err
+		}
+		return result.ModifiedCount, nil
+	}
+
+	folders, err := apply(config.GetFolderCollection(), folderIDs)
+	if err != nil {
+		return 0, 0, newRequestError(http.StatusInternalServerError, "Failed to update folder tags")
+	}
+	files, err := apply(config.GetFileCollection(), fileIDs)
+	if err != nil {
+		return folders, 0, newRequestError(http.StatusInternalServerError, "Failed to update file tags")
+	}
+	return folders, files, nil
+}
+
+// tagFilter adds the tag query parameters of a list request to filter. tags is
+// a comma-separated list of tag IDs; match is "all" (default) or "any".
+func tagFilter(r *http.Request, filter bson.M) error {
+	tagsParam := r.URL.Query().Get("tags")
+	if tagsParam == "" {
+		return nil
+	}
+
+	var tagIDs []string
+	for _, tagID := range strings.Split(tagsParam, ",") {
+		if tagID = strings.TrimSpace(tagID); tagID != "" {
+			tagIDs = append(tagIDs, tagID)
+		}
+	}
+	if len(tagIDs) == 0 {
+		return nil
+	}
+
+	switch r.URL.Query().Get("match") {
+	case "", "all":
+		filter["tags"] = bson.M{"$all": tagIDs}
+	case "any":
+		filter["tags"] = bson.M{"$in": tagIDs}
+	default:
+		return fmt.Errorf("match must be 'all' or 'any'")
+	}
+	return nil
+}
+
+func findTag(ctx context.Context, tagID string) (models.Tag, error) {
+	var tag models.Tag
+
+	objID, err := primitive.ObjectIDFromHex(tagID)
+	if err != nil {
+		return tag, newRequestError(http.StatusBadRequest, "Invalid tag ID format")
+	}
+	if err := config.GetTagCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&tag); err != nil {
+		return tag, newRequestError(http.StatusNotFound, "Tag not found")
+	}
+	return tag, nil
+}
+
+func findRoomTags(ctx context.Context, roomID string) ([]models.Tag, error) {
+	cursor, err := config.GetTagCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []models.Tag{}
+	if err := cursor.All(ctx, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// checkTagNameFree returns a conflict error if another tag of the room has the name
+func checkTagNameFree(ctx context.Context, roomID, name string, exceptID primitive.ObjectID) error {
+	filter := bson.M{
+		"room_id": roomID,
+		"name":    primitive.Regex{Pattern: "^" + regexp.QuoteMeta(name) + "$", Options: "i"},
+		"_id":     bson.M{"$ne": exceptID},
+	}
+	count, err := config.GetTagCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		return newRequestError(http.StatusInternalServerError, "Failed to check tag names")
+	}
+	if count > 0 {
+		return newRequestError(http.StatusConflict, "A tag with this name already exists")
+	}
+	return nil
+}
+
+// broadcastTagList sends the tags of a room to everyone connected to it
+func broadcastTagList(ctx context.Context, roomID string) {
+	socketServer := socketio.ServerInstance
+	if socketServer == nil {
+		return
+	}
+
+	tags, err := findRoomTags(ctx, roomID)
+	if err != nil {
+		log.Printf("Error fetching tags of room %s: %v", roomID, err)
+		return
+	}
+
+	socketServer.BroadcastToRoom("", roomID, "tag_list_updated", map[string]interface{}{
+		"roomID": roomID,
+		"tags":   tags,
+	})
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TreeNode is a folder or file in a room tree. ChildCount is the number of
+// direct sub-folders and files of a folder, or the number of papers of a file.
+// It is filled even when Children is cut off by the depth limit.
+type TreeNode struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Name        string      `json:"name"`
+	Color       int         `json:"color,omitempty"`
+	Position    string      `json:"position,omitempty"`
+	SubFolderID string      `json:"sub_folder_id"`
+	ChildCount  int         `json:"child_count"`
+	Children    []*TreeNode `json:"children,omitempty"`
+}
+
+// GetRoomTree returns the folders and files of a room as a nested tree. An
+// optional folder_id roots the tree at that folder and depth limits how many
+// levels are returned (0 means unlimited).
+func GetRoomTree(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		http.Error(w, "Missing room_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	depth := 0
+	if depthParam := r.URL.Query().Get("depth"); depthParam != "" {
+		depth, err = strconv.Atoi(depthParam)
+		if err != nil || depth < 0 {
+			http.Error(w, "Invalid depth parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rootID := r.URL.Query().Get("folder_id")
+	if isRootFolder(rootID) {
+		rootID = models.RootFolderID
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	tree, err := buildRoomTree(ctx, roomID, rootID, depth)
+	if err != nil {
+		log.Printf("Error building tree of room %s: %v", roomID, err)
+		http.Error(w, "Failed to build folder tree", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"room_id":   roomID,
+		"folder_id": rootID,
+		"children":  tree,
+	})
+}
+
+// buildRoomTree loads every folder and file of the room once and nests them
+// under rootID. Items whose parent folder no longer exists are listed at the
+// room root so they stay reachable.
+func buildRoomTree(ctx context.Context, roomID, rootID string, depth int) ([]*TreeNode, error) {
+	var folders []models.Folder
+	cursor, err := config.GetFolderCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %v", err)
+	}
+	if err := cursor.All(ctx, &folders); err != nil {
+		return nil, fmt.Errorf("failed to decode folders: %v", err)
+	}
+
+	var files []models.File
+	cursor, err = config.GetFileCollection().Find(ctx, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %v", err)
+	}
+	if err := cursor.All(ctx, &files); err != nil {
+		return nil, fmt.Errorf("failed to decode files: %v", err)
+	}
+
+	pageCounts, err := countPapersByFile(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	folderExists := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		folderExists[folder.ID.Hex()] = true
+	}
+	parentOf := func(subFolderID string) string {
+		if isRootFolder(subFolderID) || !folderExists[subFolderID] {
+			return models.RootFolderID
+		}
+		return subFolderID
+	}
+
+	children := make(map[string][]*TreeNode)
+	for _, folder := range folders {
+		parent := parentOf(folder.SubFolderID)
+		children[parent] = append(children[parent], &TreeNode{
+			ID:          folder.ID.Hex(),
+			Type:        models.StarFolder,
+			Name:        folder.Name,
+			Color:       folder.Color,
+			Position:    folder.Position,
+			SubFolderID: folder.SubFolderID,
+		})
+	}
+	for _, file := range files {
+		parent := parentOf(file.SubFolderID)
+		children[parent] = append(children[parent], &TreeNode{
+			ID:          file.ID.Hex(),
+			Type:        models.StarFile,
+			Name:        file.Name,
+			Position:    file.Position,
+			SubFolderID: file.SubFolderID,
+			ChildCount:  pageCounts[file.ID.Hex()],
+		})
+	}
+
+	// Folders first, then files, each by manual position and then by name
+	for _, nodes := range children {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			if nodes[i].Type != nodes[j].Type {
+				return nodes[i].Type == models.StarFolder
+			}
+			if nodes[i].Position != nodes[j].Position {
+				return nodes[i].Position < nodes[j].Position
+			}
+			return nodes[i].Name < nodes[j].Name
+		})
+	}
+
+	visited := make(map[string]bool)
+	var attach func(parentID string, level int) []*TreeNode
+	attach = func(parentID string, level int) []*TreeNode {
+		nodes := children[parentID]
+		for _, node := range nodes {
+			if node.Type != models.StarFolder || visited[node.ID] {
+				continue
+			}
+			visited[node.ID] = true
+			node.ChildCount = len(children[node.ID])
+			if depth == 0 || level < depth {
+				node.Children = attach(node.ID, level+1)
+			}
+		}
+		return nodes
+	}
+
+	tree := attach(rootID, 1)
+	if tree == nil {
+		tree = []*TreeNode{}
+	}
+	return tree, nil
+}
+
+// countPapersByFile returns the number of papers of each file in the room
+func countPapersByFile(ctx context.Context, roomID string) (map[string]int, error) {
+	pipeline := []bson.M{
+		{"$match": bson.M{"room_id": roomID}},
+		{"$group": bson.M{"_id": "$file_id", "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := config.GetPaperCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count papers: %v", err)
+	}
+
+	var groups []struct {
+		FileID string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("failed to decode paper counts: %v", err)
+	}
+
+	counts := make(map[string]int, len(groups))
+	for _, group := range groups {
+		counts[group.FileID] = group.Count
+	}
+	return counts, nil
+}
+
+// GetItemPath returns the breadcrumb of a folder, file or paper, from the
+// room root down to the item itself
+func GetItemPath(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	itemType := r.URL.Query().Get("item_type")
+	itemID := r.URL.Query().Get("item_id")
+	if itemType == "" || itemID == "" {
+		http.Error(w, "Missing item_type or item_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	roomID, path, err := resolveItemPath(ctx, itemType, itemID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, roomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var room models.Room
+	if roomObjID, err := primitive.ObjectIDFromHex(roomID); err == nil {
+		config.GetRoomCollection().FindOne(ctx, bson.M{"_id": roomObjID}).Decode(&room)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"item_type": itemType,
+		"item_id":   itemID,
+		"room_id":   roomID,
+		"room_name": room.Name,
+		"path":      path,
+	})
+}
+
+// resolveItemPath returns the room of an item and its path including the item
+func resolveItemPath(ctx context.Context, itemType, itemID string) (string, []PathEntry, error) {
+	objID, err := primitive.ObjectIDFromHex(itemID)
+	if err != nil {
+		return "", nil, newRequestError(http.StatusBadRequest, "Invalid item ID format")
+	}
+
+	switch itemType {
+	case models.StarFolder:
+		var folder models.Folder
+		if err := config.GetFolderCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&folder); err != nil {
+			return "", nil, newRequestError(http.StatusNotFound, "Folder not found")
+		}
+		return folder.RoomID, resolveFolderPath(ctx, itemID), nil
+
+	case models.StarFile:
+		var file models.File
+		if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+			return "", nil, newRequestError(http.StatusNotFound, "File not found")
+		}
+		path := append(resolveFolderPath(ctx, file.SubFolderID), PathEntry{ID: itemID, Type: models.StarFile, Name: file.Name})
+		return file.RoomID, path, nil
+
+	case models.StarPaper:
+		var paper models.Paper
+		if err := config.GetPaperCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&paper); err != nil {
+			return "", nil, newRequestError(http.StatusNotFound, "Paper not found")
+		}
+		_, path, err := resolveItemPath(ctx, models.StarFile, paper.FileID)
+		if err != nil {
+			return "", nil, err
+		}
+		path = append(path, PathEntry{ID: itemID, Type: models.StarPaper, Name: fmt.Sprintf("Page %d", paper.PageNumber)})
+		return paper.RoomID, path, nil
+	}
+
+	return "", nil, newRequestError(http.StatusBadRequest, "item_type must be 'folder', 'file' or 'paper'")
+}
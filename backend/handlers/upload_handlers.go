@@ -14,6 +14,7 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/joho/godotenv"
 )
 
@@ -25,15 +26,27 @@ var (
 	containerName string
 )
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
+// LoadStorageConfig reads the Azure Storage settings from the .env file and
+// the environment. The server calls it on startup and stops when the file or
+// a setting is missing; it is not an init function so the package can load
+// in tests without them.
+func LoadStorageConfig() {
+	if err := godotenv.Load(); err != nil {
 		log.Fatal("Error loading .env file")
 	}
 
 	accountName = os.Getenv("AZURE_STORAGE_ACCOUNT")
 	accountKey = os.Getenv("AZURE_STORAGE_KEY")
 	containerName = os.Getenv("AZURE_STORAGE_CONTAINER")
+	for name, value := range map[string]string{
+		"AZURE_STORAGE_ACCOUNT":   accountName,
+		"AZURE_STORAGE_KEY":       accountKey,
+		"AZURE_STORAGE_CONTAINER": containerName,
+	} {
+		if value == "" {
+			log.Fatalf("%s environment variable is not set", name)
+		}
+	}
 }
 
 func UploadHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,20 +82,29 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func UploadToAzureBlob(file io.Reader, filename string) (string, error) {
+// newContainerClient builds a client for the configured blob container
+func newContainerClient() (*container.Client, error) {
 	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	serviceClient, err := azblob.NewClientWithSharedKeyCredential(
 		fmt.Sprintf("https://%s.blob.core.windows.net/", accountName),
 		cred, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return serviceClient.ServiceClient().NewContainerClient(containerName), nil
+}
+
+func UploadToAzureBlob(file io.Reader, filename string) (string, error) {
+	containerClient, err := newContainerClient()
 	if err != nil {
 		return "", err
 	}
 
-	containerClient := serviceClient.ServiceClient().NewContainerClient(containerName)
 	blobClient := containerClient.NewBlockBlobClient(filename)
 	_, err = blobClient.UploadStream(context.Background(), file, &blockblob.UploadStreamOptions{
 		BlockSize:   4 * 1024 * 1024,
@@ -100,38 +122,58 @@ func UploadToAzureBlob(file io.Reader, filename string) (string, error) {
 	return blobURL, nil
 }
 
-func DeleteByURL(blobURL string) error {
+// blobNameFromURL validates that a URL points into our container and returns the blob name
+func blobNameFromURL(blobURL string) (string, error) {
 	// Validate the URL is from your Azure Blob Storage
 	if !strings.Contains(blobURL, fmt.Sprintf("%s.blob.core.windows.net/%s", accountName, containerName)) {
-		return fmt.Errorf("invalid URL: not part of Azure Blob container")
+		return "", fmt.Errorf("invalid URL: not part of Azure Blob container")
 	}
 
 	// Extract the blob name from the URL
 	parsedURL, err := url.Parse(blobURL)
 	if err != nil {
-		return fmt.Errorf("invalid URL format: %w", err)
+		return "", fmt.Errorf("invalid URL format: %w", err)
+	}
+	return path.Base(parsedURL.Path), nil
+}
+
+func DeleteByURL(blobURL string) error {
+	blobName, err := blobNameFromURL(blobURL)
+	if err != nil {
+		return err
 	}
-	blobName := path.Base(parsedURL.Path)
 
 	// Delete the blob
 	return DeleteFromAzureBlob(blobName)
 }
 
-// DeleteFromAzureBlob deletes a blob from Azure Blob Storage
-func DeleteFromAzureBlob(blobName string) error {
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+// DownloadByURL opens a blob from our container for reading. The caller must close it.
+func DownloadByURL(blobURL string) (io.ReadCloser, error) {
+	blobName, err := blobNameFromURL(blobURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	serviceClient, err := azblob.NewClientWithSharedKeyCredential(
-		fmt.Sprintf("https://%s.blob.core.windows.net/", accountName),
-		cred, nil)
+	containerClient, err := newContainerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := containerClient.NewBlobClient(blobName).DownloadStream(context.Background(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// DeleteFromAzureBlob deletes a blob from Azure Blob Storage
+func DeleteFromAzureBlob(blobName string) error {
+	containerClient, err := newContainerClient()
 	if err != nil {
 		return err
 	}
 
-	containerClient := serviceClient.ServiceClient().NewContainerClient(containerName)
 	blobClient := containerClient.NewBlobClient(blobName)
 
 	_, err = blobClient.Delete(context.Background(), nil)
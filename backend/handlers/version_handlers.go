@@ -0,0 +1,642 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/config"
+	"backend/models"
+	"backend/socketio"
+	"backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// A file version copies every paper of the file into Paper_Snapshots, with
+// chunked strokes where needed. Besides the versions users name, a version is
+// taken automatically before a paper is edited when the file has no version
+// younger than the snapshot interval, and before every restore.
+
+const (
+	defaultSnapshotInterval = 30 * time.Minute
+	defaultAutoVersionsKept = 50
+	maxVersionNameLength    = 100
+)
+
+type snapshotConfig struct {
+	interval time.Duration
+	keep     int
+}
+
+var (
+	snapshotConfigOnce sync.Once
+	snapshotSettings   snapshotConfig
+)
+
+// getSnapshotConfig reads the automatic snapshot settings from the
+// environment the first time they are needed:
+//
+//	FILE_SNAPSHOT_INTERVAL_MINUTES  minimum time between automatic versions,
+//	                                0 turns them off
+//	FILE_SNAPSHOT_KEEP              automatic versions kept per file
+func getSnapshotConfig() snapshotConfig {
+	snapshotConfigOnce.Do(func() {
+		snapshotSettings = snapshotConfig{interval: defaultSnapshotInterval, keep: defaultAutoVersionsKept}
+		if value := os.Getenv("FILE_SNAPSHOT_INTERVAL_MINUTES"); value != "" {
+			minutes, err := strconv.Atoi(value)
+			if err != nil || minutes < 0 {
+				log.Printf("Ignoring invalid FILE_SNAPSHOT_INTERVAL_MINUTES %q", value)
+			} else {
+				snapshotSettings.interval = time.Duration(minutes) * time.Minute
+			}
+		}
+		if value := os.Getenv("FILE_SNAPSHOT_KEEP"); value != "" {
+			keep, err := strconv.Atoi(value)
+			if err != nil || keep < 1 {
+				log.Printf("Ignoring invalid FILE_SNAPSHOT_KEEP %q", value)
+			} else {
+				snapshotSettings.keep = keep
+			}
+		}
+	})
+	return snapshotSettings
+}
+
+// CreateFileVersion saves the current papers of a file as a named version
+func CreateFileVersion(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var versionRequest struct {
+		FileID string `json:"file_id"`
+		Name   string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &versionRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	versionRequest.Name = strings.TrimSpace(versionRequest.Name)
+	if versionRequest.Name == "" {
+		http.Error(w, "Version name is required", http.StatusBadRequest)
+		return
+	}
+	if len(versionRequest.Name) > maxVersionNameLength {
+		http.Error(w, fmt.Sprintf("Version names can be at most %d characters", maxVersionNameLength), http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	file, err := findVersionedFile(ctx, versionRequest.FileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, file.RoomID, models.PermEdit)
+	if !ok {
+		return
+	}
+
+	var version models.FileVersion
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		var err error
+		version, err = createVersion(sessCtx, versionRequest.FileID, versionRequest.Name, models.VersionNamed, userID, "")
+		return err
+	})
+	if err != nil {
+		log.Printf("Error creating version of file %s: %v", versionRequest.FileID, err)
+		http.Error(w, "Failed to create version", http.StatusInternalServerError)
+		return
+	}
+
+	broadcastVersionsUpdated(file.RoomID, versionRequest.FileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Version created successfully",
+		"version": version,
+	})
+}
+
+// GetFileVersions lists the versions of a file, newest first
+func GetFileVersions(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	fileID := r.URL.Query().Get("file_id")
+	if fileID == "" {
+		http.Error(w, "Missing file_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	file, err := findVersionedFile(ctx, fileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, file.RoomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	versions := []models.FileVersion{}
+	findOptions := options.Find().SetSort(bson.M{"created_at": -1})
+	cursor, err := config.GetFileVersionCollection().Find(ctx, bson.M{"file_id": fileID}, findOptions)
+	if err != nil || cursor.All(ctx, &versions) != nil {
+		http.Error(w, "Failed to fetch versions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// GetFileVersion returns a version with its papers, for a read-only preview
+func GetFileVersion(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.GetUserIDFromToken(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.Background()
+	version, err := findVersion(ctx, r.URL.Query().Get("version_id"))
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	file, err := findVersionedFile(ctx, version.FileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if _, err := utils.GetUserRoleInRoom(ctx, userID, file.RoomID); err != nil {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	papers, err := findPaperSnapshots(ctx, bson.M{"version_id": version.ID.Hex()})
+	if err != nil {
+		log.Printf("Error fetching papers of version %s: %v", version.ID.Hex(), err)
+		http.Error(w, "Failed to fetch version papers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"version": version,
+		"papers":  papers,
+	})
+}
+
+// RestoreFileVersion puts the papers of a file, or only the paper named by
+// paper_id, back the way they were in a version. The current state is saved
+// as a new version first, so a restore can itself be undone.
+func RestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	var restoreRequest struct {
+		VersionID string `json:"version_id"`
+		PaperID   string `json:"paper_id"`
+	}
+	if err := json.Unmarshal(body, &restoreRequest); err != nil {
+		http.Error(w, "Invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	ctx := context.Background()
+	version, err := findVersion(ctx, restoreRequest.VersionID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	file, err := findVersionedFile(ctx, version.FileID)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+
+	userID, ok := requirePermission(w, r, file.RoomID, models.PermEdit)
+	if !ok {
+		return
+	}
+
+	snapshotFilter := bson.M{"version_id": version.ID.Hex()}
+	if restoreRequest.PaperID != "" {
+		snapshotFilter["paper_id"] = restoreRequest.PaperID
+	}
+
+	var saved models.FileVersion
+	var removedBackgrounds []string
+	var restored int
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		snapshots, err := findPaperSnapshots(sessCtx, snapshotFilter)
+		if err != nil {
+			return err
+		}
+		if restoreRequest.PaperID != "" && len(snapshots) == 0 {
+			return newRequestError(http.StatusNotFound, "Paper is not part of this version")
+		}
+
+		name := fmt.Sprintf("Before restoring %q", version.Name)
+		if saved, err = createVersion(sessCtx, version.FileID, name, models.VersionRestore, userID, version.ID.Hex()); err != nil {
+			return err
+		}
+
+		removedBackgrounds, err = restoreSnapshots(sessCtx, file, snapshots, restoreRequest.PaperID == "")
+		restored = len(snapshots)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error restoring version %s: %v", restoreRequest.VersionID, err)
+		writeRequestError(w, versionedWriteError(err, "Failed to restore version"))
+		return
+	}
+
+	for _, blobURL := range removedBackgrounds {
+		deleteUnreferencedBlob(ctx, blobURL)
+	}
+	touchFile(ctx, file.RoomID, version.FileID, userID)
+	refreshFileMetadata(ctx, file.RoomID, version.FileID)
+
+	if socketServer := socketio.ServerInstance; socketServer != nil {
+		papers, _ := findPapers(ctx, bson.M{"room_id": file.RoomID})
+		socketServer.BroadcastToRoom("", file.RoomID, "paper_list_updated", map[string]interface{}{
+			"roomID": file.RoomID,
+			"papers": papers,
+		})
+	}
+	broadcastVersionsUpdated(file.RoomID, version.FileID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Version restored successfully",
+		"file_id":  version.FileID,
+		"restored": restored,
+		"version":  saved,
+	})
+}
+
+func findVersionedFile(ctx context.Context, fileID string) (models.File, error) {
+	var file models.File
+	objID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return file, newRequestError(http.StatusBadRequest, "Invalid File ID format")
+	}
+	if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+		return file, newRequestError(http.StatusNotFound, "File not found")
+	}
+	return file, nil
+}
+
+func findVersion(ctx context.Context, versionID string) (models.FileVersion, error) {
+	var version models.FileVersion
+	objID, err := primitive.ObjectIDFromHex(versionID)
+	if err != nil {
+		return version, newRequestError(http.StatusBadRequest, "Invalid version ID format")
+	}
+	if err := config.GetFileVersionCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&version); err != nil {
+		return version, newRequestError(http.StatusNotFound, "Version not found")
+	}
+	return version, nil
+}
+
+// findPaperSnapshots returns snapshots in page order, with their strokes
+func findPaperSnapshots(ctx context.Context, filter bson.M) ([]models.PaperSnapshot, error) {
+	snapshots := []models.PaperSnapshot{}
+	findOptions := options.Find().SetSort(bson.M{"page_number": 1})
+	cursor, err := config.GetPaperSnapshotCollection().Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query paper snapshots: %v", err)
+	}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to decode paper snapshots: %v", err)
+	}
+
+	papers := make([]models.Paper, len(snapshots))
+	for i := range snapshots {
+		papers[i] = snapshots[i].Paper
+	}
+	if err := hydrateStrokes(ctx, papers); err != nil {
+		return nil, err
+	}
+	for i := range snapshots {
+		snapshots[i].Paper = papers[i]
+	}
+	return snapshots, nil
+}
+
+// createVersion copies the current papers of a file into a new version
+func createVersion(sessCtx mongo.SessionContext, fileID, name, kind, userID, restoredFrom string) (models.FileVersion, error) {
+	papers, err := findPapers(sessCtx, bson.M{"file_id": fileID}, options.Find().SetSort(bson.M{"page_number": 1}))
+	if err != nil {
+		return models.FileVersion{}, fmt.Errorf("failed to query papers: %v", err)
+	}
+
+	version := models.FileVersion{
+		ID:           primitive.NewObjectID(),
+		FileID:       fileID,
+		Name:         name,
+		Kind:         kind,
+		PageCount:    len(papers),
+		RestoredFrom: restoredFrom,
+		CreatedBy:    userID,
+		CreatedAt:    time.Now(),
+	}
+
+	// Snapshots get IDs of their own, which also key their stroke chunks
+	copies := make([]models.Paper, len(papers))
+	for i, paper := range papers {
+		copies[i] = paper
+		copies[i].ID = primitive.NewObjectID()
+	}
+	if err := storeNewPaperStrokes(sessCtx, copies); err != nil {
+		return version, err
+	}
+
+	if _, err := config.GetFileVersionCollection().InsertOne(sessCtx, version); err != nil {
+		return version, fmt.Errorf("failed to insert version: %v", err)
+	}
+	if len(copies) == 0 {
+		return version, nil
+	}
+
+	snapshots := make([]interface{}, 0, len(copies))
+	for i, paper := range copies {
+		snapshots = append(snapshots, models.PaperSnapshot{
+			VersionID: version.ID.Hex(),
+			PaperID:   papers[i].ID.Hex(),
+			Paper:     paper,
+		})
+	}
+	if _, err := config.GetPaperSnapshotCollection().InsertMany(sessCtx, snapshots); err != nil {
+		return version, fmt.Errorf("failed to insert paper snapshots: %v", err)
+	}
+	return version, nil
+}
+
+// restoreSnapshots writes snapshots back over the papers they were taken
+// from, re-creating papers deleted since. A whole-file restore also puts the
+// pages back in their old order and deletes papers added since; it returns
+// their background images, to clean up once the transaction has committed.
+func restoreSnapshots(sessCtx mongo.SessionContext, file models.File, snapshots []models.PaperSnapshot, wholeFile bool) ([]string, error) {
+	paperCollection := config.GetPaperCollection()
+	fileID := file.ID.Hex()
+
+	var current []models.Paper
+	findOptions := options.Find().SetProjection(bson.M{"page_number": 1, "background_image": 1})
+	cursor, err := paperCollection.Find(sessCtx, bson.M{"file_id": fileID}, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query papers: %v", err)
+	}
+	if err := cursor.All(sessCtx, &current); err != nil {
+		return nil, fmt.Errorf("failed to decode papers: %v", err)
+	}
+	existing := make(map[string]bool, len(current))
+	for _, paper := range current {
+		existing[paper.ID.Hex()] = true
+	}
+
+	now := time.Now()
+	restored := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		paperObjID, err := primitive.ObjectIDFromHex(snapshot.PaperID)
+		if err != nil {
+			continue
+		}
+		restored[snapshot.PaperID] = true
+		paper := snapshot.Paper
+
+		if existing[snapshot.PaperID] {
+			update, err := replaceStrokes(sessCtx, snapshot.PaperID, paper.DrawingData)
+			if err != nil {
+				return nil, err
+			}
+			set := update["$set"].(bson.M)
+			set["template_id"] = paper.TemplateID
+			set["width"] = paper.Width
+			set["height"] = paper.Height
+			set["background_image"] = paper.BackgroundImage
+			set["text_data"] = paper.TextData
+			set["updated_at"] = now
+			if wholeFile {
+				set["page_number"] = paper.PageNumber
+			}
+			if _, err := paperCollection.UpdateOne(sessCtx, bson.M{"_id": paperObjID}, bumpVersion(update)); err != nil {
+				return nil, fmt.Errorf("failed to restore paper %s: %v", snapshot.PaperID, err)
+			}
+			continue
+		}
+
+		if !wholeFile {
+			// Make room for the page where it used to be
+			filter := bson.M{"file_id": fileID, "page_number": bson.M{"$gte": paper.PageNumber}}
+			if _, err := paperCollection.UpdateMany(sessCtx, filter, bumpVersion(bson.M{"$inc": bson.M{"page_number": 1}})); err != nil {
+				return nil, fmt.Errorf("failed to update page numbers: %v", err)
+			}
+		}
+
+		paper.ID = paperObjID
+		paper.FileID = fileID
+		paper.RoomID = file.RoomID
+		paper.Version = 0
+		paper.UpdatedAt = now
+		papers := []models.Paper{paper}
+		if err := storeNewPaperStrokes(sessCtx, papers); err != nil {
+			return nil, err
+		}
+		if _, err := paperCollection.InsertOne(sessCtx, papers[0]); err != nil {
+			return nil, fmt.Errorf("failed to re-create paper %s: %v", snapshot.PaperID, err)
+		}
+	}
+
+	if !wholeFile {
+		return nil, nil
+	}
+
+	// Papers added after the version was taken
+	var removedIDs, backgrounds []string
+	for _, paper := range current {
+		if restored[paper.ID.Hex()] {
+			continue
+		}
+		removedIDs = append(removedIDs, paper.ID.Hex())
+		if paper.BackgroundImage != "" {
+			backgrounds = append(backgrounds, paper.BackgroundImage)
+		}
+	}
+	if len(removedIDs) == 0 {
+		return nil, nil
+	}
+	if _, err := paperCollection.DeleteMany(sessCtx, bson.M{"_id": bson.M{"$in": toObjectIDs(removedIDs)}}); err != nil {
+		return nil, fmt.Errorf("failed to delete papers: %v", err)
+	}
+	if err := deleteStrokeChunks(sessCtx, removedIDs); err != nil {
+		return nil, err
+	}
+	if err := deletePaperHistory(sessCtx, removedIDs); err != nil {
+		return nil, err
+	}
+	if err := deletePaperThumbnails(sessCtx, removedIDs); err != nil {
+		return nil, err
+	}
+	deleteStars(sessCtx, models.StarPaper, removedIDs...)
+	return backgrounds, nil
+}
+
+// autoSnapshot saves the papers of a file before they are edited, unless the
+// file already has a version younger than the snapshot interval. A failed
+// snapshot is logged and does not stop the edit.
+func autoSnapshot(ctx context.Context, fileID, userID string) {
+	settings := getSnapshotConfig()
+	if settings.interval <= 0 || fileID == "" {
+		return
+	}
+
+	filter := bson.M{"file_id": fileID, "created_at": bson.M{"$gt": time.Now().Add(-settings.interval)}}
+	recent, err := config.GetFileVersionCollection().CountDocuments(ctx, filter)
+	if err != nil {
+		log.Printf("Failed to check versions of file %s: %v", fileID, err)
+		return
+	}
+	if recent > 0 {
+		return
+	}
+
+	err = config.RunInTransaction(ctx, func(sessCtx mongo.SessionContext) error {
+		_, err := createVersion(sessCtx, fileID, "Automatic snapshot", models.VersionAuto, userID, "")
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to snapshot file %s: %v", fileID, err)
+		return
+	}
+
+	pruneAutoVersions(ctx, fileID, settings.keep)
+}
+
+// pruneAutoVersions deletes all but the newest keep automatic versions of a file
+func pruneAutoVersions(ctx context.Context, fileID string, keep int) {
+	findOptions := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip(int64(keep)).
+		SetProjection(bson.M{"_id": 1})
+	cursor, err := config.GetFileVersionCollection().Find(ctx, bson.M{"file_id": fileID, "kind": models.VersionAuto}, findOptions)
+	if err != nil {
+		log.Printf("Failed to query old versions of file %s: %v", fileID, err)
+		return
+	}
+	var old []models.FileVersion
+	if err := cursor.All(ctx, &old); err != nil || len(old) == 0 {
+		return
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(old))
+	for _, version := range old {
+		ids = append(ids, version.ID)
+	}
+	backgrounds, err := deleteVersions(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Printf("Failed to prune versions of file %s: %v", fileID, err)
+		return
+	}
+	for _, blobURL := range backgrounds {
+		deleteUnreferencedBlob(ctx, blobURL)
+	}
+}
+
+// deleteVersions deletes the versions matching filter with their snapshots
+// and returns the background images the snapshots referred to
+func deleteVersions(ctx context.Context, filter bson.M) ([]string, error) {
+	versionCollection := config.GetFileVersionCollection()
+	snapshotCollection := config.GetPaperSnapshotCollection()
+
+	var versions []models.FileVersion
+	cursor, err := versionCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %v", err)
+	}
+	if err := cursor.All(ctx, &versions); err != nil {
+		return nil, fmt.Errorf("failed to decode versions: %v", err)
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+
+	versionIDs := make([]string, 0, len(versions))
+	objIDs := make([]primitive.ObjectID, 0, len(versions))
+	for _, version := range versions {
+		versionIDs = append(versionIDs, version.ID.Hex())
+		objIDs = append(objIDs, version.ID)
+	}
+	snapshotFilter := bson.M{"version_id": bson.M{"$in": versionIDs}}
+
+	values, err := snapshotCollection.Distinct(ctx, "background_image", snapshotFilter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot backgrounds: %v", err)
+	}
+	var backgrounds []string
+	for _, value := range values {
+		if blobURL, ok := value.(string); ok && blobURL != "" {
+			backgrounds = append(backgrounds, blobURL)
+		}
+	}
+
+	var chunked []models.PaperSnapshot
+	chunkedFilter := bson.M{"version_id": bson.M{"$in": versionIDs}, "stroke_storage": models.StrokesChunked}
+	cursor, err = snapshotCollection.Find(ctx, chunkedFilter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshots: %v", err)
+	}
+	if err := cursor.All(ctx, &chunked); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshots: %v", err)
+	}
+	chunkedIDs := make([]string, 0, len(chunked))
+	for _, snapshot := range chunked {
+		chunkedIDs = append(chunkedIDs, snapshot.ID.Hex())
+	}
+	if err := deleteStrokeChunks(ctx, chunkedIDs); err != nil {
+		return nil, err
+	}
+
+	if _, err := snapshotCollection.DeleteMany(ctx, snapshotFilter); err != nil {
+		return nil, fmt.Errorf("failed to delete paper snapshots: %v", err)
+	}
+	if _, err := versionCollection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objIDs}}); err != nil {
+		return nil, fmt.Errorf("failed to delete versions: %v", err)
+	}
+	return backgrounds, nil
+}
+
+func broadcastVersionsUpdated(roomID, fileID string) {
+	if socketServer := socketio.ServerInstance; socketServer != nil {
+		socketServer.BroadcastToRoom("", roomID, "file_versions_updated", map[string]interface{}{
+			"roomID":  roomID,
+			"file_id": fileID,
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Rooms, folders, files and papers carry a version that every change to them
+// increments. Clients send the version their change is based on, as If-Match
+// or expected_version, and get a versionConflict instead of overwriting a
+// newer change. Metadata derived from other documents, like a file's page
+// count or last editor, does not change the version.
+
+// versionConflict is returned when a change was based on an older version of
+// an item. Current is the item as it is now, so the client can merge.
+type versionConflict struct {
+	ItemType string
+	Version  int64
+	Current  interface{}
+}
+
+func (e *versionConflict) Error() string {
+	return fmt.Sprintf("%s was changed by someone else and is now at version %d", e.ItemType, e.Version)
+}
+
+// expectedVersion returns the version the client based its change on, taken
+// from the If-Match header, then bodyVersion, then the expected_version query
+// parameter. It returns nil when the client sent none or If-Match is "*", in
+// which case the change applies to whatever version is stored.
+func expectedVersion(r *http.Request, bodyVersion *int64) (*int64, error) {
+	if ifMatch := strings.TrimSpace(r.Header.Get("If-Match")); ifMatch != "" {
+		if ifMatch == "*" {
+			return nil, nil
+		}
+		tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+		version, err := strconv.ParseInt(tag, 10, 64)
+		if err != nil || version < 0 {
+			return nil, newRequestError(http.StatusBadRequest, "If-Match must be a version ETag")
+		}
+		return &version, nil
+	}
+
+	if bodyVersion != nil {
+		if *bodyVersion < 0 {
+			return nil, newRequestError(http.StatusBadRequest, "expected_version must not be negative")
+		}
+		return bodyVersion, nil
+	}
+
+	if param := r.URL.Query().Get("expected_version"); param != "" {
+		version, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || version < 0 {
+			return nil, newRequestError(http.StatusBadRequest, "expected_version must be a version number")
+		}
+		return &version, nil
+	}
+	return nil, nil
+}
+
+// bumpVersion adds the version increment to an update document
+func bumpVersion(update bson.M) bson.M {
+	inc, ok := update["$inc"].(bson.M)
+	if !ok {
+		inc = bson.M{}
+		update["$inc"] = inc
+	}
+	inc["version"] = 1
+	return update
+}
+
+// versionMatch matches a stored version. Documents written before versions
+// were tracked have none, which counts as version 0.
+func versionMatch(version int64) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// updateVersioned applies update to one document, bumps its version and
+// decodes the updated document into result. When expected is set and the
+// document has moved past it, nothing is written and a versionConflict is
+// returned with the current document decoded into result.
+func updateVersioned(ctx context.Context, collection *mongo.Collection, itemType string, objID primitive.ObjectID, update bson.M, expected *int64, result interface{}) (int64, error) {
+	filter := bson.M{"_id": objID}
+	if expected != nil {
+		filter["version"] = versionMatch(*expected)
+	}
+
+	updateOptions := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	raw, err := collection.FindOneAndUpdate(ctx, filter, bumpVersion(update), updateOptions).Raw()
+	if err == mongo.ErrNoDocuments {
+		return 0, currentVersionError(ctx, collection, itemType, objID, expected, result)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update %s: %v", itemType, err)
+	}
+
+	if err := bson.Unmarshal(raw, result); err != nil {
+		return 0, fmt.Errorf("failed to decode %s: %v", itemType, err)
+	}
+	return rawVersion(raw), nil
+}
+
+// checkVersion returns a versionConflict, with the current document decoded
+// into current, when the stored document is not at the expected version
+func checkVersion(ctx context.Context, collection *mongo.Collection, itemType string, objID primitive.ObjectID, expected *int64, current interface{}) error {
+	if expected == nil {
+		return nil
+	}
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": objID, "version": versionMatch(*expected)})
+	if err != nil {
+		return fmt.Errorf("failed to check %s version: %v", itemType, err)
+	}
+	if count == 0 {
+		return currentVersionError(ctx, collection, itemType, objID, expected, current)
+	}
+	return nil
+}
+
+// versionedCollection returns the collection holding an item type and a
+// value to decode one of its items into
+func versionedCollection(itemType string) (*mongo.Collection, interface{}) {
+	switch itemType {
+	case models.StarRoom:
+		return config.GetRoomCollection(), &models.Room{}
+	case models.StarFolder:
+		return config.GetFolderCollection(), &models.Folder{}
+	case models.StarFile:
+		return config.GetFileCollection(), &models.File{}
+	case models.StarPaper:
+		return config.GetPaperCollection(), &models.Paper{}
+	}
+	return nil, nil
+}
+
+// compareVersion returns a versionConflict when an item that was already
+// loaded is not at the expected version, for changes like deletes that do
+// not go through updateVersioned
+func compareVersion(itemType string, version int64, expected *int64, current interface{}) error {
+	if expected == nil || *expected == version {
+		return nil
+	}
+	return &versionConflict{ItemType: itemType, Version: version, Current: current}
+}
+
+// currentVersionError explains why a versioned write matched nothing: the
+// document is gone, or it is at another version than expected
+func currentVersionError(ctx context.Context, collection *mongo.Collection, itemType string, objID primitive.ObjectID, expected *int64, current interface{}) error {
+	raw, err := collection.FindOne(ctx, bson.M{"_id": objID}).Raw()
+	if err != nil {
+		return newRequestError(http.StatusNotFound, fmt.Sprintf("%s not found", itemType))
+	}
+	if expected == nil {
+		return fmt.Errorf("%s changed while it was being updated", itemType)
+	}
+	if err := bson.Unmarshal(raw, current); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", itemType, err)
+	}
+	// Chunked papers keep their strokes outside the document
+	if paper, ok := current.(*models.Paper); ok {
+		papers := []models.Paper{*paper}
+		if err := hydrateStrokes(ctx, papers); err != nil {
+			return fmt.Errorf("failed to load strokes of %s: %v", itemType, err)
+		}
+		*paper = papers[0]
+	}
+	return &versionConflict{ItemType: itemType, Version: rawVersion(raw), Current: current}
+}
+
+// versionedWriteError keeps conflicts and not-found errors from a versioned
+// write and turns anything else into a 500 with message
+func versionedWriteError(err error, message string) error {
+	switch err.(type) {
+	case *versionConflict, *requestError:
+		return err
+	}
+	return newRequestError(http.StatusInternalServerError, message)
+}
+
+func rawVersion(raw bson.Raw) int64 {
+	version, _ := raw.Lookup("version").AsInt64OK()
+	return version
+}
+
+// setETag exposes a version as the response's ETag
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(version, 10)))
+}
+
+// writeVersionConflict answers 409 with the current state of the item
+func writeVersionConflict(w http.ResponseWriter, conflict *versionConflict) {
+	setETag(w, conflict.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":         conflict.Error(),
+		"item_type":       conflict.ItemType,
+		"current_version": conflict.Version,
+		"current":         conflict.Current,
+	})
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"backend/config"
 	"backend/handlers"
 	"backend/middleware"
+	"backend/migrations"
 	"backend/socketio"
+	"backend/utils"
 
 	"github.com/gorilla/mux"
 	"github.com/grandcat/zeroconf"
@@ -15,6 +19,15 @@ import (
 
 func main() {
 
+	// "migrate [up|status]" manages the schema without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		config.ConnectDB()
+		if err := migrations.Command(os.Args[2:]); err != nil {
+			log.Fatal("Migration failed: ", err)
+		}
+		return
+	}
+
 	server, err := zeroconf.Register(
 		"my-backend", // service instance name
 		"_http._tcp", // service type and protocol
@@ -34,8 +47,20 @@ func main() {
 
 	log.Println("Registered mDNS service: my-backend._http._tcp.local")
 
-	// Initialize database
+	// Initialize database and blob storage
 	config.ConnectDB()
+	handlers.LoadStorageConfig()
+	// The handlers rely on every migration, so the server does not start on
+	// a schema that is behind. Fix what the error names, then start again or
+	// run "migrate up".
+	if applied, err := migrations.Run(context.Background()); err != nil {
+		log.Fatal("Failed to migrate database: ", err)
+	} else if applied > 0 {
+		log.Printf("Applied %d database migrations", applied)
+	}
+	if err := utils.EnsureBuiltInRoles(context.Background()); err != nil {
+		log.Println("Failed to seed built-in roles:", err)
+	}
 
 	// Set up the router
 	router := mux.NewRouter()
@@ -50,8 +75,14 @@ func main() {
 	router.HandleFunc("/api/room/name", handlers.RenameRoom).Methods("PUT")
 	router.HandleFunc("/api/room", handlers.GetRooms).Methods("GET")
 	router.HandleFunc("/api/room", handlers.ToggleFavoriteRoom).Methods("PUT")
+	router.HandleFunc("/api/star", handlers.StarItem).Methods("POST")
+	router.HandleFunc("/api/star", handlers.GetStarredItems).Methods("GET")
+	router.HandleFunc("/api/star", handlers.UnstarItem).Methods("DELETE")
+	router.HandleFunc("/api/star/order", handlers.ReorderStarredItems).Methods("PUT")
 	router.HandleFunc("/api/room/id", handlers.GetSharedRoomID).Methods("GET")
 	router.HandleFunc("/api/room", handlers.DeleteRoom).Methods("DELETE")
+	router.HandleFunc("/api/room/export", handlers.ExportRoom).Methods("GET")
+	router.HandleFunc("/api/room/import", handlers.ImportRoom).Methods("POST")
 	router.HandleFunc("/api/folder", handlers.AddFolder).Methods("POST")
 	router.HandleFunc("/api/folder", handlers.GetFolder).Methods("GET")
 	router.HandleFunc("/api/folder/name", handlers.RenameFolder).Methods("PUT")
@@ -61,12 +92,41 @@ func main() {
 	router.HandleFunc("/api/file/name", handlers.RenameFile).Methods("PUT")
 	router.HandleFunc("/api/file/id", handlers.GetFileIDByOriginalID).Methods("GET")
 	router.HandleFunc("/api/file", handlers.DeleteFile).Methods("DELETE")
+	router.HandleFunc("/api/file/versions", handlers.CreateFileVersion).Methods("POST")
+	router.HandleFunc("/api/file/versions", handlers.GetFileVersions).Methods("GET")
+	router.HandleFunc("/api/file/version", handlers.GetFileVersion).Methods("GET")
+	router.HandleFunc("/api/file/version/restore", handlers.RestoreFileVersion).Methods("POST")
+	router.HandleFunc("/api/file/export/pdf", handlers.ExportFilePDF).Methods("GET")
+	router.HandleFunc("/api/file/import/pdf", handlers.ImportFilePDF).Methods("POST")
+	router.HandleFunc("/api/file/export/svg", handlers.ExportFileSVG).Methods("GET")
+	router.HandleFunc("/api/move", handlers.MoveItem).Methods("POST")
+	router.HandleFunc("/api/copy", handlers.CopyItem).Methods("POST")
+	router.HandleFunc("/api/search", handlers.Search).Methods("GET")
+	router.HandleFunc("/api/room/tree", handlers.GetRoomTree).Methods("GET")
+	router.HandleFunc("/api/path", handlers.GetItemPath).Methods("GET")
+	router.HandleFunc("/api/tag", handlers.AddTag).Methods("POST")
+	router.HandleFunc("/api/tag", handlers.GetTags).Methods("GET")
+	router.HandleFunc("/api/tag", handlers.UpdateTag).Methods("PUT")
+	router.HandleFunc("/api/tag", handlers.DeleteTag).Methods("DELETE")
+	router.HandleFunc("/api/tag/items", handlers.TagItems).Methods("POST", "DELETE")
+	router.HandleFunc("/api/order", handlers.ReorderItem).Methods("PUT")
+	router.HandleFunc("/api/file/open", handlers.OpenFile).Methods("POST")
+	router.HandleFunc("/api/recent", handlers.GetRecentFiles).Methods("GET")
+	router.HandleFunc("/api/recent", handlers.RemoveRecentFiles).Methods("DELETE")
+	router.HandleFunc("/api/batch", handlers.BatchItems).Methods("POST")
 	router.HandleFunc("/api/paper", handlers.AddPaper).Methods("POST")
 	router.HandleFunc("/api/paper/insert", handlers.InsertPaperAt).Methods("POST") // addmore
 	router.HandleFunc("/api/paper", handlers.GetPaper).Methods("GET")
 	router.HandleFunc("/api/paper", handlers.DeletePaper).Methods("DELETE")
 	router.HandleFunc("/api/paper/drawing", handlers.AddDrawingPoint).Methods("PUT")
 	router.HandleFunc("/api/paper/text", handlers.AddTextAnnotation).Methods("PUT")
+	router.HandleFunc("/api/paper/strokes", handlers.ApplyStrokeOps).Methods("POST")
+	router.HandleFunc("/api/paper/undo", handlers.UndoPaper).Methods("POST")
+	router.HandleFunc("/api/paper/redo", handlers.RedoPaper).Methods("POST")
+	router.HandleFunc("/api/paper/history", handlers.GetPaperHistory).Methods("GET")
+	router.HandleFunc("/api/paper/thumbnail", handlers.GetPaperThumbnail).Methods("GET")
+	router.HandleFunc("/api/paper/preview", handlers.GetPaperPreview).Methods("GET")
+	router.HandleFunc("/api/paper/export/svg", handlers.ExportPaperSVG).Methods("GET")
 	router.HandleFunc("/api/paper/swap", handlers.SwapPaper).Methods("PUT") // addmore
 
 	router.HandleFunc("/api/paper/import", handlers.UploadHandler).Methods("POST")
@@ -79,10 +139,26 @@ func main() {
 	router.HandleFunc("/api/roomMember", handlers.ChangeRoomMemberRole).Methods("PUT")
 	router.HandleFunc("/api/roomMember", handlers.GetRoomMembersInRoom).Methods("GET")
 	router.HandleFunc("/api/roomMember", handlers.RemoveRoomMember).Methods("DELETE")
+	router.HandleFunc("/api/group", handlers.CreateGroup).Methods("POST")
+	router.HandleFunc("/api/group", handlers.GetGroups).Methods("GET")
+	router.HandleFunc("/api/group/name", handlers.RenameGroup).Methods("PUT")
+	router.HandleFunc("/api/group", handlers.DeleteGroup).Methods("DELETE")
+	router.HandleFunc("/api/group/member", handlers.AddGroupMembers).Methods("POST")
+	router.HandleFunc("/api/group/member", handlers.RemoveGroupMember).Methods("DELETE")
+	router.HandleFunc("/api/roomGroup", handlers.RoomGroup).Methods("POST")
+	router.HandleFunc("/api/roomGroup", handlers.ChangeRoomGroupRole).Methods("PUT")
+	router.HandleFunc("/api/roomGroup", handlers.GetRoomGroups).Methods("GET")
+	router.HandleFunc("/api/roomGroup", handlers.RemoveRoomGroup).Methods("DELETE")
+	router.HandleFunc("/api/role", handlers.AddRole).Methods("POST")
+	router.HandleFunc("/api/role", handlers.GetRoles).Methods("GET")
+	router.HandleFunc("/api/role", handlers.UpdateRole).Methods("PUT")
+	router.HandleFunc("/api/role", handlers.DeleteRole).Methods("DELETE")
 
 	router.HandleFunc("/api/auth/refresh", handlers.RefreshToken).Methods("POST")
 
 	socketServer := socketio.SetupSocketIO(router)
+	socketio.OnFileOpened = handlers.TrackFileOpen
+	socketio.OnHistoryCommand = handlers.HistoryCommand
 
 	// Explicitly handle socket.io routes
 	router.Handle("/socket.io/", socketServer)
@@ -9,7 +9,8 @@ func CorsMiddleware(next http.Handler) http.Handler {
 		// More comprehensive CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, CONNECT")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Socket-ID")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Socket-ID, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		// Specific WebSocket headers
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Command runs the migrate command line: "migrate" or "migrate up" applies
+// pending migrations, "migrate status" lists applied and pending ones
+func Command(args []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	switch action {
+	case "up":
+		applied, err := Run(ctx)
+		if err != nil {
+			return err
+		}
+		version, err := CurrentVersion(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("Applied %d migrations, schema is at version %d", applied, version)
+		return nil
+	case "status":
+		applied, err := Applied(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range applied {
+			fmt.Printf("%4d  applied %s  %s\n", migration.Version, migration.AppliedAt.Format(time.RFC3339), migration.Name)
+		}
+		pending, err := Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			fmt.Printf("%4d  pending                    %s\n", migration.Version, migration.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action %q, expected 'up' or 'status'", action)
+	}
+}
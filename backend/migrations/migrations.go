@@ -0,0 +1,100 @@
+// Package migrations brings the database schema up to date. Every migration
+// has a version; the versions that have been applied are recorded in the
+// Schema_Migrations collection, so each one runs once per database.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"backend/config"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one step of the schema. Up must be safe to run again, since a
+// migration that fails halfway is retried from the start.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context) error
+}
+
+// AppliedMigration is the record of a migration in Schema_Migrations
+type AppliedMigration struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// CurrentVersion returns the highest applied version, or 0 for a fresh database
+func CurrentVersion(ctx context.Context) (int, error) {
+	var latest AppliedMigration
+	findOptions := options.FindOne().SetSort(bson.M{"_id": -1})
+	err := config.GetSchemaMigrationCollection().FindOne(ctx, bson.M{}, findOptions).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %v", err)
+	}
+	return latest.Version, nil
+}
+
+// Pending returns the migrations newer than the applied version, in order
+func Pending(ctx context.Context) ([]Migration, error) {
+	current, err := CurrentVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range all {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Run applies every pending migration in order and stops at the first one
+// that fails. It returns how many migrations were applied.
+func Run(ctx context.Context) (int, error) {
+	pending, err := Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, migration := range pending {
+		log.Printf("Applying migration %d: %s", migration.Version, migration.Name)
+		if err := migration.Up(ctx); err != nil {
+			return i, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+
+		record := AppliedMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}
+		_, err := config.GetSchemaMigrationCollection().InsertOne(ctx, record)
+		// Another instance may have applied the same migration at the same time
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return i, fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+		}
+	}
+	return len(pending), nil
+}
+
+// Applied lists the migrations recorded in the database, oldest first
+func Applied(ctx context.Context) ([]AppliedMigration, error) {
+	cursor, err := config.GetSchemaMigrationCollection().Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %v", err)
+	}
+
+	var applied []AppliedMigration
+	if err := cursor.All(ctx, &applied); err != nil {
+		return nil, fmt.Errorf("failed to decode applied migrations: %v", err)
+	}
+	return applied, nil
+}
@@ -0,0 +1,400 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"backend/config"
+	"backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// all is every migration in version order. Append new migrations at the end
+// and never change one that has shipped.
+var all = []Migration{
+	{Version: 1, Name: "create lookup indexes", Up: createLookupIndexes},
+	{Version: 2, Name: "create unique indexes", Up: createUniqueIndexes},
+	{Version: 3, Name: "rename updatedAT to updatedAt", Up: normalizeUpdatedAt},
+	{Version: 4, Name: "backfill file page counts and thumbnails", Up: backfillFileMetadata},
+	{Version: 5, Name: "index stroke chunks", Up: createStrokeChunkIndex},
+	{Version: 6, Name: "pack stroke offsets", Up: packStrokeOffsets},
+	{Version: 7, Name: "index file versions", Up: createVersionIndexes},
+	{Version: 8, Name: "index paper history", Up: createHistoryIndexes},
+	{Version: 9, Name: "index paper thumbnails", Up: createThumbnailIndex},
+	{Version: 10, Name: "point file thumbnails at the thumbnail endpoint", Up: rewriteFileThumbnails},
+}
+
+// index is an index on one collection
+type index struct {
+	collection func() *mongo.Collection
+	keys       bson.D
+}
+
+func keys(fields ...string) bson.D {
+	d := make(bson.D, 0, len(fields))
+	for _, field := range fields {
+		d = append(d, bson.E{Key: field, Value: 1})
+	}
+	return d
+}
+
+// createLookupIndexes indexes the fields the handlers filter on
+func createLookupIndexes(ctx context.Context) error {
+	indexes := []index{
+		{config.GetRoomCollection, keys("owner_id")},
+		{config.GetRoomCollection, keys("original_id")},
+		{config.GetFolderCollection, keys("room_id", "sub_folder_id", "position")},
+		{config.GetFolderCollection, keys("original_id")},
+		{config.GetFileCollection, keys("room_id", "sub_folder_id", "position")},
+		{config.GetFileCollection, keys("sub_folder_id")},
+		{config.GetFileCollection, keys("original_id")},
+		{config.GetPaperCollection, keys("file_id", "page_number")},
+		{config.GetPaperCollection, keys("room_id")},
+		{config.GetPaperCollection, keys("original_id")},
+		{config.GetPaperCollection, keys("background_image")},
+		{config.GetSharedCollection, keys("sharedWith")},
+		{config.GetSharedCollection, keys("ownerId")},
+		{config.GetRoomMemberCollection, keys("room_id", "shared_with")},
+		{config.GetRoomMemberCollection, keys("shared_with")},
+		{config.GetRoomGroupCollection, keys("room_id")},
+		{config.GetRoomGroupCollection, keys("group_id")},
+		{config.GetGroupCollection, keys("owner_id")},
+		{config.GetGroupCollection, keys("member_ids")},
+		{config.GetRoleCollection, keys("room_id", "role_id")},
+		{config.GetFavoriteCollection, keys("user_id", "room_id")},
+		{config.GetFavoriteCollection, keys("room_id")},
+		{config.GetStarredCollection, keys("room_id")},
+		{config.GetStarredCollection, keys("item_type", "item_id")},
+		{config.GetTagCollection, keys("room_id")},
+		{config.GetFileActivityCollection, keys("file_id")},
+		{config.GetFileActivityCollection, keys("room_id")},
+		{config.GetRefreshTokenCollection, keys("token")},
+		{config.GetBlacklistCollection, keys("token")},
+	}
+
+	for _, idx := range indexes {
+		collection := idx.collection()
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys}); err != nil {
+			return fmt.Errorf("failed to index %s on %v: %v", collection.Name(), idx.keys, err)
+		}
+	}
+	return nil
+}
+
+// createUniqueIndexes enforces the uniqueness the handlers only check before
+// inserting. Duplicate stars and activity records are merged away first, and
+// users sharing an email are told apart by renaming all but the first. It
+// used to stop on duplicate emails instead, so it only ever completed where
+// there were none and renaming changes nothing there.
+func createUniqueIndexes(ctx context.Context) error {
+	if err := renameDuplicateEmails(ctx); err != nil {
+		return err
+	}
+
+	if err := removeDuplicates(ctx, config.GetStarredCollection(), "user_id", "item_type", "item_id"); err != nil {
+		return err
+	}
+	if err := removeDuplicates(ctx, config.GetFileActivityCollection(), "user_id", "file_id"); err != nil {
+		return err
+	}
+
+	unique := options.Index().SetUnique(true)
+	indexes := []index{
+		{config.GetUserCollection, keys("email")},
+		{config.GetStarredCollection, keys("user_id", "item_type", "item_id")},
+		{config.GetFileActivityCollection, keys("user_id", "file_id")},
+	}
+	for _, idx := range indexes {
+		collection := idx.collection()
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys, Options: unique}); err != nil {
+			return fmt.Errorf("failed to create unique index on %s %v: %v", collection.Name(), idx.keys, err)
+		}
+	}
+	return nil
+}
+
+// renameDuplicateEmails keeps the email of the oldest user of every group
+// sharing one, which is the account login finds, and gives the others a
+// unique address such as "ann+duplicate-<id>@example.com". Those accounts
+// could not log in before either. Each rename is logged so the accounts can
+// be merged or given a new email by hand.
+func renameDuplicateEmails(ctx context.Context) error {
+	userCollection := config.GetUserCollection()
+	pipeline := []bson.M{
+		{"$sort": bson.M{"_id": 1}},
+		{"$group": bson.M{"_id": "$email", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}
+	cursor, err := userCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to look for duplicate emails: %v", err)
+	}
+
+	var groups []struct {
+		Email interface{}          `bson:"_id"`
+		IDs   []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return fmt.Errorf("failed to decode duplicate emails: %v", err)
+	}
+
+	for _, group := range groups {
+		email, _ := group.Email.(string)
+		for _, id := range group.IDs[1:] {
+			renamed := duplicateEmail(email, id.Hex())
+			if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email": renamed}}); err != nil {
+				return fmt.Errorf("failed to rename email of user %s: %v", id.Hex(), err)
+			}
+			log.Printf("User %s had the email %q of user %s and now has %q; merge the accounts or set a new email by hand",
+				id.Hex(), email, group.IDs[0].Hex(), renamed)
+		}
+	}
+	return nil
+}
+
+// duplicateEmail adds a tag naming the user to the local part of an email
+func duplicateEmail(email, userID string) string {
+	tag := "+duplicate-" + userID
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return email[:at] + tag + email[at:]
+	}
+	return email + tag
+}
+
+// removeDuplicates keeps the newest document of every group of documents
+// that share the same fields and deletes the rest
+func removeDuplicates(ctx context.Context, collection *mongo.Collection, fields ...string) error {
+	groupKey := bson.M{}
+	for _, field := range fields {
+		groupKey[field] = "$" + field
+	}
+	pipeline := []bson.M{
+		{"$sort": bson.M{"_id": -1}},
+		{"$group": bson.M{"_id": groupKey, "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}},
+		{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+	}
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to look for duplicates in %s: %v", collection.Name(), err)
+	}
+
+	var groups []struct {
+		IDs []interface{} `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return fmt.Errorf("failed to decode duplicates in %s: %v", collection.Name(), err)
+	}
+
+	for _, group := range groups {
+		if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}}); err != nil {
+			return fmt.Errorf("failed to delete duplicates in %s: %v", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// normalizeUpdatedAt moves the updatedAT field the rename handlers used to
+// write into updatedAt, keeping the later of the two when both are set
+func normalizeUpdatedAt(ctx context.Context) error {
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"updatedAt": bson.M{"$max": bson.A{"$updatedAt", "$updatedAT"}}}}},
+		{{Key: "$unset", Value: "updatedAT"}},
+	}
+
+	for _, collection := range []*mongo.Collection{
+		config.GetRoomCollection(),
+		config.GetFolderCollection(),
+		config.GetFileCollection(),
+	} {
+		filter := bson.M{"updatedAT": bson.M{"$exists": true}}
+		if _, err := collection.UpdateMany(ctx, filter, update); err != nil {
+			return fmt.Errorf("failed to normalize updatedAt in %s: %v", collection.Name(), err)
+		}
+	}
+	return nil
+}
+
+// backfillFileMetadata sets the page count and thumbnail of files created
+// before they were tracked. The thumbnail is the background of the first page.
+func backfillFileMetadata(ctx context.Context) error {
+	pipeline := []bson.M{
+		{"$sort": bson.D{{Key: "file_id", Value: 1}, {Key: "page_number", Value: 1}}},
+		{"$group": bson.M{
+			"_id":       "$file_id",
+			"count":     bson.M{"$sum": 1},
+			"thumbnail": bson.M{"$first": "$background_image"},
+		}},
+	}
+	cursor, err := config.GetPaperCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to count papers: %v", err)
+	}
+
+	var counts []struct {
+		FileID    string `bson:"_id"`
+		Count     int64  `bson:"count"`
+		Thumbnail string `bson:"thumbnail"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return fmt.Errorf("failed to decode paper counts: %v", err)
+	}
+
+	fileCollection := config.GetFileCollection()
+	writes := make([]mongo.WriteModel, 0, len(counts))
+	for _, count := range counts {
+		objID, err := primitive.ObjectIDFromHex(count.FileID)
+		if err != nil {
+			continue
+		}
+		set := bson.M{"page_count": count.Count}
+		if count.Thumbnail != "" {
+			set["thumbnail_url"] = count.Thumbnail
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objID, "page_count": bson.M{"$exists": false}}).
+			SetUpdate(bson.M{"$set": set}))
+	}
+	if len(writes) > 0 {
+		if _, err := fileCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to backfill file metadata: %v", err)
+		}
+	}
+
+	// Files without any papers
+	_, err = fileCollection.UpdateMany(ctx, bson.M{"page_count": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"page_count": 0}})
+	if err != nil {
+		return fmt.Errorf("failed to backfill empty files: %v", err)
+	}
+	return nil
+}
+
+// createStrokeChunkIndex keeps the chunks of a paper unique and in order
+func createStrokeChunkIndex(ctx context.Context) error {
+	model := mongo.IndexModel{Keys: keys("paper_id", "index"), Options: options.Index().SetUnique(true)}
+	if _, err := config.GetPaperStrokeCollection().Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("failed to index stroke chunks: %v", err)
+	}
+	return nil
+}
+
+// packStrokeOffsets rewrites strokes saved with one document per offset in
+// the packed form. Strokes are not simplified, which would lose detail.
+func packStrokeOffsets(ctx context.Context) error {
+	paperCollection := config.GetPaperCollection()
+	filter := bson.M{"drawing_data.offsets": bson.M{"$exists": true}}
+	findOptions := options.Find().SetProjection(bson.M{"drawing_data": 1})
+	cursor, err := paperCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return fmt.Errorf("failed to query papers: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var paper models.Paper
+		if err := cursor.Decode(&paper); err != nil {
+			return fmt.Errorf("failed to decode paper: %v", err)
+		}
+		update := bson.M{"$set": bson.M{"drawing_data": paper.DrawingData}}
+		if _, err := paperCollection.UpdateOne(ctx, bson.M{"_id": paper.ID}, update); err != nil {
+			return fmt.Errorf("failed to pack strokes of paper %s: %v", paper.ID.Hex(), err)
+		}
+	}
+	return cursor.Err()
+}
+
+// createVersionIndexes indexes file versions and their paper snapshots
+func createVersionIndexes(ctx context.Context) error {
+	indexes := []index{
+		{config.GetFileVersionCollection, keys("file_id", "created_at")},
+		{config.GetFileVersionCollection, keys("file_id", "kind", "created_at")},
+		{config.GetPaperSnapshotCollection, keys("version_id", "page_number")},
+		{config.GetPaperSnapshotCollection, keys("file_id")},
+		{config.GetPaperSnapshotCollection, keys("background_image")},
+	}
+	for _, idx := range indexes {
+		collection := idx.collection()
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys}); err != nil {
+			return fmt.Errorf("failed to index %s on %v: %v", collection.Name(), idx.keys, err)
+		}
+	}
+	return nil
+}
+
+// createHistoryIndexes indexes the undo and redo stacks of each user and paper
+func createHistoryIndexes(ctx context.Context) error {
+	indexes := []index{
+		{config.GetPaperHistoryCollection, keys("paper_id", "user_id", "undone", "undone_at")},
+		{config.GetPaperHistoryCollection, keys("paper_id", "user_id", "_id")},
+	}
+	for _, idx := range indexes {
+		collection := idx.collection()
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: idx.keys}); err != nil {
+			return fmt.Errorf("failed to index %s on %v: %v", collection.Name(), idx.keys, err)
+		}
+	}
+	return nil
+}
+
+// createThumbnailIndex keeps one cached thumbnail per paper and size
+func createThumbnailIndex(ctx context.Context) error {
+	model := mongo.IndexModel{Keys: keys("paper_id", "width", "height"), Options: options.Index().SetUnique(true)}
+	if _, err := config.GetPaperThumbnailCollection().Indexes().CreateOne(ctx, model); err != nil {
+		return fmt.Errorf("failed to index paper thumbnails: %v", err)
+	}
+	return nil
+}
+
+// rewriteFileThumbnails replaces the background image that version 4 stored
+// as the thumbnail of a file with the thumbnail endpoint of its first page,
+// which also shows the strokes and works for pages without a background
+func rewriteFileThumbnails(ctx context.Context) error {
+	pipeline := []bson.M{
+		{"$sort": bson.D{{Key: "file_id", Value: 1}, {Key: "page_number", Value: 1}}},
+		{"$group": bson.M{
+			"_id":   "$file_id",
+			"first": bson.M{"$first": "$_id"},
+		}},
+	}
+	cursor, err := config.GetPaperCollection().Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to find first pages: %v", err)
+	}
+
+	var firstPages []struct {
+		FileID string             `bson:"_id"`
+		First  primitive.ObjectID `bson:"first"`
+	}
+	if err := cursor.All(ctx, &firstPages); err != nil {
+		return fmt.Errorf("failed to decode first pages: %v", err)
+	}
+
+	fileCollection := config.GetFileCollection()
+	writes := make([]mongo.WriteModel, 0, len(firstPages))
+	for _, page := range firstPages {
+		objID, err := primitive.ObjectIDFromHex(page.FileID)
+		if err != nil {
+			continue
+		}
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objID}).
+			SetUpdate(bson.M{"$set": bson.M{"thumbnail_url": models.PaperThumbnailURL(page.First.Hex())}}))
+	}
+	if len(writes) > 0 {
+		if _, err := fileCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to rewrite file thumbnails: %v", err)
+		}
+	}
+
+	// Files without any papers have no thumbnail
+	_, err = fileCollection.UpdateMany(ctx, bson.M{"page_count": 0}, bson.M{"$unset": bson.M{"thumbnail_url": ""}})
+	if err != nil {
+		return fmt.Errorf("failed to clear thumbnails of empty files: %v", err)
+	}
+	return nil
+}
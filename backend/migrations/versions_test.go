@@ -0,0 +1,20 @@
+package migrations
+
+import "testing"
+
+func TestDuplicateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{email: "ann@example.com", want: "ann+duplicate-42@example.com"},
+		{email: "a@b@example.com", want: "a@b+duplicate-42@example.com"},
+		{email: "ann", want: "ann+duplicate-42"},
+		{email: "", want: "+duplicate-42"},
+	}
+	for _, tt := range tests {
+		if got := duplicateEmail(tt.email, "42"); got != tt.want {
+			t.Errorf("duplicateEmail(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
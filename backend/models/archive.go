@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// RoomArchiveFormat identifies archives produced by the room export
+const RoomArchiveFormat = "loomlen-room-archive"
+
+// RoomArchiveVersion is bumped whenever the archive layout changes. Version 2
+// added tags.json.
+const RoomArchiveVersion = 2
+
+type RoomArchiveManifest struct {
+	Format     string             `json:"format"`
+	Version    int                `json:"version"`
+	ExportedAt time.Time          `json:"exported_at"`
+	ExportedBy string             `json:"exported_by"`
+	RoomID     string             `json:"room_id"`
+	RoomName   string             `json:"room_name"`
+	Folders    int                `json:"folders"`
+	Files      int                `json:"files"`
+	Papers     int                `json:"papers"`
+	Assets     []RoomArchiveAsset `json:"assets"`
+}
+
+// RoomArchiveAsset maps a background image URL to its path inside the archive
+type RoomArchiveAsset struct {
+	Path      string `json:"path"`
+	SourceURL string `json:"source_url"`
+}
@@ -12,6 +12,20 @@ type File struct {
 	RoomID      string             `bson:"room_id" json:"room_id"`
 	SubFolderID string             `bson:"sub_folder_id" json:"sub_folder_id"`
 	Name        string             `bson:"name" json:"name"`
+	Tags        []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	Position    string             `bson:"position,omitempty" json:"position,omitempty"`
 	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+
+	// Incremented by every change, exposed as the ETag
+	Version int64 `bson:"version" json:"version"`
+
+	// Maintained as papers are added, deleted and edited
+	PageCount    int       `bson:"page_count" json:"page_count"`
+	ThumbnailURL string    `bson:"thumbnail_url,omitempty" json:"thumbnail_url,omitempty"`
+	LastEditedBy string    `bson:"last_edited_by,omitempty" json:"last_edited_by,omitempty"`
+	LastEditedAt time.Time `bson:"last_edited_at,omitempty" json:"last_edited_at,omitempty"`
+
+	// Per-user, filled from File_Activity when the file is listed
+	LastOpenedAt *time.Time `bson:"-" json:"last_opened_at,omitempty"`
 }
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileActivity records when a user last opened and last edited a file. There
+// is one document per user and file. Hidden entries were removed from the
+// user's recents and come back on the next open or edit.
+type FileActivity struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID       string             `bson:"user_id" json:"user_id"`
+	FileID       string             `bson:"file_id" json:"file_id"`
+	RoomID       string             `bson:"room_id" json:"room_id"`
+	LastOpenedAt time.Time          `bson:"last_opened_at,omitempty" json:"last_opened_at,omitempty"`
+	LastEditedAt time.Time          `bson:"last_edited_at,omitempty" json:"last_edited_at,omitempty"`
+	Hidden       bool               `bson:"hidden,omitempty" json:"hidden,omitempty"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of file versions
+const (
+	VersionAuto    = "auto"    // taken before an edit when the last version is old enough
+	VersionNamed   = "named"   // created by a user
+	VersionRestore = "restore" // taken before a restore, so the restore can be undone
+)
+
+// FileVersion is the state of all papers of a file at one point in time. The
+// papers are stored as PaperSnapshots.
+type FileVersion struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FileID       string             `bson:"file_id" json:"file_id"`
+	Name         string             `bson:"name" json:"name"`
+	Kind         string             `bson:"kind" json:"kind"`
+	PageCount    int                `bson:"page_count" json:"page_count"`
+	RestoredFrom string             `bson:"restored_from,omitempty" json:"restored_from,omitempty"`
+	CreatedBy    string             `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// PaperSnapshot is a paper as it was when a version was taken. The embedded
+// paper has an ID of its own; PaperID is the paper it was taken from.
+type PaperSnapshot struct {
+	VersionID string `bson:"version_id" json:"version_id"`
+	PaperID   string `bson:"paper_id" json:"paper_id"`
+	Paper     `bson:",inline"`
+}
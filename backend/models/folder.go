@@ -6,13 +6,21 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// RootFolderID is the sub_folder_id clients use for items at the top level of a room
+const RootFolderID = "Unknow"
+
 type Folder struct {
 	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	OriginalID  string             `bson:"original_id" json:"original_id"`
 	RoomID      string             `bson:"room_id" json:"room_id"`
 	SubFolderID string             `bson:"sub_folder_id" json:"sub_folder_id"`
 	Name        string             `bson:"name" json:"name"`
+	Tags        []string           `bson:"tags,omitempty" json:"tags,omitempty"`
+	Position    string             `bson:"position,omitempty" json:"position,omitempty"`
 	Color       int                `bson:"color" json:"color"`
 	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+
+	// Incremented by every change, exposed as the ETag
+	Version int64 `bson:"version" json:"version"`
 }
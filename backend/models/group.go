@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Group is a named set of users owned by one user that can be added to rooms as a unit
+type Group struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OwnerID   string             `bson:"owner_id" json:"owner_id"`
+	Name      string             `bson:"name" json:"name"`
+	MemberIDs []string           `bson:"member_ids" json:"member_ids"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RoomGroup grants every member of a group a role on a room
+type RoomGroup struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	InviterID string             `bson:"inviter_id" json:"inviter_id"`
+	RoomID    string             `bson:"room_id" json:"room_id"`
+	GroupID   string             `bson:"group_id" json:"group_id"`
+	RoleID    string             `bson:"role_id" json:"role_id"`
+	JoinAt    time.Time          `bson:"join_at" json:"join_at"`
+}
@@ -21,6 +21,13 @@ type Paper struct {
 	BackgroundImage string           `json:"background_image" bson:"background_image"`
 	CreatedAt       time.Time        `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time        `bson:"updated_at" json:"updated_at"`
+
+	// Incremented by every change, exposed as the ETag
+	Version int64 `bson:"version" json:"version"`
+
+	// StrokeStorage is StrokesChunked when the strokes are kept in
+	// Paper_Strokes instead of DrawingData
+	StrokeStorage string `bson:"stroke_storage,omitempty" json:"-"`
 }
 
 type Offset struct {
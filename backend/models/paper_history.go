@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of paper history entries
+const (
+	HistoryStrokes = "strokes"
+	HistoryText    = "text"
+)
+
+// StrokeChange is one stroke before and after an edit. Before is nil when the
+// edit added the stroke, After is nil when it deleted it.
+type StrokeChange struct {
+	StrokeID int           `bson:"stroke_id" json:"stroke_id"`
+	Before   *DrawingPoint `bson:"before,omitempty" json:"before,omitempty"`
+	After    *DrawingPoint `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// TextChange is one text annotation before and after an edit
+type TextChange struct {
+	TextID int             `bson:"text_id" json:"text_id"`
+	Before *TextAnnotation `bson:"before,omitempty" json:"before,omitempty"`
+	After  *TextAnnotation `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// PaperHistoryEntry is one edit a user made to a paper. Undone entries form
+// the user's redo stack until they make a new edit to the paper.
+type PaperHistoryEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PaperID   string             `bson:"paper_id" json:"paper_id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	Kind      string             `bson:"kind" json:"kind"`
+	Strokes   []StrokeChange     `bson:"strokes,omitempty" json:"strokes,omitempty"`
+	Texts     []TextChange       `bson:"texts,omitempty" json:"texts,omitempty"`
+	Undone    bool               `bson:"undone" json:"undone"`
+	UndoneAt  *time.Time         `bson:"undone_at,omitempty" json:"undone_at,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
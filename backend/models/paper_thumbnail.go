@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaperThumbnail is a cached PNG rendering of a paper at one size. It is only
+// valid while the paper is still at Version.
+type PaperThumbnail struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PaperID   string             `bson:"paper_id" json:"paper_id"`
+	Width     int                `bson:"width" json:"width"`
+	Height    int                `bson:"height" json:"height"`
+	Version   int64              `bson:"version" json:"version"`
+	Data      []byte             `bson:"data" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// PaperThumbnailURL is the path of the thumbnail endpoint for a paper. Files
+// use it for their first page as their thumbnail_url.
+func PaperThumbnailURL(paperID string) string {
+	return "/api/paper/thumbnail?paper_id=" + paperID
+}
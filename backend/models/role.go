@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permissions that can be granted to a role. Each mutating endpoint checks exactly one of these.
+const (
+	PermCreate        = "create"
+	PermEdit          = "edit"
+	PermDelete        = "delete"
+	PermReorder       = "reorder"
+	PermExport        = "export"
+	PermShare         = "share"
+	PermInvite        = "invite"
+	PermManageMembers = "manage_members"
+)
+
+var AllPermissions = []string{
+	PermCreate,
+	PermEdit,
+	PermDelete,
+	PermReorder,
+	PermExport,
+	PermShare,
+	PermInvite,
+	PermManageMembers,
+}
+
+// Built-in role IDs. These are the values that were stored in Room_Member.role_id
+// before custom roles existed, so existing memberships keep working unchanged.
+const (
+	RoleOwner = "owner"
+	RoleWrite = "write"
+	RoleRead  = "read"
+)
+
+// BuiltInRoles maps each built-in role ID to its permission set
+var BuiltInRoles = map[string][]string{
+	RoleOwner: AllPermissions,
+	RoleWrite: {PermCreate, PermEdit, PermDelete, PermReorder, PermExport},
+	RoleRead:  {PermExport},
+}
+
+// Role is a named permission set. Built-in roles have an empty RoomID and use
+// their fixed name as RoleID; custom roles belong to one room and use the hex
+// of their ObjectID as RoleID.
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RoleID      string             `bson:"role_id" json:"role_id"`
+	RoomID      string             `bson:"room_id" json:"room_id"`
+	Name        string             `bson:"name" json:"name"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	BuiltIn     bool               `bson:"built_in" json:"built_in"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
@@ -16,4 +16,7 @@ type Room struct {
 	//isshare bool
 	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+
+	// Incremented by every change, exposed as the ETag
+	Version int64 `bson:"version" json:"version"`
 }
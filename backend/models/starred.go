@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Item types that can be starred
+const (
+	StarRoom   = "room"
+	StarFolder = "folder"
+	StarFile   = "file"
+	StarPaper  = "paper"
+)
+
+// StarredItem is one entry of a user's starred list. Position is the
+// user-defined order, lowest first.
+type StarredItem struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    string             `bson:"user_id" json:"user_id"`
+	ItemType  string             `bson:"item_type" json:"item_type"`
+	ItemID    string             `bson:"item_id" json:"item_id"`
+	RoomID    string             `bson:"room_id" json:"room_id"`
+	Position  int                `bson:"position" json:"position"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
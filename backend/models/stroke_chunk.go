@@ -0,0 +1,17 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// StrokesChunked marks a paper whose strokes are stored in StrokeChunks
+const StrokesChunked = "chunked"
+
+// StrokeChunk holds a run of a paper's strokes. Chunks are read in Index
+// order, which is the order the strokes were drawn in.
+type StrokeChunk struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PaperID string             `bson:"paper_id" json:"paper_id"`
+	Index   int                `bson:"index" json:"index"`
+	Count   int                `bson:"count" json:"count"`
+	Size    int                `bson:"size" json:"size"` // estimated, see DrawingPoint.PackedSize
+	Strokes []DrawingPoint     `bson:"strokes" json:"strokes"`
+}
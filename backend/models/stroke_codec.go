@@ -0,0 +1,128 @@
+package models
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Stroke offsets are stored packed instead of as one sub-document per point,
+// which takes a point from about 30 bytes of BSON to two or three. Points are
+// kept to OffsetPrecision: each coordinate is rounded to a multiple of it and
+// stored as the varint difference from the previous point.
+const (
+	OffsetPrecision  = 0.01
+	offsetScale      = 1 / OffsetPrecision
+	packedOffsetsV1  = 1
+	maxPackedOffsets = 1 << 24
+)
+
+var errBadPackedOffsets = errors.New("malformed packed offsets")
+
+// EncodeOffsets packs a list of offsets
+func EncodeOffsets(offsets []Offset) []byte {
+	buf := make([]byte, 0, 2+len(offsets)*4)
+	buf = append(buf, packedOffsetsV1)
+	buf = binary.AppendUvarint(buf, uint64(len(offsets)))
+
+	var prevX, prevY int64
+	for _, offset := range offsets {
+		x := int64(math.Round(offset.X * offsetScale))
+		y := int64(math.Round(offset.Y * offsetScale))
+		buf = binary.AppendVarint(buf, x-prevX)
+		buf = binary.AppendVarint(buf, y-prevY)
+		prevX, prevY = x, y
+	}
+	return buf
+}
+
+// DecodeOffsets unpacks offsets written by EncodeOffsets
+func DecodeOffsets(data []byte) ([]Offset, error) {
+	if len(data) == 0 || data[0] != packedOffsetsV1 {
+		return nil, errBadPackedOffsets
+	}
+	data = data[1:]
+
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > maxPackedOffsets {
+		return nil, errBadPackedOffsets
+	}
+	data = data[n:]
+
+	offsets := make([]Offset, 0, count)
+	var x, y int64
+	for i := uint64(0); i < count; i++ {
+		dx, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errBadPackedOffsets
+		}
+		data = data[n:]
+		dy, n := binary.Varint(data)
+		if n <= 0 {
+			return nil, errBadPackedOffsets
+		}
+		data = data[n:]
+
+		x, y = x+dx, y+dy
+		offsets = append(offsets, Offset{X: float64(x) / offsetScale, Y: float64(y) / offsetScale})
+	}
+	return offsets, nil
+}
+
+// storedDrawingPoint is the BSON form of a DrawingPoint. Offsets is only
+// read, from strokes written before offsets were packed.
+type storedDrawingPoint struct {
+	ID            int      `bson:"id"`
+	Type          string   `bson:"type"`
+	Offsets       []Offset `bson:"offsets,omitempty"`
+	PackedOffsets []byte   `bson:"offsets_packed,omitempty"`
+	Color         int      `bson:"color"`
+	Width         float64  `bson:"width"`
+	Tool          string   `bson:"tool"`
+}
+
+// MarshalBSON stores the stroke with packed offsets
+func (d DrawingPoint) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(storedDrawingPoint{
+		ID:            d.ID,
+		Type:          d.Type,
+		PackedOffsets: EncodeOffsets(d.Offsets),
+		Color:         d.Color,
+		Width:         d.Width,
+		Tool:          d.Tool,
+	})
+}
+
+// UnmarshalBSON reads a stroke with packed or plain offsets
+func (d *DrawingPoint) UnmarshalBSON(data []byte) error {
+	var stored storedDrawingPoint
+	if err := bson.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+
+	offsets := stored.Offsets
+	if len(stored.PackedOffsets) > 0 {
+		var err error
+		if offsets, err = DecodeOffsets(stored.PackedOffsets); err != nil {
+			return err
+		}
+	}
+
+	*d = DrawingPoint{
+		ID:      stored.ID,
+		Type:    stored.Type,
+		Offsets: offsets,
+		Color:   stored.Color,
+		Width:   stored.Width,
+		Tool:    stored.Tool,
+	}
+	return nil
+}
+
+// PackedSize estimates the BSON size of a stroke
+func (d DrawingPoint) PackedSize() int {
+	// Field names, the other values and the document framing take about 80 bytes
+	return 80 + len(d.Type) + len(d.Tool) + 2 + len(d.Offsets)*4
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDrawingPointBSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		stroke DrawingPoint
+	}{
+		{name: "pen", stroke: DrawingPoint{ID: 7, Type: "drawing", Color: -16777216, Width: 2.5, Tool: "pen",
+			Offsets: []Offset{{X: 10.25, Y: 20.5}, {X: 11, Y: 19.75}, {X: 0, Y: 0}}}},
+		{name: "negative and large coordinates", stroke: DrawingPoint{ID: 1, Type: "drawing", Tool: "pen",
+			Offsets: []Offset{{X: -3.5, Y: 1200.01}, {X: 4096.99, Y: -0.01}}}},
+		{name: "single point eraser", stroke: DrawingPoint{ID: 2, Type: "drawing", Width: 20, Tool: "eraser",
+			Offsets: []Offset{{X: 1, Y: 1}}}},
+		{name: "no offsets", stroke: DrawingPoint{ID: 3, Type: "drawing", Offsets: []Offset{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(tt.stroke)
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+			if _, err := bson.Raw(data).LookupErr("offsets"); err == nil {
+				t.Errorf("offsets stored unpacked")
+			}
+
+			var got DrawingPoint
+			if err := bson.Unmarshal(data, &got); err != nil {
+				t.Fatalf("unmarshal failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.stroke) {
+				t.Errorf("round trip = %+v, want %+v", got, tt.stroke)
+			}
+		})
+	}
+}
+
+func TestDrawingPointRoundsToPrecision(t *testing.T) {
+	tests := []struct {
+		in, want Offset
+	}{
+		{in: Offset{X: 1.004, Y: 2.006}, want: Offset{X: 1, Y: 2.01}},
+		{in: Offset{X: -0.004, Y: 0.005}, want: Offset{X: 0, Y: 0.01}},
+	}
+	for _, tt := range tests {
+		data, err := bson.Marshal(DrawingPoint{Offsets: []Offset{tt.in}})
+		if err != nil {
+			t.Fatalf("marshal failed: %v", err)
+		}
+		var got DrawingPoint
+		if err := bson.Unmarshal(data, &got); err != nil {
+			t.Fatalf("unmarshal failed: %v", err)
+		}
+		if len(got.Offsets) != 1 || got.Offsets[0] != tt.want {
+			t.Errorf("%v stored as %v, want %v", tt.in, got.Offsets, tt.want)
+		}
+	}
+}
+
+func TestDrawingPointReadsPlainOffsets(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"id": 4, "type": "drawing", "tool": "pen", "width": 3.0,
+		"offsets": bson.A{bson.M{"x": 1.5, "y": 2.5}, bson.M{"x": 3.0, "y": 4.0}}})
+	if err != nil {
+		t.Fatalf("marshal failed: %v", err)
+	}
+	var got DrawingPoint
+	if err := bson.Unmarshal(data, &got); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	want := DrawingPoint{ID: 4, Type: "drawing", Tool: "pen", Width: 3, Offsets: []Offset{{X: 1.5, Y: 2.5}, {X: 3, Y: 4}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeOffsetsRejectsMalformedData(t *testing.T) {
+	valid := EncodeOffsets([]Offset{{X: 1, Y: 2}, {X: 3, Y: 4}})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "unknown version", data: append([]byte{2}, valid[1:]...)},
+		{name: "truncated", data: valid[:len(valid)-1]},
+		{name: "count too large", data: []byte{packedOffsetsV1, 0xff, 0xff, 0xff, 0xff, 0x0f}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeOffsets(tt.data); err == nil {
+				t.Errorf("DecodeOffsets(%v) succeeded", tt.data)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tag is a user-defined label of a room. Folders and files store the hex IDs
+// of their tags in their Tags field.
+type Tag struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	RoomID    string             `bson:"room_id" json:"room_id"`
+	Name      string             `bson:"name" json:"name"`
+	Color     int                `bson:"color" json:"color"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
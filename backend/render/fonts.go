@@ -0,0 +1,78 @@
+package render
+
+import (
+	"sync"
+
+	"backend/models"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+)
+
+// DefaultFontSize is the client's size for text annotations without one
+const DefaultFontSize = 16.0
+
+// FontSize returns the size a text annotation is drawn at
+func FontSize(annotation models.TextAnnotation) float64 {
+	if annotation.FontSize <= 0 {
+		return DefaultFontSize
+	}
+	return annotation.FontSize
+}
+
+// fontStyle picks one of the Go fonts
+type fontStyle struct {
+	bold, italic bool
+}
+
+// fontFiles are the TrueType files of the Go fonts
+var fontFiles = map[fontStyle][]byte{
+	{false, false}: goregular.TTF,
+	{true, false}:  gobold.TTF,
+	{false, true}:  goitalic.TTF,
+	{true, true}:   gobolditalic.TTF,
+}
+
+var (
+	fontsOnce sync.Once
+	fonts     map[fontStyle]*opentype.Font
+	fontsErr  error
+)
+
+func parseFonts() {
+	fonts = make(map[fontStyle]*opentype.Font)
+	for style, data := range fontFiles {
+		f, err := opentype.Parse(data)
+		if err != nil {
+			fontsErr = err
+			return
+		}
+		fonts[style] = f
+	}
+}
+
+// parsedFont returns the Go font in the given style
+func parsedFont(style fontStyle) (*opentype.Font, error) {
+	fontsOnce.Do(parseFonts)
+	if fontsErr != nil {
+		return nil, fontsErr
+	}
+	return fonts[style], nil
+}
+
+// newFace returns a face of the given size in pixels. The caller must close it.
+func newFace(size float64, bold, italic bool) (font.Face, error) {
+	f, err := parsedFont(fontStyle{bold, italic})
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+}
@@ -0,0 +1,489 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"backend/models"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// PDFOptions controls what a PDFWriter draws
+type PDFOptions struct {
+	// AnnotationsOnly leaves out templates and background images
+	AnnotationsOnly bool
+}
+
+// PDFWriter writes papers as the pages of a PDF, at their own size with one
+// point per paper unit. Each page is written out as it is added; Close
+// writes the fonts and the page tree.
+type PDFWriter struct {
+	w       io.Writer
+	opts    PDFOptions
+	written int64
+	err     error
+
+	offsets  []int64 // of each object, by number - 1
+	catalog  int
+	pageTree int
+	pages    []int
+	fonts    map[fontStyle]*pdfFont
+}
+
+// NewPDFWriter starts a PDF on w
+func NewPDFWriter(w io.Writer, opts PDFOptions) *PDFWriter {
+	p := &PDFWriter{w: w, opts: opts, fonts: make(map[fontStyle]*pdfFont)}
+	p.catalog = p.reserve()
+	p.pageTree = p.reserve()
+	// The binary comment marks the file as binary for transfer tools
+	p.printf("%%PDF-1.7\n%%\xe2\xe3\xcf\xd3\n")
+	return p
+}
+
+// AddPage draws a paper as the next page, with its BackgroundImage if
+// background is not nil. An error leaves the PDF unfinished.
+func (p *PDFWriter) AddPage(paper models.Paper, background *PDFImage) error {
+	width, height := PageSize(paper)
+	page := &pdfPage{
+		writer:    p,
+		width:     width,
+		height:    height,
+		resources: p.reserve(),
+		extGState: make(map[string]string),
+		xObjects:  make(map[string]string),
+		fonts:     make(map[string]string),
+	}
+
+	// Paper coordinates run down from the top left corner
+	content := &page.content
+	fmt.Fprintf(content, "1 0 0 -1 0 %s cm\n", formatNumber(height))
+
+	if !p.opts.AnnotationsOnly {
+		page.template(TemplateFor(paper.TemplateID))
+		if background != nil {
+			page.image(background)
+		}
+	}
+	page.strokes(paper.DrawingData)
+	for _, annotation := range paper.TextData {
+		if err := page.text(annotation); err != nil {
+			return err
+		}
+	}
+
+	contents := p.reserve()
+	p.stream(contents, "", content.Bytes())
+	p.object(page.resources, "%s", page.resourceDict())
+	id := p.reserve()
+	p.object(id, "<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %s %s] /Resources %d 0 R /Contents %d 0 R /Group << /S /Transparency /CS /DeviceRGB >> >>",
+		p.pageTree, formatNumber(width), formatNumber(height), page.resources, contents)
+	p.pages = append(p.pages, id)
+	return p.err
+}
+
+// Close finishes the PDF. It does not close the underlying writer.
+func (p *PDFWriter) Close() error {
+	styles := make([]fontStyle, 0, len(p.fonts))
+	for style := range p.fonts {
+		styles = append(styles, style)
+	}
+	sort.Slice(styles, func(i, j int) bool { return p.fonts[styles[i]].id < p.fonts[styles[j]].id })
+	for _, style := range styles {
+		p.fonts[style].write(p)
+	}
+
+	kids := make([]string, len(p.pages))
+	for i, id := range p.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", id)
+	}
+	p.object(p.pageTree, "<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages))
+	p.object(p.catalog, "<< /Type /Catalog /Pages %d 0 R >>", p.pageTree)
+
+	xref := p.written
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		p.printf("%010d 00000 n \n", offset)
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, p.catalog, xref)
+	return p.err
+}
+
+func (p *PDFWriter) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.written += int64(n)
+	p.err = err
+}
+
+func (p *PDFWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(data)
+	p.written += int64(n)
+	p.err = err
+}
+
+// reserve allocates an object number, so that objects can refer to objects
+// written after them
+func (p *PDFWriter) reserve() int {
+	p.offsets = append(p.offsets, 0)
+	return len(p.offsets)
+}
+
+func (p *PDFWriter) object(id int, format string, args ...interface{}) {
+	p.offsets[id-1] = p.written
+	p.printf("%d 0 obj\n", id)
+	p.printf(format, args...)
+	p.printf("\nendobj\n")
+}
+
+// stream writes a stream object compressed with Flate. dict holds any
+// entries besides the length and filter.
+func (p *PDFWriter) stream(id int, dict string, data []byte) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+	p.rawStream(id, dict+" /Filter /FlateDecode", compressed.Bytes())
+}
+
+// rawStream writes a stream object as it is
+func (p *PDFWriter) rawStream(id int, dict string, data []byte) {
+	p.offsets[id-1] = p.written
+	p.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", id, strings.TrimSpace(dict), len(data))
+	p.write(data)
+	p.printf("\nendstream\nendobj\n")
+}
+
+// font returns the font for a style, reserving it on first use
+func (p *PDFWriter) font(style fontStyle) (*pdfFont, error) {
+	if f, ok := p.fonts[style]; ok {
+		return f, nil
+	}
+	parsed, err := parsedFont(style)
+	if err != nil {
+		return nil, err
+	}
+	f := &pdfFont{
+		id:     p.reserve(),
+		name:   fmt.Sprintf("F%d", len(p.fonts)+1),
+		style:  style,
+		font:   parsed,
+		used:   make(map[sfnt.GlyphIndex]rune),
+		widths: make(map[sfnt.GlyphIndex]int),
+	}
+	p.fonts[style] = f
+	return f, nil
+}
+
+// pdfPage collects the content and resources of one page. The forms drawn
+// on a page share its resource dictionary.
+type pdfPage struct {
+	writer        *PDFWriter
+	width, height float64
+	content       bytes.Buffer
+
+	resources int
+	// By name: inline dictionaries for graphics states, references for
+	// the others
+	extGState map[string]string
+	xObjects  map[string]string
+	fonts     map[string]string
+}
+
+func (pg *pdfPage) resourceDict() string {
+	var dict strings.Builder
+	dict.WriteString("<<")
+	for _, kind := range []struct {
+		name    string
+		entries map[string]string
+	}{{"ExtGState", pg.extGState}, {"XObject", pg.xObjects}, {"Font", pg.fonts}} {
+		if len(kind.entries) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(kind.entries))
+		for name := range kind.entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&dict, " /%s <<", kind.name)
+		for _, name := range names {
+			fmt.Fprintf(&dict, " /%s %s", name, kind.entries[name])
+		}
+		dict.WriteString(" >>")
+	}
+	dict.WriteString(" >>")
+	return dict.String()
+}
+
+// graphicsState returns the name of a graphics state dictionary, adding it
+// to the page's resources
+func (pg *pdfPage) graphicsState(dict string) string {
+	for name, existing := range pg.extGState {
+		if existing == dict {
+			return name
+		}
+	}
+	name := fmt.Sprintf("G%d", len(pg.extGState)+1)
+	pg.extGState[name] = dict
+	return name
+}
+
+// opacity returns the graphics state for drawing at an alpha below 255
+func (pg *pdfPage) opacity(alpha uint8) string {
+	value := formatNumber(float64(alpha) / 255)
+	return pg.graphicsState(fmt.Sprintf("<< /CA %s /ca %s >>", value, value))
+}
+
+func (pg *pdfPage) xObject(id int) string {
+	name := fmt.Sprintf("X%d", len(pg.xObjects)+1)
+	pg.xObjects[name] = fmt.Sprintf("%d 0 R", id)
+	return name
+}
+
+// form writes content as a transparency group covering the page and
+// returns its object number
+func (pg *pdfPage) form(content []byte, group string) int {
+	id := pg.writer.reserve()
+	pg.writer.stream(id, fmt.Sprintf("/Type /XObject /Subtype /Form /BBox [0 0 %s %s] /Group << /S /Transparency%s >> /Resources %d 0 R",
+		formatNumber(pg.width), formatNumber(pg.height), group, pg.resources), content)
+	return id
+}
+
+func (pg *pdfPage) template(template Template) {
+	content := &pg.content
+	fmt.Fprintf(content, "%s rg 0 0 %s %s re f\n", pdfColor(template.Background), formatNumber(pg.width), formatNumber(pg.height))
+
+	lines := template.Lines(pg.width, pg.height)
+	if len(lines) > 0 {
+		fmt.Fprintf(content, "q %s RG %s w 0 J\n", pdfColor(template.Line), formatNumber(template.LineWidth))
+		for _, line := range lines {
+			fmt.Fprintf(content, "%s %s m %s %s l\n", formatNumber(line.From.X), formatNumber(line.From.Y), formatNumber(line.To.X), formatNumber(line.To.Y))
+		}
+		content.WriteString("S Q\n")
+	}
+
+	dots := template.Dots(pg.width, pg.height)
+	if len(dots) > 0 {
+		fmt.Fprintf(content, "q %s rg\n", pdfColor(template.Line))
+		for _, dot := range dots {
+			pdfCircle(content, dot, DotRadius)
+		}
+		content.WriteString("f Q\n")
+	}
+}
+
+// PDFImage is a background image ready to be embedded. JPEGs are embedded
+// as they are; other formats are decoded and stored losslessly.
+type PDFImage struct {
+	width, height int
+	dict          string // entries describing the image data
+	data          []byte
+	compress      bool
+	alpha         []byte // soft mask, nil when the image is opaque
+}
+
+// NewPDFImage reads an encoded image for AddPage
+func NewPDFImage(data []byte) (*PDFImage, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if format == "jpeg" && (config.ColorModel == color.YCbCrModel || config.ColorModel == color.GrayModel) {
+		colorSpace := "/DeviceRGB"
+		if config.ColorModel == color.GrayModel {
+			colorSpace = "/DeviceGray"
+		}
+		return &PDFImage{
+			width:  config.Width,
+			height: config.Height,
+			dict:   "/ColorSpace " + colorSpace + " /BitsPerComponent 8 /Filter /DCTDecode",
+			data:   data,
+		}, nil
+	}
+
+	img, err := DecodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	rgb := make([]byte, 0, bounds.Dx()*bounds.Dy()*3)
+	alpha := make([]byte, 0, bounds.Dx()*bounds.Dy())
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			rgb = append(rgb, c.R, c.G, c.B)
+			alpha = append(alpha, c.A)
+			opaque = opaque && c.A == 0xFF
+		}
+	}
+	if opaque {
+		alpha = nil
+	}
+	return &PDFImage{
+		width:    bounds.Dx(),
+		height:   bounds.Dy(),
+		dict:     "/ColorSpace /DeviceRGB /BitsPerComponent 8",
+		data:     rgb,
+		compress: true,
+		alpha:    alpha,
+	}, nil
+}
+
+// image draws a background image contained in the page
+func (pg *pdfPage) image(img *PDFImage) {
+	writer := pg.writer
+	dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d %s", img.width, img.height, img.dict)
+	if img.alpha != nil {
+		mask := writer.reserve()
+		writer.stream(mask, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8",
+			img.width, img.height), img.alpha)
+		dict += fmt.Sprintf(" /SMask %d 0 R", mask)
+	}
+	id := writer.reserve()
+	if img.compress {
+		writer.stream(id, dict, img.data)
+	} else {
+		writer.rawStream(id, dict, img.data)
+	}
+
+	// Images fill the unit square bottom up, so they are flipped back
+	x, y, width, height := ContainRect(pg.width, pg.height, img.width, img.height)
+	fmt.Fprintf(&pg.content, "q %s 0 0 %s %s %s cm /%s Do Q\n",
+		formatNumber(width), formatNumber(-height), formatNumber(x), formatNumber(y+height), pg.xObject(id))
+}
+
+// strokes draws the strokes in order. Erased strokes are put in a form that
+// is painted through a soft mask, white except where the eraser went.
+func (pg *pdfPage) strokes(strokes []models.DrawingPoint) {
+	draw := func(layer *bytes.Buffer, stroke models.DrawingPoint) {
+		c := Color(stroke.Color)
+		layer.WriteString("q ")
+		if c.A < 0xFF {
+			fmt.Fprintf(layer, "/%s gs ", pg.opacity(c.A))
+		}
+		fmt.Fprintf(layer, "%s RG ", pdfColor(c))
+		pdfStroke(layer, stroke)
+		layer.WriteString(" Q\n")
+	}
+	erase := func(mask *bytes.Buffer, stroke models.DrawingPoint) {
+		mask.WriteString("q 0 G ")
+		pdfStroke(mask, stroke)
+		mask.WriteString(" Q\n")
+	}
+	applyMask := func(layer, mask []byte) string {
+		layerID := pg.form(layer, "")
+		maskContent := fmt.Sprintf("1 g 0 0 %s %s re f\n%s", formatNumber(pg.width), formatNumber(pg.height), mask)
+		maskID := pg.form([]byte(maskContent), " /CS /DeviceGray")
+		state := pg.graphicsState(fmt.Sprintf("<< /SMask << /Type /Mask /S /Luminosity /G %d 0 R >> >>", maskID))
+		return fmt.Sprintf("q /%s gs /%s Do Q\n", state, pg.xObject(layerID))
+	}
+	pg.content.Write(maskStrokes(strokes, draw, erase, applyMask))
+}
+
+// pdfStroke writes a stroke's path with round caps and joins, like the
+// client's
+func pdfStroke(buf *bytes.Buffer, stroke models.DrawingPoint) {
+	fmt.Fprintf(buf, "%s w 1 J 1 j ", formatNumber(stroke.Width))
+	writeStrokePath(buf, stroke, "%s %s m", " %s %s l")
+	buf.WriteString(" S")
+}
+
+// text draws an annotation as text the reader can select. Bubbles are drawn
+// open, as a box around their text.
+func (pg *pdfPage) text(annotation models.TextAnnotation) error {
+	style := fontStyle{annotation.IsBold, annotation.IsItalic}
+	size := FontSize(annotation)
+	fill := Color(annotation.Color)
+	x, y := annotation.Position.X, annotation.Position.Y
+	if annotation.IsBubble {
+		style, size, fill = fontStyle{}, BubbleFontSize, color.NRGBA{A: 0xFF}
+	}
+
+	f, err := pg.writer.font(style)
+	if err != nil {
+		return err
+	}
+	pg.fonts[f.name] = fmt.Sprintf("%d 0 R", f.id)
+
+	ascent, _, lineHeight := f.metrics()
+	lines := strings.Split(annotation.Text, "\n")
+	encoded := make([]string, len(lines))
+	textWidth := 0.0
+	for i, line := range lines {
+		var width float64
+		encoded[i], width = f.encode(line)
+		textWidth = math.Max(textWidth, width*size/1000)
+	}
+
+	content := &pg.content
+	if annotation.IsBubble {
+		boxWidth := textWidth + 2*BubblePadding
+		boxHeight := float64(len(lines))*lineHeight*size/1000 + 2*BubblePadding
+		fmt.Fprintf(content, "q /%s gs 0 g ", pg.graphicsState(fmt.Sprintf("<< /ca %s >>", formatNumber(BubbleOpacity))))
+		pdfRoundedRect(content, x, y, boxWidth, boxHeight, BubbleRadius)
+		content.WriteString("f Q\nq 0 G 1 w ")
+		pdfRoundedRect(content, x, y, boxWidth, boxHeight, BubbleRadius)
+		content.WriteString("S Q\n")
+		x, y = x+BubblePadding, y+BubblePadding
+	}
+
+	content.WriteString("q ")
+	if fill.A < 0xFF {
+		fmt.Fprintf(content, "/%s gs ", pg.opacity(fill.A))
+	}
+	fmt.Fprintf(content, "%s rg BT /%s %s Tf\n", pdfColor(fill), f.name, formatNumber(size))
+	baseline := y + ascent*size/1000
+	for _, line := range encoded {
+		// The text matrix flips glyphs upright again
+		fmt.Fprintf(content, "1 0 0 -1 %s %s Tm %s Tj\n", formatNumber(x), formatNumber(baseline), line)
+		baseline += lineHeight * size / 1000
+	}
+	content.WriteString("ET Q\n")
+	return nil
+}
+
+// Control points of a quarter circle drawn as a Bézier curve
+const kappa = 0.5522847498
+
+func pdfCircle(buf *bytes.Buffer, center Point, radius float64) {
+	k := radius * kappa
+	cx, cy := center.X, center.Y
+	fmt.Fprintf(buf, "%s %s m ", formatNumber(cx+radius), formatNumber(cy))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(cx+radius), formatNumber(cy+k), formatNumber(cx+k), formatNumber(cy+radius), formatNumber(cx), formatNumber(cy+radius))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(cx-k), formatNumber(cy+radius), formatNumber(cx-radius), formatNumber(cy+k), formatNumber(cx-radius), formatNumber(cy))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(cx-radius), formatNumber(cy-k), formatNumber(cx-k), formatNumber(cy-radius), formatNumber(cx), formatNumber(cy-radius))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c h\n", formatNumber(cx+k), formatNumber(cy-radius), formatNumber(cx+radius), formatNumber(cy-k), formatNumber(cx+radius), formatNumber(cy))
+}
+
+func pdfRoundedRect(buf *bytes.Buffer, x, y, width, height, radius float64) {
+	radius = math.Min(radius, math.Min(width, height)/2)
+	k := radius * kappa
+	right, bottom := x+width, y+height
+	fmt.Fprintf(buf, "%s %s m ", formatNumber(x+radius), formatNumber(y))
+	fmt.Fprintf(buf, "%s %s l ", formatNumber(right-radius), formatNumber(y))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(right-radius+k), formatNumber(y), formatNumber(right), formatNumber(y+radius-k), formatNumber(right), formatNumber(y+radius))
+	fmt.Fprintf(buf, "%s %s l ", formatNumber(right), formatNumber(bottom-radius))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(right), formatNumber(bottom-radius+k), formatNumber(right-radius+k), formatNumber(bottom), formatNumber(right-radius), formatNumber(bottom))
+	fmt.Fprintf(buf, "%s %s l ", formatNumber(x+radius), formatNumber(bottom))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c ", formatNumber(x+radius-k), formatNumber(bottom), formatNumber(x), formatNumber(bottom-radius+k), formatNumber(x), formatNumber(bottom-radius))
+	fmt.Fprintf(buf, "%s %s l ", formatNumber(x), formatNumber(y+radius))
+	fmt.Fprintf(buf, "%s %s %s %s %s %s c h ", formatNumber(x), formatNumber(y+radius-k), formatNumber(x+radius-k), formatNumber(y), formatNumber(x+radius), formatNumber(y))
+}
+
+// pdfColor formats the RGB part of a color as three operands
+func pdfColor(c color.NRGBA) string {
+	return fmt.Sprintf("%s %s %s", formatNumber(float64(c.R)/255), formatNumber(float64(c.G)/255), formatNumber(float64(c.B)/255))
+}
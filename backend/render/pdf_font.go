@@ -0,0 +1,153 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// PDF font sizes are in thousandths of an em, so fonts are measured at a
+// size of 1000
+var pdfEm = fixed.I(1000)
+
+// pdfFont is one of the Go fonts, embedded whole as a composite font whose
+// character codes are glyph indexes. The glyphs used are recorded for the
+// width table and for the ToUnicode map, which lets readers copy the text.
+type pdfFont struct {
+	id     int
+	name   string // resource name on pages
+	style  fontStyle
+	font   *sfnt.Font
+	buf    sfnt.Buffer
+	used   map[sfnt.GlyphIndex]rune
+	widths map[sfnt.GlyphIndex]int
+}
+
+// pdfFontNames are the PostScript names of the Go fonts
+var pdfFontNames = map[fontStyle]string{
+	{false, false}: "GoRegular",
+	{true, false}:  "GoBold",
+	{false, true}:  "GoItalic",
+	{true, true}:   "GoBoldItalic",
+}
+
+// encode returns a line of text as a hex string of glyph indexes and its
+// width in thousandths of an em
+func (f *pdfFont) encode(text string) (string, float64) {
+	var codes strings.Builder
+	codes.WriteByte('<')
+	width := 0
+	for _, r := range text {
+		glyph, err := f.font.GlyphIndex(&f.buf, r)
+		if err != nil {
+			glyph = 0
+		}
+		advance, ok := f.widths[glyph]
+		if !ok {
+			value, err := f.font.GlyphAdvance(&f.buf, glyph, pdfEm, font.HintingNone)
+			if err == nil {
+				advance = value.Round()
+			}
+			f.widths[glyph] = advance
+		}
+		if _, ok := f.used[glyph]; !ok && glyph != 0 {
+			f.used[glyph] = r
+		}
+		width += advance
+		fmt.Fprintf(&codes, "%04X", uint16(glyph))
+	}
+	codes.WriteByte('>')
+	return codes.String(), float64(width)
+}
+
+// metrics returns the ascent, descent and line height in thousandths of an em
+func (f *pdfFont) metrics() (float64, float64, float64) {
+	metrics, err := f.font.Metrics(&f.buf, pdfEm, font.HintingNone)
+	if err != nil {
+		return 800, 200, 1200
+	}
+	return float64(metrics.Ascent.Round()), float64(metrics.Descent.Round()), float64(metrics.Height.Round())
+}
+
+// write writes the font and its descendants under the reserved f.id
+func (f *pdfFont) write(p *PDFWriter) {
+	baseFont := pdfFontNames[f.style]
+
+	file := p.reserve()
+	data := fontFiles[f.style]
+	p.stream(file, fmt.Sprintf("/Length1 %d", len(data)), data)
+
+	bounds, err := f.font.Bounds(&f.buf, pdfEm, font.HintingNone)
+	if err != nil {
+		bounds = fixed.R(0, -800, 1000, 200)
+	}
+	ascent, descent, _ := f.metrics()
+	flags := 32 // nonsymbolic
+	if f.style.italic {
+		flags |= 64
+	}
+	italicAngle := 0.0
+	if post := f.font.PostTable(); post != nil {
+		italicAngle = post.ItalicAngle
+	}
+	descriptor := p.reserve()
+	p.object(descriptor, "<< /Type /FontDescriptor /FontName /%s /Flags %d /FontBBox [%d %d %d %d] /ItalicAngle %s /Ascent %s /Descent %s /CapHeight %s /StemV 80 /FontFile2 %d 0 R >>",
+		baseFont, flags, bounds.Min.X.Round(), -bounds.Max.Y.Round(), bounds.Max.X.Round(), -bounds.Min.Y.Round(),
+		formatNumber(italicAngle), formatNumber(ascent), formatNumber(-descent), formatNumber(ascent), file)
+
+	glyphs := make([]int, 0, len(f.widths))
+	for glyph := range f.widths {
+		glyphs = append(glyphs, int(glyph))
+	}
+	sort.Ints(glyphs)
+	var widths strings.Builder
+	for _, glyph := range glyphs {
+		fmt.Fprintf(&widths, "%d [%d] ", glyph, f.widths[sfnt.GlyphIndex(glyph)])
+	}
+	descendant := p.reserve()
+	p.object(descendant, "<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /DW 1000 /W [%s] /CIDToGIDMap /Identity >>",
+		baseFont, descriptor, widths.String())
+
+	toUnicode := p.reserve()
+	p.stream(toUnicode, "", []byte(f.toUnicode(glyphs)))
+
+	p.object(f.id, "<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		baseFont, descendant, toUnicode)
+}
+
+// toUnicode builds the CMap from glyph indexes back to text
+func (f *pdfFont) toUnicode(glyphs []int) string {
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n")
+	cmap.WriteString("/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n")
+	cmap.WriteString("/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n")
+	cmap.WriteString("1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+
+	var mapped []int
+	for _, glyph := range glyphs {
+		if _, ok := f.used[sfnt.GlyphIndex(glyph)]; ok {
+			mapped = append(mapped, glyph)
+		}
+	}
+	// A bfchar block holds at most 100 entries
+	for start := 0; start < len(mapped); start += 100 {
+		block := mapped[start:min(start+100, len(mapped))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(block))
+		for _, glyph := range block {
+			fmt.Fprintf(&cmap, "<%04X> <", glyph)
+			for _, unit := range utf16.Encode([]rune{f.used[sfnt.GlyphIndex(glyph)]}) {
+				fmt.Fprintf(&cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return cmap.String()
+}
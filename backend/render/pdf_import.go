@@ -0,0 +1,383 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+)
+
+// PDFRenderDPI is the resolution imported pages are rendered at. Like the
+// client, which rasterizes pages at twice their size in points, it is twice
+// the 72 points per inch of PDF.
+const PDFRenderDPI = 144
+
+// Limits on the images made from an uploaded PDF. Pages too large to render
+// at PDFRenderDPI are scaled down to maxRenderSide pixels on their long
+// side, and scanned images of more than maxScanPixels are not decoded.
+const (
+	maxRenderSide = 8192
+	maxScanPixels = 50 << 20
+)
+
+var (
+	ErrInvalidPDF   = errors.New("not a valid PDF")
+	ErrEncryptedPDF = errors.New("encrypted PDFs are not supported")
+
+	// errNoPageImage is returned for pages that are not a single scanned
+	// image when no renderer is installed
+	errNoPageImage = errors.New("page is not a scanned image and pdftoppm is not installed")
+)
+
+// PDFPage is the size of a page in points, as it is displayed
+type PDFPage struct {
+	Width  float64
+	Height float64
+}
+
+// PDFSource is an uploaded PDF whose pages are imported as papers. Pages are
+// rendered with pdftoppm when it is installed, otherwise scanned pages are
+// imported from the image they consist of.
+type PDFSource struct {
+	Pages []PDFPage
+
+	data  []byte
+	doc   *pdfDocument
+	pages []pdfSourcePage
+	path  string // the PDF written out for pdftoppm
+}
+
+// OpenPDF reads the pages of a PDF
+func OpenPDF(data []byte) (*PDFSource, error) {
+	doc, err := readPDF(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPDF, err)
+	}
+	if doc.trailer["Encrypt"] != nil {
+		return nil, ErrEncryptedPDF
+	}
+
+	source := &PDFSource{data: data, doc: doc, pages: doc.pages()}
+	for _, page := range source.pages {
+		width, height := page.size()
+		source.Pages = append(source.Pages, PDFPage{Width: width, Height: height})
+	}
+	return source, nil
+}
+
+// Close removes the temporary copy made for pdftoppm
+func (s *PDFSource) Close() error {
+	if s.path == "" {
+		return nil
+	}
+	return os.Remove(s.path)
+}
+
+// Background returns the image for the page at index as a background and
+// its file extension
+func (s *PDFSource) Background(ctx context.Context, index int) ([]byte, string, error) {
+	if index < 0 || index >= len(s.pages) {
+		return nil, "", fmt.Errorf("page %d out of range", index+1)
+	}
+
+	renderErr := errNoPageImage
+	if path := pdftoppmPath(); path != "" {
+		data, err := s.render(ctx, path, index)
+		if err == nil {
+			return data, "png", nil
+		}
+		renderErr = err
+	}
+	if data, ext, ok := s.scannedImage(s.pages[index]); ok {
+		return data, ext, nil
+	}
+	return nil, "", renderErr
+}
+
+var (
+	pdftoppmOnce sync.Once
+	pdftoppm     string
+)
+
+// pdftoppmPath finds the renderer from poppler-utils, at PDFTOPPM_PATH or on
+// the PATH. It is empty when pages cannot be rendered.
+func pdftoppmPath() string {
+	pdftoppmOnce.Do(func() {
+		if value := os.Getenv("PDFTOPPM_PATH"); value != "" {
+			pdftoppm = value
+			return
+		}
+		if path, err := exec.LookPath("pdftoppm"); err == nil {
+			pdftoppm = path
+			return
+		}
+		log.Printf("pdftoppm not found, only scanned PDF pages will get a background on import")
+	})
+	return pdftoppm
+}
+
+// render rasterizes a page of the crop box to PNG with pdftoppm
+func (s *PDFSource) render(ctx context.Context, path string, index int) ([]byte, error) {
+	if s.path == "" {
+		file, err := os.CreateTemp("", "import-*.pdf")
+		if err != nil {
+			return nil, err
+		}
+		s.path = file.Name()
+		_, err = file.Write(s.data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page := strconv.Itoa(index + 1)
+	args := []string{"-png", "-cropbox", "-singlefile", "-f", page, "-l", page}
+	width, height := s.pages[index].size()
+	if math.Max(width, height)*PDFRenderDPI/72 > maxRenderSide {
+		args = append(args, "-scale-to", strconv.Itoa(maxRenderSide))
+	} else {
+		args = append(args, "-r", strconv.Itoa(PDFRenderDPI))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, path, append(args, s.path)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm failed on page %s: %v: %s", page, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("pdftoppm produced no image for page %s", page)
+	}
+	return stdout.Bytes(), nil
+}
+
+// scannedImage returns the image of a page that consists of a single image
+// with the proportions of the page, as scanners produce. JPEG scans are kept
+// as they are unless the page is rotated, anything else is converted to PNG.
+func (s *PDFSource) scannedImage(page pdfSourcePage) ([]byte, string, bool) {
+	var scan *pdfStream
+	for _, value := range s.doc.dict(page.resources["XObject"]) {
+		stream, ok := s.doc.resolve(value).(*pdfStream)
+		if !ok || stream.dict["Subtype"] != pdfName("Image") {
+			continue
+		}
+		if scan != nil {
+			return nil, "", false
+		}
+		scan = stream
+	}
+	if scan == nil {
+		return nil, "", false
+	}
+
+	width, okWidth := s.doc.number(scan.dict["Width"])
+	height, okHeight := s.doc.number(scan.dict["Height"])
+	boxWidth, boxHeight := page.box[2]-page.box[0], page.box[3]-page.box[1]
+	if !okWidth || !okHeight || width < 1 || height < 1 || width*height > maxScanPixels ||
+		math.Abs(width/height-boxWidth/boxHeight) > 0.02*boxWidth/boxHeight {
+		return nil, "", false
+	}
+
+	data, filter, err := s.doc.decodeUntil(scan, "DCTDecode")
+	if err != nil {
+		return nil, "", false
+	}
+	var img image.Image
+	if filter == "DCTDecode" {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		// CMYK JPEGs in PDFs are often stored inverted, so they are skipped
+		if err != nil || config.ColorModel == color.CMYKModel || config.Width*config.Height > maxScanPixels {
+			return nil, "", false
+		}
+		if page.rotate == 0 {
+			return data, "jpg", true
+		}
+		if img, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+			return nil, "", false
+		}
+	} else if img = s.doc.samples(scan, data, int(width), int(height)); img == nil {
+		return nil, "", false
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, rotateImage(img, page.rotate)); err != nil {
+		return nil, "", false
+	}
+	return out.Bytes(), "png", true
+}
+
+// samples builds an image from decoded image samples in a gray, RGB or
+// indexed color space. Other color spaces return nil.
+func (d *pdfDocument) samples(stream *pdfStream, data []byte, width, height int) image.Image {
+	bits := 8
+	if value, ok := d.number(stream.dict["BitsPerComponent"]); ok {
+		bits = int(value)
+	}
+	if bits != 1 && bits != 2 && bits != 4 && bits != 8 {
+		return nil
+	}
+
+	components, palette := d.colorSpace(stream.dict["ColorSpace"])
+	if components == 0 {
+		return nil
+	}
+	perPixel := components
+	if palette != nil {
+		perPixel = 1
+	}
+	rowSize := (width*perPixel*bits + 7) / 8
+	if len(data) < rowSize*height {
+		return nil
+	}
+
+	// Decode maps each sample onto a range, [1 0] inverts gray scans
+	levels := float64(int(1)<<bits - 1)
+	decode := make([]float64, 0, perPixel*2)
+	for _, value := range d.array(stream.dict["Decode"]) {
+		number, _ := d.number(value)
+		decode = append(decode, number)
+	}
+	if len(decode) != perPixel*2 {
+		decode = decode[:0]
+		for i := 0; i < perPixel; i++ {
+			if palette != nil {
+				decode = append(decode, 0, levels)
+			} else {
+				decode = append(decode, 0, 1)
+			}
+		}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	pixel := make([]float64, perPixel)
+	for y := 0; y < height; y++ {
+		row := data[y*rowSize : (y+1)*rowSize]
+		bit := 0
+		for x := 0; x < width; x++ {
+			for c := range pixel {
+				sample := int(row[bit/8]>>(8-bits-bit%8)) & (int(1)<<bits - 1)
+				bit += bits
+				pixel[c] = decode[c*2] + float64(sample)*(decode[c*2+1]-decode[c*2])/levels
+			}
+
+			var rgb [3]uint8
+			switch {
+			case palette != nil:
+				entry := int(math.Round(pixel[0]))
+				rgb = palette[max(0, min(entry, len(palette)-1))]
+			case components == 1:
+				gray := toByte(pixel[0])
+				rgb = [3]uint8{gray, gray, gray}
+			default:
+				rgb = [3]uint8{toByte(pixel[0]), toByte(pixel[1]), toByte(pixel[2])}
+			}
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = rgb[0], rgb[1], rgb[2], 255
+		}
+	}
+	return img
+}
+
+func toByte(value float64) uint8 {
+	return uint8(math.Round(math.Max(0, math.Min(1, value)) * 255))
+}
+
+// colorSpace returns the number of components of a gray or RGB color space,
+// and the palette of an indexed one as RGB entries. Unsupported color spaces
+// have no components.
+func (d *pdfDocument) colorSpace(value interface{}) (int, [][3]uint8) {
+	switch space := d.resolve(value).(type) {
+	case pdfName:
+		switch space {
+		case "DeviceGray", "G", "CalGray":
+			return 1, nil
+		case "DeviceRGB", "RGB", "CalRGB":
+			return 3, nil
+		}
+	case []interface{}:
+		if len(space) == 0 {
+			return 0, nil
+		}
+		name, _ := d.resolve(space[0]).(pdfName)
+		switch name {
+		case "CalGray":
+			return 1, nil
+		case "CalRGB":
+			return 3, nil
+		case "ICCBased":
+			if len(space) > 1 {
+				if n, ok := d.number(d.dict(space[1])["N"]); ok && (n == 1 || n == 3) {
+					return int(n), nil
+				}
+			}
+		case "Indexed", "I":
+			if len(space) < 4 {
+				return 0, nil
+			}
+			base, _ := d.colorSpace(space[1])
+			var lookup []byte
+			switch table := d.resolve(space[3]).(type) {
+			case []byte:
+				lookup = table
+			case *pdfStream:
+				lookup, _ = d.decode(table)
+			}
+			if base == 0 || len(lookup) < base {
+				return 0, nil
+			}
+			palette := make([][3]uint8, len(lookup)/base)
+			for i := range palette {
+				entry := lookup[i*base : (i+1)*base]
+				if base == 1 {
+					palette[i] = [3]uint8{entry[0], entry[0], entry[0]}
+				} else {
+					palette[i] = [3]uint8{entry[0], entry[1], entry[2]}
+				}
+			}
+			return 1, palette
+		}
+	}
+	return 0, nil
+}
+
+// rotateImage turns an image clockwise by a multiple of 90 degrees, as the
+// /Rotate of a page turns it when displayed
+func rotateImage(img image.Image, degrees int) image.Image {
+	if degrees == 0 {
+		return img
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	size := image.Rect(0, 0, width, height)
+	if degrees != 180 {
+		size = image.Rect(0, 0, height, width)
+	}
+	rotated := image.NewNRGBA(size)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+			switch degrees {
+			case 90:
+				rotated.Set(height-1-y, x, c)
+			case 180:
+				rotated.Set(width-1-x, height-1-y, c)
+			case 270:
+				rotated.Set(y, width-1-x, c)
+			}
+		}
+	}
+	return rotated
+}
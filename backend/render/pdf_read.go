@@ -0,0 +1,985 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// The objects of a parsed PDF are nil, bool, int, float64, []byte for
+// strings, pdfName, []interface{} for arrays, pdfDict, pdfRef and *pdfStream.
+// Only as much of the format is read as importing pages needs: the
+// cross-reference data, the page tree and image XObjects.
+
+type pdfName string
+
+type pdfDict map[pdfName]interface{}
+
+type pdfRef struct {
+	num, gen int
+}
+
+type pdfStream struct {
+	dict pdfDict
+	raw  []byte
+}
+
+// pdfKeyword is a bare word such as obj, stream or trailer
+type pdfKeyword string
+
+var errPDFSyntax = errors.New("malformed PDF")
+
+// Limits on the work an uploaded PDF can make the reader do
+const (
+	maxPDFNesting      = 100       // arrays and dictionaries inside each other
+	maxPDFObjectDepth  = 64        // objects loaded while loading another
+	maxPDFStreamSize   = 128 << 20 // decompressed size of one stream
+	maxPDFInflatedSize = 512 << 20 // decompressed size of all streams of a file
+	maxPDFColumns      = 1 << 16   // samples per row of predicted data
+)
+
+// pdfLexer reads objects from PDF syntax
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int // of the arrays and dictionaries being read
+}
+
+// nest enters an array or dictionary, failing on files nested deeply enough
+// to exhaust the stack
+func (l *pdfLexer) nest() error {
+	if l.depth >= maxPDFNesting {
+		return fmt.Errorf("%w: objects nested too deeply at %d", errPDFSyntax, l.pos)
+	}
+	l.depth++
+	return nil
+}
+
+func isPDFSpace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isPDFDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == '<' || c == '>' || c == '[' || c == ']' || c == '{' || c == '}' || c == '/' || c == '%'
+}
+
+// skipSpace skips white space and comments
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) {
+		switch c := l.data[l.pos]; {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *pdfLexer) hasPrefix(prefix string) bool {
+	return bytes.HasPrefix(l.data[l.pos:], []byte(prefix))
+}
+
+// word reads characters up to the next white space or delimiter
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+func (l *pdfLexer) object() (interface{}, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	switch c := l.data[l.pos]; {
+	case c == '/':
+		l.pos++
+		return l.name(), nil
+	case c == '(':
+		l.pos++
+		return l.literalString()
+	case l.hasPrefix("<<"):
+		l.pos += 2
+		return l.dict()
+	case c == '<':
+		l.pos++
+		return l.hexString()
+	case c == '[':
+		l.pos++
+		return l.array()
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.numberOrRef(), nil
+	}
+
+	word := l.word()
+	switch word {
+	case "":
+		return nil, fmt.Errorf("%w: unexpected %q at %d", errPDFSyntax, l.data[l.pos], l.pos)
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return pdfKeyword(word), nil
+}
+
+func (l *pdfLexer) number() interface{} {
+	word := l.word()
+	if value, err := strconv.Atoi(word); err == nil {
+		return value
+	}
+	value, _ := strconv.ParseFloat(word, 64)
+	return value
+}
+
+// numberOrRef reads a number, or a reference when the number is followed by
+// a generation and R
+func (l *pdfLexer) numberOrRef() interface{} {
+	value := l.number()
+	num, ok := value.(int)
+	if !ok {
+		return value
+	}
+
+	start := l.pos
+	l.skipSpace()
+	if l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '9' {
+		if gen, ok := l.number().(int); ok {
+			l.skipSpace()
+			if l.word() == "R" {
+				return pdfRef{num, gen}
+			}
+		}
+	}
+	l.pos = start
+	return num
+}
+
+func (l *pdfLexer) name() pdfName {
+	word := []byte(l.word())
+	var name []byte
+	for i := 0; i < len(word); i++ {
+		if word[i] == '#' && i+2 < len(word) {
+			if value, err := strconv.ParseUint(string(word[i+1:i+3]), 16, 8); err == nil {
+				name = append(name, byte(value))
+				i += 2
+				continue
+			}
+		}
+		name = append(name, word[i])
+	}
+	return pdfName(name)
+}
+
+func (l *pdfLexer) literalString() ([]byte, error) {
+	var text []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth == 0 {
+				return text, nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				break
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// A backslash at the end of a line continues the string
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		text = append(text, c)
+	}
+	return nil, fmt.Errorf("%w: unterminated string", errPDFSyntax)
+}
+
+func (l *pdfLexer) hexString() ([]byte, error) {
+	var digits []byte
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			text := make([]byte, len(digits)/2)
+			for i := range text {
+				value, _ := strconv.ParseUint(string(digits[i*2:i*2+2]), 16, 8)
+				text[i] = byte(value)
+			}
+			return text, nil
+		}
+		if !isPDFSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	return nil, fmt.Errorf("%w: unterminated hex string", errPDFSyntax)
+}
+
+func (l *pdfLexer) array() ([]interface{}, error) {
+	if err := l.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { l.depth-- }()
+
+	var array []interface{}
+	for {
+		l.skipSpace()
+		if l.pos < len(l.data) && l.data[l.pos] == ']' {
+			l.pos++
+			return array, nil
+		}
+		value, err := l.object()
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, value)
+	}
+}
+
+func (l *pdfLexer) dict() (pdfDict, error) {
+	if err := l.nest(); err != nil {
+		return nil, err
+	}
+	defer func() { l.depth-- }()
+
+	dict := make(pdfDict)
+	for {
+		l.skipSpace()
+		if l.hasPrefix(">>") {
+			l.pos += 2
+			return dict, nil
+		}
+		key, err := l.object()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(pdfName)
+		if !ok {
+			return nil, fmt.Errorf("%w: dictionary key %v", errPDFSyntax, key)
+		}
+		if dict[name], err = l.object(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pdfXref locates an object, either at an offset in the file or inside an
+// object stream
+type pdfXref struct {
+	offset     int
+	stream     int
+	compressed bool
+}
+
+// pdfDocument is a parsed PDF whose objects are loaded as they are needed
+type pdfDocument struct {
+	data    []byte
+	xref    map[int]pdfXref
+	trailer pdfDict
+	objects map[int]interface{}
+	loading map[int]bool
+	streams map[int]map[int]interface{}
+
+	inflated int // bytes decompressed so far
+}
+
+func readPDF(data []byte) (*pdfDocument, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing header", errPDFSyntax)
+	}
+	d := &pdfDocument{
+		data:    data,
+		xref:    make(map[int]pdfXref),
+		objects: make(map[int]interface{}),
+		loading: make(map[int]bool),
+		streams: make(map[int]map[int]interface{}),
+	}
+
+	if err := d.readXrefChain(); err != nil || d.dict(d.trailer["Root"]) == nil {
+		// Damaged or rewritten files often have stale offsets, so fall back
+		// to finding the objects themselves
+		d.xref = make(map[int]pdfXref)
+		d.objects = make(map[int]interface{})
+		d.streams = make(map[int]map[int]interface{})
+		d.trailer = nil
+		d.reconstruct()
+	}
+	if d.dict(d.trailer["Root"]) == nil {
+		return nil, fmt.Errorf("%w: no document catalog", errPDFSyntax)
+	}
+	return d, nil
+}
+
+// readXrefChain reads the newest cross-reference section and the ones it
+// updates. Entries already read take precedence over older ones.
+func (d *pdfDocument) readXrefChain() error {
+	at := bytes.LastIndex(d.data, []byte("startxref"))
+	if at < 0 {
+		return fmt.Errorf("%w: missing startxref", errPDFSyntax)
+	}
+	l := &pdfLexer{data: d.data, pos: at + len("startxref")}
+	l.skipSpace()
+	offset, ok := l.number().(int)
+	if !ok {
+		return fmt.Errorf("%w: invalid startxref", errPDFSyntax)
+	}
+
+	seen := make(map[int]bool)
+	for {
+		if offset <= 0 || offset >= len(d.data) || seen[offset] {
+			return fmt.Errorf("%w: invalid xref offset %d", errPDFSyntax, offset)
+		}
+		seen[offset] = true
+
+		trailer, err := d.readXref(offset)
+		if err != nil {
+			return err
+		}
+		if d.trailer == nil {
+			d.trailer = trailer
+		}
+		// Hybrid files keep the entries of compressed objects in a stream
+		if stream, ok := trailer["XRefStm"].(int); ok && !seen[stream] {
+			seen[stream] = true
+			if _, err := d.readXref(stream); err != nil {
+				return err
+			}
+		}
+		prev, ok := trailer["Prev"].(int)
+		if !ok {
+			return nil
+		}
+		offset = prev
+	}
+}
+
+// readXref reads a cross-reference table or stream and returns its trailer
+func (d *pdfDocument) readXref(offset int) (pdfDict, error) {
+	l := &pdfLexer{data: d.data, pos: offset}
+	l.skipSpace()
+	if !l.hasPrefix("xref") {
+		return d.readXrefStream(offset)
+	}
+	l.pos += len("xref")
+
+	for {
+		token, err := l.object()
+		if err != nil {
+			return nil, err
+		}
+		if token == pdfKeyword("trailer") {
+			trailer, err := l.object()
+			if dict, ok := trailer.(pdfDict); ok && err == nil {
+				return dict, nil
+			}
+			return nil, fmt.Errorf("%w: invalid trailer", errPDFSyntax)
+		}
+
+		start, ok := token.(int)
+		count, err := l.object()
+		if _, isInt := count.(int); !ok || !isInt || err != nil {
+			return nil, fmt.Errorf("%w: invalid xref subsection", errPDFSyntax)
+		}
+		for i := 0; i < count.(int); i++ {
+			offset, _ := l.object()
+			l.object()
+			kind, err := l.object()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := d.xref[start+i]; !ok && kind == pdfKeyword("n") {
+				if offset, ok := offset.(int); ok {
+					d.xref[start+i] = pdfXref{offset: offset}
+				}
+			}
+		}
+	}
+}
+
+// readXrefStream reads a cross-reference stream, whose dictionary is also
+// the trailer
+func (d *pdfDocument) readXrefStream(offset int) (pdfDict, error) {
+	value, err := d.parseAt(offset)
+	if err != nil {
+		return nil, err
+	}
+	stream, ok := value.(*pdfStream)
+	if !ok || stream.dict["Type"] != pdfName("XRef") {
+		return nil, fmt.Errorf("%w: no xref at %d", errPDFSyntax, offset)
+	}
+	data, err := d.decode(stream)
+	if err != nil {
+		return nil, err
+	}
+
+	var widths []int
+	for _, width := range d.array(stream.dict["W"]) {
+		width, _ := width.(int)
+		widths = append(widths, width)
+	}
+	if len(widths) != 3 {
+		return nil, fmt.Errorf("%w: invalid xref stream widths", errPDFSyntax)
+	}
+	index := d.array(stream.dict["Index"])
+	if index == nil {
+		index = []interface{}{0, stream.dict["Size"]}
+	}
+
+	field := func(width int) int {
+		value := 0
+		for i := 0; i < width && len(data) > 0; i++ {
+			value = value<<8 | int(data[0])
+			data = data[1:]
+		}
+		return value
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int)
+		count, _ := index[i+1].(int)
+		for num := start; num < start+count && len(data) > 0; num++ {
+			kind := 1
+			if widths[0] > 0 {
+				kind = field(widths[0])
+			}
+			// The third field is a generation or an index in the object
+			// stream, neither of which is needed to find the object
+			second := field(widths[1])
+			field(widths[2])
+			if _, ok := d.xref[num]; ok {
+				continue
+			}
+			switch kind {
+			case 1:
+				d.xref[num] = pdfXref{offset: second}
+			case 2:
+				d.xref[num] = pdfXref{stream: second, compressed: true}
+			}
+		}
+	}
+	return stream.dict, nil
+}
+
+var pdfObjectHeader = regexp.MustCompile(`(\d+)[ \t\r\n\f\x00]+\d+[ \t\r\n\f\x00]+obj\b`)
+
+// reconstruct rebuilds the cross-reference data by scanning the file for
+// objects. Later definitions replace earlier ones, as incremental updates do.
+func (d *pdfDocument) reconstruct() {
+	for _, match := range pdfObjectHeader.FindAllSubmatchIndex(d.data, -1) {
+		if match[0] > 0 && !isPDFSpace(d.data[match[0]-1]) && !isPDFDelimiter(d.data[match[0]-1]) {
+			continue
+		}
+		num, err := strconv.Atoi(string(d.data[match[2]:match[3]]))
+		if err == nil {
+			d.xref[num] = pdfXref{offset: match[0]}
+		}
+	}
+
+	direct := make([]int, 0, len(d.xref))
+	for num := range d.xref {
+		direct = append(direct, num)
+	}
+	for _, num := range direct {
+		stream, ok := d.object(num).(*pdfStream)
+		if !ok {
+			continue
+		}
+		switch stream.dict["Type"] {
+		case pdfName("ObjStm"):
+			objects, err := d.objectStream(num)
+			if err != nil {
+				continue
+			}
+			for inner := range objects {
+				if _, ok := d.xref[inner]; !ok {
+					d.xref[inner] = pdfXref{stream: num, compressed: true}
+				}
+			}
+		case pdfName("XRef"):
+			if d.trailer == nil || d.dict(d.trailer["Root"]) == nil {
+				d.trailer = stream.dict
+			}
+		}
+	}
+
+	if at := bytes.LastIndex(d.data, []byte("trailer")); at >= 0 {
+		l := &pdfLexer{data: d.data, pos: at + len("trailer")}
+		if trailer, err := l.object(); err == nil {
+			if dict, ok := trailer.(pdfDict); ok && d.dict(dict["Root"]) != nil {
+				d.trailer = dict
+			}
+		}
+	}
+	if d.trailer != nil && d.dict(d.trailer["Root"]) != nil {
+		return
+	}
+	for num := range d.xref {
+		if dict := d.dict(pdfRef{num: num}); dict != nil && dict["Type"] == pdfName("Catalog") {
+			d.trailer = pdfDict{"Root": pdfRef{num: num}}
+			return
+		}
+	}
+}
+
+// parseAt reads the indirect object at an offset in the file
+func (d *pdfDocument) parseAt(offset int) (interface{}, error) {
+	if offset < 0 || offset >= len(d.data) {
+		return nil, fmt.Errorf("%w: offset %d out of range", errPDFSyntax, offset)
+	}
+	l := &pdfLexer{data: d.data, pos: offset}
+	l.object()
+	l.object()
+	if keyword, err := l.object(); err != nil || keyword != pdfKeyword("obj") {
+		return nil, fmt.Errorf("%w: no object at %d", errPDFSyntax, offset)
+	}
+	value, err := l.object()
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := value.(pdfDict)
+	if !ok {
+		return value, nil
+	}
+
+	l.skipSpace()
+	if !l.hasPrefix("stream") {
+		return dict, nil
+	}
+	l.pos += len("stream")
+	if l.pos < len(d.data) && d.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(d.data) && d.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+
+	// Trust /Length only when endstream follows it
+	length, ok := d.resolve(dict["Length"]).(int)
+	end := start + length
+	if !ok || length < 0 || end > len(d.data) || !bytes.HasPrefix(bytes.TrimLeft(d.data[end:], " \t\r\n\f\x00"), []byte("endstream")) {
+		at := bytes.Index(d.data[start:], []byte("endstream"))
+		if at < 0 {
+			return nil, fmt.Errorf("%w: unterminated stream at %d", errPDFSyntax, offset)
+		}
+		end = start + at
+		if end > start && d.data[end-1] == '\n' {
+			end--
+		}
+		if end > start && d.data[end-1] == '\r' {
+			end--
+		}
+	}
+	return &pdfStream{dict: dict, raw: d.data[start:end]}, nil
+}
+
+// objectStream parses the objects packed in an object stream
+func (d *pdfDocument) objectStream(num int) (map[int]interface{}, error) {
+	if objects, ok := d.streams[num]; ok {
+		return objects, nil
+	}
+	d.streams[num] = nil
+
+	stream, ok := d.object(num).(*pdfStream)
+	if !ok {
+		return nil, fmt.Errorf("%w: object stream %d not found", errPDFSyntax, num)
+	}
+	data, err := d.decode(stream)
+	if err != nil {
+		return nil, err
+	}
+	count, _ := d.resolve(stream.dict["N"]).(int)
+	first, _ := d.resolve(stream.dict["First"]).(int)
+	if first < 0 || first > len(data) {
+		return nil, fmt.Errorf("%w: invalid object stream %d", errPDFSyntax, num)
+	}
+
+	header := &pdfLexer{data: data[:first]}
+	objects := make(map[int]interface{}, count)
+	for i := 0; i < count; i++ {
+		inner, err := header.object()
+		if err != nil {
+			break
+		}
+		offset, err := header.object()
+		if err != nil {
+			break
+		}
+		innerNum, ok := inner.(int)
+		at, isInt := offset.(int)
+		if !ok || !isInt || at < 0 || first+at >= len(data) {
+			continue
+		}
+		body := &pdfLexer{data: data, pos: first + at}
+		if value, err := body.object(); err == nil {
+			objects[innerNum] = value
+		}
+	}
+	d.streams[num] = objects
+	return objects, nil
+}
+
+// object loads an object by number. Objects that cannot be read are null.
+func (d *pdfDocument) object(num int) interface{} {
+	if value, ok := d.objects[num]; ok {
+		return value
+	}
+	entry, ok := d.xref[num]
+	// Stream lengths can refer to other streams, so chains of them are cut
+	// off before they exhaust the stack
+	if !ok || d.loading[num] || len(d.loading) >= maxPDFObjectDepth {
+		return nil
+	}
+	d.loading[num] = true
+	defer delete(d.loading, num)
+
+	var value interface{}
+	if entry.compressed {
+		if objects, err := d.objectStream(entry.stream); err == nil {
+			value = objects[num]
+		}
+	} else if parsed, err := d.parseAt(entry.offset); err == nil {
+		value = parsed
+	}
+	d.objects[num] = value
+	return value
+}
+
+// resolve follows references to the object they point at
+func (d *pdfDocument) resolve(value interface{}) interface{} {
+	for depth := 0; depth < 32; depth++ {
+		ref, ok := value.(pdfRef)
+		if !ok {
+			return value
+		}
+		value = d.object(ref.num)
+	}
+	return nil
+}
+
+func (d *pdfDocument) dict(value interface{}) pdfDict {
+	switch value := d.resolve(value).(type) {
+	case pdfDict:
+		return value
+	case *pdfStream:
+		return value.dict
+	}
+	return nil
+}
+
+func (d *pdfDocument) array(value interface{}) []interface{} {
+	array, _ := d.resolve(value).([]interface{})
+	return array
+}
+
+func (d *pdfDocument) number(value interface{}) (float64, bool) {
+	switch value := d.resolve(value).(type) {
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// filters lists the filters of a stream with their parameters
+func (d *pdfDocument) filters(stream *pdfStream) ([]pdfName, []pdfDict) {
+	var names []pdfName
+	var params []pdfDict
+	switch filter := d.resolve(stream.dict["Filter"]).(type) {
+	case pdfName:
+		names = append(names, filter)
+		params = append(params, d.dict(stream.dict["DecodeParms"]))
+	case []interface{}:
+		parms := d.array(stream.dict["DecodeParms"])
+		for i, name := range filter {
+			name, _ := d.resolve(name).(pdfName)
+			names = append(names, name)
+			if i < len(parms) {
+				params = append(params, d.dict(parms[i]))
+			} else {
+				params = append(params, nil)
+			}
+		}
+	}
+	return names, params
+}
+
+// decode returns the data of a stream with all of its filters applied
+func (d *pdfDocument) decode(stream *pdfStream) ([]byte, error) {
+	data, remaining, err := d.decodeUntil(stream, "")
+	if err == nil && remaining != "" {
+		err = fmt.Errorf("unsupported PDF filter %s", remaining)
+	}
+	return data, err
+}
+
+// decodeUntil applies the filters of a stream up to the filter named stop,
+// which is returned along with the data still encoded by it
+func (d *pdfDocument) decodeUntil(stream *pdfStream, stop pdfName) ([]byte, pdfName, error) {
+	data := stream.raw
+	names, params := d.filters(stream)
+	for i, name := range names {
+		switch {
+		case name == stop:
+			if i != len(names)-1 {
+				return nil, "", fmt.Errorf("unsupported PDF filter after %s", name)
+			}
+			return data, name, nil
+		case name == "FlateDecode" || name == "Fl":
+			var err error
+			if data, err = d.inflate(data); err != nil {
+				return nil, "", err
+			}
+			if data, err = d.unpredict(data, params[i]); err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", fmt.Errorf("unsupported PDF filter %s", name)
+		}
+	}
+	return data, "", nil
+}
+
+// inflate decompresses zlib data, keeping what was read from truncated
+// streams as readers do. Streams that decompress beyond the size limits are
+// refused.
+func (d *pdfDocument) inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	limit := min(maxPDFStreamSize, maxPDFInflatedSize-d.inflated)
+	out, err := io.ReadAll(io.LimitReader(reader, int64(limit)+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, fmt.Errorf("%w: stream decompresses beyond %d bytes", errPDFSyntax, limit)
+	}
+	d.inflated += len(out)
+	return out, nil
+}
+
+// unpredict reverses the PNG or TIFF predictor of Flate-encoded data
+func (d *pdfDocument) unpredict(data []byte, params pdfDict) ([]byte, error) {
+	param := func(key pdfName, fallback int) int {
+		if value, ok := d.number(params[key]); ok {
+			return int(value)
+		}
+		return fallback
+	}
+	predictor := param("Predictor", 1)
+	if predictor < 2 {
+		return data, nil
+	}
+	colors, bits, columns := param("Colors", 1), param("BitsPerComponent", 8), param("Columns", 1)
+	if colors < 1 || colors > 32 || (bits != 1 && bits != 2 && bits != 4 && bits != 8 && bits != 16) ||
+		columns < 1 || columns > maxPDFColumns {
+		return nil, fmt.Errorf("%w: invalid predictor parameters", errPDFSyntax)
+	}
+	rowSize := (colors*bits*columns + 7) / 8
+	pixelSize := max((colors*bits+7)/8, 1)
+
+	if predictor == 2 {
+		if bits != 8 {
+			return nil, fmt.Errorf("unsupported TIFF predictor with %d bits", bits)
+		}
+		for row := 0; row+rowSize <= len(data); row += rowSize {
+			for i := row + pixelSize; i < row+rowSize; i++ {
+				data[i] += data[i-pixelSize]
+			}
+		}
+		return data, nil
+	}
+
+	out := make([]byte, 0, len(data)/(rowSize+1)*rowSize)
+	prev := make([]byte, rowSize)
+	for len(data) > rowSize {
+		filter, row := data[0], data[1:rowSize+1]
+		data = data[rowSize+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= pixelSize {
+				left, upLeft = row[i-pixelSize], prev[i-pixelSize]
+			}
+			up := prev[i]
+			switch filter {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := math.Abs(float64(p-int(a))), math.Abs(float64(p-int(b))), math.Abs(float64(p-int(c)))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// pdfSourcePage is a page of a parsed PDF with its inherited attributes
+type pdfSourcePage struct {
+	box       [4]float64
+	rotate    int
+	resources pdfDict
+}
+
+// defaultMediaBox is US Letter, which readers assume for pages without one
+var defaultMediaBox = [4]float64{0, 0, 612, 792}
+
+// pages walks the page tree in order
+func (d *pdfDocument) pages() []pdfSourcePage {
+	var pages []pdfSourcePage
+	visited := make(map[int]bool)
+	var walk func(node interface{}, inherited pdfDict, depth int)
+	walk = func(node interface{}, inherited pdfDict, depth int) {
+		if ref, ok := node.(pdfRef); ok {
+			if visited[ref.num] {
+				return
+			}
+			visited[ref.num] = true
+		}
+		dict := d.dict(node)
+		if dict == nil || depth > 64 {
+			return
+		}
+
+		attributes := make(pdfDict, len(inherited))
+		for key, value := range inherited {
+			attributes[key] = value
+		}
+		for _, key := range []pdfName{"MediaBox", "CropBox", "Rotate", "Resources"} {
+			if value, ok := dict[key]; ok {
+				attributes[key] = value
+			}
+		}
+
+		if kids := d.array(dict["Kids"]); kids != nil && dict["Type"] != pdfName("Page") {
+			for _, kid := range kids {
+				walk(kid, attributes, depth+1)
+			}
+			return
+		}
+		pages = append(pages, d.page(attributes))
+	}
+	walk(d.dict(d.trailer["Root"])["Pages"], nil, 0)
+	return pages
+}
+
+func (d *pdfDocument) page(attributes pdfDict) pdfSourcePage {
+	page := pdfSourcePage{box: defaultMediaBox, resources: d.dict(attributes["Resources"])}
+	if box, ok := d.rect(attributes["MediaBox"]); ok {
+		page.box = box
+	}
+	// The crop box is what readers display, clipped to the media box
+	if crop, ok := d.rect(attributes["CropBox"]); ok {
+		crop = [4]float64{
+			math.Max(crop[0], page.box[0]), math.Max(crop[1], page.box[1]),
+			math.Min(crop[2], page.box[2]), math.Min(crop[3], page.box[3]),
+		}
+		if crop[2] > crop[0] && crop[3] > crop[1] {
+			page.box = crop
+		}
+	}
+	if rotate, ok := d.number(attributes["Rotate"]); ok {
+		page.rotate = ((int(rotate)/90)%4 + 4) % 4 * 90
+	}
+	return page
+}
+
+// rect reads a rectangle, normalized so that its first corner is the lower
+// left one
+func (d *pdfDocument) rect(value interface{}) ([4]float64, bool) {
+	array := d.array(value)
+	if len(array) != 4 {
+		return [4]float64{}, false
+	}
+	var rect [4]float64
+	for i, value := range array {
+		number, ok := d.number(value)
+		if !ok {
+			return [4]float64{}, false
+		}
+		rect[i] = number
+	}
+	rect = [4]float64{
+		math.Min(rect[0], rect[2]), math.Min(rect[1], rect[3]),
+		math.Max(rect[0], rect[2]), math.Max(rect[1], rect[3]),
+	}
+	if rect[2] == rect[0] || rect[3] == rect[1] {
+		return [4]float64{}, false
+	}
+	return rect, true
+}
+
+// size returns the page size in points as it is displayed, after rotation
+func (p pdfSourcePage) size() (float64, float64) {
+	width, height := p.box[2]-p.box[0], p.box[3]-p.box[1]
+	if p.rotate == 90 || p.rotate == 270 {
+		return height, width
+	}
+	return width, height
+}
@@ -0,0 +1,171 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF from the bodies of objects 1 to n, with a
+// cross-reference table unless brokenXref is set
+func buildPDF(objects []string, trailer string, brokenXref bool) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	if brokenXref {
+		xref += 1000
+	} else {
+		fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+		for _, offset := range offsets {
+			fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+		}
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return out.Bytes()
+}
+
+func deflate(data string) string {
+	var out bytes.Buffer
+	w := zlib.NewWriter(&out)
+	w.Write([]byte(data))
+	w.Close()
+	return out.String()
+}
+
+func TestOpenPDF(t *testing.T) {
+	catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+	tests := []struct {
+		name      string
+		data      []byte
+		wantSizes []PDFPage
+		wantErr   error
+	}{
+		{
+			name: "inherited media box",
+			data: buildPDF([]string{
+				catalog,
+				"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /MediaBox [0 0 595 842] >>",
+				"<< /Type /Page /Parent 2 0 R >>",
+				"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 842 595] >>",
+			}, "/Root 1 0 R", false),
+			wantSizes: []PDFPage{{Width: 595, Height: 842}, {Width: 842, Height: 595}},
+		},
+		{
+			name: "rotated page with a crop box",
+			data: buildPDF([]string{
+				catalog,
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 600 800] /CropBox [100 100 500 400] /Rotate -90 >>",
+			}, "/Root 1 0 R", false),
+			wantSizes: []PDFPage{{Width: 300, Height: 400}},
+		},
+		{
+			name: "stale xref is reconstructed",
+			data: buildPDF([]string{
+				catalog,
+				"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+				"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 100] >>",
+			}, "/Root 1 0 R", true),
+			wantSizes: []PDFPage{{Width: 200, Height: 100}},
+		},
+		{
+			name: "page tree in a compressed object stream",
+			data: func() []byte {
+				pages := "<< /Type /Pages /Kids [4 0 R] /Count 1 >> "
+				page := "<< /Type /Page /Parent 3 0 R /MediaBox [0 0 300 300] >>"
+				header := fmt.Sprintf("3 0 4 %d ", len(pages))
+				compressed := deflate(header + pages + page)
+				return buildPDF([]string{
+					"<< /Type /Catalog /Pages 3 0 R >>",
+					fmt.Sprintf("<< /Type /ObjStm /N 2 /First %d /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream", len(header), len(compressed), compressed),
+				}, "/Root 1 0 R", true)
+			}(),
+			wantSizes: []PDFPage{{Width: 300, Height: 300}},
+		},
+		{
+			name:    "not a PDF",
+			data:    []byte("hello"),
+			wantErr: ErrInvalidPDF,
+		},
+		{
+			name:    "no catalog",
+			data:    buildPDF([]string{"<< /Type /Pages /Kids [] /Count 0 >>"}, "", false),
+			wantErr: ErrInvalidPDF,
+		},
+		{
+			name: "encrypted",
+			data: buildPDF([]string{
+				catalog,
+				"<< /Type /Pages /Kids [] /Count 0 >>",
+				"<< /Filter /Standard /V 2 >>",
+			}, "/Root 1 0 R /Encrypt 3 0 R", false),
+			wantErr: ErrEncryptedPDF,
+		},
+		{
+			name:    "deeply nested arrays",
+			data:    buildPDF([]string{"<< /Type /Catalog /Pages " + strings.Repeat("[", 100000) + " >>"}, "/Root 1 0 R", false),
+			wantErr: ErrInvalidPDF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := OpenPDF(tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("OpenPDF error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("OpenPDF failed: %v", err)
+			}
+			defer source.Close()
+			if fmt.Sprint(source.Pages) != fmt.Sprint(tt.wantSizes) {
+				t.Errorf("pages = %v, want %v", source.Pages, tt.wantSizes)
+			}
+		})
+	}
+}
+
+func TestInflateLimit(t *testing.T) {
+	small := deflate("BT /F1 12 Tf (hi) Tj ET")
+	zeros := deflate(strings.Repeat("\x00", 1<<20))
+	tests := []struct {
+		name     string
+		data     string
+		inflated int // bytes the document already decompressed
+		want     string
+		wantErr  bool
+	}{
+		{name: "small stream", data: small, want: "BT /F1 12 Tf (hi) Tj ET"},
+		{name: "within the budget", data: zeros, inflated: maxPDFInflatedSize - 1<<20, want: strings.Repeat("\x00", 1<<20)},
+		{name: "beyond the budget", data: zeros, inflated: maxPDFInflatedSize - 1<<20 + 1, wantErr: true},
+		{name: "corrupt data", data: "not zlib", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &pdfDocument{inflated: tt.inflated}
+			got, err := d.inflate([]byte(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("inflate succeeded with %d bytes", len(got))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("inflate failed: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("inflate = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
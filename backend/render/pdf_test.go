@@ -0,0 +1,94 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"backend/models"
+)
+
+func TestPDFWriterPages(t *testing.T) {
+	pen := models.DrawingPoint{ID: 1, Type: "drawing", Tool: "pen", Color: -16777216, Width: 2,
+		Offsets: []models.Offset{{X: 10, Y: 10}, {X: 100, Y: 120}}}
+	translucent := pen
+	translucent.Color = 0x80FF0000
+	eraser := models.DrawingPoint{ID: 2, Type: "drawing", Tool: "eraser", Width: 20,
+		Offsets: []models.Offset{{X: 50, Y: 50}}}
+	text := models.TextAnnotation{ID: 1, Text: "Notes (draft) \\ 1\nsecond line", Color: -16777216,
+		Position: models.Offset{X: 20, Y: 30}}
+
+	tests := []struct {
+		name      string
+		papers    []models.Paper
+		opts      PDFOptions
+		wantSizes []PDFPage
+		wantFont  bool
+		wantMask  bool
+	}{
+		{
+			name:      "blank page has the default size",
+			papers:    []models.Paper{{}},
+			wantSizes: []PDFPage{{Width: DefaultPageWidth, Height: DefaultPageHeight}},
+		},
+		{
+			name: "pages keep their own sizes",
+			papers: []models.Paper{
+				{Width: 300, Height: 200, TemplateID: "grid"},
+				{Width: 612, Height: 792, TemplateID: "dotted"},
+			},
+			wantSizes: []PDFPage{{Width: 300, Height: 200}, {Width: 612, Height: 792}},
+		},
+		{
+			name:      "strokes and eraser",
+			papers:    []models.Paper{{Width: 400, Height: 400, DrawingData: []models.DrawingPoint{pen, translucent, eraser, pen}}},
+			wantSizes: []PDFPage{{Width: 400, Height: 400}},
+			wantMask:  true,
+		},
+		{
+			name:      "text",
+			papers:    []models.Paper{{Width: 400, Height: 400, TextData: []models.TextAnnotation{text}}},
+			opts:      PDFOptions{AnnotationsOnly: true},
+			wantSizes: []PDFPage{{Width: 400, Height: 400}},
+			wantFont:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writer := NewPDFWriter(&out, tt.opts)
+			for _, paper := range tt.papers {
+				if err := writer.AddPage(paper, nil); err != nil {
+					t.Fatalf("AddPage failed: %v", err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			source, err := OpenPDF(out.Bytes())
+			if err != nil {
+				t.Fatalf("OpenPDF failed on a written PDF: %v", err)
+			}
+			defer source.Close()
+			if fmt.Sprint(source.Pages) != fmt.Sprint(tt.wantSizes) {
+				t.Fatalf("pages = %v, want %v", source.Pages, tt.wantSizes)
+			}
+
+			for i, page := range source.pages {
+				if _, ok := page.resources["Font"]; ok != tt.wantFont {
+					t.Errorf("page %d has fonts: %v, want %v", i+1, ok, tt.wantFont)
+				}
+				hasMask := false
+				for _, state := range source.doc.dict(page.resources["ExtGState"]) {
+					if source.doc.dict(state)["SMask"] != nil {
+						hasMask = true
+					}
+				}
+				if hasMask != tt.wantMask {
+					t.Errorf("page %d has an eraser mask: %v, want %v", i+1, hasMask, tt.wantMask)
+				}
+			}
+		})
+	}
+}
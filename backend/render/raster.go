@@ -0,0 +1,270 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"strings"
+
+	"backend/models"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+	_ "golang.org/x/image/webp"
+)
+
+// Options sets the size of a rasterized paper and the decoded
+// BackgroundImage, if the paper has one
+type Options struct {
+	Width      int
+	Height     int
+	Background image.Image
+}
+
+// DecodeImage decodes a background image in any format the client uploads
+func DecodeImage(r io.Reader) (image.Image, error) {
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// Rasterize draws a paper stretched to the size in opts. Like the client,
+// the strokes are drawn on their own layer so that eraser strokes clear
+// earlier strokes but not the template or background image, and text is
+// drawn above the strokes.
+func Rasterize(paper models.Paper, opts Options) (*image.RGBA, error) {
+	pageWidth, pageHeight := PageSize(paper)
+	c := &canvas{
+		img:    image.NewRGBA(image.Rect(0, 0, opts.Width, opts.Height)),
+		scaleX: float64(opts.Width) / pageWidth,
+		scaleY: float64(opts.Height) / pageHeight,
+	}
+
+	template := TemplateFor(paper.TemplateID)
+	draw.Draw(c.img, c.img.Bounds(), image.NewUniform(template.Background), image.Point{}, draw.Src)
+	for _, line := range template.Lines(pageWidth, pageHeight) {
+		c.fill(c.img, c.line(line.From, line.To, template.LineWidth), template.Line)
+	}
+	for _, dot := range template.Dots(pageWidth, pageHeight) {
+		c.fill(c.img, c.disc(dot, DotRadius), template.Line)
+	}
+
+	if opts.Background != nil {
+		size := opts.Background.Bounds().Size()
+		x, y, width, height := ContainRect(pageWidth, pageHeight, size.X, size.Y)
+		topLeft, bottomRight := c.point(Point{x, y}), c.point(Point{x + width, y + height})
+		bounds := image.Rect(int(math.Round(topLeft.x)), int(math.Round(topLeft.y)), int(math.Round(bottomRight.x)), int(math.Round(bottomRight.y)))
+		xdraw.ApproxBiLinear.Scale(c.img, bounds, opts.Background, opts.Background.Bounds(), draw.Over, nil)
+	}
+
+	strokes := &canvas{img: image.NewRGBA(c.img.Bounds()), scaleX: c.scaleX, scaleY: c.scaleY}
+	for _, stroke := range paper.DrawingData {
+		if len(stroke.Offsets) == 0 {
+			continue
+		}
+		path := strokes.stroke(stroke.Offsets, stroke.Width)
+		if stroke.Tool == "eraser" {
+			strokes.clear(path)
+		} else {
+			strokes.fill(strokes.img, path, Color(stroke.Color))
+		}
+	}
+	draw.Draw(c.img, c.img.Bounds(), strokes.img, image.Point{}, draw.Over)
+
+	for _, text := range paper.TextData {
+		if err := c.text(text); err != nil {
+			return nil, err
+		}
+	}
+	return c.img, nil
+}
+
+// canvas maps paper coordinates onto an image
+type canvas struct {
+	img            *image.RGBA
+	scaleX, scaleY float64
+	rasterizer     vector.Rasterizer
+	mask           image.Alpha
+}
+
+// vec is a point in pixels
+type vec struct {
+	x, y float64
+}
+
+// polygon is a set of closed outlines in pixels, all wound the same way so
+// that overlapping parts are filled once
+type polygon [][]vec
+
+func (c *canvas) point(p Point) vec {
+	return vec{p.X * c.scaleX, p.Y * c.scaleY}
+}
+
+// thickness converts a width in paper coordinates to pixels, keeping thin
+// lines visible on small thumbnails
+func (c *canvas) thickness(width float64) float64 {
+	return math.Max(width*(c.scaleX+c.scaleY)/2, 0.5)
+}
+
+// line is a line with butt ends, as the template is drawn
+func (c *canvas) line(from, to Point, width float64) polygon {
+	return polygon{segment(c.point(from), c.point(to), c.thickness(width)/2)}
+}
+
+func (c *canvas) disc(center Point, radius float64) polygon {
+	return polygon{circle(c.point(center), c.thickness(radius*2)/2)}
+}
+
+// stroke is a polyline with round caps and joins
+func (c *canvas) stroke(offsets []models.Offset, width float64) polygon {
+	radius := c.thickness(width) / 2
+	path := make(polygon, 0, len(offsets)*2)
+	var prev vec
+	for i, offset := range offsets {
+		p := c.point(Point{offset.X, offset.Y})
+		path = append(path, circle(p, radius))
+		if i > 0 && (p.x != prev.x || p.y != prev.y) {
+			path = append(path, segment(prev, p, radius))
+		}
+		prev = p
+	}
+	return path
+}
+
+// segment is the rectangle around a line from a to b
+func segment(a, b vec, radius float64) []vec {
+	length := math.Hypot(b.x-a.x, b.y-a.y)
+	if length == 0 {
+		return nil
+	}
+	nx, ny := -(b.y-a.y)/length*radius, (b.x-a.x)/length*radius
+	return []vec{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}}
+}
+
+// circle is a polygon close enough to a circle at the given radius
+func circle(center vec, radius float64) []vec {
+	sides := int(math.Min(math.Max(radius*2, 8), 64))
+	outline := make([]vec, sides)
+	for i := range outline {
+		angle := -2 * math.Pi * float64(i) / float64(sides)
+		outline[i] = vec{center.x + radius*math.Cos(angle), center.y + radius*math.Sin(angle)}
+	}
+	return outline
+}
+
+// rasterize computes the coverage of a polygon into c.mask and returns the
+// part of the image it covers
+func (c *canvas) rasterize(path polygon) image.Rectangle {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, outline := range path {
+		for _, p := range outline {
+			minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+			maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+		}
+	}
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+	bounds := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY))).Intersect(c.img.Bounds())
+	if bounds.Empty() {
+		return bounds
+	}
+
+	c.rasterizer.Reset(bounds.Dx(), bounds.Dy())
+	for _, outline := range path {
+		if len(outline) < 3 {
+			continue
+		}
+		c.rasterizer.MoveTo(float32(outline[0].x-float64(bounds.Min.X)), float32(outline[0].y-float64(bounds.Min.Y)))
+		for _, p := range outline[1:] {
+			c.rasterizer.LineTo(float32(p.x-float64(bounds.Min.X)), float32(p.y-float64(bounds.Min.Y)))
+		}
+		c.rasterizer.ClosePath()
+	}
+
+	size := bounds.Dx() * bounds.Dy()
+	if cap(c.mask.Pix) < size {
+		c.mask.Pix = make([]uint8, size)
+	}
+	c.mask.Pix = c.mask.Pix[:size]
+	clear(c.mask.Pix)
+	c.mask.Stride = bounds.Dx()
+	c.mask.Rect = image.Rect(0, 0, bounds.Dx(), bounds.Dy())
+	c.rasterizer.Draw(&c.mask, c.mask.Rect, image.Opaque, image.Point{})
+	return bounds
+}
+
+func (c *canvas) fill(dst *image.RGBA, path polygon, fill color.Color) {
+	bounds := c.rasterize(path)
+	if bounds.Empty() {
+		return
+	}
+	draw.DrawMask(dst, bounds, image.NewUniform(fill), image.Point{}, &c.mask, image.Point{}, draw.Over)
+}
+
+// clear erases a polygon back to transparent
+func (c *canvas) clear(path polygon) {
+	bounds := c.rasterize(path)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			coverage := uint32(c.mask.Pix[(y-bounds.Min.Y)*c.mask.Stride+x-bounds.Min.X])
+			if coverage == 0 {
+				continue
+			}
+			i := c.img.PixOffset(x, y)
+			for j := i; j < i+4; j++ {
+				c.img.Pix[j] = uint8(uint32(c.img.Pix[j]) * (255 - coverage) / 255)
+			}
+		}
+	}
+}
+
+// text draws an annotation with its top left corner at its position
+func (c *canvas) text(annotation models.TextAnnotation) error {
+	scale := (c.scaleX + c.scaleY) / 2
+	if annotation.IsBubble {
+		center := Point{annotation.Position.X + BubbleSize/2, annotation.Position.Y + BubbleSize/2}
+		c.fill(c.img, c.disc(center, BubbleSize/2), color.Black)
+
+		face, err := newFace(BubbleFontSize*scale, true, false)
+		if err != nil {
+			return err
+		}
+		defer face.Close()
+
+		label := BubbleLabel(annotation.Text)
+		metrics := face.Metrics()
+		p := c.point(center)
+		drawer := font.Drawer{Dst: c.img, Src: image.White, Face: face}
+		drawer.Dot = fixed.Point26_6{
+			X: fixed.Int26_6(p.x*64) - drawer.MeasureString(label)/2,
+			Y: fixed.Int26_6(p.y*64) + (metrics.Ascent-metrics.Descent)/2,
+		}
+		drawer.DrawString(label)
+		return nil
+	}
+
+	face, err := newFace(FontSize(annotation)*scale, annotation.IsBold, annotation.IsItalic)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	p := c.point(Point{annotation.Position.X, annotation.Position.Y})
+	drawer := font.Drawer{Dst: c.img, Src: image.NewUniform(Color(annotation.Color)), Face: face}
+	baseline := fixed.Int26_6(p.y*64) + metrics.Ascent
+	for _, line := range strings.Split(annotation.Text, "\n") {
+		drawer.Dot = fixed.Point26_6{X: fixed.Int26_6(p.x * 64), Y: baseline}
+		drawer.DrawString(line)
+		baseline += metrics.Height
+	}
+	return nil
+}
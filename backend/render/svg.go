@@ -0,0 +1,210 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+
+	"backend/models"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// SVGOptions controls what WriteSVG draws
+type SVGOptions struct {
+	// AnnotationsOnly leaves out the template and background image
+	AnnotationsOnly bool
+
+	// Background is the encoded BackgroundImage to embed. When it is nil,
+	// or not in a format images are decoded from, the image is linked by
+	// its URL instead.
+	Background []byte
+}
+
+// svgFontFamily names the Go fonts text is measured with, falling back to
+// whatever sans-serif font the viewer has
+const svgFontFamily = "Go, sans-serif"
+
+// WriteSVG writes a paper as a standalone SVG document with one unit per
+// paper unit. Strokes are paths, eraser strokes are masks over the strokes
+// drawn before them, and text annotations are text that vector tools can
+// edit. Bubbles are drawn open, as in PDFs.
+func WriteSVG(w io.Writer, paper models.Paper, opts SVGOptions) error {
+	width, height := PageSize(paper)
+	s := &svgPage{width: width, height: height}
+
+	if !opts.AnnotationsOnly {
+		s.template(TemplateFor(paper.TemplateID))
+		s.image(paper.BackgroundImage, opts.Background)
+	}
+	s.strokes(paper.DrawingData)
+	for _, annotation := range paper.TextData {
+		if err := s.text(annotation); err != nil {
+			return err
+		}
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" xmlns:xlink=\"http://www.w3.org/1999/xlink\" width=\"%s\" height=\"%s\" viewBox=\"0 0 %s %s\">\n",
+		formatNumber(width), formatNumber(height), formatNumber(width), formatNumber(height))
+	if s.defs.Len() > 0 {
+		fmt.Fprintf(out, "<defs>\n%s</defs>\n", s.defs.String())
+	}
+	out.Write(s.body.Bytes())
+	out.WriteString("</svg>\n")
+	return out.Flush()
+}
+
+// svgPage collects the elements of a paper and the masks they use
+type svgPage struct {
+	width, height float64
+	defs, body    bytes.Buffer
+	masks         int
+}
+
+func (s *svgPage) template(template Template) {
+	fmt.Fprintf(&s.body, "<rect width=\"%s\" height=\"%s\" fill=\"%s\"/>\n", formatNumber(s.width), formatNumber(s.height), svgColor(template.Background))
+
+	lines := template.Lines(s.width, s.height)
+	if len(lines) > 0 {
+		var path strings.Builder
+		for _, line := range lines {
+			fmt.Fprintf(&path, "M%s %sL%s %s", formatNumber(line.From.X), formatNumber(line.From.Y), formatNumber(line.To.X), formatNumber(line.To.Y))
+		}
+		fmt.Fprintf(&s.body, "<path d=\"%s\" fill=\"none\" stroke=\"%s\" stroke-width=\"%s\"/>\n",
+			path.String(), svgColor(template.Line), formatNumber(template.LineWidth))
+	}
+
+	dots := template.Dots(s.width, s.height)
+	if len(dots) > 0 {
+		fmt.Fprintf(&s.body, "<g fill=\"%s\">\n", svgColor(template.Line))
+		for _, dot := range dots {
+			fmt.Fprintf(&s.body, "<circle cx=\"%s\" cy=\"%s\" r=\"%s\"/>\n", formatNumber(dot.X), formatNumber(dot.Y), formatNumber(DotRadius))
+		}
+		s.body.WriteString("</g>\n")
+	}
+}
+
+// image draws the background image contained in the page, like the client's
+// BoxFit.contain. It is embedded as a data URI when data is an image and
+// linked by url otherwise.
+func (s *svgPage) image(url string, data []byte) {
+	if data != nil {
+		if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			url = "data:image/" + format + ";base64," + base64.StdEncoding.EncodeToString(data)
+		}
+	}
+	if url == "" {
+		return
+	}
+	fmt.Fprintf(&s.body, "<image xlink:href=\"%s\" width=\"%s\" height=\"%s\" preserveAspectRatio=\"xMidYMid meet\"/>\n",
+		svgEscape(url), formatNumber(s.width), formatNumber(s.height))
+}
+
+// strokes draws the strokes in order. Erased strokes are grouped under a
+// mask, white except where the eraser went.
+func (s *svgPage) strokes(strokes []models.DrawingPoint) {
+	draw := func(layer *bytes.Buffer, stroke models.DrawingPoint) {
+		svgStroke(layer, stroke, Color(stroke.Color))
+	}
+	erase := func(mask *bytes.Buffer, stroke models.DrawingPoint) {
+		svgStroke(mask, stroke, color.NRGBA{A: 0xFF})
+	}
+	applyMask := func(layer, mask []byte) string {
+		s.masks++
+		id := fmt.Sprintf("eraser-%d", s.masks)
+		fmt.Fprintf(&s.defs, "<mask id=\"%s\" maskUnits=\"userSpaceOnUse\" x=\"0\" y=\"0\" width=\"%s\" height=\"%s\">\n<rect width=\"%s\" height=\"%s\" fill=\"white\"/>\n%s</mask>\n",
+			id, formatNumber(s.width), formatNumber(s.height), formatNumber(s.width), formatNumber(s.height), mask)
+		return fmt.Sprintf("<g mask=\"url(#%s)\">\n%s</g>\n", id, layer)
+	}
+	s.body.Write(maskStrokes(strokes, draw, erase, applyMask))
+}
+
+// svgStroke writes a stroke as a path with round caps and joins, like the
+// client's
+func svgStroke(buf *bytes.Buffer, stroke models.DrawingPoint, c color.NRGBA) {
+	var path strings.Builder
+	writeStrokePath(&path, stroke, "M%s %s", "L%s %s")
+	fmt.Fprintf(buf, "<path d=\"%s\" fill=\"none\" stroke=\"%s\"%s stroke-width=\"%s\" stroke-linecap=\"round\" stroke-linejoin=\"round\"/>\n",
+		path.String(), svgColor(c), svgOpacity("stroke-opacity", c), formatNumber(stroke.Width))
+}
+
+// text draws an annotation with its top left corner at its position, one
+// tspan per line
+func (s *svgPage) text(annotation models.TextAnnotation) error {
+	bold, italic := annotation.IsBold, annotation.IsItalic
+	size := FontSize(annotation)
+	fill := Color(annotation.Color)
+	x, y := annotation.Position.X, annotation.Position.Y
+	if annotation.IsBubble {
+		bold, italic, size, fill = false, false, BubbleFontSize, color.NRGBA{A: 0xFF}
+	}
+
+	face, err := newFace(size, bold, italic)
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	metrics := face.Metrics()
+	ascent, lineHeight := fixedToFloat(metrics.Ascent), fixedToFloat(metrics.Height)
+	lines := strings.Split(annotation.Text, "\n")
+
+	if annotation.IsBubble {
+		textWidth := 0.0
+		for _, line := range lines {
+			textWidth = math.Max(textWidth, fixedToFloat(font.MeasureString(face, line)))
+		}
+		fmt.Fprintf(&s.body, "<rect x=\"%s\" y=\"%s\" width=\"%s\" height=\"%s\" rx=\"%s\" fill=\"#000000\" fill-opacity=\"%s\" stroke=\"#000000\" stroke-width=\"1\"/>\n",
+			formatNumber(x), formatNumber(y), formatNumber(textWidth+2*BubblePadding), formatNumber(float64(len(lines))*lineHeight+2*BubblePadding),
+			formatNumber(BubbleRadius), formatNumber(BubbleOpacity))
+		x, y = x+BubblePadding, y+BubblePadding
+	}
+
+	fmt.Fprintf(&s.body, "<text xml:space=\"preserve\" font-family=\"%s\" font-size=\"%s\"", svgFontFamily, formatNumber(size))
+	if bold {
+		s.body.WriteString(" font-weight=\"bold\"")
+	}
+	if italic {
+		s.body.WriteString(" font-style=\"italic\"")
+	}
+	fmt.Fprintf(&s.body, " fill=\"%s\"%s>", svgColor(fill), svgOpacity("fill-opacity", fill))
+	baseline := y + ascent
+	for _, line := range lines {
+		fmt.Fprintf(&s.body, "<tspan x=\"%s\" y=\"%s\">%s</tspan>", formatNumber(x), formatNumber(baseline), svgEscape(line))
+		baseline += lineHeight
+	}
+	s.body.WriteString("</text>\n")
+	return nil
+}
+
+func fixedToFloat(value fixed.Int26_6) float64 {
+	return float64(value) / 64
+}
+
+// svgColor formats the RGB part of a color
+func svgColor(c color.NRGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// svgOpacity returns the opacity attribute for a translucent color
+func svgOpacity(attribute string, c color.NRGBA) string {
+	if c.A == 0xFF {
+		return ""
+	}
+	return fmt.Sprintf(" %s=\"%s\"", attribute, formatNumber(float64(c.A)/255))
+}
+
+func svgEscape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"backend/models"
+)
+
+// svgContent parses an SVG document and returns the text of its tspans and
+// the links of its images, failing on anything that is not well-formed
+func svgContent(t *testing.T, data []byte) (texts, links []string) {
+	t.Helper()
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	inTspan := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return texts, links
+		}
+		if err != nil {
+			t.Fatalf("SVG is not well-formed: %v\n%s", err, data)
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "tspan":
+				inTspan = true
+				texts = append(texts, "")
+			case "image":
+				for _, attr := range token.Attr {
+					if attr.Name.Local == "href" {
+						links = append(links, attr.Value)
+					}
+				}
+			}
+		case xml.EndElement:
+			if token.Name.Local == "tspan" {
+				inTspan = false
+			}
+		case xml.CharData:
+			if inTspan {
+				texts[len(texts)-1] += string(token)
+			}
+		}
+	}
+}
+
+func TestWriteSVGEscaping(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		background string
+		wantTexts  []string
+		wantLinks  []string
+	}{
+		{name: "markup in text", text: `<script>alert("x")</script>`, wantTexts: []string{`<script>alert("x")</script>`}},
+		{name: "entities in text", text: "Tom & Jerry's &amp; co", wantTexts: []string{"Tom & Jerry's &amp; co"}},
+		{name: "one tspan per line", text: "first <\n& second", wantTexts: []string{"first <", "& second"}},
+		{name: "control characters are replaced", text: "bell\x07", wantTexts: []string{"bell\uFFFD"}},
+		{name: "kept spaces", text: "  indented", wantTexts: []string{"  indented"}},
+		{
+			name:       "link with query and quotes",
+			background: `https://blob.example/page.png?sv=1&sig=a"b<c`,
+			wantLinks:  []string{`https://blob.example/page.png?sv=1&sig=a"b<c`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paper := models.Paper{Width: 400, Height: 300, BackgroundImage: tt.background}
+			if tt.text != "" {
+				paper.TextData = []models.TextAnnotation{{ID: 1, Text: tt.text, Color: -16777216, Position: models.Offset{X: 10, Y: 10}}}
+			}
+
+			var out bytes.Buffer
+			if err := WriteSVG(&out, paper, SVGOptions{}); err != nil {
+				t.Fatalf("WriteSVG failed: %v", err)
+			}
+			texts, links := svgContent(t, out.Bytes())
+			if !reflect.DeepEqual(texts, tt.wantTexts) {
+				t.Errorf("texts = %q, want %q", texts, tt.wantTexts)
+			}
+			if !reflect.DeepEqual(links, tt.wantLinks) {
+				t.Errorf("links = %q, want %q", links, tt.wantLinks)
+			}
+		})
+	}
+}
+
+func TestWriteSVGStrokes(t *testing.T) {
+	pen := models.DrawingPoint{ID: 1, Tool: "pen", Color: -16777216, Width: 2, Offsets: []models.Offset{{X: 1, Y: 2}, {X: 3.14159, Y: 4}}}
+	dot := models.DrawingPoint{ID: 2, Tool: "pen", Color: 0x80FF0000, Width: 4, Offsets: []models.Offset{{X: 5, Y: 5}}}
+	eraser := models.DrawingPoint{ID: 3, Tool: "eraser", Width: 10, Offsets: []models.Offset{{X: 2, Y: 3}}}
+
+	tests := []struct {
+		name      string
+		strokes   []models.DrawingPoint
+		want      []string
+		wantMasks int
+	}{
+		{name: "path", strokes: []models.DrawingPoint{pen}, want: []string{`d="M1 2L3.142 4"`}},
+		{name: "single point", strokes: []models.DrawingPoint{dot}, want: []string{`d="M5 5L5.1 5.1"`, `stroke="#ff0000" stroke-opacity="0.502"`}},
+		{name: "eraser masks earlier strokes", strokes: []models.DrawingPoint{pen, eraser, dot}, want: []string{`<g mask="url(#eraser-1)">`}, wantMasks: 1},
+		{name: "eraser runs share a mask", strokes: []models.DrawingPoint{pen, eraser, eraser, dot, eraser}, wantMasks: 2},
+		{name: "eraser before any stroke", strokes: []models.DrawingPoint{eraser, pen}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			paper := models.Paper{Width: 100, Height: 100, DrawingData: tt.strokes}
+			if err := WriteSVG(&out, paper, SVGOptions{AnnotationsOnly: true}); err != nil {
+				t.Fatalf("WriteSVG failed: %v", err)
+			}
+			svg := out.String()
+			svgContent(t, out.Bytes())
+			for _, want := range tt.want {
+				if !strings.Contains(svg, want) {
+					t.Errorf("SVG lacks %s:\n%s", want, svg)
+				}
+			}
+			if masks := strings.Count(svg, "<mask "); masks != tt.wantMasks {
+				t.Errorf("SVG has %d masks, want %d", masks, tt.wantMasks)
+			}
+		})
+	}
+}
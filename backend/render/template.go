@@ -0,0 +1,138 @@
+// Package render draws papers the way the client's canvas does
+package render
+
+import (
+	"image/color"
+	"math"
+	"unicode"
+
+	"backend/models"
+)
+
+// Page size the client gives papers that have none
+const (
+	DefaultPageWidth  = 595.0
+	DefaultPageHeight = 842.0
+)
+
+// Template is the ruling of a paper, mirroring PaperTemplate in the client
+type Template struct {
+	ID         string
+	Background color.NRGBA
+	Line       color.NRGBA
+	LineWidth  float64
+	Spacing    float64
+}
+
+// DotRadius is the radius of the dots of a dotted template
+const DotRadius = 1.0
+
+// Point is a position in paper coordinates
+type Point struct {
+	X, Y float64
+}
+
+// Line is a straight template line in paper coordinates
+type Line struct {
+	From, To Point
+}
+
+// TemplateFor returns the template with the given ID, or plain paper for IDs
+// the client does not know either
+func TemplateFor(id string) Template {
+	switch id {
+	case "lined", "grid", "dotted":
+	default:
+		id = "plain"
+	}
+	return Template{
+		ID:         id,
+		Background: color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		Line:       color.NRGBA{0xCC, 0xCC, 0xCC, 0xFF},
+		LineWidth:  1,
+		Spacing:    30,
+	}
+}
+
+// Lines returns the ruling of a page of the given size
+func (t Template) Lines(width, height float64) []Line {
+	var lines []Line
+	if t.ID != "lined" && t.ID != "grid" {
+		return lines
+	}
+	for y := t.Spacing; y < height; y += t.Spacing {
+		lines = append(lines, Line{Point{0, y}, Point{width, y}})
+	}
+	if t.ID == "grid" {
+		for x := t.Spacing; x < width; x += t.Spacing {
+			lines = append(lines, Line{Point{x, 0}, Point{x, height}})
+		}
+	}
+	return lines
+}
+
+// Dots returns the centres of the dots of a page of the given size
+func (t Template) Dots(width, height float64) []Point {
+	var dots []Point
+	if t.ID != "dotted" {
+		return dots
+	}
+	for x := t.Spacing; x < width; x += t.Spacing {
+		for y := t.Spacing; y < height; y += t.Spacing {
+			dots = append(dots, Point{x, y})
+		}
+	}
+	return dots
+}
+
+// PageSize returns the size of a paper in paper coordinates
+func PageSize(paper models.Paper) (float64, float64) {
+	width, height := paper.Width, paper.Height
+	if width <= 0 {
+		width = DefaultPageWidth
+	}
+	if height <= 0 {
+		height = DefaultPageHeight
+	}
+	return width, height
+}
+
+// Color converts a color stored by the client, a 32-bit ARGB value
+func Color(argb int) color.NRGBA {
+	return color.NRGBA{
+		R: uint8(argb >> 16),
+		G: uint8(argb >> 8),
+		B: uint8(argb),
+		A: uint8(argb >> 24),
+	}
+}
+
+// On the canvas a bubble is collapsed to a black disc with the first letter
+// of its text until it is opened. Documents show it open: the text in a
+// rounded, translucent box with a black border.
+const (
+	BubbleSize     = 30.0
+	BubbleFontSize = 16.0
+	BubblePadding  = 8.0
+	BubbleRadius   = 10.0
+	BubbleOpacity  = 0.2
+)
+
+// BubbleLabel is the letter shown on a collapsed bubble
+func BubbleLabel(text string) string {
+	for _, r := range text {
+		return string(unicode.ToUpper(r))
+	}
+	return ""
+}
+
+// ContainRect fits an image into a page keeping its aspect ratio, centred
+// like the client's BoxFit.contain, and returns where it goes
+func ContainRect(pageWidth, pageHeight float64, imageWidth, imageHeight int) (x, y, width, height float64) {
+	if imageWidth <= 0 || imageHeight <= 0 {
+		return 0, 0, 0, 0
+	}
+	scale := math.Min(pageWidth/float64(imageWidth), pageHeight/float64(imageHeight))
+	width, height = float64(imageWidth)*scale, float64(imageHeight)*scale
+	return (pageWidth - width) / 2, (pageHeight - height) / 2, width, height
+}
@@ -0,0 +1,74 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"backend/models"
+)
+
+// The PDF and SVG writers draw the same paths and layers, differing only in
+// syntax, so what they share is here.
+
+// formatNumber formats a number with at most three decimals
+func formatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
+
+// writeStrokePath writes the path through a stroke's offsets, formatting the
+// first point with moveTo and the others with lineTo. A single point becomes
+// a tiny line, as the client draws it.
+func writeStrokePath(w io.Writer, stroke models.DrawingPoint, moveTo, lineTo string) {
+	first := stroke.Offsets[0]
+	fmt.Fprintf(w, moveTo, formatNumber(first.X), formatNumber(first.Y))
+	if len(stroke.Offsets) == 1 {
+		fmt.Fprintf(w, lineTo, formatNumber(first.X+0.1), formatNumber(first.Y+0.1))
+	}
+	for _, offset := range stroke.Offsets[1:] {
+		fmt.Fprintf(w, lineTo, formatNumber(offset.X), formatNumber(offset.Y))
+	}
+}
+
+// maskStrokes lays out strokes in order for formats that erase with masks.
+// An eraser only clears strokes drawn before it, so when a run of eraser
+// strokes ends, everything drawn so far is replaced by what applyMask makes
+// of it and the mask the run drew; a run shares one mask. draw and erase add
+// a stroke to the layer and the mask. It returns the finished layer.
+func maskStrokes(strokes []models.DrawingPoint, draw, erase func(*bytes.Buffer, models.DrawingPoint), applyMask func(layer, mask []byte) string) []byte {
+	var layer, mask bytes.Buffer
+	erasing := false
+	endEraser := func() {
+		if !erasing {
+			return
+		}
+		erasing = false
+		if layer.Len() == 0 {
+			return
+		}
+		masked := applyMask(layer.Bytes(), mask.Bytes())
+		layer.Reset()
+		layer.WriteString(masked)
+	}
+
+	for _, stroke := range strokes {
+		if len(stroke.Offsets) == 0 {
+			continue
+		}
+		if stroke.Tool == "eraser" {
+			if !erasing {
+				erasing = true
+				mask.Reset()
+			}
+			erase(&mask, stroke)
+			continue
+		}
+
+		endEraser()
+		draw(&layer, stroke)
+	}
+	endEraser()
+	return layer.Bytes()
+}
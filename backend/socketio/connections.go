@@ -0,0 +1,106 @@
+package socketio
+
+import (
+	"fmt"
+	"sync"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+// Connections are tracked per authenticated user so the REST handlers can
+// force a user out of a room when their access is revoked.
+var (
+	connectionsMu   sync.Mutex
+	userConnections = make(map[string]map[string]socketio.Conn) // userID -> connID -> conn
+	connectionFiles = make(map[string]map[string]string)        // connID -> fileID -> roomID
+)
+
+func trackConnection(userID string, s socketio.Conn) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if userConnections[userID] == nil {
+		userConnections[userID] = make(map[string]socketio.Conn)
+	}
+	userConnections[userID][s.ID()] = s
+}
+
+func untrackConnection(s socketio.Conn) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if userID, ok := s.Context().(string); ok {
+		if conns, ok := userConnections[userID]; ok {
+			delete(conns, s.ID())
+			if len(conns) == 0 {
+				delete(userConnections, userID)
+			}
+		}
+	}
+	delete(connectionFiles, s.ID())
+}
+
+func trackFile(s socketio.Conn, fileID, roomID string) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if connectionFiles[s.ID()] == nil {
+		connectionFiles[s.ID()] = make(map[string]string)
+	}
+	connectionFiles[s.ID()][fileID] = roomID
+}
+
+func untrackFile(s socketio.Conn, fileID string) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	if files, ok := connectionFiles[s.ID()]; ok {
+		delete(files, fileID)
+	}
+}
+
+// DisconnectUserFromRoom makes every connection of the user leave the room and
+// any file of that room they have open, then sends them the event with the
+// payload. It returns the number of connections that were affected.
+func DisconnectUserFromRoom(roomID, userID, event string, payload map[string]interface{}) int {
+	if ServerInstance == nil {
+		return 0
+	}
+
+	type fileLeave struct {
+		conn   socketio.Conn
+		fileID string
+	}
+
+	connectionsMu.Lock()
+	conns := make([]socketio.Conn, 0, len(userConnections[userID]))
+	var files []fileLeave
+	for connID, conn := range userConnections[userID] {
+		conns = append(conns, conn)
+		for fileID, fileRoomID := range connectionFiles[connID] {
+			if fileRoomID == roomID {
+				files = append(files, fileLeave{conn, fileID})
+				delete(connectionFiles[connID], fileID)
+			}
+		}
+	}
+	connectionsMu.Unlock()
+
+	for _, file := range files {
+		ServerInstance.LeaveRoom("/", file.fileID, file.conn)
+		RemoveUserFromFile(file.fileID, file.conn.ID())
+		ServerInstance.BroadcastToRoom("/", file.fileID, "file_users_update", map[string]interface{}{
+			"users": GetUsersInFile(file.fileID),
+		})
+	}
+
+	for _, conn := range conns {
+		ServerInstance.LeaveRoom("/", roomID, conn)
+		conn.Emit(event, payload)
+	}
+
+	if len(conns) > 0 {
+		fmt.Printf("🚪 Removed user %s (%d connections) from room %s\n", userID, len(conns), roomID)
+	}
+	return len(conns)
+}
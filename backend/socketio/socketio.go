@@ -1,10 +1,12 @@
 package socketio
 
 import (
-	// "backend/utils"
+	"backend/utils"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/googollee/go-socket.io/engineio"
@@ -21,6 +23,14 @@ func allowOriginFunc(r *http.Request) bool {
 // ServerInstance holds the reference to the socket.io server
 var ServerInstance *socketio.Server
 
+// OnFileOpened, when set, is called in the background whenever an
+// authenticated user joins a file
+var OnFileOpened func(userID, fileID string)
+
+// OnHistoryCommand, when set, runs an "undo" or "redo" of the user's own last
+// edit to a paper, persisting and broadcasting the result
+var OnHistoryCommand func(userID, command, paperID string) (interface{}, error)
+
 // SetupSocketIO initializes the Socket.IO server and registers event handlers
 func SetupSocketIO(router *mux.Router) *socketio.Server {
 	fmt.Println("Socket")
@@ -67,25 +77,38 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 			return
 		}
 
-		// userID, err := utils.GetUserIDFromTokenSocket(token)
-		// if err != nil {
-		// 	fmt.Println("❌ Unauthorized: Invalid token")
-		// 	s.Emit("room_joined", map[string]interface{}{
-		// 		"success": false,
-		// 		"error":   "Unauthorized: Invalid token",
-		// 	})
-		// 	return
-		// }
+		userID, err := utils.GetUserIDFromTokenString(token)
+		if err != nil {
+			fmt.Println("❌ Unauthorized: Invalid token")
+			s.Emit("room_joined", map[string]interface{}{
+				"success": false,
+				"error":   "Unauthorized: Invalid token",
+			})
+			return
+		}
+
+		// Removed members must not be able to rejoin and keep receiving events
+		role, err := utils.GetUserRoleInRoom(context.Background(), userID, roomID)
+		if err != nil {
+			fmt.Printf("❌ User %s has no access to room %s: %v\n", userID, roomID, err)
+			s.Emit("room_joined", map[string]interface{}{
+				"success": false,
+				"error":   "Access denied",
+			})
+			return
+		}
 
-		// s.SetContext(userID)
+		s.SetContext(userID)
+		trackConnection(userID, s)
 
 		fmt.Printf("✅ User %s  joined room %s\n", s.ID(), roomID)
 		s.Join(roomID)
 
 		s.Emit("room_joined", map[string]interface{}{
-			"success": true,
-			"roomID":  roomID,
-			// "userID":   userID,
+			"success":  true,
+			"roomID":   roomID,
+			"userID":   userID,
+			"role":     role,
 			"clientID": s.ID(),
 		})
 	})
@@ -94,8 +117,58 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 		fileId := msg["fileId"]
 		userId := s.ID()
 
+		// The same checks as join_room, against the room the file is in
+		fail := func(message string) {
+			s.Emit("file_joined", map[string]interface{}{
+				"success": false,
+				"fileId":  fileId,
+				"error":   message,
+			})
+		}
+
+		token := msg["token"]
+		if token == "" {
+			fmt.Println("❌ Missing or invalid token in join_file")
+			fail("Missing or invalid token")
+			return
+		}
+
+		authUserID, err := utils.GetUserIDFromTokenString(token)
+		if err != nil {
+			fmt.Println("❌ Unauthorized: Invalid token")
+			fail("Unauthorized: Invalid token")
+			return
+		}
+
+		ctx := context.Background()
+		roomID, err := utils.GetFileRoomID(ctx, fileId)
+		if err != nil {
+			fmt.Printf("❌ File %s not found: %v\n", fileId, err)
+			fail("File not found")
+			return
+		}
+		if _, err := utils.GetUserRoleInRoom(ctx, authUserID, roomID); err != nil {
+			fmt.Printf("❌ User %s has no access to room %s: %v\n", authUserID, roomID, err)
+			fail("Access denied")
+			return
+		}
+
+		s.SetContext(authUserID)
+		trackConnection(authUserID, s)
+
 		server.JoinRoom("/", fileId, s)
 		AddUserToFile(fileId, userId)
+		trackFile(s, fileId, roomID)
+
+		if OnFileOpened != nil {
+			go OnFileOpened(authUserID, fileId)
+		}
+
+		s.Emit("file_joined", map[string]interface{}{
+			"success": true,
+			"fileId":  fileId,
+			"roomID":  roomID,
+		})
 
 		users := GetUsersInFile(fileId)
 		fmt.Printf("User %s joined file %s\n", userId, fileId)
@@ -110,6 +183,7 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 
 		server.LeaveRoom("/", fileId, s)
 		RemoveUserFromFile(fileId, userId)
+		untrackFile(s, fileId)
 
 		users := GetUsersInFile(fileId)
 		server.BroadcastToRoom("/", fileId, "file_users_update", map[string]interface{}{
@@ -153,6 +227,12 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 	})
 
 	server.OnEvent("/", "undo", func(s socketio.Conn, data map[string]interface{}) {
+		// With a paperId the server undoes the caller's own last edit
+		if paperID, ok := data["paperId"].(string); ok && paperID != "" {
+			runHistoryCommand(s, "undo", paperID)
+			return
+		}
+
 		roomID, ok := data["roomId"].(string)
 		if !ok || roomID == "" {
 			fmt.Println("⚠️ Invalid or missing roomId in undo event")
@@ -166,6 +246,11 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 	})
 
 	server.OnEvent("/", "redo", func(s socketio.Conn, data map[string]interface{}) {
+		if paperID, ok := data["paperId"].(string); ok && paperID != "" {
+			runHistoryCommand(s, "redo", paperID)
+			return
+		}
+
 		roomID, ok := data["roomId"].(string)
 		if !ok || roomID == "" {
 			fmt.Println("⚠️ Invalid or missing roomId in redo event")
@@ -346,14 +431,14 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 		fmt.Println("closed", reason, "UserID:", userID)
 
 		// Iterate over the files the user is in and remove them
-		for fileID := range fileUsers {
-			if fileUsers[fileID][userID] {
-				RemoveUserFromFile(fileID, userID)
-				server.BroadcastToRoom("/", fileID, "file_users_update", map[string]interface{}{
-					"users": GetUsersInFile(fileID),
-				})
-			}
+		for _, fileID := range getFilesOfUser(userID) {
+			RemoveUserFromFile(fileID, userID)
+			server.BroadcastToRoom("/", fileID, "file_users_update", map[string]interface{}{
+				"users": GetUsersInFile(fileID),
+			})
 		}
+
+		untrackConnection(s)
 	})
 
 	// Run the server in a goroutine
@@ -369,9 +454,43 @@ func SetupSocketIO(router *mux.Router) *socketio.Server {
 	return server
 }
 
-var fileUsers = make(map[string]map[string]bool)
+// runHistoryCommand answers an undo or redo with a <command>_result event to
+// the caller; everyone in the room gets the resulting changes from the handler
+func runHistoryCommand(s socketio.Conn, command, paperID string) {
+	userID, _ := s.Context().(string)
+	if userID == "" || OnHistoryCommand == nil {
+		s.Emit(command+"_result", map[string]interface{}{
+			"success": false,
+			"error":   "Join a room before using " + command,
+		})
+		return
+	}
+
+	fmt.Printf("🔄 %s of paper %s requested by %s\n", command, paperID, userID)
+	result, err := OnHistoryCommand(userID, command, paperID)
+	if err != nil {
+		s.Emit(command+"_result", map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+	s.Emit(command+"_result", map[string]interface{}{
+		"success": true,
+		"result":  result,
+	})
+}
+
+// fileUsers is also modified from REST handlers when a member is removed, so access is guarded
+var (
+	fileUsersMu sync.Mutex
+	fileUsers   = make(map[string]map[string]bool)
+)
 
 func AddUserToFile(fileID, userID string) {
+	fileUsersMu.Lock()
+	defer fileUsersMu.Unlock()
+
 	if fileUsers[fileID] == nil {
 		fileUsers[fileID] = make(map[string]bool)
 	}
@@ -379,6 +498,9 @@ func AddUserToFile(fileID, userID string) {
 }
 
 func RemoveUserFromFile(fileID, userID string) {
+	fileUsersMu.Lock()
+	defer fileUsersMu.Unlock()
+
 	if users, ok := fileUsers[fileID]; ok {
 		delete(users, userID)
 		if len(users) == 0 {
@@ -388,6 +510,9 @@ func RemoveUserFromFile(fileID, userID string) {
 }
 
 func GetUsersInFile(fileID string) []string {
+	fileUsersMu.Lock()
+	defer fileUsersMu.Unlock()
+
 	users := []string{}
 	if userSet, ok := fileUsers[fileID]; ok {
 		for userID := range userSet {
@@ -396,3 +521,16 @@ func GetUsersInFile(fileID string) []string {
 	}
 	return users
 }
+
+func getFilesOfUser(userID string) []string {
+	fileUsersMu.Lock()
+	defer fileUsersMu.Unlock()
+
+	files := []string{}
+	for fileID, userSet := range fileUsers {
+		if userSet[userID] {
+			files = append(files, fileID)
+		}
+	}
+	return files
+}
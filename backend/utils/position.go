@@ -0,0 +1,164 @@
+// utils/position.go
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// positionDigits are the digits of a fractional position key, in sort order.
+// Keys compare as plain strings, so MongoDB can sort on them directly.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// KeyBetween returns a position key that sorts strictly between a and b. An
+// empty a means "before everything" and an empty b means "after everything",
+// so KeyBetween("", "") returns the first key of an empty list. Keys after
+// everything increment the integer part of a, so appending keeps keys short.
+func KeyBetween(a, b string) (string, error) {
+	if err := validatePositionKey(a); err != nil {
+		return "", err
+	}
+	if err := validatePositionKey(b); err != nil {
+		return "", err
+	}
+	if a != "" && b != "" && a >= b {
+		return "", fmt.Errorf("position %q is not before %q", a, b)
+	}
+	if a != "" && b == "" {
+		return positionAfter(a), nil
+	}
+	return positionMidpoint(a, b), nil
+}
+
+// KeysBetween returns n increasing keys between a and b, spread out so later
+// inserts between any two of them stay short
+func KeysBetween(a, b string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if b == "" {
+		// Appended keys are consecutive steps
+		keys := make([]string, 0, n)
+		for len(keys) < n {
+			key, err := KeyBetween(a, "")
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			a = key
+		}
+		return keys, nil
+	}
+	if n == 1 {
+		key, err := KeyBetween(a, b)
+		if err != nil {
+			return nil, err
+		}
+		return []string{key}, nil
+	}
+
+	mid := n / 2
+	key, err := KeyBetween(a, b)
+	if err != nil {
+		return nil, err
+	}
+	before, err := KeysBetween(a, key, mid)
+	if err != nil {
+		return nil, err
+	}
+	after, err := KeysBetween(key, b, n-mid-1)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(before, key), after...), nil
+}
+
+// positionMidpoint treats the keys as base-62 fractions and returns a short
+// key between them. b == "" stands for 1.
+func positionMidpoint(a, b string) string {
+	if b != "" {
+		// Keep the common prefix and find the midpoint of the rest
+		n := 0
+		for n < len(b) && positionDigitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + positionMidpoint(rest, b[n:])
+		}
+	}
+
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(positionDigits, a[0])
+	}
+	digitB := len(positionDigits)
+	if b != "" {
+		digitB = strings.IndexByte(positionDigits, b[0])
+	}
+
+	if digitB-digitA > 1 {
+		return string(positionDigits[(digitA+digitB+1)/2])
+	}
+	// The first digits are consecutive
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(positionDigits[digitA]) + positionMidpoint(rest, "")
+}
+
+// positionAfter returns a key after a by incrementing its integer part.
+// The integer part of a key starting with m top digits is the m+1 digits
+// after them, so keys grow logarithmically as items are appended: after "y"
+// comes "z", then "z01" up to "zyz", then "zz001" and so on.
+func positionAfter(a string) string {
+	top := positionDigits[len(positionDigits)-1]
+	m := 0
+	for m < len(a) && a[m] == top {
+		m++
+	}
+
+	digits := []byte(a[m:])
+	for len(digits) < m+1 {
+		digits = append(digits, positionDigits[0])
+	}
+	digits = digits[:m+1]
+
+	// The first digit is not a top digit, so the carry stops within digits
+	for i := m; i >= 0; i-- {
+		digit := strings.IndexByte(positionDigits, digits[i])
+		if digit < len(positionDigits)-1 {
+			digits[i] = positionDigits[digit+1]
+			break
+		}
+		digits[i] = positionDigits[0]
+	}
+	return strings.TrimRight(a[:m]+string(digits), positionDigits[:1])
+}
+
+func positionDigitAt(key string, i int) byte {
+	if i < len(key) {
+		return key[i]
+	}
+	return positionDigits[0]
+}
+
+func validatePositionKey(key string) error {
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(positionDigits, key[i]) < 0 {
+			return fmt.Errorf("invalid position %q", key)
+		}
+	}
+	// A trailing zero digit would make two different keys equal in value
+	if key != "" && key[len(key)-1] == positionDigits[0] {
+		return fmt.Errorf("invalid position %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package utils
+
+import "testing"
+
+func TestKeyBetween(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		want    string
+		wantErr bool
+	}{
+		{name: "empty list", a: "", b: "", want: "V"},
+		{name: "before first", a: "", b: "V", want: "G"},
+		{name: "append", a: "V", b: "", want: "W"},
+		{name: "append after fraction", a: "VV", b: "", want: "W"},
+		{name: "append after last single digit", a: "z", b: "", want: "z01"},
+		{name: "append carries", a: "z0z", b: "", want: "z1"},
+		{name: "append to next tier", a: "zyz", b: "", want: "zz"},
+		{name: "between", a: "V", b: "X", want: "W"},
+		{name: "between consecutive", a: "V", b: "W", want: "VV"},
+		{name: "common prefix", a: "V1", b: "V3", want: "V2"},
+		{name: "not before", a: "W", b: "V", wantErr: true},
+		{name: "equal", a: "V", b: "V", wantErr: true},
+		{name: "invalid digit", a: "V-", b: "", wantErr: true},
+		{name: "trailing zero", a: "V0", b: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := KeyBetween(tt.a, tt.b)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("KeyBetween(%q, %q) = %q, want an error", tt.a, tt.b, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("KeyBetween(%q, %q) failed: %v", tt.a, tt.b, err)
+			}
+			if got != tt.want {
+				t.Errorf("KeyBetween(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+			}
+			if got <= tt.a || (tt.b != "" && got >= tt.b) {
+				t.Errorf("KeyBetween(%q, %q) = %q, not between them", tt.a, tt.b, got)
+			}
+		})
+	}
+}
+
+func TestKeyBetweenAppendStaysShort(t *testing.T) {
+	tests := []struct {
+		appends int
+		maxLen  int
+	}{
+		{appends: 30, maxLen: 1},
+		{appends: 1000, maxLen: 3},
+		{appends: 100000, maxLen: 5},
+	}
+	for _, tt := range tests {
+		key := ""
+		for i := 0; i < tt.appends; i++ {
+			next, err := KeyBetween(key, "")
+			if err != nil {
+				t.Fatalf("append %d after %q failed: %v", i, key, err)
+			}
+			if next <= key {
+				t.Fatalf("append %d: %q is not after %q", i, next, key)
+			}
+			key = next
+		}
+		if len(key) > tt.maxLen {
+			t.Errorf("after %d appends the key is %q, want at most %d digits", tt.appends, key, tt.maxLen)
+		}
+	}
+}
+
+func TestKeysBetween(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		n    int
+	}{
+		{name: "empty list", a: "", b: "", n: 10},
+		{name: "append", a: "z", b: "", n: 100},
+		{name: "between", a: "V", b: "W", n: 50},
+		{name: "before first", a: "", b: "1", n: 20},
+		{name: "none", a: "V", b: "W", n: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := KeysBetween(tt.a, tt.b, tt.n)
+			if err != nil {
+				t.Fatalf("KeysBetween failed: %v", err)
+			}
+			if len(keys) != tt.n {
+				t.Fatalf("got %d keys, want %d", len(keys), tt.n)
+			}
+			prev := tt.a
+			for _, key := range keys {
+				if key <= prev || (tt.b != "" && key >= tt.b) {
+					t.Fatalf("key %q out of order after %q in %v", key, prev, keys)
+				}
+				if err := validatePositionKey(key); err != nil {
+					t.Fatalf("invalid key: %v", err)
+				}
+				prev = key
+			}
+		})
+	}
+}
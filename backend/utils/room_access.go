@@ -0,0 +1,279 @@
+// utils/room_access.go
+package utils
+
+import (
+	"backend/config"
+	"backend/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNoRoomAccess is returned when a user is neither the owner nor a member of a room
+var ErrNoRoomAccess = errors.New("user has no access to this room")
+
+// GetUserRoleInRoom returns "owner" for the room owner, otherwise the role the
+// user holds in the room. A direct Room_Member role is preferred over roles
+// granted through groups.
+func GetUserRoleInRoom(ctx context.Context, userID, roomID string) (string, error) {
+	grants, err := getRoomGrants(ctx, userID, roomID)
+	if err != nil {
+		return "", err
+	}
+	return grants[0], nil
+}
+
+// GetFileRoomID returns the ID of the room a file belongs to
+func GetFileRoomID(ctx context.Context, fileID string) (string, error) {
+	objID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return "", fmt.Errorf("invalid file ID: %v", err)
+	}
+
+	var file struct {
+		RoomID string `bson:"room_id"`
+	}
+	if err := config.GetFileCollection().FindOne(ctx, bson.M{"_id": objID}).Decode(&file); err != nil {
+		return "", fmt.Errorf("error retrieving file: %v", err)
+	}
+	return file.RoomID, nil
+}
+
+// GetUserRolesInRoom returns every role the user holds in the room, directly
+// or through groups, or only "owner" for the room owner
+func GetUserRolesInRoom(ctx context.Context, userID, roomID string) ([]string, error) {
+	return getRoomGrants(ctx, userID, roomID)
+}
+
+// GetUserPermissionsInRoom returns the union of the permissions of every role
+// the user holds in the room, directly or through groups
+func GetUserPermissionsInRoom(ctx context.Context, userID, roomID string) (map[string]bool, error) {
+	grants, err := getRoomGrants(ctx, userID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := make(map[string]bool)
+	for _, roleID := range grants {
+		rolePermissions, err := GetRolePermissions(ctx, roleID, roomID)
+		if err != nil {
+			return nil, err
+		}
+		for _, permission := range rolePermissions {
+			permissions[permission] = true
+		}
+	}
+	return permissions, nil
+}
+
+// PermissionList returns the granted permissions in the order of models.AllPermissions
+func PermissionList(permissions map[string]bool) []string {
+	list := make([]string, 0, len(permissions))
+	for _, permission := range models.AllPermissions {
+		if permissions[permission] {
+			list = append(list, permission)
+		}
+	}
+	return list
+}
+
+// HasRoomPermission reports whether the user may perform the given action in the room
+func HasRoomPermission(ctx context.Context, userID, roomID, permission string) (bool, error) {
+	permissions, err := GetUserPermissionsInRoom(ctx, userID, roomID)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// GetRolePermissions returns the permission set of a built-in role or of a
+// custom role defined in the given room
+func GetRolePermissions(ctx context.Context, roleID, roomID string) ([]string, error) {
+	if permissions, ok := models.BuiltInRoles[roleID]; ok {
+		return permissions, nil
+	}
+
+	var role models.Role
+	err := config.GetRoleCollection().FindOne(ctx, bson.M{"role_id": roleID, "room_id": roomID}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// A deleted custom role grants nothing
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("error retrieving role: %v", err)
+	}
+	return role.Permissions, nil
+}
+
+// IsValidRoomRole reports whether roleID can be assigned to a member of the
+// room. The owner role can never be assigned.
+func IsValidRoomRole(ctx context.Context, roleID, roomID string) (bool, error) {
+	if roleID == models.RoleOwner {
+		return false, nil
+	}
+	if _, ok := models.BuiltInRoles[roleID]; ok {
+		return true, nil
+	}
+
+	count, err := config.GetRoleCollection().CountDocuments(ctx, bson.M{"role_id": roleID, "room_id": roomID})
+	if err != nil {
+		return false, fmt.Errorf("error retrieving role: %v", err)
+	}
+	return count > 0, nil
+}
+
+// EnsureBuiltInRoles upserts the built-in permission sets into the Roles
+// collection so clients can list them next to custom roles
+func EnsureBuiltInRoles(ctx context.Context) error {
+	for roleID, permissions := range models.BuiltInRoles {
+		filter := bson.M{"role_id": roleID, "room_id": ""}
+		update := bson.M{
+			"$set": bson.M{
+				"name":        roleID,
+				"permissions": permissions,
+				"built_in":    true,
+				"updatedAt":   time.Now(),
+			},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		}
+		_, err := config.GetRoleCollection().UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return fmt.Errorf("failed to seed role %s: %v", roleID, err)
+		}
+	}
+	return nil
+}
+
+// getRoomGrants returns every role the user holds in the room, direct
+// membership first, or ErrNoRoomAccess when there is none
+func getRoomGrants(ctx context.Context, userID, roomID string) ([]string, error) {
+	roomObjID, err := primitive.ObjectIDFromHex(roomID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid room ID: %v", err)
+	}
+
+	var room struct {
+		OwnerID string `bson:"owner_id"`
+	}
+	err = config.GetRoomCollection().FindOne(ctx, bson.M{"_id": roomObjID}).Decode(&room)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrNoRoomAccess
+		}
+		return nil, fmt.Errorf("error retrieving room: %v", err)
+	}
+
+	if room.OwnerID == userID {
+		return []string{models.RoleOwner}, nil
+	}
+
+	grants, err := getSharedRoomGrants(ctx, userID, bson.M{"room_id": roomID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(grants[roomID]) == 0 {
+		return nil, ErrNoRoomAccess
+	}
+	return grants[roomID], nil
+}
+
+// GetSharedRoomRoles returns the role a user holds in every room shared with
+// them, either directly or through group membership, keyed by room ID
+func GetSharedRoomRoles(ctx context.Context, userID string) (map[string]string, error) {
+	grants, err := getSharedRoomGrants(ctx, userID, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]string, len(grants))
+	for roomID, roomGrants := range grants {
+		roles[roomID] = roomGrants[0]
+	}
+	return roles, nil
+}
+
+func getSharedRoomGrants(ctx context.Context, userID string, roomFilter bson.M) (map[string][]string, error) {
+	grants := make(map[string][]string)
+
+	memberFilter := bson.M{"shared_with": userID}
+	for k, v := range roomFilter {
+		memberFilter[k] = v
+	}
+
+	cursor, err := config.GetRoomMemberCollection().Find(ctx, memberFilter)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving room members: %v", err)
+	}
+
+	var members []struct {
+		RoomID string `bson:"room_id"`
+		RoleID string `bson:"role_id"`
+	}
+	if err := cursor.All(ctx, &members); err != nil {
+		return nil, fmt.Errorf("error decoding room members: %v", err)
+	}
+
+	for _, member := range members {
+		grants[member.RoomID] = append(grants[member.RoomID], member.RoleID)
+	}
+
+	groupIDs, err := GetUserGroupIDs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groupIDs) == 0 {
+		return grants, nil
+	}
+
+	grantFilter := bson.M{"group_id": bson.M{"$in": groupIDs}}
+	for k, v := range roomFilter {
+		grantFilter[k] = v
+	}
+
+	cursor, err = config.GetRoomGroupCollection().Find(ctx, grantFilter)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving room groups: %v", err)
+	}
+
+	var roomGroups []struct {
+		RoomID string `bson:"room_id"`
+		RoleID string `bson:"role_id"`
+	}
+	if err := cursor.All(ctx, &roomGroups); err != nil {
+		return nil, fmt.Errorf("error decoding room groups: %v", err)
+	}
+
+	for _, grant := range roomGroups {
+		grants[grant.RoomID] = append(grants[grant.RoomID], grant.RoleID)
+	}
+
+	return grants, nil
+}
+
+// GetUserGroupIDs returns the IDs of every group the user is a member of
+func GetUserGroupIDs(ctx context.Context, userID string) ([]string, error) {
+	cursor, err := config.GetGroupCollection().Find(ctx, bson.M{"member_ids": userID})
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving groups: %v", err)
+	}
+
+	var groups []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, fmt.Errorf("error decoding groups: %v", err)
+	}
+
+	groupIDs := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupIDs = append(groupIDs, group.ID.Hex())
+	}
+	return groupIDs, nil
+}
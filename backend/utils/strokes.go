@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"math"
+
+	"backend/models"
+)
+
+// SimplifyOffsets drops the points of a stroke that lie within tolerance of
+// the line through their neighbours (Ramer-Douglas-Peucker). The first and
+// last points are always kept; a tolerance of 0 or less keeps every point.
+func SimplifyOffsets(points []models.Offset, tolerance float64) []models.Offset {
+	if tolerance <= 0 || len(points) < 3 {
+		return points
+	}
+
+	keep := make([]bool, len(points))
+	keep[0], keep[len(points)-1] = true, true
+
+	// Spans still to check, as index pairs, so long strokes cannot overflow the stack
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		span := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		first, last := span[0], span[1]
+
+		farthest, maxDistance := -1, tolerance
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > maxDistance {
+				farthest, maxDistance = i, d
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+	}
+
+	simplified := make([]models.Offset, 0, len(points))
+	for i, point := range points {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+// segmentDistance is the distance from p to the segment from a to b
+func segmentDistance(p, a, b models.Offset) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	lengthSquared := dx*dx + dy*dy
+	if lengthSquared == 0 {
+		return math.Hypot(p.X-a.X, p.Y-a.Y)
+	}
+
+	t := ((p.X-a.X)*dx + (p.Y-a.Y)*dy) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+
+	"backend/models"
+)
+
+func TestSimplifyOffsets(t *testing.T) {
+	line := []models.Offset{{X: 0, Y: 0}, {X: 1, Y: 0.01}, {X: 2, Y: -0.01}, {X: 3, Y: 0}}
+	corner := []models.Offset{{X: 0, Y: 0}, {X: 5, Y: 0.02}, {X: 10, Y: 0}, {X: 10, Y: 5}, {X: 10, Y: 10}}
+	zigzag := []models.Offset{{X: 0, Y: 0}, {X: 1, Y: 2}, {X: 2, Y: 0}, {X: 3, Y: 2}, {X: 4, Y: 0}}
+
+	tests := []struct {
+		name      string
+		points    []models.Offset
+		tolerance float64
+		want      []models.Offset
+	}{
+		{name: "nearly straight line", points: line, tolerance: 0.1, want: []models.Offset{{X: 0, Y: 0}, {X: 3, Y: 0}}},
+		{name: "corner is kept", points: corner, tolerance: 0.1, want: []models.Offset{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 10}}},
+		{name: "zigzag above tolerance", points: zigzag, tolerance: 0.5, want: zigzag},
+		{name: "zigzag below tolerance", points: zigzag, tolerance: 3, want: []models.Offset{{X: 0, Y: 0}, {X: 4, Y: 0}}},
+		{name: "no tolerance", points: line, tolerance: 0, want: line},
+		{name: "two points", points: line[:2], tolerance: 10, want: line[:2]},
+		{name: "single point", points: line[:1], tolerance: 10, want: line[:1]},
+		{name: "closed loop", points: []models.Offset{{X: 0, Y: 0}, {X: 5, Y: 5}, {X: 0, Y: 0}}, tolerance: 1,
+			want: []models.Offset{{X: 0, Y: 0}, {X: 5, Y: 5}, {X: 0, Y: 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SimplifyOffsets(tt.points, tt.tolerance)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SimplifyOffsets(%v, %v) = %v, want %v", tt.points, tt.tolerance, got, tt.want)
+			}
+		})
+	}
+}